@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/signing"
+)
+
+// Exit codes of the verify subcommand
+const (
+	verifyOK     = 0
+	verifyFailed = 1
+	verifyUsage  = 2
+)
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// runSubcommand runs the subcommand named by args[0], returning its exit
+// code, and reports false when args name none so the server starts instead.
+// main does not call it yet, so "metrics-server verify" cannot be run until
+// main starts with:
+//
+//	if code, ok := runSubcommand(os.Args[1:], os.Stdout, os.Stderr); ok {
+//		os.Exit(code)
+//	}
+func runSubcommand(args []string, stdout, stderr io.Writer) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case "verify":
+		return runVerify(args[1:], stdout, stderr), true
+	}
+	return 0, false
+}
+
+// runVerify implements "metrics-server verify": it checks an export against
+// its detached signature, taken from the X-Export-Signature header, using
+// public keys from PEM or JWKS files or from the server's well-known
+// endpoint. It is run through runSubcommand.
+//
+//	metrics-server verify -signature export.sig -keys-url https://metrics.example.com/.well-known/export-signing-keys export.csv
+func runVerify(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	sigFile := fs.String("signature", "", "file holding the X-Export-Signature header value or the signature JSON")
+	keysURL := fs.String("keys-url", "", "URL of the server's published signing keys")
+	var keyFiles stringList
+	fs.Var(&keyFiles, "key", "PEM public key or JWKS file (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: metrics-server verify -signature FILE (-key FILE | -keys-url URL) EXPORT")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return verifyUsage
+	}
+	if *sigFile == "" || fs.NArg() != 1 || (len(keyFiles) == 0 && *keysURL == "") {
+		fs.Usage()
+		return verifyUsage
+	}
+
+	fail := func(format string, args ...interface{}) int {
+		fmt.Fprintf(stderr, "verification failed: "+format+"\n", args...)
+		return verifyFailed
+	}
+
+	sigData, err := os.ReadFile(*sigFile)
+	if err != nil {
+		return fail("%v", err)
+	}
+	env, err := signing.ParseEnvelope(sigData)
+	if err != nil {
+		return fail("%v", err)
+	}
+	content, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	var keys []ed25519.PublicKey
+	for _, file := range keyFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fail("%v", err)
+		}
+		fileKeys, err := parseVerifyKeys(data)
+		if err != nil {
+			return fail("%s: %v", file, err)
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if *keysURL != "" {
+		urlKeys, err := fetchVerifyKeys(*keysURL)
+		if err != nil {
+			return fail("%v", err)
+		}
+		keys = append(keys, urlKeys...)
+	}
+
+	if err := signing.Verify(env, content, keys); err != nil {
+		return fail("%v", err)
+	}
+
+	meta := env.Metadata
+	fmt.Fprintf(stdout, "OK: %s signed by key %s\n", fs.Arg(0), env.KeyID)
+	fmt.Fprintf(stdout, "  application: %s (project %s)\n", meta.Application, meta.Project)
+	if meta.Graph != "" {
+		fmt.Fprintf(stdout, "  graph:       %s/%s/%s\n", meta.GroupKind, meta.Row, meta.Graph)
+	}
+	if meta.User != "" {
+		fmt.Fprintf(stdout, "  user:        %s\n", meta.User)
+	}
+	fmt.Fprintf(stdout, "  exported at: %s\n", meta.ExportedAt.Format(time.RFC3339))
+	fmt.Fprintf(stdout, "  sha256:      %s\n", meta.SHA256)
+	return verifyOK
+}
+
+// parseVerifyKeys reads a PEM public key or a JSON Web Key Set
+func parseVerifyKeys(data []byte) ([]ed25519.PublicKey, error) {
+	if !strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		key, err := signing.ParsePublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return []ed25519.PublicKey{key}, nil
+	}
+
+	var set signing.JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid key set: %w", err)
+	}
+	var keys []ed25519.PublicKey
+	for _, jwk := range set.Keys {
+		key, err := jwk.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("key set is empty")
+	}
+	return keys, nil
+}
+
+// fetchVerifyKeys downloads the keys published by a server
+func fetchVerifyKeys(url string) ([]ed25519.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch signing keys: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	return parseVerifyKeys(data)
+}
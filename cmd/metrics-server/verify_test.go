@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/signing"
+)
+
+func TestRunVerify(t *testing.T) {
+	seed := bytes.Repeat([]byte{5}, ed25519.SeedSize)
+	key := ed25519.NewKeyFromSeed(seed)
+	pub := key.Public().(ed25519.PublicKey)
+
+	dir := t.TempDir()
+	content := []byte("Timestamp,Value\n2024-01-01T00:00:00Z,1\n")
+	env, err := signing.NewSigner(key).Sign(signing.Metadata{
+		Application: "payments",
+		Project:     "prod",
+		Graph:       "request-rate",
+		ExportedAt:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _ := env.Encode()
+
+	exportFile := filepath.Join(dir, "metrics.csv")
+	sigFile := filepath.Join(dir, "metrics.csv.sig")
+	keyFile := filepath.Join(dir, "key.pem")
+	pubPEM, _ := signing.MarshalPublicKey(pub)
+	os.WriteFile(exportFile, content, 0o644)
+	os.WriteFile(sigFile, []byte(header), 0o644)
+	os.WriteFile(keyFile, pubPEM, 0o644)
+
+	keys := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(signing.JWKS{Keys: []signing.JWK{signing.NewJWK(pub)}})
+	}))
+	defer keys.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := runVerify([]string{"-signature", sigFile, "-key", keyFile, exportFile}, &stdout, &stderr); code != verifyOK {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "application: payments (project prod)") {
+		t.Errorf("stdout = %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := runVerify([]string{"-signature", sigFile, "-keys-url", keys.URL, exportFile}, &stdout, &stderr); code != verifyOK {
+		t.Fatalf("keys-url: exit %d: %s", code, stderr.String())
+	}
+
+	os.WriteFile(exportFile, append(content, "2024-01-01T00:01:00Z,2\n"...), 0o644)
+	stderr.Reset()
+	if code := runVerify([]string{"-signature", sigFile, "-key", keyFile, exportFile}, &stdout, &stderr); code != verifyFailed {
+		t.Errorf("edited export: exit %d", code)
+	}
+	if !strings.Contains(stderr.String(), "does not match") {
+		t.Errorf("stderr = %s", stderr.String())
+	}
+
+	if code := runVerify([]string{exportFile}, &stdout, &stderr); code != verifyUsage {
+		t.Errorf("missing flags: exit %d", code)
+	}
+}
+
+func TestRunSubcommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code, ok := runSubcommand([]string{"verify", "export.csv"}, &stdout, &stderr); !ok || code != verifyUsage {
+		t.Errorf("verify: exit %d, handled %v", code, ok)
+	}
+	if !strings.Contains(stderr.String(), "usage: metrics-server verify") {
+		t.Errorf("stderr = %s", stderr.String())
+	}
+	for _, args := range [][]string{nil, {"-config", "config.yaml"}, {"serve"}} {
+		if _, ok := runSubcommand(args, &stdout, &stderr); ok {
+			t.Errorf("%v handled as a subcommand", args)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package argocd reads Argo CD applications from the API server, so that
+// export schedules can select applications by project, label or name.
+package argocd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout = time.Minute
+	// maxResponseSize bounds the application list read from the API
+	maxResponseSize = 64 << 20
+)
+
+// Config configures a Client
+type Config struct {
+	// Server is the address of the Argo CD API server, such as
+	// https://argocd-server.argocd
+	Server string `yaml:"server" json:"server"`
+	// Token is a bearer token of an account allowed to list applications,
+	// defaulting to ARGOCD_AUTH_TOKEN from the environment
+	Token string `yaml:"token" json:"-"`
+}
+
+// Application is the part of an Argo CD application used to select it
+type Application struct {
+	Name    string            `json:"name"`
+	Project string            `json:"project"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// Error is an error response from the API
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("argocd: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("argocd: %d %s", e.StatusCode, e.Message)
+}
+
+// Client lists applications from the Argo CD API
+type Client struct {
+	baseURL    *url.URL
+	cfg        Config
+	httpClient *http.Client
+}
+
+// New creates a client of the API server cfg.Server
+func New(cfg Config) (*Client, error) {
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("ARGOCD_AUTH_TOKEN")
+	}
+	baseURL, err := url.Parse(cfg.Server)
+	if err != nil || baseURL.Host == "" || (baseURL.Scheme != "http" && baseURL.Scheme != "https") {
+		return nil, fmt.Errorf("argocd: invalid server URL %q", cfg.Server)
+	}
+
+	return &Client{
+		baseURL:    baseURL,
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+	}, nil
+}
+
+// ListApplications lists every application the token may read
+func (c *Client) ListApplications(ctx context.Context) ([]Application, error) {
+	u := *c.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v1/applications"
+	u.RawQuery = url.Values{"fields": {"items.metadata.name,items.metadata.labels,items.spec.project"}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("argocd: %w", err)
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("argocd: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, &Error{StatusCode: resp.StatusCode, Message: errorMessage(body)}
+	}
+
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name   string            `json:"name"`
+				Labels map[string]string `json:"labels"`
+			} `json:"metadata"`
+			Spec struct {
+				Project string `json:"project"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&list); err != nil {
+		return nil, fmt.Errorf("argocd: decoding application list: %w", err)
+	}
+
+	apps := make([]Application, 0, len(list.Items))
+	for _, item := range list.Items {
+		apps = append(apps, Application{
+			Name:    item.Metadata.Name,
+			Project: item.Spec.Project,
+			Labels:  item.Metadata.Labels,
+		})
+	}
+	return apps, nil
+}
+
+// errorMessage extracts the message of a gRPC gateway error response
+func errorMessage(body []byte) string {
+	var errBody struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &errBody) == nil {
+		if errBody.Message != "" {
+			return errBody.Message
+		}
+		return errBody.Error
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,61 @@
+package argocd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestListApplications(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/applications" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"error":"invalid session","code":16,"message":"invalid session"}`)
+			return
+		}
+		io.WriteString(w, `{"items":[
+			{"metadata":{"name":"checkout","labels":{"team":"payments"}},"spec":{"project":"prod"}},
+			{"metadata":{"name":"search"},"spec":{"project":"default"}}
+		]}`)
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{Server: srv.URL, Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps, err := c.ListApplications(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Application{
+		{Name: "checkout", Project: "prod", Labels: map[string]string{"team": "payments"}},
+		{Name: "search", Project: "default"},
+	}
+	if !reflect.DeepEqual(apps, want) {
+		t.Errorf("apps = %+v, want %+v", apps, want)
+	}
+
+	c.cfg.Token = "expired"
+	_, err = c.ListApplications(context.Background())
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Message != "invalid session" {
+		t.Errorf("err = %v, want an invalid session error", err)
+	}
+}
+
+func TestNew_Validation(t *testing.T) {
+	t.Setenv("ARGOCD_AUTH_TOKEN", "env-token")
+
+	if _, err := New(Config{Server: "argocd-server:443"}); err == nil {
+		t.Error("server without scheme accepted")
+	}
+	c, err := New(Config{Server: "https://argocd-server.argocd"})
+	if err != nil || c.cfg.Token != "env-token" {
+		t.Errorf("token not read from the environment: %v", err)
+	}
+}
@@ -0,0 +1,329 @@
+// Package chart lays out time series line charts as drawing primitives that
+// can be rendered to SVG or any other Canvas, such as a PDF page.
+package chart
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Default chart size, in SVG user units or PDF points
+const (
+	DefaultWidth  = 800
+	DefaultHeight = 300
+)
+
+// MaxLegendEntries bounds the series named in the legend; the rest are
+// summarised as "+N more"
+const MaxLegendEntries = 8
+
+const (
+	marginLeft   = 64
+	marginRight  = 16
+	marginTop    = 28
+	marginBottom = 24
+	legendRow    = 14
+	fontSize     = 10
+	titleSize    = 13
+)
+
+// Point is one sample of a series
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is a named sequence of points in time order. NaN values break
+// the line.
+type Series struct {
+	Name   string
+	Points []Point
+}
+
+// Chart is a line chart of one or more series over time
+type Chart struct {
+	Title  string
+	Series []Series
+	// Width and Height default to DefaultWidth and DefaultHeight
+	Width, Height float64
+}
+
+// Color is an RGB color
+type Color struct {
+	R, G, B uint8
+}
+
+// Hex returns the color as #rrggbb
+func (c Color) Hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// Colors used by the layout
+var (
+	Black     = Color{0x22, 0x22, 0x22}
+	Gray      = Color{0x6b, 0x72, 0x80}
+	LightGray = Color{0xe5, 0xe7, 0xeb}
+)
+
+// Palette is the series colors, reused in order
+var Palette = []Color{
+	{0x1f, 0x77, 0xb4}, {0xff, 0x7f, 0x0e}, {0x2c, 0xa0, 0x2c}, {0xd6, 0x27, 0x28},
+	{0x94, 0x67, 0xbd}, {0x8c, 0x56, 0x4b}, {0xe3, 0x77, 0xc2}, {0x7f, 0x7f, 0x7f},
+	{0xbc, 0xbd, 0x22}, {0x17, 0xbe, 0xcf},
+}
+
+// Anchor is the horizontal alignment of text relative to its position
+type Anchor int
+
+const (
+	AnchorStart Anchor = iota
+	AnchorMiddle
+	AnchorEnd
+)
+
+// Canvas draws primitives in a coordinate space with the origin at the top
+// left and y growing downwards
+type Canvas interface {
+	Line(x1, y1, x2, y2, width float64, c Color)
+	Polyline(points [][2]float64, width float64, c Color)
+	Rect(x, y, w, h float64, fill Color)
+	// Text draws a single line with its baseline at y
+	Text(x, y, size float64, bold bool, anchor Anchor, c Color, s string)
+}
+
+// Drawing is a laid out chart
+type Drawing struct {
+	Width, Height float64
+	ops           []func(Canvas)
+}
+
+// Draw renders the drawing onto c
+func (d *Drawing) Draw(c Canvas) {
+	for _, op := range d.ops {
+		op(c)
+	}
+}
+
+func (d *Drawing) line(x1, y1, x2, y2, width float64, c Color) {
+	d.ops = append(d.ops, func(cv Canvas) { cv.Line(x1, y1, x2, y2, width, c) })
+}
+
+func (d *Drawing) polyline(points [][2]float64, width float64, c Color) {
+	d.ops = append(d.ops, func(cv Canvas) { cv.Polyline(points, width, c) })
+}
+
+func (d *Drawing) rect(x, y, w, h float64, fill Color) {
+	d.ops = append(d.ops, func(cv Canvas) { cv.Rect(x, y, w, h, fill) })
+}
+
+func (d *Drawing) text(x, y, size float64, bold bool, anchor Anchor, c Color, s string) {
+	d.ops = append(d.ops, func(cv Canvas) { cv.Text(x, y, size, bold, anchor, c, s) })
+}
+
+// Layout computes axes, gridlines, legend and series lines. The legend is
+// placed below the plot, so charts with more series are taller than Height.
+func (ch *Chart) Layout() *Drawing {
+	width, height := ch.Width, ch.Height
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	if height <= 0 {
+		height = DefaultHeight
+	}
+
+	legendEntries := len(ch.Series)
+	if legendEntries > MaxLegendEntries {
+		legendEntries = MaxLegendEntries + 1
+	}
+	d := &Drawing{Width: width, Height: height + float64(legendEntries)*legendRow}
+	d.rect(0, 0, d.Width, d.Height, Color{0xff, 0xff, 0xff})
+	if ch.Title != "" {
+		d.text(marginLeft, 18, titleSize, true, AnchorStart, Black, ch.Title)
+	}
+
+	plotX, plotY := float64(marginLeft), float64(marginTop)
+	plotW, plotH := width-marginLeft-marginRight, height-marginTop-marginBottom
+
+	tMin, tMax, vMin, vMax, ok := ch.bounds()
+	if !ok {
+		d.rect(plotX, plotY, plotW, plotH, Color{0xf9, 0xfa, 0xfb})
+		d.text(plotX+plotW/2, plotY+plotH/2, fontSize+2, false, AnchorMiddle, Gray, "No data")
+		return d
+	}
+
+	yTicks := NiceTicks(vMin, vMax, 5)
+	vMin, vMax = math.Min(vMin, yTicks[0]), math.Max(vMax, yTicks[len(yTicks)-1])
+	if tMin.Equal(tMax) {
+		tMin, tMax = tMin.Add(-time.Minute), tMax.Add(time.Minute)
+	}
+
+	xOf := func(t time.Time) float64 {
+		return plotX + plotW*float64(t.Sub(tMin))/float64(tMax.Sub(tMin))
+	}
+	yOf := func(v float64) float64 {
+		return plotY + plotH - plotH*(v-vMin)/(vMax-vMin)
+	}
+
+	for _, v := range yTicks {
+		y := yOf(v)
+		d.line(plotX, y, plotX+plotW, y, 0.5, LightGray)
+		d.text(plotX-6, y+3, fontSize, false, AnchorEnd, Gray, FormatValue(v))
+	}
+	step, layout := timeTicks(tMin, tMax, 6)
+	for t := tMin.Truncate(step); !t.After(tMax); t = t.Add(step) {
+		if t.Before(tMin) {
+			continue
+		}
+		x := xOf(t)
+		d.line(x, plotY+plotH, x, plotY+plotH+4, 0.5, Gray)
+		d.text(x, plotY+plotH+15, fontSize, false, AnchorMiddle, Gray, t.UTC().Format(layout))
+	}
+	d.line(plotX, plotY+plotH, plotX+plotW, plotY+plotH, 1, Gray)
+
+	for i, s := range ch.Series {
+		color := Palette[i%len(Palette)]
+		var segment [][2]float64
+		flush := func() {
+			if len(segment) > 0 {
+				d.polyline(segment, 1.5, color)
+			}
+			segment = nil
+		}
+		for _, p := range s.Points {
+			if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+				flush()
+				continue
+			}
+			segment = append(segment, [2]float64{xOf(p.Time), yOf(p.Value)})
+		}
+		flush()
+	}
+
+	y := height + 4
+	for i, s := range ch.Series {
+		if i == MaxLegendEntries && len(ch.Series) > MaxLegendEntries {
+			d.text(plotX+14, y, fontSize, false, AnchorStart, Gray,
+				fmt.Sprintf("+%d more series", len(ch.Series)-MaxLegendEntries))
+			break
+		}
+		d.rect(plotX, y-8, 10, 3, Palette[i%len(Palette)])
+		d.text(plotX+14, y, fontSize, false, AnchorStart, Black, s.Name)
+		y += legendRow
+	}
+	return d
+}
+
+// bounds returns the time and value extent of the finite points
+func (ch *Chart) bounds() (tMin, tMax time.Time, vMin, vMax float64, ok bool) {
+	vMin, vMax = math.Inf(1), math.Inf(-1)
+	for _, s := range ch.Series {
+		for _, p := range s.Points {
+			if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+				continue
+			}
+			if !ok || p.Time.Before(tMin) {
+				tMin = p.Time
+			}
+			if !ok || p.Time.After(tMax) {
+				tMax = p.Time
+			}
+			vMin, vMax = math.Min(vMin, p.Value), math.Max(vMax, p.Value)
+			ok = true
+		}
+	}
+	return tMin, tMax, vMin, vMax, ok
+}
+
+// NiceTicks returns about n evenly spaced round values covering [min, max]
+func NiceTicks(min, max float64, n int) []float64 {
+	if min == max {
+		delta := math.Abs(min) / 10
+		if delta == 0 {
+			delta = 1
+		}
+		min, max = min-delta, max+delta
+	}
+	step := niceNumber((max - min) / float64(n-1))
+	lo, hi := math.Floor(min/step)*step, math.Ceil(max/step)*step
+
+	var ticks []float64
+	for i := 0; ; i++ {
+		v := lo + float64(i)*step
+		if v > hi+step/2 {
+			break
+		}
+		// Avoid printing -0 and 0.30000000000000004
+		v, _ = strconv.ParseFloat(strconv.FormatFloat(v, 'g', 12, 64), 64)
+		ticks = append(ticks, v+0)
+	}
+	return ticks
+}
+
+// niceNumber rounds x to 1, 2, 5 or 10 times a power of ten
+func niceNumber(x float64) float64 {
+	exp := math.Floor(math.Log10(x))
+	f := x / math.Pow(10, exp)
+	var nice float64
+	switch {
+	case f < 1.5:
+		nice = 1
+	case f < 3:
+		nice = 2
+	case f < 7:
+		nice = 5
+	default:
+		nice = 10
+	}
+	return nice * math.Pow(10, exp)
+}
+
+// tickSteps are the time axis intervals, smallest first
+var tickSteps = []time.Duration{
+	time.Second, 5 * time.Second, 15 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour,
+}
+
+// timeTicks picks the smallest interval giving at most n ticks and the
+// label layout suited to it
+func timeTicks(from, to time.Time, n int) (time.Duration, string) {
+	span := to.Sub(from)
+	step := tickSteps[len(tickSteps)-1]
+	for _, s := range tickSteps {
+		if span/s < time.Duration(n) {
+			step = s
+			break
+		}
+	}
+	switch {
+	case step < time.Minute:
+		return step, "15:04:05"
+	case span < 24*time.Hour:
+		return step, "15:04"
+	case step < 24*time.Hour:
+		return step, "01-02 15:04"
+	default:
+		return step, "2006-01-02"
+	}
+}
+
+// FormatValue formats an axis or summary value compactly, with SI suffixes
+// for large magnitudes
+func FormatValue(v float64) string {
+	if math.IsNaN(v) {
+		return "NaN"
+	}
+	abs := math.Abs(v)
+	for _, unit := range []struct {
+		scale  float64
+		suffix string
+	}{{1e12, "T"}, {1e9, "G"}, {1e6, "M"}, {1e3, "k"}} {
+		if abs >= unit.scale {
+			return strconv.FormatFloat(v/unit.scale, 'g', 4, 64) + unit.suffix
+		}
+	}
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
@@ -0,0 +1,156 @@
+package chart
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNiceTicks(t *testing.T) {
+	tests := []struct {
+		min, max float64
+		want     []float64
+	}{
+		{0, 100, []float64{0, 20, 40, 60, 80, 100}},
+		{0.1, 0.87, []float64{0, 0.2, 0.4, 0.6, 0.8, 1}},
+		{-3, 12, []float64{-5, 0, 5, 10, 15}},
+		{5, 5, []float64{4.4, 4.6, 4.8, 5, 5.2, 5.4, 5.6}},
+		{0, 0, []float64{-1, -0.5, 0, 0.5, 1}},
+	}
+	for _, tt := range tests {
+		if got := NiceTicks(tt.min, tt.max, 5); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NiceTicks(%v, %v) = %v, want %v", tt.min, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := map[float64]string{
+		0:          "0",
+		0.25:       "0.25",
+		1234:       "1.234k",
+		-2500000:   "-2.5M",
+		3.2e9:      "3.2G",
+		math.NaN(): "NaN",
+	}
+	for v, want := range tests {
+		if got := FormatValue(v); got != want {
+			t.Errorf("FormatValue(%v) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+// recorder is a Canvas that records what is drawn
+type recorder struct {
+	polylines [][][2]float64
+	texts     []string
+}
+
+func (r *recorder) Line(x1, y1, x2, y2, width float64, c Color) {}
+func (r *recorder) Rect(x, y, w, h float64, fill Color)         {}
+func (r *recorder) Polyline(points [][2]float64, width float64, c Color) {
+	r.polylines = append(r.polylines, points)
+}
+func (r *recorder) Text(x, y, size float64, bold bool, anchor Anchor, c Color, s string) {
+	r.texts = append(r.texts, s)
+}
+
+func TestLayout(t *testing.T) {
+	origin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(min int) time.Time { return origin.Add(time.Duration(min) * time.Minute) }
+	ch := Chart{
+		Title: "request-rate",
+		Series: []Series{
+			{Name: `pod="a"`, Points: []Point{{at(0), 0}, {at(30), 10}, {at(60), math.NaN()}, {at(90), 5}, {at(120), 8}}},
+			{Name: `pod="b"`, Points: []Point{{at(0), 4}, {at(120), 4}}},
+		},
+	}
+	d := ch.Layout()
+	if d.Width != DefaultWidth || d.Height != DefaultHeight+2*legendRow {
+		t.Errorf("size = %vx%v", d.Width, d.Height)
+	}
+
+	var rec recorder
+	d.Draw(&rec)
+	// The NaN splits the first series in two
+	if len(rec.polylines) != 3 || len(rec.polylines[0]) != 2 || len(rec.polylines[1]) != 2 {
+		t.Fatalf("polylines = %v", rec.polylines)
+	}
+	first := rec.polylines[0][0]
+	if first[0] != marginLeft || first[1] != DefaultHeight-marginBottom {
+		t.Errorf("first point at %v, want the bottom left of the plot", first)
+	}
+	for _, want := range []string{"request-rate", "00:00", "00:30", "02:00", "10", `pod="b"`} {
+		if !contains(rec.texts, want) {
+			t.Errorf("missing text %q in %q", want, rec.texts)
+		}
+	}
+}
+
+func TestLayout_NoData(t *testing.T) {
+	var rec recorder
+	(&Chart{Series: []Series{{Name: "x", Points: []Point{{time.Now(), math.NaN()}}}}}).Layout().Draw(&rec)
+	if len(rec.polylines) != 0 || !contains(rec.texts, "No data") {
+		t.Errorf("drew %v, %q", rec.polylines, rec.texts)
+	}
+}
+
+func TestLayout_LegendLimit(t *testing.T) {
+	var ch Chart
+	for i := 0; i < MaxLegendEntries+3; i++ {
+		ch.Series = append(ch.Series, Series{Name: string(rune('a' + i)), Points: []Point{{time.Now(), 1}}})
+	}
+	var rec recorder
+	d := ch.Layout()
+	d.Draw(&rec)
+	if !contains(rec.texts, "+3 more series") || d.Height != DefaultHeight+(MaxLegendEntries+1)*legendRow {
+		t.Errorf("legend = %q, height %v", rec.texts, d.Height)
+	}
+}
+
+func TestSVG(t *testing.T) {
+	ch := Chart{
+		Title:  "<script>",
+		Series: []Series{{Name: `path="/a&b"`, Points: []Point{{time.Unix(0, 0), 1}, {time.Unix(60, 0), 2}}}},
+	}
+	var b strings.Builder
+	if err := ch.Layout().SVG(&b); err != nil {
+		t.Fatal(err)
+	}
+	svg := b.String()
+	if strings.Contains(svg, "<script>") || !strings.Contains(svg, "&lt;script&gt;") {
+		t.Error("text not escaped")
+	}
+
+	// The output must be well-formed XML
+	dec := xml.NewDecoder(strings.NewReader(svg))
+	polylines := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatalf("invalid SVG: %v", err)
+			}
+			break
+		}
+		if el, ok := tok.(xml.StartElement); ok && el.Name.Local == "polyline" {
+			polylines++
+		}
+	}
+	if polylines != 1 {
+		t.Errorf("got %d polylines, want 1", polylines)
+	}
+}
+
+func contains(texts []string, s string) bool {
+	for _, t := range texts {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
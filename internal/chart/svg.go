@@ -0,0 +1,71 @@
+package chart
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// SVG writes the drawing as a standalone SVG element, suitable for inlining
+// into HTML
+func (d *Drawing) SVG(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="0 0 %s %s" font-family="Helvetica, Arial, sans-serif">`,
+		num(d.Width), num(d.Height), num(d.Width), num(d.Height))
+	bw.WriteByte('\n')
+	d.Draw(&svgCanvas{w: bw})
+	bw.WriteString("</svg>\n")
+	return bw.Flush()
+}
+
+// svgCanvas writes primitives as SVG elements
+type svgCanvas struct {
+	w *bufio.Writer
+}
+
+func (c *svgCanvas) Line(x1, y1, x2, y2, width float64, col Color) {
+	fmt.Fprintf(c.w, `<line x1="%s" y1="%s" x2="%s" y2="%s" stroke="%s" stroke-width="%s"/>`+"\n",
+		num(x1), num(y1), num(x2), num(y2), col.Hex(), num(width))
+}
+
+func (c *svgCanvas) Polyline(points [][2]float64, width float64, col Color) {
+	c.w.WriteString(`<polyline fill="none" stroke-linejoin="round" points="`)
+	for i, p := range points {
+		if i > 0 {
+			c.w.WriteByte(' ')
+		}
+		c.w.WriteString(num(p[0]))
+		c.w.WriteByte(',')
+		c.w.WriteString(num(p[1]))
+	}
+	fmt.Fprintf(c.w, `" stroke="%s" stroke-width="%s"/>`+"\n", col.Hex(), num(width))
+}
+
+func (c *svgCanvas) Rect(x, y, w, h float64, fill Color) {
+	fmt.Fprintf(c.w, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`+"\n",
+		num(x), num(y), num(w), num(h), fill.Hex())
+}
+
+func (c *svgCanvas) Text(x, y, size float64, bold bool, anchor Anchor, col Color, s string) {
+	fmt.Fprintf(c.w, `<text x="%s" y="%s" font-size="%s" fill="%s"`, num(x), num(y), num(size), col.Hex())
+	if bold {
+		c.w.WriteString(` font-weight="bold"`)
+	}
+	switch anchor {
+	case AnchorMiddle:
+		c.w.WriteString(` text-anchor="middle"`)
+	case AnchorEnd:
+		c.w.WriteString(` text-anchor="end"`)
+	}
+	c.w.WriteByte('>')
+	xml.EscapeText(c.w, []byte(s))
+	c.w.WriteString("</text>\n")
+}
+
+// num formats a coordinate to two decimal places, trimming zeros
+func num(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// LogEntry is one log line returned for a log panel
+type LogEntry struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Line      string            `json:"line"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// LogsResponse holds the most recent log lines matching a log panel's
+// query, newest first
+type LogsResponse struct {
+	Application string     `json:"application"`
+	Project     string     `json:"project"`
+	Graph       string     `json:"graph"`
+	Entries     []LogEntry `json:"entries"`
+	// Truncated is set when more lines matched than were returned
+	Truncated bool `json:"truncated"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// TimeRange restricts a metrics query to a time window
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Step is the resolution of the returned points; zero leaves it to the
+	// provider or the graph definition
+	Step time.Duration `json:"step,omitempty"`
+}
+
+// Contains reports whether t is within the range, including both ends
+func (r TimeRange) Contains(t time.Time) bool {
+	return !t.Before(r.Start) && !t.After(r.End)
+}
@@ -0,0 +1,63 @@
+package pdf
+
+// helveticaWidths are the advance widths of Helvetica for ASCII 32-126, in
+// thousandths of the font size, from the Adobe font metrics
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space - /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0 - ?
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @ - O
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P - _
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // ` - o
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p - ~
+}
+
+// winAnsiExtras maps the characters WinAnsiEncoding places in 0x80-0x9f,
+// with their widths
+var winAnsiExtras = map[rune]struct {
+	code  byte
+	width int
+}{
+	'€': {0x80, 556}, '…': {0x85, 1000}, '‘': {0x91, 222}, '’': {0x92, 222},
+	'“': {0x93, 333}, '”': {0x94, 333}, '•': {0x95, 350}, '–': {0x96, 556},
+	'—': {0x97, 1000}, '™': {0x99, 1000},
+}
+
+// winAnsi encodes s for the standard fonts. Characters outside the encoding
+// become '?'.
+func winAnsi(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r >= 0x20 && r <= 0x7e, r >= 0xa0 && r <= 0xff:
+			b = append(b, byte(r))
+		default:
+			if e, ok := winAnsiExtras[r]; ok {
+				b = append(b, e.code)
+			} else {
+				b = append(b, '?')
+			}
+		}
+	}
+	return string(b)
+}
+
+// TextWidth returns the width of s set in Helvetica at size. Bold text is
+// slightly wider; Latin-1 letters are approximated by the average width.
+func TextWidth(s string, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		switch {
+		case r >= 0x20 && r <= 0x7e:
+			total += helveticaWidths[r-0x20]
+		case r >= 0xa0 && r <= 0xff:
+			total += 556
+		default:
+			if e, ok := winAnsiExtras[r]; ok {
+				total += e.width
+			} else {
+				total += helveticaWidths['?'-0x20]
+			}
+		}
+	}
+	return float64(total) * size / 1000
+}
@@ -0,0 +1,252 @@
+// Package pdf writes simple PDF 1.4 documents of vector graphics and text
+// in the standard Helvetica fonts, which every PDF reader provides, so no
+// fonts are embedded.
+//
+// Coordinates are in points with the origin at the top left of the page and
+// y growing downwards, as in SVG; they are flipped when written.
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Page sizes in points
+const (
+	A4Width  = 595.28
+	A4Height = 841.89
+)
+
+// Align is the horizontal alignment of text relative to its position
+type Align int
+
+const (
+	AlignLeft Align = iota
+	AlignCenter
+	AlignRight
+)
+
+// Font resource names
+const (
+	fontRegular = "F1"
+	fontBold    = "F2"
+)
+
+// Document is a PDF document under construction
+type Document struct {
+	// Title and Created are written to the document information dictionary
+	Title   string
+	Created time.Time
+	pages   []*Page
+}
+
+// New creates an empty document
+func New(title string) *Document {
+	return &Document{Title: title, Created: time.Now()}
+}
+
+// AddPage appends a page of the given size
+func (d *Document) AddPage(width, height float64) *Page {
+	p := &Page{width: width, height: height}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Page is one page of a document. Drawing methods append to its content
+// stream.
+type Page struct {
+	width, height float64
+	content       bytes.Buffer
+}
+
+// Size returns the page width and height
+func (p *Page) Size() (width, height float64) {
+	return p.width, p.height
+}
+
+// Line strokes a line of the given width and color
+func (p *Page) Line(x1, y1, x2, y2, width float64, r, g, b uint8) {
+	p.stroke(width, r, g, b)
+	fmt.Fprintf(&p.content, "%s %s m %s %s l S\n", num(x1), num(p.height-y1), num(x2), num(p.height-y2))
+}
+
+// Polyline strokes connected line segments through points
+func (p *Page) Polyline(points [][2]float64, width float64, r, g, b uint8) {
+	if len(points) == 0 {
+		return
+	}
+	p.stroke(width, r, g, b)
+	p.content.WriteString("1 j\n")
+	for i, pt := range points {
+		op := "l"
+		if i == 0 {
+			op = "m"
+		}
+		fmt.Fprintf(&p.content, "%s %s %s\n", num(pt[0]), num(p.height-pt[1]), op)
+	}
+	if len(points) == 1 {
+		// A lone point is drawn as a dot
+		fmt.Fprintf(&p.content, "%s %s l\n", num(points[0][0]+width/2), num(p.height-points[0][1]))
+	}
+	p.content.WriteString("S\n")
+}
+
+// Rect fills a rectangle whose top left corner is at x, y
+func (p *Page) Rect(x, y, w, h float64, r, g, b uint8) {
+	fmt.Fprintf(&p.content, "%s rg %s %s %s %s re f\n", rgb(r, g, b), num(x), num(p.height-y-h), num(w), num(h))
+}
+
+// Text draws a single line of text with its baseline at y
+func (p *Page) Text(x, y, size float64, bold bool, align Align, r, g, b uint8, s string) {
+	encoded := winAnsi(s)
+	switch align {
+	case AlignCenter:
+		x -= TextWidth(s, size) / 2
+	case AlignRight:
+		x -= TextWidth(s, size)
+	}
+	font := fontRegular
+	if bold {
+		font = fontBold
+	}
+	fmt.Fprintf(&p.content, "BT %s rg /%s %s Tf %s %s Td (%s) Tj ET\n",
+		rgb(r, g, b), font, num(size), num(x), num(p.height-y), escape(encoded))
+}
+
+func (p *Page) stroke(width float64, r, g, b uint8) {
+	fmt.Fprintf(&p.content, "%s w %s RG\n", num(width), rgb(r, g, b))
+}
+
+// WriteTo writes the document
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	pw := &pdfWriter{w: bufio.NewWriter(w)}
+
+	// Objects 1-5 are fixed; each page then takes a page and a content
+	// object
+	const (
+		catalogObj = 1
+		pagesObj   = 2
+		regularObj = 3
+		boldObj    = 4
+		infoObj    = 5
+		firstPage  = 6
+	)
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+	pw.object(catalogObj, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesObj))
+	pw.object(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	pw.object(regularObj, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	pw.object(boldObj, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	pw.object(infoObj, fmt.Sprintf("<< /Title %s /Producer (argocd-observability-extensions) /CreationDate (D:%s) >>",
+		textString(d.Title), d.Created.UTC().Format("20060102150405Z")))
+
+	for i, page := range d.pages {
+		pageObj := firstPage + 2*i
+		pw.object(pageObj, fmt.Sprintf(
+			"<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /%s %d 0 R /%s %d 0 R >> >> /Contents %d 0 R >>",
+			pagesObj, num(page.width), num(page.height), fontRegular, regularObj, fontBold, boldObj, pageObj+1))
+
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(page.content.Bytes())
+		zw.Close()
+		pw.stream(pageObj+1, compressed.Bytes())
+	}
+
+	size := firstPage + 2*len(d.pages)
+	xref := pw.n
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", size)
+	for _, offset := range pw.offsets {
+		pw.printf("%010d 00000 n \n", offset)
+	}
+	pw.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", size, catalogObj, infoObj, xref)
+
+	if pw.err != nil {
+		return pw.n, pw.err
+	}
+	return pw.n, pw.w.Flush()
+}
+
+// pdfWriter tracks the offset of each object for the cross-reference table
+type pdfWriter struct {
+	w       *bufio.Writer
+	n       int64
+	offsets []int64
+	err     error
+}
+
+func (pw *pdfWriter) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(pw.w, format, args...)
+	pw.n += int64(n)
+	pw.err = err
+}
+
+// object writes object number id, which must follow the previous one
+func (pw *pdfWriter) object(id int, dict string) {
+	pw.offsets = append(pw.offsets, pw.n)
+	pw.printf("%d 0 obj\n%s\nendobj\n", id, dict)
+}
+
+func (pw *pdfWriter) stream(id int, data []byte) {
+	pw.offsets = append(pw.offsets, pw.n)
+	pw.printf("%d 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", id, len(data))
+	if pw.err == nil {
+		n, err := pw.w.Write(data)
+		pw.n += int64(n)
+		pw.err = err
+	}
+	pw.printf("\nendstream\nendobj\n")
+}
+
+// num formats a number to two decimal places, trimming zeros
+func num(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+func rgb(r, g, b uint8) string {
+	c := func(v uint8) string { return strconv.FormatFloat(float64(v)/255, 'f', 3, 64) }
+	return c(r) + " " + c(g) + " " + c(b)
+}
+
+// textString encodes s for the information dictionary: as a literal when
+// it is ASCII, otherwise in UTF-16 with a byte order mark
+func textString(s string) string {
+	ascii := true
+	for _, r := range s {
+		if r < 0x20 || r > 0x7e {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return "(" + escape(s) + ")"
+	}
+
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+// escape escapes a string literal's delimiters
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`, "\r", `\r`, "\n", `\n`).Replace(s)
+}
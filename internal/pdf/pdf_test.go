@@ -0,0 +1,119 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDocument_WriteTo(t *testing.T) {
+	doc := New("Incident (review)")
+	doc.Created = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	page := doc.AddPage(A4Height, A4Width)
+	page.Text(36, 50, 18, true, AlignLeft, 0, 0, 0, `p99 \ latency (ms)`)
+	page.Line(36, 60, 300, 60, 1, 0xff, 0, 0)
+	page.Polyline([][2]float64{{36, 100}, {100, 150}}, 1.5, 0, 0, 0xff)
+	doc.AddPage(A4Height, A4Width).Rect(10, 20, 30, 40, 0, 0x80, 0)
+
+	var buf bytes.Buffer
+	n, err := doc.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	if n != int64(len(out)) {
+		t.Errorf("WriteTo returned %d, wrote %d bytes", n, len(out))
+	}
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatalf("missing header or trailer")
+	}
+
+	// startxref must point at the table, and each entry at its object
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	table := string(out[xref:])
+	if !strings.HasPrefix(table, "xref\n0 10\n") {
+		t.Fatalf("startxref %d points at %q", xref, table[:20])
+	}
+	entries := strings.Split(table, "\n")[3:12]
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(entry[:10])
+		want := strconv.Itoa(i+1) + " 0 obj"
+		if !bytes.HasPrefix(out[offset:], []byte(want)) {
+			t.Errorf("xref entry %d points at %q", i+1, out[offset:offset+10])
+		}
+	}
+
+	if !bytes.Contains(out, []byte(`/Title (Incident \(review\))`)) {
+		t.Error("title not escaped into the info dictionary")
+	}
+
+	// Coordinates are flipped: y=50 on a 595.28pt page is 545.28
+	content := pageContent(t, out, 0)
+	for _, want := range []string{
+		`/F2 18 Tf 36 545.28 Td (p99 \\ latency \(ms\)) Tj`,
+		"36 535.28 m 300 535.28 l S",
+		"1.000 0.000 0.000 RG",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("page content missing %q:\n%s", want, content)
+		}
+	}
+	if content := pageContent(t, out, 1); !strings.Contains(content, "10 535.28 30 40 re f") {
+		t.Errorf("second page content = %s", content)
+	}
+}
+
+// pageContent inflates the content stream of the i-th page
+func pageContent(t *testing.T, out []byte, i int) string {
+	t.Helper()
+	streams := regexp.MustCompile(`(?s)/Length (\d+) /Filter /FlateDecode >>\nstream\n`).FindAllSubmatchIndex(out, -1)
+	if i >= len(streams) {
+		t.Fatalf("no content stream %d", i)
+	}
+	length, _ := strconv.Atoi(string(out[streams[i][2]:streams[i][3]]))
+	start := streams[i][1]
+	zr, err := zlib.NewReader(bytes.NewReader(out[start : start+length]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestText_Alignment(t *testing.T) {
+	doc := New("")
+	page := doc.AddPage(100, 100)
+	// "ab" is 1112/1000 of the font size wide
+	page.Text(50, 10, 10, false, AlignCenter, 0, 0, 0, "ab")
+	page.Text(50, 20, 10, false, AlignRight, 0, 0, 0, "ab")
+	content := page.content.String()
+	for _, want := range []string{"44.44 90 Td (ab)", "38.88 80 Td (ab)"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("content missing %q:\n%s", want, content)
+		}
+	}
+}
+
+func TestTextEncoding(t *testing.T) {
+	if got := winAnsi("né – 5€ ✓"); got != "n\xe9 \x96 5\x80 ?" {
+		t.Errorf("winAnsi = %q", got)
+	}
+	if got := textString("né"); got != "<FEFF006E00E9>" {
+		t.Errorf("textString = %q", got)
+	}
+	if w := TextWidth("Hi", 10); w != 9.44 {
+		t.Errorf("TextWidth = %v, want 9.44", w)
+	}
+}
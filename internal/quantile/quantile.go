@@ -0,0 +1,194 @@
+// Package quantile estimates quantiles of streams of values in bounded
+// memory.
+package quantile
+
+import (
+	"math"
+	"sort"
+)
+
+// minIndexable is the smallest magnitude given its own bucket; smaller
+// values are counted as zero
+const minIndexable = 1e-12
+
+// Sketch estimates quantiles with a bounded relative error, as DDSketch
+// does: values are counted in buckets whose bounds grow geometrically, so
+// every estimate is within the relative accuracy of a value of the
+// requested rank. Memory grows with the logarithm of the range of values,
+// not with their number.
+type Sketch struct {
+	gamma    float64
+	logGamma float64
+	// positive and negative count values by bucket index of their magnitude
+	positive map[int]uint64
+	negative map[int]uint64
+	zeros    uint64
+	count    uint64
+	min, max float64
+}
+
+// NewSketch creates a sketch with the given relative accuracy, such as 0.01
+// for estimates within 1%
+func NewSketch(relativeAccuracy float64) *Sketch {
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		relativeAccuracy = 0.01
+	}
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+	return &Sketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		positive: make(map[int]uint64),
+		negative: make(map[int]uint64),
+		min:      math.Inf(1),
+		max:      math.Inf(-1),
+	}
+}
+
+// Add counts a value. NaN and infinite values are ignored.
+func (s *Sketch) Add(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	switch {
+	case v > minIndexable:
+		s.positive[s.index(v)]++
+	case v < -minIndexable:
+		s.negative[s.index(-v)]++
+	default:
+		s.zeros++
+	}
+	s.count++
+	s.min, s.max = math.Min(s.min, v), math.Max(s.max, v)
+}
+
+// Count returns the number of values added
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// index returns the bucket (gamma^(i-1), gamma^i] holding magnitude v
+func (s *Sketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value returns the estimate for bucket i, equidistant in relative terms
+// from its bounds
+func (s *Sketch) value(i int) float64 {
+	return 2 * math.Pow(s.gamma, float64(i)) / (s.gamma + 1)
+}
+
+// Quantile estimates the q-quantile, for q between 0 and 1, as a value of
+// rank q*(n-1). It returns NaN for an empty sketch.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+
+	rank := uint64(q * float64(s.count-1))
+	var seen uint64
+	estimate := math.NaN()
+
+	// Negative values from the largest magnitude down, then zeros, then
+	// positive values upwards
+	for _, i := range sortedKeys(s.negative, true) {
+		if seen += s.negative[i]; seen > rank {
+			estimate = -s.value(i)
+			break
+		}
+	}
+	if math.IsNaN(estimate) {
+		if seen += s.zeros; seen > rank {
+			estimate = 0
+		}
+	}
+	if math.IsNaN(estimate) {
+		for _, i := range sortedKeys(s.positive, false) {
+			if seen += s.positive[i]; seen > rank {
+				estimate = s.value(i)
+				break
+			}
+		}
+	}
+	// Bucket estimates may fall just outside the observed values
+	return math.Max(s.min, math.Min(s.max, estimate))
+}
+
+func sortedKeys(buckets map[int]uint64, descending bool) []int {
+	keys := make([]int, 0, len(buckets))
+	for i := range buckets {
+		keys = append(keys, i)
+	}
+	if descending {
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	} else {
+		sort.Ints(keys)
+	}
+	return keys
+}
+
+// Estimator computes exact quantiles of up to a limit of values, beyond
+// which it continues with a Sketch. Small series are thus exact, and large
+// ones accurate to the sketch's relative accuracy in constant memory.
+type Estimator struct {
+	limit    int
+	accuracy float64
+	values   []float64
+	sketch   *Sketch
+}
+
+// NewEstimator creates an estimator keeping up to limit values exactly
+func NewEstimator(limit int, relativeAccuracy float64) *Estimator {
+	return &Estimator{limit: limit, accuracy: relativeAccuracy}
+}
+
+// Add adds a value. NaN and infinite values are ignored.
+func (e *Estimator) Add(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	if e.sketch != nil {
+		e.sketch.Add(v)
+		return
+	}
+	e.values = append(e.values, v)
+	if len(e.values) > e.limit {
+		e.sketch = NewSketch(e.accuracy)
+		for _, v := range e.values {
+			e.sketch.Add(v)
+		}
+		e.values = nil
+	}
+}
+
+// Exact reports whether quantiles are computed exactly
+func (e *Estimator) Exact() bool {
+	return e.sketch == nil
+}
+
+// Quantile returns the q-quantile, interpolating linearly between the
+// closest ranks while exact. It returns NaN when no values were added.
+func (e *Estimator) Quantile(q float64) float64 {
+	if e.sketch != nil {
+		return e.sketch.Quantile(q)
+	}
+	if len(e.values) == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	if !sort.Float64sAreSorted(e.values) {
+		sort.Float64s(e.values)
+	}
+	q = math.Max(0, math.Min(1, q))
+	pos := q * float64(len(e.values)-1)
+	lower := int(math.Floor(pos))
+	if lower == len(e.values)-1 {
+		return e.values[lower]
+	}
+	frac := pos - float64(lower)
+	return e.values[lower] + frac*(e.values[lower+1]-e.values[lower])
+}
@@ -0,0 +1,90 @@
+package quantile
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSketch_RelativeAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name string
+		gen  func() float64
+	}{
+		{"uniform", func() float64 { return rng.Float64() * 1000 }},
+		{"lognormal latency", func() float64 { return math.Exp(rng.NormFloat64()*2) / 1000 }},
+		{"mixed sign", func() float64 { return rng.NormFloat64() * 50 }},
+	}
+	const accuracy = 0.01
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSketch(accuracy)
+			values := make([]float64, 100000)
+			for i := range values {
+				values[i] = tt.gen()
+				s.Add(values[i])
+			}
+			sort.Float64s(values)
+
+			for _, q := range []float64{0, 0.01, 0.25, 0.5, 0.95, 0.99, 0.999, 1} {
+				got := s.Quantile(q)
+				want := values[int(q*float64(len(values)-1))]
+				if math.Abs(got-want) > accuracy*math.Abs(want)+1e-9 {
+					t.Errorf("q%v = %v, want %v within %v", q, got, want, accuracy)
+				}
+			}
+			if s.Count() != uint64(len(values)) || len(s.positive)+len(s.negative) > 2000 {
+				t.Errorf("count %d, %d buckets", s.Count(), len(s.positive)+len(s.negative))
+			}
+		})
+	}
+}
+
+func TestSketch_Edges(t *testing.T) {
+	s := NewSketch(0.01)
+	if !math.IsNaN(s.Quantile(0.5)) {
+		t.Error("empty sketch has a median")
+	}
+	for _, v := range []float64{0, 0, 0, math.NaN(), math.Inf(1), 5} {
+		s.Add(v)
+	}
+	if s.Count() != 4 || s.Quantile(0.5) != 0 || s.Quantile(1) != 5 {
+		t.Errorf("count %d, median %v, max %v", s.Count(), s.Quantile(0.5), s.Quantile(1))
+	}
+	// A single value is returned exactly thanks to the min/max clamp
+	s = NewSketch(0.01)
+	s.Add(42.5)
+	if got := s.Quantile(0.99); got != 42.5 {
+		t.Errorf("q0.99 = %v", got)
+	}
+}
+
+func TestEstimator(t *testing.T) {
+	e := NewEstimator(10, 0.01)
+	for _, v := range []float64{5, 1, 4, 2, 3} {
+		e.Add(v)
+	}
+	if !e.Exact() {
+		t.Fatal("estimator switched to a sketch early")
+	}
+	for q, want := range map[float64]float64{0: 1, 0.5: 3, 0.9: 4.6, 1: 5} {
+		if got := e.Quantile(q); math.Abs(got-want) > 1e-12 {
+			t.Errorf("q%v = %v, want %v", q, got, want)
+		}
+	}
+
+	for i := 6; i <= 1000; i++ {
+		e.Add(float64(i))
+	}
+	if e.Exact() {
+		t.Fatal("estimator kept more values than its limit")
+	}
+	if got := e.Quantile(0.5); math.Abs(got-500) > 5 {
+		t.Errorf("median = %v, want about 500", got)
+	}
+	if !math.IsNaN(NewEstimator(10, 0.01).Quantile(0.5)) {
+		t.Error("empty estimator has a median")
+	}
+}
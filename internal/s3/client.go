@@ -0,0 +1,137 @@
+// Package s3 archives exports to AWS S3 or an S3-compatible store such as
+// MinIO or Ceph, using the MinIO client.
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	defaultRegion = "us-east-1"
+	// partSize is the size of each part of a multipart upload. Objects of
+	// unknown length are buffered one part at a time.
+	partSize = 16 << 20
+)
+
+// Config configures a Client
+type Config struct {
+	// Endpoint is the base URL of the store, e.g. http://minio:9000.
+	// Defaults to the AWS endpoint of Region.
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+	// Region is used for signing (default us-east-1)
+	Region string `yaml:"region" json:"region"`
+	Bucket string `yaml:"bucket" json:"bucket"`
+	// PathStyle addresses the bucket in the path instead of the host name,
+	// as most S3-compatible stores require
+	PathStyle bool `yaml:"pathStyle" json:"pathStyle"`
+	// Credentials default to AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+	// AWS_SESSION_TOKEN from the environment
+	AccessKeyID     string `yaml:"accessKeyID" json:"accessKeyID"`
+	SecretAccessKey string `yaml:"secretAccessKey" json:"-"`
+	SessionToken    string `yaml:"sessionToken" json:"-"`
+}
+
+// Client uploads objects to a single bucket
+type Client struct {
+	cfg      Config
+	minio    *minio.Client
+	partSize uint64
+}
+
+// New creates a client for cfg.Bucket
+func New(cfg Config) (*Client, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3: bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.AccessKeyID == "" && cfg.SecretAccessKey == "" {
+		cfg.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("s3: access key ID and secret access key are required")
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", cfg.Endpoint)
+	}
+	if strings.Trim(endpoint.Path, "/") != "" {
+		return nil, fmt.Errorf("s3: endpoint %q must not have a path", cfg.Endpoint)
+	}
+
+	lookup := minio.BucketLookupDNS
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	mc, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken),
+		Secure:       endpoint.Scheme == "https",
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	return &Client{cfg: cfg, minio: mc, partSize: partSize}, nil
+}
+
+// Bucket returns the bucket objects are written to
+func (c *Client) Bucket() string {
+	return c.cfg.Bucket
+}
+
+// Error is an error response from the store
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// PutObject uploads body under key, replacing any existing object. body is
+// streamed with a multipart upload, so its length need not be known.
+func (c *Client) PutObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("s3: invalid object key %q", key)
+	}
+
+	_, err := c.minio.PutObject(ctx, c.cfg.Bucket, key, body, -1, minio.PutObjectOptions{
+		ContentType: contentType,
+		PartSize:    c.partSize,
+	})
+	if err != nil {
+		return responseError(err)
+	}
+	return nil
+}
+
+func responseError(err error) error {
+	var resp minio.ErrorResponse
+	if !errors.As(err, &resp) || resp.StatusCode == 0 {
+		return fmt.Errorf("s3: %w", err)
+	}
+	return &Error{StatusCode: resp.StatusCode, Code: resp.Code, Message: resp.Message}
+}
@@ -0,0 +1,264 @@
+// Package signing creates and verifies detached Ed25519 signatures of
+// exported files, so that an export can be shown to come unmodified from
+// the metrics server.
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Algorithm is the signature algorithm recorded in envelopes
+const Algorithm = "ed25519"
+
+// envelopeVersion is the version of the signed payload format
+const envelopeVersion = 1
+
+// payloadPrefix separates export signatures from any other use of the key
+const payloadPrefix = "argocd-metrics-export-signature-v1\n"
+
+// Metadata describes a signed export. It includes the SHA-256 and size of
+// the file, so the signature covers the file bytes as well as the metadata.
+type Metadata struct {
+	Application string    `json:"application"`
+	Project     string    `json:"project"`
+	GroupKind   string    `json:"groupkind,omitempty"`
+	Row         string    `json:"row,omitempty"`
+	Graph       string    `json:"graph,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Path        string    `json:"path"`
+	Query       string    `json:"query,omitempty"`
+	Filename    string    `json:"filename,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	ExportedAt  time.Time `json:"exported_at"`
+	SizeBytes   int64     `json:"size_bytes"`
+	SHA256      string    `json:"sha256"`
+}
+
+// Envelope is a detached signature
+type Envelope struct {
+	Version   int      `json:"version"`
+	Algorithm string   `json:"algorithm"`
+	KeyID     string   `json:"key_id"`
+	Metadata  Metadata `json:"metadata"`
+	// Signature is the base64 Ed25519 signature of the payload prefix
+	// followed by the JSON encoding of Metadata
+	Signature string `json:"signature"`
+}
+
+// Verification errors
+var (
+	ErrUnknownKey       = errors.New("signature made with an unknown key")
+	ErrContentMismatch  = errors.New("file does not match the signed checksum")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// Signer signs exports with an Ed25519 key
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// NewSigner creates a signer for key
+func NewSigner(key ed25519.PrivateKey) *Signer {
+	return &Signer{key: key, keyID: KeyID(key.Public().(ed25519.PublicKey))}
+}
+
+// KeyID returns the ID of the signing key
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// PublicKey returns the public half of the signing key
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// Sign returns a signature of content described by meta, whose size and
+// checksum are filled in
+func (s *Signer) Sign(meta Metadata, content []byte) (*Envelope, error) {
+	sum := sha256.Sum256(content)
+	meta.SHA256 = hex.EncodeToString(sum[:])
+	meta.SizeBytes = int64(len(content))
+	meta.ExportedAt = meta.ExportedAt.UTC()
+
+	payload, err := signedPayload(meta)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		Version:   envelopeVersion,
+		Algorithm: Algorithm,
+		KeyID:     s.keyID,
+		Metadata:  meta,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, payload)),
+	}, nil
+}
+
+// Verify checks that env is a valid signature of content by one of keys
+func Verify(env *Envelope, content []byte, keys []ed25519.PublicKey) error {
+	if env.Version != envelopeVersion || env.Algorithm != Algorithm {
+		return fmt.Errorf("unsupported signature version %d or algorithm %q", env.Version, env.Algorithm)
+	}
+	var key ed25519.PublicKey
+	for _, k := range keys {
+		if KeyID(k) == env.KeyID {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		return fmt.Errorf("%w %s", ErrUnknownKey, env.KeyID)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(env.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	payload, err := signedPayload(env.Metadata)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, payload, sig) {
+		return ErrInvalidSignature
+	}
+
+	sum := sha256.Sum256(content)
+	if hex.EncodeToString(sum[:]) != env.Metadata.SHA256 || int64(len(content)) != env.Metadata.SizeBytes {
+		return ErrContentMismatch
+	}
+	return nil
+}
+
+// signedPayload returns the bytes signed for meta
+func signedPayload(meta Metadata) ([]byte, error) {
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signature metadata: %w", err)
+	}
+	return append([]byte(payloadPrefix), encoded...), nil
+}
+
+// KeyID identifies a public key by the first 8 bytes of its SHA-256, in hex
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS #8 Ed25519 private key, as
+// written by openssl genpkey -algorithm ed25519
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("no PEM PRIVATE KEY block found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is %T, not Ed25519", key)
+	}
+	return edKey, nil
+}
+
+// ParsePublicKey parses a PEM encoded PKIX Ed25519 public key
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM PUBLIC KEY block found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is %T, not Ed25519", key)
+	}
+	return edKey, nil
+}
+
+// MarshalPublicKey encodes key as PEM
+func MarshalPublicKey(key ed25519.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// JWK is an Ed25519 public key as a JSON Web Key (RFC 8037)
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK returns key as a JSON Web Key identified by its KeyID
+func NewJWK(key ed25519.PublicKey) JWK {
+	return JWK{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(key),
+		Kid: KeyID(key),
+		Use: "sig",
+		Alg: "EdDSA",
+	}
+}
+
+// PublicKey decodes an Ed25519 JSON Web Key
+func (k JWK) PublicKey() (ed25519.PublicKey, error) {
+	if k.Kty != "OKP" || k.Crv != "Ed25519" {
+		return nil, fmt.Errorf("key %s is not an Ed25519 key", k.Kid)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil || len(x) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("key %s has an invalid x", k.Kid)
+	}
+	return ed25519.PublicKey(x), nil
+}
+
+// Encode returns the envelope as base64 encoded JSON, for use in a header
+func (env *Envelope) Encode() (string, error) {
+	encoded, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encoded), nil
+}
+
+// ParseEnvelope parses a signature as JSON or in its base64 header form
+func ParseEnvelope(data []byte) (*Envelope, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' {
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("signature is neither JSON nor base64: %w", err)
+		}
+		data = decoded
+	}
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	return &env, nil
+}
@@ -0,0 +1,121 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T, seed byte) ed25519.PrivateKey {
+	t.Helper()
+	s := make([]byte, ed25519.SeedSize)
+	for i := range s {
+		s[i] = seed
+	}
+	return ed25519.NewKeyFromSeed(s)
+}
+
+func TestSignVerify(t *testing.T) {
+	key := testKey(t, 1)
+	signer := NewSigner(key)
+	content := []byte("Timestamp,Value\n2024-01-01T00:00:00Z,1\n")
+	meta := Metadata{
+		Application: "payments",
+		Project:     "prod",
+		Graph:       "request-rate",
+		Path:        "/api/applications/payments/export",
+		ExportedAt:  time.Date(2024, 1, 1, 12, 0, 0, 123456789, time.FixedZone("CET", 3600)),
+	}
+
+	env, err := signer.Sign(meta, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Metadata.SizeBytes != int64(len(content)) || len(env.Metadata.SHA256) != 64 || env.KeyID != signer.KeyID() {
+		t.Errorf("envelope = %+v", env)
+	}
+
+	// Round trip through the header encoding, as a verifier receives it
+	encoded, err := env.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseEnvelope([]byte(encoded + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []ed25519.PublicKey{testKey(t, 2).Public().(ed25519.PublicKey), signer.PublicKey()}
+	if err := Verify(parsed, content, keys); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+
+	tampered := append([]byte(nil), content...)
+	tampered[len(tampered)-2] = '9'
+	if err := Verify(parsed, tampered, keys); !errors.Is(err, ErrContentMismatch) {
+		t.Errorf("edited file: err = %v", err)
+	}
+
+	forged := *parsed
+	forged.Metadata.Application = "checkout"
+	if err := Verify(&forged, content, keys); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("edited metadata: err = %v", err)
+	}
+
+	if err := Verify(parsed, content, keys[:1]); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown key: err = %v", err)
+	}
+}
+
+func TestParseEnvelope_JSON(t *testing.T) {
+	env, err := NewSigner(testKey(t, 1)).Sign(Metadata{Application: "a"}, []byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	indented, _ := json.MarshalIndent(env, "", "  ")
+	parsed, err := ParseEnvelope(indented)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(parsed, []byte("x"), []ed25519.PublicKey{testKey(t, 1).Public().(ed25519.PublicKey)}); err != nil {
+		t.Errorf("pretty-printed signature rejected: %v", err)
+	}
+	if _, err := ParseEnvelope([]byte("not a signature")); err == nil {
+		t.Error("garbage accepted")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	key := testKey(t, 3)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil || !parsed.Equal(key) {
+		t.Fatalf("ParsePrivateKey = %v, %v", parsed, err)
+	}
+
+	pub := key.Public().(ed25519.PublicKey)
+	pubPEM, err := MarshalPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsedPub, err := ParsePublicKey(pubPEM); err != nil || !parsedPub.Equal(pub) {
+		t.Errorf("ParsePublicKey = %v, %v", parsedPub, err)
+	}
+	if _, err := ParsePrivateKey(pubPEM); err == nil {
+		t.Error("public key accepted as private key")
+	}
+
+	jwk := NewJWK(pub)
+	if jwk.Kid != KeyID(pub) || jwk.Crv != "Ed25519" {
+		t.Errorf("jwk = %+v", jwk)
+	}
+	if fromJWK, err := jwk.PublicKey(); err != nil || !fromJWK.Equal(pub) {
+		t.Errorf("JWK round trip = %v, %v", fromJWK, err)
+	}
+}
@@ -0,0 +1,294 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+// Defaults for Graph
+const (
+	defaultGraphWindow = time.Hour
+	// defaultGraphPoints sets the step of queries without one
+	defaultGraphPoints = 300
+)
+
+var (
+	// ErrGraphNotFound is returned for graphs missing from an application's
+	// dashboards
+	ErrGraphNotFound = errors.New("graph not found")
+	// ErrInvalidName is returned when rendering a query for an application
+	// or project name Argo CD would not accept
+	ErrInvalidName = errors.New("invalid application or project name")
+)
+
+// maxNameLength bounds application and project names, as Kubernetes bounds
+// resource names
+const maxNameLength = 253
+
+// argoCDName matches the names of Argo CD applications and projects, which
+// are Kubernetes resource names. Query expressions interpolate them inside
+// quoted strings, such as app="{{.Application}}", so names that could leave
+// the quotes are never rendered.
+var argoCDName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+
+// DashboardConfig defines the graphs shown for applications, in the layout of
+// the upstream argocd-extension-metrics configuration. Query expressions are
+// in the language of the provider serving the dashboard.
+type DashboardConfig struct {
+	Applications []ApplicationDashboards `yaml:"applications" json:"applications"`
+}
+
+// ApplicationDashboards holds the dashboards of the applications matching
+// Name
+type ApplicationDashboards struct {
+	// Name is a glob pattern matched against the application name
+	Name string `yaml:"name" json:"name"`
+	// Default marks the dashboards of applications matching no other entry
+	Default bool `yaml:"default" json:"default"`
+	// Provider names the registry provider serving these dashboards,
+	// overriding the registry routes
+	Provider   string      `yaml:"provider" json:"provider,omitempty"`
+	Dashboards []Dashboard `yaml:"dashboards" json:"dashboards"`
+}
+
+// Dashboard holds the graphs of one resource kind
+type Dashboard struct {
+	GroupKind string         `yaml:"groupKind" json:"groupKind"`
+	Rows      []DashboardRow `yaml:"rows" json:"rows"`
+}
+
+// DashboardRow is a titled row of graphs
+type DashboardRow struct {
+	Name   string  `yaml:"name" json:"name"`
+	Title  string  `yaml:"title" json:"title"`
+	Graphs []Graph `yaml:"graphs" json:"graphs"`
+}
+
+// Graph is a single panel
+type Graph struct {
+	Name      string `yaml:"name" json:"name"`
+	Title     string `yaml:"title" json:"title"`
+	GraphType string `yaml:"graphType" json:"graphType"`
+	// MetricName is the label naming each series in the legend
+	MetricName string `yaml:"metricName" json:"metricName"`
+	// QueryExpression is a text/template rendered with QueryData
+	QueryExpression string `yaml:"queryExpression" json:"queryExpression"`
+	// Window is the time range shown without an explicit one (default 1h)
+	Window time.Duration `yaml:"window" json:"window"`
+
+	tmpl *template.Template
+}
+
+// QueryData is the data query expressions are rendered with. Application and
+// Project are valid Argo CD names, so they may be quoted as they are. Besides
+// its fields, templates may use duration (Prometheus-style, such as 5m),
+// seconds, unix and rfc3339 to format times and durations.
+type QueryData struct {
+	Application string
+	Project     string
+	GroupKind   string
+	Row         string
+	Graph       string
+	Start       time.Time
+	End         time.Time
+	Step        time.Duration
+	// Vars holds provider settings a query may need, such as the InfluxDB
+	// bucket, as {{.Vars.bucket}}
+	Vars map[string]string
+}
+
+var queryFuncs = template.FuncMap{
+	"duration": func(d time.Duration) string { return model.Duration(d).String() },
+	"seconds":  func(d time.Duration) int64 { return int64(d / time.Second) },
+	"unix":     func(t time.Time) int64 { return t.Unix() },
+	"rfc3339":  func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}
+
+// TimeRange returns tr, or the graph's default window ending at now without
+// one, with a step giving about 300 points when none is set
+func (g *Graph) TimeRange(tr *models.TimeRange, now time.Time) models.TimeRange {
+	var r models.TimeRange
+	if tr != nil {
+		r = *tr
+	} else {
+		window := g.Window
+		if window <= 0 {
+			window = defaultGraphWindow
+		}
+		r = models.TimeRange{Start: now.Add(-window), End: now}
+	}
+	if r.Step <= 0 {
+		r.Step = (r.End.Sub(r.Start)/defaultGraphPoints + time.Second - 1).Truncate(time.Second)
+		if r.Step < time.Second {
+			r.Step = time.Second
+		}
+	}
+	return r
+}
+
+// Render renders the query expression for query over r
+func (g *Graph) Render(query *models.MetricsQuery, r models.TimeRange) (string, error) {
+	return g.RenderVars(query, r, nil)
+}
+
+// RenderVars renders the query expression with provider settings in Vars.
+// It fails with ErrInvalidName unless the application and project are valid
+// Argo CD names.
+func (g *Graph) RenderVars(query *models.MetricsQuery, r models.TimeRange, vars map[string]string) (string, error) {
+	for _, name := range []string{query.Application, query.Project} {
+		if len(name) > maxNameLength || !argoCDName.MatchString(name) {
+			return "", fmt.Errorf("failed to render query of graph %s: %w %q", g.Name, ErrInvalidName, name)
+		}
+	}
+	data := QueryData{
+		Application: query.Application,
+		Project:     query.Project,
+		GroupKind:   query.GroupKind,
+		Row:         query.Row,
+		Graph:       query.Graph,
+		Start:       r.Start,
+		End:         r.End,
+		Step:        r.Step,
+		Vars:        vars,
+	}
+	var buf bytes.Buffer
+	if err := g.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render query of graph %s: %w", g.Name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Describe renders the query of the graph queried by query over tr, or its
+// default window ending at now, with provider settings in vars
+func (d *Dashboards) Describe(query *models.MetricsQuery, tr *models.TimeRange, now time.Time, vars map[string]string) (*QueryDescription, error) {
+	graph, err := d.Graph(query)
+	if err != nil {
+		return nil, err
+	}
+	r := graph.TimeRange(tr, now)
+	expr, err := graph.RenderVars(query, r, vars)
+	if err != nil {
+		return nil, err
+	}
+	return &QueryDescription{Expression: expr, Range: r}, nil
+}
+
+// Dashboards looks up the graphs of applications. It implements
+// GraphLister and DashboardResolver, so providers can list graphs and
+// a Registry can honour the Provider setting.
+type Dashboards struct {
+	apps []ApplicationDashboards
+	def  *ApplicationDashboards
+}
+
+var (
+	_ GraphLister       = (*Dashboards)(nil)
+	_ DashboardResolver = (*Dashboards)(nil)
+)
+
+// NewDashboards validates cfg and parses its query expressions
+func NewDashboards(cfg DashboardConfig) (*Dashboards, error) {
+	d := &Dashboards{apps: cfg.Applications}
+	for i := range d.apps {
+		app := &d.apps[i]
+		if _, err := path.Match(app.Name, ""); err != nil {
+			return nil, fmt.Errorf("invalid application pattern %q: %w", app.Name, err)
+		}
+		if app.Default {
+			if d.def != nil {
+				return nil, errors.New("only one application entry may be the default")
+			}
+			d.def = app
+		}
+		for j := range app.Dashboards {
+			for k := range app.Dashboards[j].Rows {
+				row := &app.Dashboards[j].Rows[k]
+				for l := range row.Graphs {
+					g := &row.Graphs[l]
+					if g.QueryExpression == "" {
+						return nil, fmt.Errorf("graph %s/%s/%s of %q has no query expression",
+							app.Dashboards[j].GroupKind, row.Name, g.Name, app.Name)
+					}
+					tmpl, err := template.New(g.Name).Funcs(queryFuncs).Option("missingkey=error").Parse(g.QueryExpression)
+					if err != nil {
+						return nil, fmt.Errorf("invalid query expression of graph %s/%s/%s: %w",
+							app.Dashboards[j].GroupKind, row.Name, g.Name, err)
+					}
+					g.tmpl = tmpl
+				}
+			}
+		}
+	}
+	return d, nil
+}
+
+// application returns the dashboards of an application
+func (d *Dashboards) application(name string) *ApplicationDashboards {
+	for i := range d.apps {
+		if ok, _ := path.Match(d.apps[i].Name, name); ok && !d.apps[i].Default {
+			return &d.apps[i]
+		}
+	}
+	return d.def
+}
+
+// Graph returns the graph queried by query
+func (d *Dashboards) Graph(query *models.MetricsQuery) (*Graph, error) {
+	app := d.application(query.Application)
+	if app != nil {
+		for _, dash := range app.Dashboards {
+			if dash.GroupKind != query.GroupKind {
+				continue
+			}
+			for _, row := range dash.Rows {
+				if row.Name != query.Row {
+					continue
+				}
+				for i := range row.Graphs {
+					if row.Graphs[i].Name == query.Graph {
+						return &row.Graphs[i], nil
+					}
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: %s/%s/%s for application %s",
+		ErrGraphNotFound, query.GroupKind, query.Row, query.Graph, query.Application)
+}
+
+// ListGraphs lists every graph of an application's dashboards
+func (d *Dashboards) ListGraphs(ctx context.Context, application, project string) ([]GraphRef, error) {
+	app := d.application(application)
+	if app == nil {
+		return nil, nil
+	}
+	var graphs []GraphRef
+	for _, dash := range app.Dashboards {
+		for _, row := range dash.Rows {
+			for _, g := range row.Graphs {
+				graphs = append(graphs, GraphRef{GroupKind: dash.GroupKind, Row: row.Name, Graph: g.Name})
+			}
+		}
+	}
+	return graphs, nil
+}
+
+// DashboardProvider returns the provider named by the application's
+// dashboards, if any
+func (d *Dashboards) DashboardProvider(ctx context.Context, query *models.MetricsQuery) (string, error) {
+	if app := d.application(query.Application); app != nil {
+		return app.Provider, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,182 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+func testDashboards(t *testing.T) *Dashboards {
+	t.Helper()
+	d, err := NewDashboards(DashboardConfig{Applications: []ApplicationDashboards{
+		{
+			Name:     "payments-*",
+			Provider: "prometheus-eu",
+			Dashboards: []Dashboard{{
+				GroupKind: "deployment",
+				Rows: []DashboardRow{{
+					Name: "http",
+					Graphs: []Graph{
+						{Name: "request-rate", QueryExpression: `sum(rate(http_requests_total{app="{{.Application}}"}[{{duration .Step}}]))`},
+						{Name: "latency", QueryExpression: `latency{app="{{.Application}}"}`, Window: 6 * time.Hour},
+					},
+				}},
+			}},
+		},
+		{
+			Name:    "default",
+			Default: true,
+			Dashboards: []Dashboard{{
+				GroupKind: "pod",
+				Rows: []DashboardRow{{
+					Name:   "container",
+					Graphs: []Graph{{Name: "cpu", QueryExpression: `cpu{project="{{.Project}}"} from {{unix .Start}} to {{rfc3339 .End}} every {{seconds .Step}}`}},
+				}},
+			}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDashboards_Graph(t *testing.T) {
+	d := testDashboards(t)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	query := &models.MetricsQuery{Application: "payments-api", Project: "prod", GroupKind: "deployment", Row: "http", Graph: "request-rate"}
+	g, err := d.Graph(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := g.TimeRange(nil, now)
+	if r.End != now || r.Start != now.Add(-time.Hour) || r.Step != 12*time.Second {
+		t.Errorf("default range = %+v", r)
+	}
+	expr, err := g.Render(query, r)
+	if want := `sum(rate(http_requests_total{app="payments-api"}[12s]))`; err != nil || expr != want {
+		t.Errorf("Render = %q, %v; want %q", expr, err, want)
+	}
+
+	query.Graph = "latency"
+	if g, _ := d.Graph(query); g.TimeRange(nil, now).Start != now.Add(-6*time.Hour) {
+		t.Error("graph window ignored")
+	}
+	explicit := &models.TimeRange{Start: now.Add(-time.Minute), End: now, Step: time.Minute}
+	if r := g.TimeRange(explicit, now); r != *explicit {
+		t.Errorf("explicit range = %+v", r)
+	}
+
+	// Applications matching no entry use the default one
+	query = &models.MetricsQuery{Application: "checkout", Project: "prod", GroupKind: "pod", Row: "container", Graph: "cpu"}
+	g, err = d.Graph(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expr, _ = g.Render(query, models.TimeRange{Start: now.Add(-time.Hour), End: now, Step: 90 * time.Second})
+	if want := `cpu{project="prod"} from 1704106800 to 2024-01-01T12:00:00Z every 90`; expr != want {
+		t.Errorf("Render = %q, want %q", expr, want)
+	}
+
+	query.Graph = "memory"
+	if _, err := d.Graph(query); !errors.Is(err, ErrGraphNotFound) {
+		t.Errorf("missing graph: err = %v", err)
+	}
+}
+
+func TestDashboards_Describe(t *testing.T) {
+	d := testDashboards(t)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	query := &models.MetricsQuery{Application: "payments-api", Project: "prod", GroupKind: "deployment", Row: "http", Graph: "request-rate"}
+
+	desc, err := d.Describe(query, nil, now, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.TimeRange{Start: now.Add(-time.Hour), End: now, Step: 12 * time.Second}
+	if desc.Range != want || desc.Expression != `sum(rate(http_requests_total{app="payments-api"}[12s]))` {
+		t.Errorf("Describe = %+v", desc)
+	}
+
+	query.Graph = "memory"
+	if _, err := d.Describe(query, nil, now, nil); !errors.Is(err, ErrGraphNotFound) {
+		t.Errorf("missing graph: err = %v", err)
+	}
+}
+
+func TestGraph_RenderInvalidName(t *testing.T) {
+	d := testDashboards(t)
+	query := &models.MetricsQuery{Application: "payments-api", Project: "prod", GroupKind: "deployment", Row: "http", Graph: "request-rate"}
+	g, err := d.Graph(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := models.TimeRange{Start: time.Unix(0, 0), End: time.Unix(3600, 0), Step: time.Minute}
+
+	for _, name := range []string{`payments-api"} or vector(1) or {app="x`, "' OR '1'='1", "Payments", "-payments", "", strings.Repeat("a", 254)} {
+		query.Application = name
+		if expr, err := g.Render(query, r); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("application %q: Render = %q, %v", name, expr, err)
+		}
+	}
+	query.Application, query.Project = "payments.api", `prod"}`
+	if expr, err := g.Render(query, r); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("project %q: Render = %q, %v", query.Project, expr, err)
+	}
+	query.Project = "prod"
+	if _, err := g.Render(query, r); err != nil {
+		t.Errorf("dotted application: %v", err)
+	}
+}
+
+func TestDashboards_ListAndProvider(t *testing.T) {
+	d := testDashboards(t)
+	ctx := context.Background()
+
+	graphs, _ := d.ListGraphs(ctx, "payments-api", "prod")
+	if len(graphs) != 2 || graphs[1].GroupKind != "deployment" || graphs[1].Graph != "latency" {
+		t.Errorf("ListGraphs = %+v", graphs)
+	}
+	if name, _ := d.DashboardProvider(ctx, &models.MetricsQuery{Application: "payments-api"}); name != "prometheus-eu" {
+		t.Errorf("DashboardProvider = %q", name)
+	}
+	if name, _ := d.DashboardProvider(ctx, &models.MetricsQuery{Application: "checkout"}); name != "" {
+		t.Errorf("DashboardProvider for default = %q", name)
+	}
+}
+
+func TestNewDashboards_Invalid(t *testing.T) {
+	graph := func(expr string) DashboardConfig {
+		return DashboardConfig{Applications: []ApplicationDashboards{{
+			Name:       "app",
+			Dashboards: []Dashboard{{GroupKind: "pod", Rows: []DashboardRow{{Name: "r", Graphs: []Graph{{Name: "g", QueryExpression: expr}}}}}},
+		}}}
+	}
+	tests := map[string]DashboardConfig{
+		"empty query":    graph(""),
+		"bad template":   graph("up{app={{.Application}"),
+		"bad pattern":    {Applications: []ApplicationDashboards{{Name: "[app"}}},
+		"double default": {Applications: []ApplicationDashboards{{Name: "a", Default: true}, {Name: "b", Default: true}}},
+	}
+	for name, cfg := range tests {
+		if _, err := NewDashboards(cfg); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	// Unknown fields fail when rendering rather than rendering "<no value>"
+	d, err := NewDashboards(graph("up{app={{.Namespace}}}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := &models.MetricsQuery{Application: "app", GroupKind: "pod", Row: "r", Graph: "g"}
+	g, _ := d.Graph(query)
+	if _, err := g.Render(query, models.TimeRange{}); err == nil {
+		t.Error("unknown field rendered")
+	}
+}
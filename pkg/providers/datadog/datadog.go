@@ -0,0 +1,327 @@
+// Package datadog queries metrics from the Datadog metrics query API. Graph
+// query expressions are Datadog queries, such as
+//
+//	sum:trace.http.request.hits{service:{{.Application}}} by {resource_name}.as_rate()
+package datadog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+// Defaults for Config
+const (
+	defaultSite         = "datadoghq.com"
+	defaultMaxRetries   = 2
+	defaultMaxRetryWait = 30 * time.Second
+	defaultTimeout      = time.Minute
+)
+
+// maxResponseSize bounds the responses read from the API
+const maxResponseSize = 64 << 20
+
+// Rate limit headers sent with every API response
+const (
+	headerRateLimitRemaining = "X-RateLimit-Remaining"
+	headerRateLimitReset     = "X-RateLimit-Reset"
+)
+
+// ErrRateLimited is returned when the API keeps rejecting queries for
+// exceeding the organization's rate limit
+var ErrRateLimited = errors.New("datadog: rate limit exceeded")
+
+// Config configures a Provider
+type Config struct {
+	// Site is the Datadog site of the organization, such as datadoghq.eu
+	// (default datadoghq.com)
+	Site string `yaml:"site" json:"site"`
+	// URL overrides the API address derived from Site, such as for a proxy
+	URL string `yaml:"url" json:"url"`
+	// Keys default to DD_API_KEY and DD_APP_KEY from the environment
+	APIKey string `yaml:"apiKey" json:"-"`
+	AppKey string `yaml:"appKey" json:"-"`
+	// MaxRetries is the number of retries of rate limited queries (default 2,
+	// -1 for none)
+	MaxRetries int `yaml:"maxRetries" json:"maxRetries"`
+	// MaxRetryWait is the longest wait for a rate limit to reset before
+	// giving up (default 30s)
+	MaxRetryWait time.Duration `yaml:"maxRetryWait" json:"maxRetryWait"`
+}
+
+// Error is an error response from the API
+type Error struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("datadog: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("datadog: %d %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+// Provider runs the dashboard graph queries against Datadog
+type Provider struct {
+	baseURL    *url.URL
+	cfg        Config
+	dashboards *providers.Dashboards
+	httpClient *http.Client
+	now        func() time.Time
+	sleep      func(ctx context.Context, d time.Duration) error
+	logger     *slog.Logger
+}
+
+var (
+	_ providers.MetricsQuerier = (*Provider)(nil)
+	_ providers.RangeQuerier   = (*Provider)(nil)
+	_ providers.GraphLister    = (*Provider)(nil)
+	_ providers.QueryDescriber = (*Provider)(nil)
+	_ providers.HealthChecker  = (*Provider)(nil)
+)
+
+// New creates a provider serving the graphs of dashboards
+func New(cfg Config, dashboards *providers.Dashboards, logger *slog.Logger) (*Provider, error) {
+	if cfg.Site == "" {
+		cfg.Site = defaultSite
+	}
+	if cfg.URL == "" {
+		cfg.URL = "https://api." + cfg.Site
+	}
+	if cfg.APIKey == "" && cfg.AppKey == "" {
+		cfg.APIKey = os.Getenv("DD_API_KEY")
+		cfg.AppKey = os.Getenv("DD_APP_KEY")
+	}
+	if cfg.APIKey == "" || cfg.AppKey == "" {
+		return nil, errors.New("datadog: API key and application key are required")
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	if cfg.MaxRetryWait <= 0 {
+		cfg.MaxRetryWait = defaultMaxRetryWait
+	}
+
+	baseURL, err := url.Parse(cfg.URL)
+	if err != nil || baseURL.Host == "" || (baseURL.Scheme != "http" && baseURL.Scheme != "https") {
+		return nil, fmt.Errorf("datadog: invalid URL %q", cfg.URL)
+	}
+
+	return &Provider{
+		baseURL:    baseURL,
+		cfg:        cfg,
+		dashboards: dashboards,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		now:        time.Now,
+		sleep:      sleep,
+		logger:     logger.With("component", "datadog"),
+	}, nil
+}
+
+// Query runs the graph's query over its default window
+func (p *Provider) Query(ctx context.Context, query *models.MetricsQuery) (*models.MetricsResponse, error) {
+	return p.query(ctx, query, nil)
+}
+
+// QueryRange runs the graph's query over r. Datadog picks the resolution;
+// query expressions may use {{seconds .Step}} in a .rollup() to set it.
+func (p *Provider) QueryRange(ctx context.Context, query *models.MetricsQuery, r models.TimeRange) (*models.MetricsResponse, error) {
+	return p.query(ctx, query, &r)
+}
+
+// DescribeQuery renders the graph's query over r, or its default window
+func (p *Provider) DescribeQuery(ctx context.Context, query *models.MetricsQuery, r *models.TimeRange) (*providers.QueryDescription, error) {
+	return p.dashboards.Describe(query, r, p.now(), nil)
+}
+
+// ListGraphs lists the graphs of an application's dashboards
+func (p *Provider) ListGraphs(ctx context.Context, application, project string) ([]providers.GraphRef, error) {
+	return p.dashboards.ListGraphs(ctx, application, project)
+}
+
+// HealthCheck validates the API key
+func (p *Provider) HealthCheck(ctx context.Context) error {
+	var result struct {
+		Valid bool `json:"valid"`
+	}
+	if err := p.get(ctx, "/api/v1/validate", nil, &result); err != nil {
+		return err
+	}
+	if !result.Valid {
+		return errors.New("datadog: API key is not valid")
+	}
+	return nil
+}
+
+// queryResponse is the response of /api/v1/query
+type queryResponse struct {
+	Status string   `json:"status"`
+	Error  string   `json:"error"`
+	Series []series `json:"series"`
+}
+
+type series struct {
+	Metric string   `json:"metric"`
+	TagSet []string `json:"tag_set"`
+	// Pointlist holds [milliseconds, value] pairs; values are null where
+	// there is no data
+	Pointlist [][2]*float64 `json:"pointlist"`
+}
+
+func (p *Provider) query(ctx context.Context, query *models.MetricsQuery, tr *models.TimeRange) (*models.MetricsResponse, error) {
+	graph, err := p.dashboards.Graph(query)
+	if err != nil {
+		return nil, err
+	}
+	r := graph.TimeRange(tr, p.now())
+	expr, err := graph.Render(query, r)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("query", expr)
+	params.Set("from", strconv.FormatInt(r.Start.Unix(), 10))
+	params.Set("to", strconv.FormatInt(r.End.Unix(), 10))
+
+	var result queryResponse
+	if err := p.get(ctx, "/api/v1/query", params, &result); err != nil {
+		return nil, err
+	}
+	if result.Status == "error" {
+		return nil, fmt.Errorf("datadog: query %q failed: %s", expr, result.Error)
+	}
+
+	response := &models.MetricsResponse{
+		Application: query.Application,
+		Project:     query.Project,
+		Graph:       query.Graph,
+		Data:        []models.MetricData{},
+	}
+	for _, s := range result.Series {
+		labels := tagLabels(s.TagSet)
+		labels["__name__"] = s.Metric
+		for _, point := range s.Pointlist {
+			if point[0] == nil || point[1] == nil {
+				continue
+			}
+			ms := int64(*point[0])
+			response.Data = append(response.Data, models.MetricData{
+				Timestamp: time.UnixMilli(ms).UTC(),
+				Value:     *point[1],
+				Labels:    labels,
+			})
+		}
+	}
+	return response, nil
+}
+
+// tagLabels turns "key:value" tags into labels. Tags without a value keep
+// an empty one; a key tagged more than once has its values joined with
+// commas.
+func tagLabels(tags []string) map[string]string {
+	values := make(map[string][]string, len(tags))
+	for _, tag := range tags {
+		key, value, _ := strings.Cut(tag, ":")
+		values[key] = append(values[key], value)
+	}
+	labels := make(map[string]string, len(values)+1)
+	for key, v := range values {
+		sort.Strings(v)
+		labels[key] = strings.Join(v, ",")
+	}
+	return labels
+}
+
+// get sends an authenticated request to the API and decodes the response,
+// waiting for the rate limit to reset and retrying when it is exceeded
+func (p *Provider) get(ctx context.Context, path string, params url.Values, result interface{}) error {
+	u := p.baseURL.JoinPath(path)
+	u.RawQuery = params.Encode()
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return fmt.Errorf("datadog: %w", err)
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("DD-API-KEY", p.cfg.APIKey)
+		req.Header.Set("DD-APPLICATION-KEY", p.cfg.AppKey)
+
+		resp, err := p.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("datadog: %w", err)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("datadog: failed to read response: %w", err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			wait := rateLimitReset(resp.Header)
+			if attempt >= p.cfg.MaxRetries || wait > p.cfg.MaxRetryWait {
+				return fmt.Errorf("%w, resets in %s", ErrRateLimited, wait)
+			}
+			p.logger.Warn("rate limited, waiting for reset", "path", path, "wait", wait, "attempt", attempt+1)
+			if err := p.sleep(ctx, wait); err != nil {
+				return err
+			}
+			continue
+		}
+		if remaining := resp.Header.Get(headerRateLimitRemaining); remaining == "0" {
+			p.logger.Warn("rate limit exhausted", "path", path, "reset", rateLimitReset(resp.Header))
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			apiErr := &Error{StatusCode: resp.StatusCode}
+			var errBody struct {
+				Errors []string `json:"errors"`
+			}
+			if json.Unmarshal(body, &errBody) == nil {
+				apiErr.Errors = errBody.Errors
+			}
+			return apiErr
+		}
+		if err := json.Unmarshal(body, result); err != nil {
+			return fmt.Errorf("datadog: invalid response: %w", err)
+		}
+		return nil
+	}
+}
+
+// rateLimitReset returns the time until the rate limit resets, one second
+// when the header is missing
+func rateLimitReset(h http.Header) time.Duration {
+	seconds, err := strconv.Atoi(h.Get(headerRateLimitReset))
+	if err != nil || seconds <= 0 {
+		return time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,227 @@
+package datadog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+var testNow = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+const queryResult = `{
+  "status": "ok",
+  "res_type": "time_series",
+  "query": "sum:trace.http.request.hits{service:payments} by {resource_name}.as_rate()",
+  "series": [
+    {
+      "metric": "trace.http.request.hits",
+      "scope": "resource_name:get_/orders,service:payments",
+      "tag_set": ["resource_name:get_/orders", "service:payments"],
+      "pointlist": [[1704103200000.0, 12.5], [1704103260000.0, null], [1704103320000.0, 14]]
+    },
+    {
+      "metric": "trace.http.request.hits",
+      "scope": "resource_name:post_/orders,service:payments",
+      "tag_set": ["resource_name:post_/orders", "service:payments", "canary"],
+      "pointlist": [[1704103200000.0, 3]]
+    }
+  ]
+}`
+
+func newTestProvider(t *testing.T, handler http.HandlerFunc) *Provider {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	dashboards, err := providers.NewDashboards(providers.DashboardConfig{Applications: []providers.ApplicationDashboards{{
+		Default: true,
+		Dashboards: []providers.Dashboard{{
+			GroupKind: "deployment",
+			Rows: []providers.DashboardRow{{
+				Name: "http",
+				Graphs: []providers.Graph{{
+					Name:            "request-rate",
+					QueryExpression: "sum:trace.http.request.hits{service:{{.Application}}} by {resource_name}.as_rate()",
+				}},
+			}},
+		}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(Config{URL: ts.URL, APIKey: "api-key", AppKey: "app-key"}, dashboards, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.now = func() time.Time { return testNow }
+	p.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	return p
+}
+
+var testQuery = &models.MetricsQuery{Application: "payments", Project: "prod", GroupKind: "deployment", Row: "http", Graph: "request-rate"}
+
+func TestProvider_Query(t *testing.T) {
+	wantFrom := "1704106800"
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("DD-API-KEY") != "api-key" || r.Header.Get("DD-APPLICATION-KEY") != "app-key" {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"errors": ["Forbidden"]}`)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("query") != "sum:trace.http.request.hits{service:payments} by {resource_name}.as_rate()" ||
+			q.Get("from") != wantFrom || q.Get("to") != "1704110400" {
+			t.Errorf("query parameters = %v", q)
+		}
+		io.WriteString(w, queryResult)
+	})
+
+	response, err := p.Query(context.Background(), testQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Application != "payments" || len(response.Data) != 3 {
+		t.Fatalf("response = %+v", response)
+	}
+	first := response.Data[0]
+	if !first.Timestamp.Equal(time.Unix(1704103200, 0)) || first.Value != 12.5 ||
+		first.Labels["resource_name"] != "get_/orders" || first.Labels["service"] != "payments" ||
+		first.Labels["__name__"] != "trace.http.request.hits" {
+		t.Errorf("first point = %+v", first)
+	}
+	if second := response.Data[1]; second.Value != 14 {
+		t.Errorf("null point not skipped: %+v", second)
+	}
+	if last := response.Data[2]; last.Labels["resource_name"] != "post_/orders" {
+		t.Errorf("last point = %+v", last)
+	} else if v, ok := last.Labels["canary"]; !ok || v != "" {
+		t.Errorf("valueless tag = %q, %v", v, ok)
+	}
+
+	wantFrom = "1704024000"
+	r := models.TimeRange{Start: testNow.Add(-24 * time.Hour), End: testNow}
+	if _, err := p.QueryRange(context.Background(), testQuery, r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProvider_RateLimit(t *testing.T) {
+	var calls atomic.Int32
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "300")
+		w.Header().Set("X-RateLimit-Period", "3600")
+		if calls.Add(1) <= 2 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", "5")
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, `{"errors": ["Rate limit of 300 requests in 3600 seconds reached."]}`)
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", "299")
+		io.WriteString(w, queryResult)
+	})
+
+	var waited time.Duration
+	p.sleep = func(ctx context.Context, d time.Duration) error { waited += d; return nil }
+	if _, err := p.Query(context.Background(), testQuery); err != nil {
+		t.Fatalf("query after retries: %v", err)
+	}
+	if calls.Load() != 3 || waited != 10*time.Second {
+		t.Errorf("%d calls, waited %s", calls.Load(), waited)
+	}
+
+	calls.Store(0)
+	p.cfg.MaxRetries = 1
+	if _, err := p.Query(context.Background(), testQuery); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("err = %v", err)
+	}
+
+	calls.Store(0)
+	p.cfg.MaxRetries = 2
+	p.cfg.MaxRetryWait = time.Second
+	if _, err := p.Query(context.Background(), testQuery); !errors.Is(err, ErrRateLimited) || calls.Load() != 1 {
+		t.Errorf("reset beyond MaxRetryWait: %d calls, err = %v", calls.Load(), err)
+	}
+}
+
+func TestProvider_Errors(t *testing.T) {
+	status := http.StatusForbidden
+	body := `{"errors": ["Forbidden"]}`
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	})
+
+	_, err := p.Query(context.Background(), testQuery)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden || apiErr.Error() != "datadog: 403 Forbidden" {
+		t.Errorf("err = %v", err)
+	}
+
+	status, body = http.StatusOK, `{"status": "error", "error": "Error parsing query: unexpected token"}`
+	if _, err := p.Query(context.Background(), testQuery); err == nil {
+		t.Error("query error ignored")
+	}
+
+	missing := *testQuery
+	missing.Graph = "latency"
+	if _, err := p.Query(context.Background(), &missing); !errors.Is(err, providers.ErrGraphNotFound) {
+		t.Errorf("unknown graph: err = %v", err)
+	}
+}
+
+func TestProvider_HealthCheck(t *testing.T) {
+	valid := true
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/validate" {
+			http.NotFound(w, r)
+			return
+		}
+		if valid {
+			io.WriteString(w, `{"valid": true}`)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"errors": ["Forbidden"]}`)
+	})
+
+	if err := p.HealthCheck(context.Background()); err != nil {
+		t.Errorf("healthy: %v", err)
+	}
+	valid = false
+	if err := p.HealthCheck(context.Background()); err == nil {
+		t.Error("invalid key reported healthy")
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Setenv("DD_API_KEY", "")
+	t.Setenv("DD_APP_KEY", "")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := New(Config{}, nil, logger); err == nil {
+		t.Error("missing keys accepted")
+	}
+
+	t.Setenv("DD_API_KEY", "env-api")
+	t.Setenv("DD_APP_KEY", "env-app")
+	p, err := New(Config{Site: "datadoghq.eu"}, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.baseURL.String() != "https://api.datadoghq.eu" || p.cfg.APIKey != "env-api" {
+		t.Errorf("provider = %+v", p.cfg)
+	}
+}
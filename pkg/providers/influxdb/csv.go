@@ -0,0 +1,142 @@
+package influxdb
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+// Flux result columns that are not labels
+var fluxReservedColumns = map[string]bool{
+	"":          true, // annotation column
+	"result":    true,
+	"table":     true,
+	"_start":    true,
+	"_stop":     true,
+	"_time":     true,
+	"_value":    true,
+	"error":     true,
+	"reference": true,
+}
+
+// readAnnotatedCSV yields the points of Flux results in annotated CSV as
+// they are read, stopping at the first error from yield. Each table starts
+// with #datatype, #group and #default annotations and a header row. Every
+// row with a numeric _value becomes a point labeled with the row's other
+// columns, such as _measurement, _field and tags; rows with a null value or
+// a non-numeric one are dropped, while rows whose width differs from the
+// header fail. An error table, which InfluxDB sends when a
+// query fails after the response has started, is returned as an *Error.
+func readAnnotatedCSV(r io.Reader, yield func(models.MetricData) error) error {
+	reader := csv.NewReader(r)
+	// Tables differ in width, so rows are checked against their header
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	var (
+		datatypes, defaults []string
+		header              []string
+		expectHeader        = true
+		timeCol, valueCol   int
+		errorCol            = -1
+	)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("influxdb: invalid CSV response: %w", err)
+		}
+
+		if strings.HasPrefix(row[0], "#") {
+			switch row[0] {
+			case "#datatype":
+				datatypes = append(datatypes[:0], row...)
+			case "#default":
+				defaults = append(defaults[:0], row...)
+			}
+			expectHeader = true
+			continue
+		}
+		if expectHeader {
+			header = append(header[:0], row...)
+			expectHeader = false
+			timeCol, valueCol, errorCol = -1, -1, -1
+			for i, name := range header {
+				switch name {
+				case "_time":
+					timeCol = i
+				case "_value":
+					valueCol = i
+				case "error":
+					errorCol = i
+				}
+			}
+			continue
+		}
+		if len(row) != len(header) {
+			return fmt.Errorf("influxdb: invalid CSV response: row has %d columns, header %d", len(row), len(header))
+		}
+
+		cell := func(i int) string {
+			if i < len(row) && row[i] != "" {
+				return row[i]
+			}
+			if i < len(defaults) {
+				return defaults[i]
+			}
+			return ""
+		}
+
+		if errorCol >= 0 && cell(errorCol) != "" {
+			return &Error{Message: cell(errorCol)}
+		}
+		if timeCol < 0 || valueCol < 0 {
+			continue
+		}
+		if valueCol < len(datatypes) && !numericType(datatypes[valueCol]) {
+			continue
+		}
+		raw := cell(valueCol)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339Nano, cell(timeCol))
+		if err != nil {
+			return fmt.Errorf("influxdb: invalid _time %q: %w", cell(timeCol), err)
+		}
+
+		labels := make(map[string]string, len(header))
+		for i, name := range header {
+			if fluxReservedColumns[name] {
+				continue
+			}
+			if v := cell(i); v != "" {
+				labels[name] = v
+			}
+		}
+		if err := yield(models.MetricData{Timestamp: ts.UTC(), Value: value, Labels: labels}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// numericType reports whether a #datatype annotation is a number
+func numericType(datatype string) bool {
+	switch datatype {
+	case "double", "long", "unsignedLong":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,435 @@
+// Package influxdb queries metrics from InfluxDB, with Flux through the v2
+// query API or with InfluxQL through the v1 query API. Query expressions are
+// in the configured language, such as
+//
+//	from(bucket: "{{.Vars.bucket}}")
+//	  |> range(start: {{rfc3339 .Start}}, stop: {{rfc3339 .End}})
+//	  |> filter(fn: (r) => r._measurement == "cpu" and r.app == "{{.Application}}")
+//	  |> aggregateWindow(every: {{duration .Step}}, fn: mean)
+//
+// or
+//
+//	SELECT mean("usage") FROM "cpu" WHERE "app" = '{{.Application}}'
+//	  AND time >= {{unix .Start}}s AND time <= {{unix .End}}s GROUP BY time({{duration .Step}}), "host"
+package influxdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+// Query languages
+const (
+	LanguageFlux     = "flux"
+	LanguageInfluxQL = "influxql"
+)
+
+const (
+	defaultTimeout = time.Minute
+	// maxResponseSize bounds the responses read from the API, except for
+	// streamed ones
+	maxResponseSize = 64 << 20
+)
+
+// Labels carrying the InfluxDB measurement and field of a point
+const (
+	labelMeasurement = "_measurement"
+	labelField       = "_field"
+)
+
+// Config configures a Provider
+type Config struct {
+	// URL is the address of the server, such as http://influxdb:8086
+	URL string `yaml:"url" json:"url"`
+	// Language is flux (default) or influxql
+	Language string `yaml:"language" json:"language"`
+	// Org and Bucket are used with Flux; Bucket is available to query
+	// templates as {{.Vars.bucket}}
+	Org    string `yaml:"org" json:"org"`
+	Bucket string `yaml:"bucket" json:"bucket"`
+	// Database and RetentionPolicy are used with InfluxQL
+	Database        string `yaml:"database" json:"database"`
+	RetentionPolicy string `yaml:"retentionPolicy" json:"retentionPolicy"`
+	// Token authenticates to InfluxDB 2.x, including its v1 compatibility
+	// API, defaulting to INFLUX_TOKEN from the environment
+	Token string `yaml:"token" json:"-"`
+	// Username and Password authenticate to InfluxDB 1.x instead
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"-"`
+}
+
+// Error is an error response from the server, or an error reported with a
+// query's results
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("influxdb: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	if e.StatusCode == 0 {
+		return "influxdb: " + e.Message
+	}
+	return fmt.Sprintf("influxdb: %d %s", e.StatusCode, e.Message)
+}
+
+// Provider runs the dashboard graph queries against InfluxDB
+type Provider struct {
+	baseURL    *url.URL
+	cfg        Config
+	vars       map[string]string
+	dashboards *providers.Dashboards
+	httpClient *http.Client
+	now        func() time.Time
+	logger     *slog.Logger
+}
+
+var (
+	_ providers.MetricsQuerier = (*Provider)(nil)
+	_ providers.RangeQuerier   = (*Provider)(nil)
+	_ providers.GraphLister    = (*Provider)(nil)
+	_ providers.QueryDescriber = (*Provider)(nil)
+	_ providers.PointStreamer  = (*Provider)(nil)
+	_ providers.HealthChecker  = (*Provider)(nil)
+)
+
+// New creates a provider serving the graphs of dashboards
+func New(cfg Config, dashboards *providers.Dashboards, logger *slog.Logger) (*Provider, error) {
+	if cfg.Language == "" {
+		cfg.Language = LanguageFlux
+	}
+	if cfg.Token == "" && cfg.Username == "" {
+		cfg.Token = os.Getenv("INFLUX_TOKEN")
+	}
+	switch cfg.Language {
+	case LanguageFlux:
+		if cfg.Org == "" || cfg.Bucket == "" {
+			return nil, errors.New("influxdb: org and bucket are required with Flux")
+		}
+		if cfg.Token == "" {
+			return nil, errors.New("influxdb: token is required with Flux")
+		}
+	case LanguageInfluxQL:
+		if cfg.Database == "" {
+			return nil, errors.New("influxdb: database is required with InfluxQL")
+		}
+	default:
+		return nil, fmt.Errorf("influxdb: unsupported language %q (supported languages: flux, influxql)", cfg.Language)
+	}
+
+	baseURL, err := url.Parse(cfg.URL)
+	if err != nil || baseURL.Host == "" || (baseURL.Scheme != "http" && baseURL.Scheme != "https") {
+		return nil, fmt.Errorf("influxdb: invalid URL %q", cfg.URL)
+	}
+
+	return &Provider{
+		baseURL: baseURL,
+		cfg:     cfg,
+		vars: map[string]string{
+			"org":             cfg.Org,
+			"bucket":          cfg.Bucket,
+			"database":        cfg.Database,
+			"retentionPolicy": cfg.RetentionPolicy,
+		},
+		dashboards: dashboards,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		now:        time.Now,
+		logger:     logger.With("component", "influxdb"),
+	}, nil
+}
+
+// Query runs the graph's query over its default window
+func (p *Provider) Query(ctx context.Context, query *models.MetricsQuery) (*models.MetricsResponse, error) {
+	return p.query(ctx, query, nil)
+}
+
+// QueryRange runs the graph's query over r. The range and step are only
+// applied through the query template.
+func (p *Provider) QueryRange(ctx context.Context, query *models.MetricsQuery, r models.TimeRange) (*models.MetricsResponse, error) {
+	return p.query(ctx, query, &r)
+}
+
+// DescribeQuery renders the graph's query over r, or its default window
+func (p *Provider) DescribeQuery(ctx context.Context, query *models.MetricsQuery, r *models.TimeRange) (*providers.QueryDescription, error) {
+	return p.dashboards.Describe(query, r, p.now(), p.vars)
+}
+
+// QueryStream yields the points of the graph's query over its default
+// window. Flux results are yielded row by row as the response is read, so
+// memory stays bounded however many points match; InfluxQL responses are a
+// single JSON document and are decoded whole first.
+func (p *Provider) QueryStream(ctx context.Context, query *models.MetricsQuery, yield func(models.MetricData) error) error {
+	if p.cfg.Language != LanguageFlux {
+		response, err := p.query(ctx, query, nil)
+		if err != nil {
+			return err
+		}
+		for _, data := range response.Data {
+			if err := yield(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	expr, err := p.render(query, nil)
+	if err != nil {
+		return err
+	}
+	return p.queryFlux(ctx, expr, 0, yield)
+}
+
+// ListGraphs lists the graphs of an application's dashboards
+func (p *Provider) ListGraphs(ctx context.Context, application, project string) ([]providers.GraphRef, error) {
+	return p.dashboards.ListGraphs(ctx, application, project)
+}
+
+// HealthCheck asks the server whether it is ready
+func (p *Provider) HealthCheck(ctx context.Context) error {
+	path := "/health"
+	if p.cfg.Language == LanguageInfluxQL {
+		path = "/ping"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL.JoinPath(path).String(), nil)
+	if err != nil {
+		return fmt.Errorf("influxdb: %w", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// render renders the graph's query over tr, or its default window
+func (p *Provider) render(query *models.MetricsQuery, tr *models.TimeRange) (string, error) {
+	graph, err := p.dashboards.Graph(query)
+	if err != nil {
+		return "", err
+	}
+	return graph.RenderVars(query, graph.TimeRange(tr, p.now()), p.vars)
+}
+
+func (p *Provider) query(ctx context.Context, query *models.MetricsQuery, tr *models.TimeRange) (*models.MetricsResponse, error) {
+	expr, err := p.render(query, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []models.MetricData
+	if p.cfg.Language == LanguageFlux {
+		data = []models.MetricData{}
+		err = p.queryFlux(ctx, expr, maxResponseSize, func(point models.MetricData) error {
+			data = append(data, point)
+			return nil
+		})
+	} else {
+		data, err = p.queryInfluxQL(ctx, expr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.MetricsResponse{
+		Application: query.Application,
+		Project:     query.Project,
+		Graph:       query.Graph,
+		Data:        data,
+	}, nil
+}
+
+// queryFlux runs a Flux query, yielding points as the annotated CSV
+// response is read. A positive maxSize bounds the bytes read; longer
+// responses fail rather than being cut off, which could leave a last row
+// that still parses.
+func (p *Provider) queryFlux(ctx context.Context, flux string, maxSize int64, yield func(models.MetricData) error) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": flux,
+		"type":  "flux",
+		"dialect": map[string]interface{}{
+			"header":      true,
+			"annotations": []string{"datatype", "group", "default"},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("influxdb: %w", err)
+	}
+
+	u := p.baseURL.JoinPath("/api/v2/query")
+	u.RawQuery = url.Values{"org": {p.cfg.Org}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("influxdb: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/csv")
+
+	resp, err := p.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if maxSize <= 0 {
+		return readAnnotatedCSV(resp.Body, yield)
+	}
+	results := &limitedReader{r: io.LimitReader(resp.Body, maxSize+1), max: maxSize}
+	err = readAnnotatedCSV(results, yield)
+	if results.exceeded {
+		return fmt.Errorf("influxdb: response too large, over %d bytes", maxSize)
+	}
+	return err
+}
+
+// limitedReader reads up to max bytes from r, which should be limited to
+// one byte more, and records whether that byte was there
+type limitedReader struct {
+	r        io.Reader
+	max      int64
+	read     int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.max {
+		l.exceeded = true
+		return n - int(l.read-l.max), errors.New("influxdb: response too large")
+	}
+	return n, err
+}
+
+// influxQLResponse is the response of the v1 /query endpoint
+type influxQLResponse struct {
+	Results []struct {
+		Series []struct {
+			Name    string            `json:"name"`
+			Tags    map[string]string `json:"tags"`
+			Columns []string          `json:"columns"`
+			// Values holds rows of [milliseconds, value...]
+			Values [][]*float64 `json:"values"`
+		} `json:"series"`
+		Error string `json:"error"`
+	} `json:"results"`
+	Error string `json:"error"`
+}
+
+// queryInfluxQL runs an InfluxQL query. Each value column of a series
+// becomes its own series, labeled with the column name as _field; null
+// values are dropped.
+func (p *Provider) queryInfluxQL(ctx context.Context, influxQL string) ([]models.MetricData, error) {
+	params := url.Values{}
+	params.Set("db", p.cfg.Database)
+	if p.cfg.RetentionPolicy != "" {
+		params.Set("rp", p.cfg.RetentionPolicy)
+	}
+	params.Set("q", influxQL)
+	params.Set("epoch", "ms")
+
+	u := p.baseURL.JoinPath("/query")
+	u.RawQuery = params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("influxdb: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result influxQLResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("influxdb: invalid response: %w", err)
+	}
+	if result.Error != "" {
+		return nil, &Error{Message: result.Error}
+	}
+
+	data := []models.MetricData{}
+	for _, res := range result.Results {
+		if res.Error != "" {
+			return nil, &Error{Message: res.Error}
+		}
+		for _, s := range res.Series {
+			if len(s.Columns) == 0 || s.Columns[0] != "time" {
+				return nil, errors.New("influxdb: series without a time column")
+			}
+			for col := 1; col < len(s.Columns); col++ {
+				labels := make(map[string]string, len(s.Tags)+2)
+				for k, v := range s.Tags {
+					labels[k] = v
+				}
+				labels[labelMeasurement] = s.Name
+				labels[labelField] = s.Columns[col]
+				for _, row := range s.Values {
+					if len(row) <= col || row[0] == nil || row[col] == nil {
+						continue
+					}
+					data = append(data, models.MetricData{
+						Timestamp: time.UnixMilli(int64(*row[0])).UTC(),
+						Value:     *row[col],
+						Labels:    labels,
+					})
+				}
+			}
+		}
+	}
+	return data, nil
+}
+
+// do authenticates and sends req, turning error responses into *Error
+func (p *Provider) do(req *http.Request) (*http.Response, error) {
+	switch {
+	case p.cfg.Token != "":
+		req.Header.Set("Authorization", "Token "+p.cfg.Token)
+	case p.cfg.Username != "":
+		req.SetBasicAuth(p.cfg.Username, p.cfg.Password)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("influxdb: %w", err)
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return nil, &Error{StatusCode: resp.StatusCode, Message: errorMessage(body)}
+}
+
+// errorMessage extracts the message of an error response: "message" in v2
+// responses, "error" in v1 ones
+func errorMessage(body []byte) string {
+	var errBody struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(body, &errBody) == nil {
+		if errBody.Message != "" {
+			return errBody.Message
+		}
+		return errBody.Error
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,303 @@
+package influxdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+var (
+	testNow    = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	testQuery  = &models.MetricsQuery{Application: "edge-gateway", Project: "edge", GroupKind: "deployment", Row: "system", Graph: "cpu"}
+)
+
+// fluxResult holds two tables, the second with a tag the first lacks and a
+// null value, as InfluxDB returns them for a query grouped by host
+const fluxResult = "#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,dateTime:RFC3339,double,string,string,string\r\n" +
+	"#group,false,false,true,true,false,false,true,true,true\r\n" +
+	"#default,_result,,,,,,,,\r\n" +
+	",result,table,_start,_stop,_time,_value,_field,_measurement,host\r\n" +
+	",,0,2024-01-01T11:00:00Z,2024-01-01T12:00:00Z,2024-01-01T11:00:00Z,0.25,usage,cpu,edge-1\r\n" +
+	",,0,2024-01-01T11:00:00Z,2024-01-01T12:00:00Z,2024-01-01T11:01:00Z,0.5,usage,cpu,edge-1\r\n" +
+	"\r\n" +
+	"#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,dateTime:RFC3339,double,string,string,string,string\r\n" +
+	"#group,false,false,true,true,false,false,true,true,true,true\r\n" +
+	"#default,_result,,,,,,,,,\r\n" +
+	",result,table,_start,_stop,_time,_value,_field,_measurement,host,site\r\n" +
+	",,1,2024-01-01T11:00:00Z,2024-01-01T12:00:00Z,2024-01-01T11:00:00Z,,usage,cpu,edge-2,berlin\r\n" +
+	",,1,2024-01-01T11:00:00Z,2024-01-01T12:00:00Z,2024-01-01T11:01:00.5Z,0.75,usage,cpu,edge-2,berlin\r\n" +
+	"\r\n"
+
+const fluxError = "#datatype,string,string\r\n" +
+	"#group,true,true\r\n" +
+	"#default,,\r\n" +
+	",error,reference\r\n" +
+	",\"runtime error @4:6-4:50: filter: type error: missing object property \"\"app\"\"\",897\r\n"
+
+func newTestProvider(t *testing.T, cfg Config, handler http.HandlerFunc) *Provider {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	expr := `from(bucket: "{{.Vars.bucket}}") |> range(start: {{rfc3339 .Start}}, stop: {{rfc3339 .End}}) |> filter(fn: (r) => r.app == "{{.Application}}") |> aggregateWindow(every: {{duration .Step}}, fn: mean)`
+	if cfg.Language == LanguageInfluxQL {
+		expr = `SELECT mean("usage"), max("usage") FROM "cpu" WHERE "app" = '{{.Application}}' AND time >= {{unix .Start}}s AND time <= {{unix .End}}s GROUP BY time({{duration .Step}}), "host"`
+	}
+	dashboards, err := providers.NewDashboards(providers.DashboardConfig{Applications: []providers.ApplicationDashboards{{
+		Default: true,
+		Dashboards: []providers.Dashboard{{
+			GroupKind: "deployment",
+			Rows: []providers.DashboardRow{{
+				Name:   "system",
+				Graphs: []providers.Graph{{Name: "cpu", QueryExpression: expr}},
+			}},
+		}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.URL = ts.URL
+	p, err := New(cfg, dashboards, testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.now = func() time.Time { return testNow }
+	return p
+}
+
+func TestProvider_Flux(t *testing.T) {
+	result := fluxResult
+	p := newTestProvider(t, Config{Org: "edge", Bucket: "telegraf", Token: "secret"}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v2/query" || r.URL.Query().Get("org") != "edge" {
+			t.Errorf("request = %s %s", r.Method, r.URL)
+		}
+		if got := r.Header.Get("Authorization"); got != "Token secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var body struct {
+			Query   string `json:"query"`
+			Dialect struct {
+				Annotations []string `json:"annotations"`
+			} `json:"dialect"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		want := `from(bucket: "telegraf") |> range(start: 2024-01-01T11:00:00Z, stop: 2024-01-01T12:00:00Z) |> filter(fn: (r) => r.app == "edge-gateway") |> aggregateWindow(every: 12s, fn: mean)`
+		if body.Query != want || len(body.Dialect.Annotations) != 3 {
+			t.Errorf("query = %q, annotations %v", body.Query, body.Dialect.Annotations)
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		io.WriteString(w, result)
+	})
+
+	response, err := p.Query(context.Background(), testQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Application != "edge-gateway" || len(response.Data) != 3 {
+		t.Fatalf("response = %+v", response)
+	}
+	first := response.Data[0]
+	if !first.Timestamp.Equal(testNow.Add(-time.Hour)) || first.Value != 0.25 {
+		t.Errorf("first point = %+v", first)
+	}
+	wantLabels := map[string]string{"_field": "usage", "_measurement": "cpu", "host": "edge-1"}
+	if len(first.Labels) != len(wantLabels) {
+		t.Errorf("labels = %v, want %v", first.Labels, wantLabels)
+	}
+	for k, v := range wantLabels {
+		if first.Labels[k] != v {
+			t.Errorf("labels = %v, want %v", first.Labels, wantLabels)
+		}
+	}
+	last := response.Data[2]
+	if last.Value != 0.75 || last.Labels["site"] != "berlin" || last.Timestamp.Nanosecond() != 5e8 {
+		t.Errorf("last point = %+v", last)
+	}
+
+	result = fluxError
+	_, err = p.Query(context.Background(), testQuery)
+	var influxErr *Error
+	if !errors.As(err, &influxErr) || !strings.Contains(influxErr.Message, `missing object property "app"`) {
+		t.Errorf("error table: err = %v", err)
+	}
+}
+
+func TestProvider_FluxTooLarge(t *testing.T) {
+	p := newTestProvider(t, Config{Org: "edge", Bucket: "telegraf", Token: "secret"}, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, fluxResult)
+	})
+	expr, err := p.render(testQuery, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func(models.MetricData) error { return nil }
+
+	if err := p.queryFlux(context.Background(), expr, int64(len(fluxResult)), count); err != nil {
+		t.Errorf("response of exactly maxSize: %v", err)
+	}
+	// Cut off inside the last row, whose first columns would still parse
+	cut := int64(strings.LastIndex(fluxResult, ",usage,"))
+	if err := p.queryFlux(context.Background(), expr, cut, count); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("truncated response: err = %v", err)
+	}
+}
+
+func TestReadAnnotatedCSV_RowWidth(t *testing.T) {
+	short := strings.Replace(fluxResult, ",0.5,usage,cpu,edge-1", ",0.5,usage", 1)
+	err := readAnnotatedCSV(strings.NewReader(short), func(models.MetricData) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "columns") {
+		t.Errorf("short row: err = %v", err)
+	}
+}
+
+func TestProvider_QueryStream(t *testing.T) {
+	// The second table is only sent once the first point has been yielded,
+	// so the test hangs unless points are yielded as they arrive
+	yielded := make(chan struct{})
+	tables := strings.SplitAfter(fluxResult, "\r\n\r\n")
+	p := newTestProvider(t, Config{Org: "edge", Bucket: "telegraf", Token: "secret"}, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, tables[0])
+		w.(http.Flusher).Flush()
+		select {
+		case <-yielded:
+		case <-r.Context().Done():
+			return
+		}
+		io.WriteString(w, tables[1])
+	})
+
+	var points []models.MetricData
+	err := p.QueryStream(context.Background(), testQuery, func(data models.MetricData) error {
+		if len(points) == 0 {
+			close(yielded)
+		}
+		points = append(points, data)
+		return nil
+	})
+	if err != nil || len(points) != 3 || points[2].Labels["site"] != "berlin" {
+		t.Fatalf("QueryStream yielded %+v, err = %v", points, err)
+	}
+
+	stop := errors.New("client went away")
+	err = p.QueryStream(context.Background(), testQuery, func(models.MetricData) error { return stop })
+	if err != stop {
+		t.Errorf("QueryStream err = %v, want the yield error", err)
+	}
+}
+
+func TestProvider_InfluxQL(t *testing.T) {
+	p := newTestProvider(t, Config{Language: LanguageInfluxQL, Database: "telegraf", RetentionPolicy: "autogen", Username: "reader", Password: "pw"},
+		func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if r.URL.Path != "/query" || q.Get("db") != "telegraf" || q.Get("rp") != "autogen" || q.Get("epoch") != "ms" {
+				t.Errorf("request = %s", r.URL)
+			}
+			if user, pass, ok := r.BasicAuth(); !ok || user != "reader" || pass != "pw" {
+				t.Errorf("basic auth = %q %q %v", user, pass, ok)
+			}
+			want := `SELECT mean("usage"), max("usage") FROM "cpu" WHERE "app" = 'edge-gateway' AND time >= 1703505600s AND time <= 1704110400s GROUP BY time(1h), "host"`
+			if q.Get("q") != want {
+				t.Errorf("q = %q", q.Get("q"))
+			}
+			io.WriteString(w, `{"results":[{"statement_id":0,"series":[
+				{"name":"cpu","tags":{"host":"edge-1"},"columns":["time","mean","max"],
+				 "values":[[1703505600000,0.25,0.5],[1703509200000,null,0.75]]}]}]}`)
+		})
+
+	r := models.TimeRange{Start: testNow.Add(-7 * 24 * time.Hour), End: testNow, Step: time.Hour}
+	response, err := p.QueryRange(context.Background(), testQuery, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Data) != 3 {
+		t.Fatalf("data = %+v", response.Data)
+	}
+	mean, max := response.Data[0], response.Data[2]
+	if mean.Value != 0.25 || mean.Labels["_field"] != "mean" || mean.Labels["_measurement"] != "cpu" || mean.Labels["host"] != "edge-1" {
+		t.Errorf("mean = %+v", mean)
+	}
+	if max.Value != 0.75 || max.Labels["_field"] != "max" || !max.Timestamp.Equal(r.Start.Add(time.Hour)) {
+		t.Errorf("max = %+v", max)
+	}
+}
+
+func TestProvider_Errors(t *testing.T) {
+	status, body := http.StatusBadRequest, `{"code":"invalid","message":"compilation failed: error at @1:6-1:12: undefined identifier bucket"}`
+	p := newTestProvider(t, Config{Org: "edge", Bucket: "telegraf", Token: "secret"}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	})
+	_, err := p.Query(context.Background(), testQuery)
+	var influxErr *Error
+	if !errors.As(err, &influxErr) || influxErr.StatusCode != http.StatusBadRequest || !strings.HasPrefix(influxErr.Message, "compilation failed") {
+		t.Errorf("err = %v", err)
+	}
+
+	v1 := newTestProvider(t, Config{Language: LanguageInfluxQL, Database: "telegraf"}, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"results":[{"statement_id":0,"error":"database not found: telegraf"}]}`)
+	})
+	if _, err := v1.Query(context.Background(), testQuery); err == nil || err.Error() != "influxdb: database not found: telegraf" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestProvider_HealthCheck(t *testing.T) {
+	for _, cfg := range []Config{
+		{Org: "edge", Bucket: "telegraf", Token: "secret"},
+		{Language: LanguageInfluxQL, Database: "telegraf"},
+	} {
+		healthy := true
+		p := newTestProvider(t, cfg, func(w http.ResponseWriter, r *http.Request) {
+			want := "/health"
+			if cfg.Language == LanguageInfluxQL {
+				want = "/ping"
+			}
+			if r.URL.Path != want || !healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+		if err := p.HealthCheck(context.Background()); err != nil {
+			t.Errorf("%s: %v", cfg.Language, err)
+		}
+		healthy = false
+		if err := p.HealthCheck(context.Background()); err == nil {
+			t.Errorf("%s: unavailable server reported healthy", cfg.Language)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Setenv("INFLUX_TOKEN", "")
+	tests := map[string]Config{
+		"flux without bucket": {URL: "http://influxdb:8086", Org: "edge", Token: "t"},
+		"flux without token":  {URL: "http://influxdb:8086", Org: "edge", Bucket: "b"},
+		"influxql without db": {URL: "http://influxdb:8086", Language: LanguageInfluxQL},
+		"unknown language":    {URL: "http://influxdb:8086", Language: "sql", Database: "d"},
+		"missing URL":         {Language: LanguageInfluxQL, Database: "d"},
+	}
+	for name, cfg := range tests {
+		if _, err := New(cfg, nil, testLogger); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	t.Setenv("INFLUX_TOKEN", "env-token")
+	p, err := New(Config{URL: "http://influxdb:8086", Org: "edge", Bucket: "b"}, nil, testLogger)
+	if err != nil || p.cfg.Token != "env-token" || p.cfg.Language != LanguageFlux {
+		t.Errorf("New = %+v, %v", p, err)
+	}
+}
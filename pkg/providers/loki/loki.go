@@ -0,0 +1,365 @@
+// Package loki queries Grafana Loki through its query_range API. Graphs run
+// LogQL metric queries, such as
+//
+//	sum by (pod) (rate({namespace="{{.Project}}", app="{{.Application}}"} |= "error" [{{duration .Step}}]))
+//
+// while graphs of type logs are log panels, whose log queries return the
+// most recent matching lines instead of points:
+//
+//	{namespace="{{.Project}}", app="{{.Application}}"} |= "error"
+package loki
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+// GraphTypeLogs marks graphs that are log panels
+const GraphTypeLogs = "logs"
+
+const (
+	defaultTimeout = time.Minute
+	// maxResponseSize bounds the responses read from the API
+	maxResponseSize = 64 << 20
+)
+
+// Config configures a Provider
+type Config struct {
+	// URL is the address of Loki or its query frontend, such as
+	// http://loki-gateway.monitoring
+	URL string `yaml:"url" json:"url"`
+	// TenantID is sent as X-Scope-OrgID to multi-tenant installations
+	TenantID string `yaml:"tenantID" json:"tenantID"`
+	// Token is sent as a bearer token, defaulting to LOKI_TOKEN from the
+	// environment
+	Token string `yaml:"token" json:"-"`
+	// Username and Password authenticate with basic auth instead, as
+	// Grafana Cloud expects
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"-"`
+}
+
+// Error is an error response from the API
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("loki: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("loki: %d %s", e.StatusCode, e.Message)
+}
+
+// Provider runs the dashboard graph queries against Loki
+type Provider struct {
+	baseURL    *url.URL
+	cfg        Config
+	dashboards *providers.Dashboards
+	httpClient *http.Client
+	now        func() time.Time
+	logger     *slog.Logger
+}
+
+var (
+	_ providers.MetricsQuerier = (*Provider)(nil)
+	_ providers.RangeQuerier   = (*Provider)(nil)
+	_ providers.GraphLister    = (*Provider)(nil)
+	_ providers.QueryDescriber = (*Provider)(nil)
+	_ providers.LogQuerier     = (*Provider)(nil)
+	_ providers.HealthChecker  = (*Provider)(nil)
+)
+
+// New creates a provider serving the graphs of dashboards
+func New(cfg Config, dashboards *providers.Dashboards, logger *slog.Logger) (*Provider, error) {
+	if cfg.Token == "" && cfg.Username == "" {
+		cfg.Token = os.Getenv("LOKI_TOKEN")
+	}
+	baseURL, err := url.Parse(cfg.URL)
+	if err != nil || baseURL.Host == "" || (baseURL.Scheme != "http" && baseURL.Scheme != "https") {
+		return nil, fmt.Errorf("loki: invalid URL %q", cfg.URL)
+	}
+
+	return &Provider{
+		baseURL:    baseURL,
+		cfg:        cfg,
+		dashboards: dashboards,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		now:        time.Now,
+		logger:     logger.With("component", "loki"),
+	}, nil
+}
+
+// Query runs the graph's metric query over its default window
+func (p *Provider) Query(ctx context.Context, query *models.MetricsQuery) (*models.MetricsResponse, error) {
+	return p.query(ctx, query, nil)
+}
+
+// QueryRange runs the graph's metric query over r
+func (p *Provider) QueryRange(ctx context.Context, query *models.MetricsQuery, r models.TimeRange) (*models.MetricsResponse, error) {
+	return p.query(ctx, query, &r)
+}
+
+// DescribeQuery renders the graph's query over r, or its default window
+func (p *Provider) DescribeQuery(ctx context.Context, query *models.MetricsQuery, r *models.TimeRange) (*providers.QueryDescription, error) {
+	return p.dashboards.Describe(query, r, p.now(), nil)
+}
+
+// QueryLogs returns up to limit of the most recent lines matching the log
+// query of a log panel, newest first
+func (p *Provider) QueryLogs(ctx context.Context, query *models.MetricsQuery, tr *models.TimeRange, limit int) (*models.LogsResponse, error) {
+	graph, err := p.dashboards.Graph(query)
+	if err != nil {
+		return nil, err
+	}
+	if graph.GraphType != GraphTypeLogs {
+		return nil, fmt.Errorf("loki: graph %s is not a log panel", graph.Name)
+	}
+	r := graph.TimeRange(tr, p.now())
+	expr, err := graph.Render(query, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// One line more than asked for tells whether the result is truncated
+	result, err := p.queryRange(ctx, expr, r, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	if result.ResultType != "streams" {
+		return nil, fmt.Errorf("loki: graph %s returned %s, not log lines", graph.Name, result.ResultType)
+	}
+	var streams []lokiStream
+	if err := json.Unmarshal(result.Result, &streams); err != nil {
+		return nil, fmt.Errorf("loki: invalid streams: %w", err)
+	}
+
+	entries := []models.LogEntry{}
+	for _, s := range streams {
+		for _, v := range s.Values {
+			ns, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("loki: invalid timestamp %q: %w", v[0], err)
+			}
+			entries = append(entries, models.LogEntry{
+				Timestamp: time.Unix(0, ns).UTC(),
+				Line:      v[1],
+				Labels:    s.Stream,
+			})
+		}
+	}
+	// Loki sorts within each stream only
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.After(entries[j].Timestamp)
+	})
+
+	response := &models.LogsResponse{
+		Application: query.Application,
+		Project:     query.Project,
+		Graph:       query.Graph,
+		Entries:     entries,
+	}
+	if len(entries) > limit {
+		response.Entries = entries[:limit]
+		response.Truncated = true
+	}
+	return response, nil
+}
+
+// ListGraphs lists the graphs of an application's dashboards
+func (p *Provider) ListGraphs(ctx context.Context, application, project string) ([]providers.GraphRef, error) {
+	return p.dashboards.ListGraphs(ctx, application, project)
+}
+
+// HealthCheck asks Loki whether it is ready to serve queries
+func (p *Provider) HealthCheck(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL.JoinPath("/ready").String(), nil)
+	if err != nil {
+		return fmt.Errorf("loki: %w", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (p *Provider) query(ctx context.Context, query *models.MetricsQuery, tr *models.TimeRange) (*models.MetricsResponse, error) {
+	graph, err := p.dashboards.Graph(query)
+	if err != nil {
+		return nil, err
+	}
+	if graph.GraphType == GraphTypeLogs {
+		return nil, fmt.Errorf("loki: graph %s is a log panel", graph.Name)
+	}
+	r := graph.TimeRange(tr, p.now())
+	expr, err := graph.Render(query, r)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := p.queryRange(ctx, expr, r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if result.ResultType != "matrix" {
+		return nil, fmt.Errorf("loki: graph %s returned %s, not a metric query result", graph.Name, result.ResultType)
+	}
+	var series []lokiSeries
+	if err := json.Unmarshal(result.Result, &series); err != nil {
+		return nil, fmt.Errorf("loki: invalid matrix: %w", err)
+	}
+
+	data := []models.MetricData{}
+	for _, s := range series {
+		for _, v := range s.Values {
+			point, err := v.metricData(s.Metric)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, point)
+		}
+	}
+
+	return &models.MetricsResponse{
+		Application: query.Application,
+		Project:     query.Project,
+		Graph:       query.Graph,
+		Data:        data,
+	}, nil
+}
+
+// queryResult is the data of a query_range response. Result holds a
+// []lokiSeries for matrix results and a []lokiStream for streams.
+type queryResult struct {
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// lokiSeries is a series of a metric query
+type lokiSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values []samplePair      `json:"values"`
+}
+
+// samplePair is a [seconds, "value"] pair of a series
+type samplePair [2]json.RawMessage
+
+func (v samplePair) metricData(labels map[string]string) (models.MetricData, error) {
+	var (
+		seconds float64
+		raw     string
+	)
+	if err := json.Unmarshal(v[0], &seconds); err != nil {
+		return models.MetricData{}, fmt.Errorf("loki: invalid sample time %s", v[0])
+	}
+	if err := json.Unmarshal(v[1], &raw); err != nil {
+		return models.MetricData{}, fmt.Errorf("loki: invalid sample value %s", v[1])
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return models.MetricData{}, fmt.Errorf("loki: invalid sample value %q", raw)
+	}
+	ms := int64(seconds*1000 + 0.5)
+	return models.MetricData{Timestamp: time.UnixMilli(ms).UTC(), Value: value, Labels: labels}, nil
+}
+
+// lokiStream is a stream of log lines, each a ["nanoseconds", "line"] pair
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// queryRange runs a LogQL query over r. A limit bounds the lines of log
+// queries, which are returned newest first.
+func (p *Provider) queryRange(ctx context.Context, logQL string, r models.TimeRange, limit int) (*queryResult, error) {
+	params := url.Values{}
+	params.Set("query", logQL)
+	params.Set("start", strconv.FormatInt(r.Start.UnixNano(), 10))
+	params.Set("end", strconv.FormatInt(r.End.UnixNano(), 10))
+	params.Set("step", strconv.FormatFloat(r.Step.Seconds(), 'f', -1, 64))
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+		params.Set("direction", "backward")
+	}
+
+	u := p.baseURL.JoinPath("/loki/api/v1/query_range")
+	u.RawQuery = params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("loki: %w", err)
+	}
+
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status string      `json:"status"`
+		Data   queryResult `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("loki: invalid response: %w", err)
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("loki: query status %q", body.Status)
+	}
+	return &body.Data, nil
+}
+
+// do authenticates and sends req, turning error responses into *Error
+func (p *Provider) do(req *http.Request) (*http.Response, error) {
+	if p.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", p.cfg.TenantID)
+	}
+	switch {
+	case p.cfg.Token != "":
+		req.Header.Set("Authorization", "Bearer "+p.cfg.Token)
+	case p.cfg.Username != "":
+		req.SetBasicAuth(p.cfg.Username, p.cfg.Password)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("loki: %w", err)
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return nil, &Error{StatusCode: resp.StatusCode, Message: errorMessage(body)}
+}
+
+// errorMessage extracts the message of an error response, which Loki sends
+// as plain text, or as JSON from some gateways
+func errorMessage(body []byte) string {
+	var errBody struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &errBody) == nil {
+		if errBody.Error != "" {
+			return errBody.Error
+		}
+		return errBody.Message
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,207 @@
+package loki
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+var (
+	testNow    = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	rateQuery  = &models.MetricsQuery{Application: "checkout", Project: "shop", GroupKind: "deployment", Row: "logs", Graph: "error-rate"}
+	linesQuery = &models.MetricsQuery{Application: "checkout", Project: "shop", GroupKind: "deployment", Row: "logs", Graph: "errors"}
+)
+
+const matrixResult = `{"status":"success","data":{"resultType":"matrix","result":[
+	{"metric":{"pod":"checkout-1"},"values":[[1704106800,"0.5"],[1704106812.5,"1.25"]]},
+	{"metric":{"pod":"checkout-2"},"values":[[1704106800,"0"]]}],
+	"stats":{}}}`
+
+// streamsResult holds two streams, each sorted newest first as Loki returns
+// them for backward queries
+const streamsResult = `{"status":"success","data":{"resultType":"streams","result":[
+	{"stream":{"pod":"checkout-1"},"values":[["1704110399000000000","error: timeout"],["1704110397000000000","error: refused"]]},
+	{"stream":{"pod":"checkout-2"},"values":[["1704110398000000000","error: reset"]]}]}}`
+
+func newTestProvider(t *testing.T, cfg Config, handler http.HandlerFunc) *Provider {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	dashboards, err := providers.NewDashboards(providers.DashboardConfig{Applications: []providers.ApplicationDashboards{{
+		Default: true,
+		Dashboards: []providers.Dashboard{{
+			GroupKind: "deployment",
+			Rows: []providers.DashboardRow{{
+				Name: "logs",
+				Graphs: []providers.Graph{
+					{Name: "error-rate", QueryExpression: `sum by (pod) (rate({namespace="{{.Project}}", app="{{.Application}}"} |= "error" [{{duration .Step}}]))`},
+					{Name: "errors", GraphType: GraphTypeLogs, QueryExpression: `{namespace="{{.Project}}", app="{{.Application}}"} |= "error"`},
+				},
+			}},
+		}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.URL = ts.URL
+	p, err := New(cfg, dashboards, testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.now = func() time.Time { return testNow }
+	return p
+}
+
+func TestProvider_Query(t *testing.T) {
+	p := newTestProvider(t, Config{TenantID: "team-a", Token: "secret"}, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/loki/api/v1/query_range" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if got := r.Header.Get("X-Scope-OrgID"); got != "team-a" {
+			t.Errorf("X-Scope-OrgID = %q", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		want := `sum by (pod) (rate({namespace="shop", app="checkout"} |= "error" [12s]))`
+		if q.Get("query") != want || q.Get("start") != "1704106800000000000" || q.Get("end") != "1704110400000000000" || q.Get("step") != "12" {
+			t.Errorf("params = %v", q)
+		}
+		if q.Has("limit") || q.Has("direction") {
+			t.Errorf("metric query sent limit or direction: %v", q)
+		}
+		io.WriteString(w, matrixResult)
+	})
+
+	response, err := p.Query(context.Background(), rateQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Application != "checkout" || response.Graph != "error-rate" || len(response.Data) != 3 {
+		t.Fatalf("response = %+v", response)
+	}
+	second := response.Data[1]
+	if second.Value != 1.25 || second.Labels["pod"] != "checkout-1" || !second.Timestamp.Equal(testNow.Add(-time.Hour+12500*time.Millisecond)) {
+		t.Errorf("second point = %+v", second)
+	}
+
+	if _, err := p.Query(context.Background(), linesQuery); err == nil {
+		t.Error("log panel queried for points")
+	}
+}
+
+func TestProvider_QueryLogs(t *testing.T) {
+	p := newTestProvider(t, Config{Username: "1234", Password: "pw"}, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if user, pass, ok := r.BasicAuth(); !ok || user != "1234" || pass != "pw" {
+			t.Errorf("basic auth = %q %q %v", user, pass, ok)
+		}
+		if q.Get("query") != `{namespace="shop", app="checkout"} |= "error"` || q.Get("direction") != "backward" {
+			t.Errorf("params = %v", q)
+		}
+		result := streamsResult
+		if q.Get("limit") == "4" {
+			// The limit is not reached
+			result = strings.Replace(result, `,["1704110397000000000","error: refused"]`, "", 1)
+		}
+		io.WriteString(w, result)
+	})
+
+	tr := &models.TimeRange{Start: testNow.Add(-5 * time.Minute), End: testNow}
+	logs, err := p.QueryLogs(context.Background(), linesQuery, tr, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !logs.Truncated || len(logs.Entries) != 2 {
+		t.Fatalf("logs = %+v", logs)
+	}
+	first, second := logs.Entries[0], logs.Entries[1]
+	if first.Line != "error: timeout" || !first.Timestamp.Equal(testNow.Add(-time.Second)) || first.Labels["pod"] != "checkout-1" {
+		t.Errorf("first entry = %+v", first)
+	}
+	if second.Line != "error: reset" || second.Labels["pod"] != "checkout-2" {
+		t.Errorf("second entry = %+v", second)
+	}
+
+	logs, err = p.QueryLogs(context.Background(), linesQuery, tr, 3)
+	if err != nil || logs.Truncated || len(logs.Entries) != 2 {
+		t.Errorf("QueryLogs = %+v, %v", logs, err)
+	}
+
+	if _, err := p.QueryLogs(context.Background(), rateQuery, tr, 10); err == nil {
+		t.Error("metric graph queried for log lines")
+	}
+}
+
+func TestProvider_Errors(t *testing.T) {
+	status, body := http.StatusBadRequest, "parse error at line 1, col 12: syntax error: unexpected IDENTIFIER\n"
+	p := newTestProvider(t, Config{}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	})
+
+	_, err := p.Query(context.Background(), rateQuery)
+	var lokiErr *Error
+	if !errors.As(err, &lokiErr) || lokiErr.StatusCode != http.StatusBadRequest || !strings.HasPrefix(lokiErr.Message, "parse error") {
+		t.Errorf("err = %v", err)
+	}
+
+	status, body = http.StatusUnauthorized, `{"status":"error","error":"no org id"}`
+	_, err = p.QueryLogs(context.Background(), linesQuery, nil, 10)
+	if !errors.As(err, &lokiErr) || lokiErr.Message != "no org id" {
+		t.Errorf("err = %v", err)
+	}
+
+	status, body = http.StatusOK, streamsResult
+	if _, err := p.Query(context.Background(), rateQuery); err == nil {
+		t.Error("streams accepted as a metric query result")
+	}
+}
+
+func TestProvider_HealthCheck(t *testing.T) {
+	ready := true
+	p := newTestProvider(t, Config{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" || !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, "Ingester not ready: waiting for 15s after being ready")
+			return
+		}
+		io.WriteString(w, "ready")
+	})
+	if err := p.HealthCheck(context.Background()); err != nil {
+		t.Error(err)
+	}
+	ready = false
+	if err := p.HealthCheck(context.Background()); err == nil {
+		t.Error("unready server reported healthy")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{URL: "loki:3100"}, nil, testLogger); err == nil {
+		t.Error("URL without scheme accepted")
+	}
+
+	t.Setenv("LOKI_TOKEN", "env-token")
+	p, err := New(Config{URL: "http://loki:3100"}, nil, testLogger)
+	if err != nil || p.cfg.Token != "env-token" {
+		t.Errorf("New = %+v, %v", p, err)
+	}
+	p, err = New(Config{URL: "http://loki:3100", Username: "1234"}, nil, testLogger)
+	if err != nil || p.cfg.Token != "" {
+		t.Errorf("New with basic auth = %+v, %v", p, err)
+	}
+}
@@ -0,0 +1,60 @@
+package providers
+
+import (
+	"context"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+// MetricsQuerier runs metrics queries; every provider implements it
+type MetricsQuerier interface {
+	Query(ctx context.Context, query *models.MetricsQuery) (*models.MetricsResponse, error)
+}
+
+// RangeQuerier is implemented by providers that can restrict a query to a
+// time range, such as a Prometheus range query. Points at both ends of the
+// range may be returned. A non-zero Step should be used as the resolution.
+type RangeQuerier interface {
+	QueryRange(ctx context.Context, query *models.MetricsQuery, r models.TimeRange) (*models.MetricsResponse, error)
+}
+
+// PointStreamer is implemented by providers that can yield data points
+// incrementally instead of materializing the whole MetricsResponse. The
+// provider must stop and return the error as soon as yield returns one.
+type PointStreamer interface {
+	QueryStream(ctx context.Context, query *models.MetricsQuery, yield func(models.MetricData) error) error
+}
+
+// QueryDescriber is implemented by providers that can report the query a
+// graph runs over r, or over its default window when r is nil, so exports
+// can record what they contain
+type QueryDescriber interface {
+	DescribeQuery(ctx context.Context, query *models.MetricsQuery, r *models.TimeRange) (*QueryDescription, error)
+}
+
+// QueryDescription is the rendered query of a graph and the time range it
+// covers. Running a RangeQuerier over Range runs Expression.
+type QueryDescription struct {
+	Expression string
+	Range      models.TimeRange
+}
+
+// GraphRef identifies a dashboard graph
+type GraphRef struct {
+	GroupKind string `yaml:"groupkind" json:"groupkind"`
+	Row       string `yaml:"row" json:"row"`
+	Graph     string `yaml:"graph" json:"graph"`
+}
+
+// GraphLister is implemented by providers that know an application's
+// dashboard, so that all of its graphs can be exported together
+type GraphLister interface {
+	ListGraphs(ctx context.Context, application, project string) ([]GraphRef, error)
+}
+
+// LogQuerier is implemented by providers serving log panels, whose graphs
+// return recent log lines instead of data points. Without a range r, the
+// graph's default window is used.
+type LogQuerier interface {
+	QueryLogs(ctx context.Context, query *models.MetricsQuery, r *models.TimeRange, limit int) (*models.LogsResponse, error)
+}
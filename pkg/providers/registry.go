@@ -0,0 +1,524 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+// Defaults for RegistryConfig
+const (
+	defaultProviderTimeout = 30 * time.Second
+	defaultHealthInterval  = 30 * time.Second
+)
+
+var (
+	// ErrNoProvider is returned when no route matches a query and no
+	// default provider is configured
+	ErrNoProvider = errors.New("no metrics provider configured for application")
+	// ErrUnknownProvider is returned when a dashboard names a provider that
+	// is not registered
+	ErrUnknownProvider = errors.New("unknown metrics provider")
+	// ErrNotSupported is returned when the routed provider lacks an
+	// optional capability, such as range queries or listing graphs
+	ErrNotSupported = errors.New("operation not supported by metrics provider")
+)
+
+// HealthChecker is implemented by providers that can probe their backend,
+// such as with a Prometheus /-/ready request
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// ClusterResolver looks up the destination cluster of an application, for
+// routes matching on clusters. Argo CD does not forward it with proxied
+// requests, so it is usually read from the Application resource.
+type ClusterResolver interface {
+	DestinationCluster(ctx context.Context, application, project string) (string, error)
+}
+
+// DashboardResolver returns the provider named by an application's
+// dashboard settings, or "" when the dashboard does not name one. An
+// explicit setting takes precedence over every route.
+type DashboardResolver interface {
+	DashboardProvider(ctx context.Context, query *models.MetricsQuery) (string, error)
+}
+
+// RegistryConfig configures the providers of a Registry and how queries are
+// routed between them
+type RegistryConfig struct {
+	// Default serves queries matching no route; without one such queries
+	// fail with ErrNoProvider
+	Default string `yaml:"default" json:"default"`
+	// HealthInterval is the time between health checks (default 30s)
+	HealthInterval time.Duration    `yaml:"healthInterval" json:"healthInterval"`
+	Providers      []ProviderConfig `yaml:"providers" json:"providers"`
+	// Routes are evaluated in order; the first match wins
+	Routes []Route `yaml:"routes" json:"routes"`
+}
+
+// ProviderConfig holds the settings of one named provider
+type ProviderConfig struct {
+	Name string `yaml:"name" json:"name"`
+	// Timeout bounds each query and health check (default 30s)
+	Timeout time.Duration `yaml:"timeout" json:"timeout"`
+	// Required providers fail the health endpoint when unhealthy, as the
+	// default provider does; others are only reported
+	Required bool `yaml:"required" json:"required"`
+}
+
+// Route sends matching queries to a provider. Each list holds glob patterns
+// such as "payments-*"; a route matches when every non-empty list has a
+// matching entry.
+type Route struct {
+	Provider     string   `yaml:"provider" json:"provider"`
+	Applications []string `yaml:"applications" json:"applications,omitempty"`
+	Projects     []string `yaml:"projects" json:"projects,omitempty"`
+	// Clusters match the destination server URL or cluster name returned by
+	// the ClusterResolver
+	Clusters []string `yaml:"clusters" json:"clusters,omitempty"`
+}
+
+// ProviderHealth is the health of one provider, from the latest health
+// check or query
+type ProviderHealth struct {
+	Name                string     `json:"name"`
+	Healthy             bool       `json:"healthy"`
+	Required            bool       `json:"required"`
+	LastCheck           *time.Time `json:"last_check,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// registeredProvider is a provider with its settings and health
+type registeredProvider struct {
+	name     string
+	provider MetricsQuerier
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	health ProviderHealth
+}
+
+// record updates the provider's health with the outcome of a request.
+// Context cancellation by the caller says nothing about the backend and is
+// ignored.
+func (p *registeredProvider) record(err error, now time.Time) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.health.LastCheck = &now
+	if err != nil {
+		p.health.Healthy = false
+		p.health.LastError = err.Error()
+		p.health.ConsecutiveFailures++
+		return
+	}
+	p.health.Healthy = true
+	p.health.LastError = ""
+	p.health.ConsecutiveFailures = 0
+}
+
+// Registry routes metrics queries between several named providers, such as
+// one Prometheus per region or tenant. It implements every optional
+// provider interface of the server and fails with ErrNotSupported when the
+// routed provider lacks one, so callers fall back as they would for a single
+// provider and a Registry can be used wherever a single provider is.
+type Registry struct {
+	providers map[string]*registeredProvider
+	names     []string
+	routes    []Route
+	def       string
+	interval  time.Duration
+	clusters  ClusterResolver
+	dashboard DashboardResolver
+	now       func() time.Time
+	logger    *slog.Logger
+}
+
+var (
+	_ MetricsQuerier = (*Registry)(nil)
+	_ RangeQuerier   = (*Registry)(nil)
+	_ PointStreamer  = (*Registry)(nil)
+	_ GraphLister    = (*Registry)(nil)
+	_ LogQuerier     = (*Registry)(nil)
+	_ QueryDescriber = (*Registry)(nil)
+)
+
+// NewRegistry creates a registry of the given providers, keyed by name.
+// Every provider in cfg.Providers, every route and the default must name
+// one of them. Call Start to run the health checks.
+func NewRegistry(cfg RegistryConfig, providers map[string]MetricsQuerier, logger *slog.Logger) (*Registry, error) {
+	if len(providers) == 0 {
+		return nil, errors.New("provider registry requires at least one provider")
+	}
+
+	r := &Registry{
+		providers: make(map[string]*registeredProvider, len(providers)),
+		routes:    cfg.Routes,
+		def:       cfg.Default,
+		interval:  cfg.HealthInterval,
+		now:       time.Now,
+		logger:    logger.With("component", "provider-registry"),
+	}
+	if r.interval <= 0 {
+		r.interval = defaultHealthInterval
+	}
+	for name, p := range providers {
+		r.providers[name] = &registeredProvider{
+			name:     name,
+			provider: p,
+			timeout:  defaultProviderTimeout,
+			health:   ProviderHealth{Name: name, Healthy: true},
+		}
+		r.names = append(r.names, name)
+	}
+	sort.Strings(r.names)
+
+	for _, pc := range cfg.Providers {
+		p, ok := r.providers[pc.Name]
+		if !ok {
+			return nil, fmt.Errorf("provider %q is configured but not registered", pc.Name)
+		}
+		if pc.Timeout > 0 {
+			p.timeout = pc.Timeout
+		}
+		p.health.Required = pc.Required
+	}
+	def, ok := r.providers[r.def]
+	if r.def != "" && !ok {
+		return nil, fmt.Errorf("default provider %q is not registered", r.def)
+	}
+	if ok {
+		def.health.Required = true
+	}
+	for i, route := range r.routes {
+		if _, ok := r.providers[route.Provider]; !ok {
+			return nil, fmt.Errorf("route %d: provider %q is not registered", i+1, route.Provider)
+		}
+		for _, patterns := range [][]string{route.Applications, route.Projects, route.Clusters} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("route %d: invalid pattern %q: %w", i+1, pattern, err)
+				}
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// SetClusterResolver sets the lookup used by routes matching on clusters.
+// Without one such routes never match.
+func (r *Registry) SetClusterResolver(resolver ClusterResolver) {
+	r.clusters = resolver
+}
+
+// SetDashboardResolver sets the lookup of providers named by dashboards
+func (r *Registry) SetDashboardResolver(resolver DashboardResolver) {
+	r.dashboard = resolver
+}
+
+// Start runs the health checks of providers implementing HealthChecker
+// until ctx is done
+func (r *Registry) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			r.CheckHealth(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// CheckHealth probes every provider implementing HealthChecker concurrently
+func (r *Registry) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, name := range r.names {
+		p := r.providers[name]
+		checker, ok := p.provider.(HealthChecker)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, p.timeout)
+			defer cancel()
+			err := checker.HealthCheck(ctx)
+			if err != nil && ctx.Err() == nil {
+				r.logger.Warn("provider health check failed", "provider", p.name, "error", err)
+			}
+			r.record(p, err)
+		}()
+	}
+	wg.Wait()
+}
+
+// Health returns the health of every provider, sorted by name
+func (r *Registry) Health() []ProviderHealth {
+	health := make([]ProviderHealth, len(r.names))
+	for i, name := range r.names {
+		p := r.providers[name]
+		p.mu.Lock()
+		health[i] = p.health
+		p.mu.Unlock()
+	}
+	return health
+}
+
+// HealthHandler serves the health of every provider as JSON, with status
+// 503 when the default provider or a required one is unhealthy. Other
+// providers only serve some applications, so they do not fail readiness.
+func (r *Registry) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		health := r.Health()
+		status := http.StatusOK
+		for _, h := range health {
+			if h.Required && !h.Healthy {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"providers": health})
+	})
+}
+
+// Route returns the name of the provider serving query
+func (r *Registry) Route(ctx context.Context, query *models.MetricsQuery) (string, error) {
+	p, err := r.route(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	return p.name, nil
+}
+
+func (r *Registry) route(ctx context.Context, query *models.MetricsQuery) (*registeredProvider, error) {
+	if r.dashboard != nil {
+		name, err := r.dashboard.DashboardProvider(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dashboard provider: %w", err)
+		}
+		if name != "" {
+			p, ok := r.providers[name]
+			if !ok {
+				return nil, fmt.Errorf("%w %q named by dashboard of %s", ErrUnknownProvider, name, query.Application)
+			}
+			return p, nil
+		}
+	}
+
+	// The cluster is looked up at most once, and only if a route needs it
+	var cluster string
+	var clusterErr error
+	clusterResolved := false
+	for _, route := range r.routes {
+		if !matchAny(route.Applications, query.Application) || !matchAny(route.Projects, query.Project) {
+			continue
+		}
+		if len(route.Clusters) > 0 {
+			if r.clusters == nil {
+				continue
+			}
+			if !clusterResolved {
+				cluster, clusterErr = r.clusters.DestinationCluster(ctx, query.Application, query.Project)
+				clusterResolved = true
+				if clusterErr != nil {
+					r.logger.Warn("failed to resolve destination cluster",
+						"application", query.Application,
+						"project", query.Project,
+						"error", clusterErr,
+					)
+				}
+			}
+			if clusterErr != nil || !matchAny(route.Clusters, cluster) {
+				continue
+			}
+		}
+		return r.providers[route.Provider], nil
+	}
+
+	if r.def == "" {
+		return nil, fmt.Errorf("%w %s in project %s", ErrNoProvider, query.Application, query.Project)
+	}
+	return r.providers[r.def], nil
+}
+
+// matchAny reports whether value matches one of patterns; an empty list
+// matches everything
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// record updates p's health, logging when it changes
+func (r *Registry) record(p *registeredProvider, err error) {
+	p.mu.Lock()
+	wasHealthy := p.health.Healthy
+	p.mu.Unlock()
+	p.record(err, r.now())
+	if wasHealthy && err != nil && !errors.Is(err, context.Canceled) {
+		r.logger.Warn("provider marked unhealthy", "provider", p.name, "error", err)
+	} else if !wasHealthy && err == nil {
+		r.logger.Info("provider recovered", "provider", p.name)
+	}
+}
+
+// do runs fn against the provider routed for query under its timeout,
+// recording the outcome in its health
+func (r *Registry) do(ctx context.Context, query *models.MetricsQuery, fn func(ctx context.Context, p *registeredProvider) error) error {
+	p, err := r.route(ctx, query)
+	if err != nil {
+		return err
+	}
+	r.logger.Debug("routing query",
+		"provider", p.name,
+		"application", query.Application,
+		"project", query.Project,
+		"graph", query.Graph,
+	)
+
+	queryCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	err = fn(queryCtx, p)
+	if err == nil {
+		r.record(p, nil)
+		return nil
+	}
+	// A caller going away says nothing about the provider's health
+	if ctx.Err() == nil && !errors.Is(err, ErrNotSupported) {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s: %w", p.timeout, err)
+		}
+		r.record(p, err)
+	}
+	return fmt.Errorf("provider %s: %w", p.name, err)
+}
+
+// Query runs query against the routed provider
+func (r *Registry) Query(ctx context.Context, query *models.MetricsQuery) (*models.MetricsResponse, error) {
+	var response *models.MetricsResponse
+	err := r.do(ctx, query, func(ctx context.Context, p *registeredProvider) error {
+		var err error
+		response, err = p.provider.Query(ctx, query)
+		return err
+	})
+	return response, err
+}
+
+// QueryRange runs a range query against the routed provider, failing with
+// ErrNotSupported if that provider cannot restrict a query to a range
+func (r *Registry) QueryRange(ctx context.Context, query *models.MetricsQuery, tr models.TimeRange) (*models.MetricsResponse, error) {
+	var response *models.MetricsResponse
+	err := r.do(ctx, query, func(ctx context.Context, p *registeredProvider) error {
+		rq, ok := p.provider.(RangeQuerier)
+		if !ok {
+			return ErrNotSupported
+		}
+		var err error
+		response, err = rq.QueryRange(ctx, query, tr)
+		return err
+	})
+	return response, err
+}
+
+// QueryStream yields the points of query from the routed provider, failing
+// with ErrNotSupported, before yielding anything, if that provider cannot
+// stream. The provider timeout covers the whole stream.
+func (r *Registry) QueryStream(ctx context.Context, query *models.MetricsQuery, yield func(models.MetricData) error) error {
+	// Errors from yield, such as a client disconnecting, are returned as
+	// they are rather than counted against the provider
+	var yieldErr error
+	track := func(data models.MetricData) error {
+		yieldErr = yield(data)
+		return yieldErr
+	}
+	err := r.do(ctx, query, func(ctx context.Context, p *registeredProvider) error {
+		streamer, ok := p.provider.(PointStreamer)
+		if !ok {
+			return ErrNotSupported
+		}
+		err := streamer.QueryStream(ctx, query, track)
+		if yieldErr != nil {
+			return nil
+		}
+		return err
+	})
+	if yieldErr != nil {
+		return yieldErr
+	}
+	return err
+}
+
+// ListGraphs lists the graphs of an application from its routed provider,
+// failing with ErrNotSupported if that provider cannot list them
+func (r *Registry) ListGraphs(ctx context.Context, application, project string) ([]GraphRef, error) {
+	var graphs []GraphRef
+	query := &models.MetricsQuery{Application: application, Project: project}
+	err := r.do(ctx, query, func(ctx context.Context, p *registeredProvider) error {
+		lister, ok := p.provider.(GraphLister)
+		if !ok {
+			return ErrNotSupported
+		}
+		var err error
+		graphs, err = lister.ListGraphs(ctx, application, project)
+		return err
+	})
+	return graphs, err
+}
+
+// DescribeQuery describes query on the routed provider, failing with
+// ErrNotSupported if that provider cannot describe its queries
+func (r *Registry) DescribeQuery(ctx context.Context, query *models.MetricsQuery, tr *models.TimeRange) (*QueryDescription, error) {
+	var desc *QueryDescription
+	err := r.do(ctx, query, func(ctx context.Context, p *registeredProvider) error {
+		describer, ok := p.provider.(QueryDescriber)
+		if !ok {
+			return ErrNotSupported
+		}
+		var err error
+		desc, err = describer.DescribeQuery(ctx, query, tr)
+		return err
+	})
+	return desc, err
+}
+
+// QueryLogs returns the log lines of a log panel from the routed provider,
+// failing with ErrNotSupported if that provider has no log panels
+func (r *Registry) QueryLogs(ctx context.Context, query *models.MetricsQuery, tr *models.TimeRange, limit int) (*models.LogsResponse, error) {
+	var response *models.LogsResponse
+	err := r.do(ctx, query, func(ctx context.Context, p *registeredProvider) error {
+		querier, ok := p.provider.(LogQuerier)
+		if !ok {
+			return ErrNotSupported
+		}
+		var err error
+		response, err = querier.QueryLogs(ctx, query, tr, limit)
+		return err
+	})
+	return response, err
+}
@@ -0,0 +1,359 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// fakeProvider answers every query with one point labeled with its name
+type fakeProvider struct {
+	name   string
+	delay  time.Duration
+	err    error
+	health error
+}
+
+func (p *fakeProvider) Query(ctx context.Context, query *models.MetricsQuery) (*models.MetricsResponse, error) {
+	// A canceled query must fail even without a delay, which select alone
+	// would pick at random
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	select {
+	case <-time.After(p.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &models.MetricsResponse{
+		Application: query.Application,
+		Data: []models.MetricData{
+			{Timestamp: base, Value: 1, Labels: map[string]string{"provider": p.name}},
+			{Timestamp: base.Add(time.Hour), Value: 2, Labels: map[string]string{"provider": p.name}},
+		},
+	}, nil
+}
+
+func (p *fakeProvider) HealthCheck(ctx context.Context) error {
+	return p.health
+}
+
+// listingProvider also lists graphs, serves logs, and queries ranges and
+// streams points
+type listingProvider struct {
+	fakeProvider
+}
+
+func (p *listingProvider) ListGraphs(ctx context.Context, application, project string) ([]GraphRef, error) {
+	return []GraphRef{{GroupKind: "deployment", Row: "http", Graph: p.name}}, nil
+}
+
+func (p *listingProvider) QueryLogs(ctx context.Context, query *models.MetricsQuery, tr *models.TimeRange, limit int) (*models.LogsResponse, error) {
+	return &models.LogsResponse{Application: query.Application, Entries: []models.LogEntry{{Line: p.name}}}, nil
+}
+
+func (p *listingProvider) QueryRange(ctx context.Context, query *models.MetricsQuery, tr models.TimeRange) (*models.MetricsResponse, error) {
+	response, err := p.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	filtered := *response
+	filtered.Data = nil
+	for _, d := range response.Data {
+		if tr.Contains(d.Timestamp) {
+			filtered.Data = append(filtered.Data, d)
+		}
+	}
+	return &filtered, nil
+}
+
+func (p *listingProvider) QueryStream(ctx context.Context, query *models.MetricsQuery, yield func(models.MetricData) error) error {
+	response, err := p.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	for _, d := range response.Data {
+		if err := yield(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type clusterMap map[string]string
+
+func (m clusterMap) DestinationCluster(ctx context.Context, application, project string) (string, error) {
+	cluster, ok := m[application]
+	if !ok {
+		return "", errors.New("application not found")
+	}
+	return cluster, nil
+}
+
+type dashboardMap map[string]string
+
+func (m dashboardMap) DashboardProvider(ctx context.Context, query *models.MetricsQuery) (string, error) {
+	return m[query.Application], nil
+}
+
+func newTestRegistry(t *testing.T, cfg RegistryConfig, providers ...MetricsQuerier) *Registry {
+	t.Helper()
+	byName := make(map[string]MetricsQuerier)
+	for _, p := range providers {
+		switch p := p.(type) {
+		case *fakeProvider:
+			byName[p.name] = p
+		case *listingProvider:
+			byName[p.name] = p
+		}
+	}
+	r, err := NewRegistry(cfg, byName, testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRegistry_Route(t *testing.T) {
+	r := newTestRegistry(t, RegistryConfig{
+		Default: "global",
+		Routes: []Route{
+			{Provider: "eu", Applications: []string{"payments-eu-*"}},
+			{Provider: "tenant-a", Projects: []string{"tenant-a"}},
+			{Provider: "us", Projects: []string{"prod"}, Clusters: []string{"us-*"}},
+		},
+	},
+		&fakeProvider{name: "global"},
+		&fakeProvider{name: "eu"},
+		&fakeProvider{name: "us"},
+		&fakeProvider{name: "tenant-a"},
+	)
+	r.SetClusterResolver(clusterMap{"checkout": "us-east-1", "search": "eu-west-1"})
+	r.SetDashboardResolver(dashboardMap{"billing": "eu"})
+
+	tests := []struct {
+		application, project string
+		want                 string
+	}{
+		{"payments-eu-1", "prod", "eu"},
+		{"payments-eu-1", "tenant-a", "eu"}, // first matching route wins
+		{"payments", "tenant-a", "tenant-a"},
+		{"checkout", "prod", "us"},
+		{"search", "prod", "global"},  // cluster does not match
+		{"unknown", "prod", "global"}, // cluster lookup fails
+		{"checkout", "staging", "global"},
+		{"billing", "tenant-a", "eu"}, // dashboard setting overrides routes
+	}
+	for _, tt := range tests {
+		query := &models.MetricsQuery{Application: tt.application, Project: tt.project}
+		got, err := r.Route(context.Background(), query)
+		if err != nil {
+			t.Errorf("Route(%s, %s): %v", tt.application, tt.project, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Route(%s, %s) = %s, want %s", tt.application, tt.project, got, tt.want)
+		}
+
+		response, err := r.Query(context.Background(), query)
+		if err != nil || response.Data[0].Labels["provider"] != tt.want {
+			t.Errorf("Query(%s, %s) = %+v, %v", tt.application, tt.project, response, err)
+		}
+	}
+}
+
+func TestRegistry_RouteErrors(t *testing.T) {
+	r := newTestRegistry(t, RegistryConfig{
+		Routes: []Route{{Provider: "eu", Applications: []string{"payments"}}},
+	}, &fakeProvider{name: "eu"})
+	r.SetDashboardResolver(dashboardMap{"billing": "missing"})
+
+	if _, err := r.Query(context.Background(), &models.MetricsQuery{Application: "checkout"}); !errors.Is(err, ErrNoProvider) {
+		t.Errorf("unrouted query: err = %v", err)
+	}
+	if _, err := r.Query(context.Background(), &models.MetricsQuery{Application: "billing"}); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("unknown dashboard provider: err = %v", err)
+	}
+}
+
+func TestNewRegistry_Invalid(t *testing.T) {
+	providers := map[string]MetricsQuerier{"global": &fakeProvider{name: "global"}}
+	tests := map[string]RegistryConfig{
+		"unknown default":  {Default: "eu"},
+		"unknown route":    {Routes: []Route{{Provider: "eu"}}},
+		"unknown settings": {Providers: []ProviderConfig{{Name: "eu", Timeout: time.Second}}},
+		"bad pattern":      {Routes: []Route{{Provider: "global", Applications: []string{"[payments"}}}},
+	}
+	for name, cfg := range tests {
+		if _, err := NewRegistry(cfg, providers, testLogger); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if _, err := NewRegistry(RegistryConfig{}, nil, testLogger); err == nil {
+		t.Error("empty registry accepted")
+	}
+}
+
+func TestRegistry_TimeoutAndHealth(t *testing.T) {
+	slow := &fakeProvider{name: "slow", delay: time.Second}
+	r := newTestRegistry(t, RegistryConfig{
+		Default:   "slow",
+		Providers: []ProviderConfig{{Name: "slow", Timeout: 20 * time.Millisecond}},
+	}, slow, &fakeProvider{name: "fast"})
+
+	start := time.Now()
+	_, err := r.Query(context.Background(), &models.MetricsQuery{Application: "payments"})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("slow query: err = %v after %s", err, time.Since(start))
+	}
+
+	health := r.Health()
+	if health[0].Name != "fast" || !health[0].Healthy || health[1].Healthy || health[1].ConsecutiveFailures != 1 {
+		t.Errorf("health = %+v", health)
+	}
+	rr := httptest.NewRecorder()
+	r.HealthHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/providers/health", nil))
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), "timed out") {
+		t.Errorf("health endpoint = %d %s", rr.Code, rr.Body.String())
+	}
+
+	// A caller giving up does not count against the provider
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow.delay = 0
+	r.Query(ctx, &models.MetricsQuery{Application: "payments"})
+	if r.Health()[1].ConsecutiveFailures != 1 {
+		t.Errorf("canceled query recorded: %+v", r.Health()[1])
+	}
+
+	// Health checks and successful queries restore it
+	r.CheckHealth(context.Background())
+	if h := r.Health()[1]; !h.Healthy || h.LastError != "" {
+		t.Errorf("after health check: %+v", h)
+	}
+	slow.health = errors.New("connection refused")
+	r.CheckHealth(context.Background())
+	if h := r.Health()[1]; h.Healthy || h.LastError != "connection refused" {
+		t.Errorf("after failed health check: %+v", h)
+	}
+	if _, err := r.Query(context.Background(), &models.MetricsQuery{Application: "payments"}); err != nil {
+		t.Fatal(err)
+	}
+	if h := r.Health()[1]; !h.Healthy {
+		t.Errorf("after successful query: %+v", h)
+	}
+}
+
+func TestRegistry_HealthReadiness(t *testing.T) {
+	down := errors.New("connection refused")
+	eu, us, apac := &fakeProvider{name: "eu"}, &fakeProvider{name: "us"}, &fakeProvider{name: "apac"}
+	r := newTestRegistry(t, RegistryConfig{
+		Default:   "eu",
+		Providers: []ProviderConfig{{Name: "us", Required: true}},
+	}, eu, us, apac)
+
+	status := func() int {
+		r.CheckHealth(context.Background())
+		rr := httptest.NewRecorder()
+		r.HealthHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/providers/health", nil))
+		return rr.Code
+	}
+
+	// An optional provider is reported but does not fail readiness
+	apac.health = down
+	if code := status(); code != http.StatusOK {
+		t.Errorf("optional provider down: status %d, want 200", code)
+	}
+	us.health = down
+	if code := status(); code != http.StatusServiceUnavailable {
+		t.Errorf("required provider down: status %d, want 503", code)
+	}
+	us.health, eu.health = nil, down
+	if code := status(); code != http.StatusServiceUnavailable {
+		t.Errorf("default provider down: status %d, want 503", code)
+	}
+
+	for _, h := range r.Health() {
+		if want := h.Name != "apac"; h.Required != want {
+			t.Errorf("%s: required = %v, want %v", h.Name, h.Required, want)
+		}
+	}
+}
+
+func TestRegistry_OptionalInterfaces(t *testing.T) {
+	r := newTestRegistry(t, RegistryConfig{
+		Default: "plain",
+		Routes:  []Route{{Provider: "listing", Projects: []string{"listed"}}},
+	}, &fakeProvider{name: "plain"}, &listingProvider{fakeProvider{name: "listing"}})
+	ctx := context.Background()
+	query := &models.MetricsQuery{Application: "payments", Project: "prod"}
+
+	listed := &models.MetricsQuery{Application: "payments", Project: "listed"}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr := models.TimeRange{Start: base.Add(time.Minute), End: base.Add(2 * time.Hour)}
+	if _, err := r.QueryRange(ctx, query, tr); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("QueryRange on plain provider: err = %v", err)
+	}
+	response, err := r.QueryRange(ctx, listed, tr)
+	if err != nil || len(response.Data) != 1 || response.Data[0].Value != 2 {
+		t.Errorf("QueryRange = %+v, %v", response, err)
+	}
+
+	var streamed int
+	count := func(models.MetricData) error { streamed++; return nil }
+	if err := r.QueryStream(ctx, query, count); !errors.Is(err, ErrNotSupported) || streamed != 0 {
+		t.Errorf("QueryStream on plain provider yielded %d points, err = %v", streamed, err)
+	}
+	if err := r.QueryStream(ctx, listed, count); err != nil || streamed != 2 {
+		t.Errorf("QueryStream yielded %d points, err = %v", streamed, err)
+	}
+	stop := errors.New("client went away")
+	if err := r.QueryStream(ctx, listed, func(models.MetricData) error { return stop }); err != stop {
+		t.Errorf("QueryStream err = %v", err)
+	}
+
+	if _, err := r.ListGraphs(ctx, "payments", "prod"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("ListGraphs on plain provider: err = %v", err)
+	}
+	graphs, err := r.ListGraphs(ctx, "payments", "listed")
+	if err != nil || len(graphs) != 1 || graphs[0].Graph != "listing" {
+		t.Errorf("ListGraphs = %+v, %v", graphs, err)
+	}
+
+	if _, err := r.QueryLogs(ctx, query, nil, 10); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("QueryLogs on plain provider: err = %v", err)
+	}
+	logs, err := r.QueryLogs(ctx, listed, nil, 10)
+	if err != nil || len(logs.Entries) != 1 || logs.Entries[0].Line != "listing" {
+		t.Errorf("QueryLogs = %+v, %v", logs, err)
+	}
+
+	for _, h := range r.Health() {
+		if !h.Healthy {
+			t.Errorf("%s marked unhealthy: %+v", h.Name, h)
+		}
+	}
+	var body struct {
+		Providers []ProviderHealth `json:"providers"`
+	}
+	rr := httptest.NewRecorder()
+	r.HealthHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/providers/health", nil))
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || rr.Code != http.StatusOK || len(body.Providers) != 2 {
+		t.Errorf("health endpoint = %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,260 @@
+// Package wavefront queries metrics from Wavefront (VMware Aria Operations
+// for Applications) through its chart API. Graph query expressions are WQL,
+// such as
+//
+//	sum(rate(ts(kubernetes.pod.cpu.usage_rate, label.app="{{.Application}}")), pod_name)
+package wavefront
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+const (
+	defaultTimeout = time.Minute
+	// maxResponseSize bounds the responses read from the API
+	maxResponseSize = 64 << 20
+)
+
+// Config configures a Provider
+type Config struct {
+	// URL is the address of the Wavefront cluster, such as
+	// https://example.wavefront.com
+	URL string `yaml:"url" json:"url"`
+	// Token is an API token of a user or service account, defaulting to
+	// WAVEFRONT_API_TOKEN from the environment
+	Token string `yaml:"token" json:"-"`
+	// Summarization aggregates points sharing a time bucket: MEAN (default),
+	// MEDIAN, MIN, MAX, SUM, COUNT, LAST or FIRST
+	Summarization string `yaml:"summarization" json:"summarization"`
+}
+
+// Error is an error response from the API
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("wavefront: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("wavefront: %d %s", e.StatusCode, e.Message)
+}
+
+// Provider runs the dashboard graph queries against Wavefront
+type Provider struct {
+	baseURL    *url.URL
+	cfg        Config
+	dashboards *providers.Dashboards
+	httpClient *http.Client
+	now        func() time.Time
+	logger     *slog.Logger
+}
+
+var (
+	_ providers.MetricsQuerier = (*Provider)(nil)
+	_ providers.RangeQuerier   = (*Provider)(nil)
+	_ providers.GraphLister    = (*Provider)(nil)
+	_ providers.QueryDescriber = (*Provider)(nil)
+)
+
+// New creates a provider serving the graphs of dashboards
+func New(cfg Config, dashboards *providers.Dashboards, logger *slog.Logger) (*Provider, error) {
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("WAVEFRONT_API_TOKEN")
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("wavefront: API token is required")
+	}
+	if cfg.Summarization == "" {
+		cfg.Summarization = "MEAN"
+	}
+	baseURL, err := url.Parse(cfg.URL)
+	if err != nil || baseURL.Host == "" || (baseURL.Scheme != "http" && baseURL.Scheme != "https") {
+		return nil, fmt.Errorf("wavefront: invalid URL %q", cfg.URL)
+	}
+
+	return &Provider{
+		baseURL:    baseURL,
+		cfg:        cfg,
+		dashboards: dashboards,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		now:        time.Now,
+		logger:     logger.With("component", "wavefront"),
+	}, nil
+}
+
+// Query runs the graph's query over its default window
+func (p *Provider) Query(ctx context.Context, query *models.MetricsQuery) (*models.MetricsResponse, error) {
+	return p.query(ctx, query, nil)
+}
+
+// QueryRange runs the graph's query over r, at the coarsest granularity not
+// exceeding its step
+func (p *Provider) QueryRange(ctx context.Context, query *models.MetricsQuery, r models.TimeRange) (*models.MetricsResponse, error) {
+	return p.query(ctx, query, &r)
+}
+
+// DescribeQuery renders the graph's query over r, or its default window
+func (p *Provider) DescribeQuery(ctx context.Context, query *models.MetricsQuery, r *models.TimeRange) (*providers.QueryDescription, error) {
+	return p.dashboards.Describe(query, r, p.now(), nil)
+}
+
+// ListGraphs lists the graphs of an application's dashboards
+func (p *Provider) ListGraphs(ctx context.Context, application, project string) ([]providers.GraphRef, error) {
+	return p.dashboards.ListGraphs(ctx, application, project)
+}
+
+// chartResponse is the response of /api/v2/chart/api
+type chartResponse struct {
+	Timeseries []timeseries `json:"timeseries"`
+	Warnings   string       `json:"warnings"`
+}
+
+type timeseries struct {
+	// Label is the metric name
+	Label string            `json:"label"`
+	Host  string            `json:"host"`
+	Tags  map[string]string `json:"tags"`
+	// Data holds [seconds, value] pairs
+	Data [][2]float64 `json:"data"`
+}
+
+func (p *Provider) query(ctx context.Context, query *models.MetricsQuery, tr *models.TimeRange) (*models.MetricsResponse, error) {
+	graph, err := p.dashboards.Graph(query)
+	if err != nil {
+		return nil, err
+	}
+	r := graph.TimeRange(tr, p.now())
+	wql, err := graph.Render(query, r)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("q", wql)
+	params.Set("queryType", "WQL")
+	params.Set("s", strconv.FormatInt(r.Start.UnixMilli(), 10))
+	params.Set("e", strconv.FormatInt(r.End.UnixMilli(), 10))
+	params.Set("g", granularity(r.Step))
+	params.Set("summarization", p.cfg.Summarization)
+	params.Set("strict", "true")
+	params.Set("sorted", "true")
+
+	var result chartResponse
+	if err := p.get(ctx, "/api/v2/chart/api", params, &result); err != nil {
+		return nil, err
+	}
+	if result.Warnings != "" {
+		p.logger.Warn("query returned warnings", "graph", query.Graph, "application", query.Application, "warnings", result.Warnings)
+	}
+
+	response := &models.MetricsResponse{
+		Application: query.Application,
+		Project:     query.Project,
+		Graph:       query.Graph,
+		Data:        []models.MetricData{},
+	}
+	for _, ts := range result.Timeseries {
+		labels := make(map[string]string, len(ts.Tags)+2)
+		for k, v := range ts.Tags {
+			labels[k] = v
+		}
+		if ts.Label != "" {
+			labels["__name__"] = ts.Label
+		}
+		if ts.Host != "" {
+			labels["source"] = ts.Host
+		}
+		for _, point := range ts.Data {
+			response.Data = append(response.Data, models.MetricData{
+				Timestamp: time.UnixMilli(int64(math.Round(point[0] * 1000))).UTC(),
+				Value:     point[1],
+				Labels:    labels,
+			})
+		}
+	}
+	return response, nil
+}
+
+// granularity returns the chart API granularity for step: seconds, minutes,
+// hours or days
+func granularity(step time.Duration) string {
+	switch {
+	case step < time.Minute:
+		return "s"
+	case step < time.Hour:
+		return "m"
+	case step < 24*time.Hour:
+		return "h"
+	default:
+		return "d"
+	}
+}
+
+// get sends an authenticated request to the API and decodes the response
+func (p *Provider) get(ctx context.Context, path string, params url.Values, result interface{}) error {
+	u := p.baseURL.JoinPath(path)
+	u.RawQuery = params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("wavefront: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.cfg.Token)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("wavefront: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("wavefront: failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &Error{StatusCode: resp.StatusCode, Message: errorMessage(body)}
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("wavefront: invalid response: %w", err)
+	}
+	return nil
+}
+
+// errorMessage extracts the message of an error response, which is JSON
+// for API errors and plain text for query syntax errors
+func errorMessage(body []byte) string {
+	var errBody struct {
+		Message string `json:"message"`
+		Status  struct {
+			Message string `json:"message"`
+		} `json:"status"`
+	}
+	if json.Unmarshal(body, &errBody) == nil {
+		if errBody.Status.Message != "" {
+			return errBody.Status.Message
+		}
+		return errBody.Message
+	}
+	message := strings.TrimSpace(string(body))
+	if len(message) > 512 {
+		message = message[:512]
+	}
+	return message
+}
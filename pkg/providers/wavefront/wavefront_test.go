@@ -0,0 +1,188 @@
+package wavefront
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+var testNow = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// fixture is a recorded chart API exchange: the request the provider should
+// send and the response Wavefront returned for it
+type fixture struct {
+	params map[string]string
+	status int
+	file   string
+}
+
+func newTestProvider(t *testing.T, fx *fixture) *Provider {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/chart/api" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		for k, want := range fx.params {
+			if got := r.URL.Query().Get(k); got != want {
+				t.Errorf("%s = %q, want %q", k, got, want)
+			}
+		}
+		body, err := os.ReadFile(filepath.Join("testdata", fx.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteHeader(fx.status)
+		w.Write(body)
+	}))
+	t.Cleanup(ts.Close)
+
+	dashboards, err := providers.NewDashboards(providers.DashboardConfig{Applications: []providers.ApplicationDashboards{{
+		Default: true,
+		Dashboards: []providers.Dashboard{{
+			GroupKind: "pod",
+			Rows: []providers.DashboardRow{{
+				Name: "container",
+				Graphs: []providers.Graph{{
+					Name:            "cpu",
+					QueryExpression: `sum(rate(ts(kubernetes.pod.cpu.usage_rate, label.app="{{.Application}}")), pod_name)`,
+				}},
+			}},
+		}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(Config{URL: ts.URL, Token: "test-token"}, dashboards, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.now = func() time.Time { return testNow }
+	return p
+}
+
+func testQuery(application string) *models.MetricsQuery {
+	return &models.MetricsQuery{Application: application, Project: "prod", GroupKind: "pod", Row: "container", Graph: "cpu"}
+}
+
+func TestProvider_Query(t *testing.T) {
+	p := newTestProvider(t, &fixture{
+		params: map[string]string{
+			"q":             `sum(rate(ts(kubernetes.pod.cpu.usage_rate, label.app="payments")), pod_name)`,
+			"queryType":     "WQL",
+			"s":             "1704106800000",
+			"e":             "1704110400000",
+			"g":             "s",
+			"summarization": "MEAN",
+		},
+		status: http.StatusOK,
+		file:   "chart_cpu.json",
+	})
+
+	response, err := p.Query(context.Background(), testQuery("payments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Application != "payments" || response.Graph != "cpu" || len(response.Data) != 5 {
+		t.Fatalf("response = %+v", response)
+	}
+	first := response.Data[0]
+	if !first.Timestamp.Equal(time.Unix(1704106800, 0)) || first.Value != 0.412 ||
+		first.Labels["pod_name"] != "payments-7d9c6b5f4-2xk8q" || first.Labels["__name__"] != "kubernetes.pod.cpu.usage_rate" {
+		t.Errorf("first point = %+v", first)
+	}
+	if _, ok := first.Labels["source"]; ok {
+		t.Errorf("empty host kept as source: %+v", first.Labels)
+	}
+	if last := response.Data[4]; last.Value != 0.401 || last.Labels["source"] != "ip-10-0-12-34.ec2.internal" {
+		t.Errorf("last point = %+v", last)
+	}
+}
+
+func TestProvider_QueryRange(t *testing.T) {
+	p := newTestProvider(t, &fixture{
+		params: map[string]string{
+			"q": `sum(rate(ts(kubernetes.pod.cpu.usage_rate, label.app="retired")), pod_name)`,
+			"s": "1703505600000",
+			"e": "1704110400000",
+			"g": "h",
+		},
+		status: http.StatusOK,
+		file:   "chart_empty.json",
+	})
+
+	r := models.TimeRange{Start: testNow.Add(-7 * 24 * time.Hour), End: testNow, Step: time.Hour}
+	response, err := p.QueryRange(context.Background(), testQuery("retired"), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Data == nil || len(response.Data) != 0 {
+		t.Errorf("data = %#v, want empty", response.Data)
+	}
+}
+
+func TestProvider_Errors(t *testing.T) {
+	tests := []struct {
+		file    string
+		status  int
+		message string
+	}{
+		{"error_unauthorized.json", http.StatusUnauthorized, "Invalid or expired API token"},
+		{"error_syntax.txt", http.StatusBadRequest, "Syntax error: sum(rate("},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			p := newTestProvider(t, &fixture{status: tt.status, file: tt.file})
+			_, err := p.Query(context.Background(), testQuery("payments"))
+			var apiErr *Error
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status || len(apiErr.Message) < len(tt.message) ||
+				apiErr.Message[:len(tt.message)] != tt.message {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+}
+
+func TestGranularity(t *testing.T) {
+	tests := map[time.Duration]string{
+		15 * time.Second: "s",
+		time.Minute:      "m",
+		30 * time.Minute: "m",
+		6 * time.Hour:    "h",
+		48 * time.Hour:   "d",
+	}
+	for step, want := range tests {
+		if got := granularity(step); got != want {
+			t.Errorf("granularity(%s) = %s, want %s", step, got, want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Setenv("WAVEFRONT_API_TOKEN", "")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := New(Config{URL: "https://example.wavefront.com"}, nil, logger); err == nil {
+		t.Error("missing token accepted")
+	}
+	t.Setenv("WAVEFRONT_API_TOKEN", "env-token")
+	if _, err := New(Config{URL: "example.wavefront.com"}, nil, logger); err == nil {
+		t.Error("URL without scheme accepted")
+	}
+	p, err := New(Config{URL: "https://example.wavefront.com"}, nil, logger)
+	if err != nil || p.cfg.Token != "env-token" {
+		t.Errorf("New = %+v, %v", p, err)
+	}
+}
@@ -0,0 +1,293 @@
+package rbac
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// Effect is the outcome of a policy rule
+type Effect string
+
+const (
+	EffectAllow Effect = "allow"
+	EffectDeny  Effect = "deny"
+)
+
+// Request describes an attempt to query a dashboard graph
+type Request struct {
+	User        string
+	Groups      []string
+	Project     string
+	Application string
+	GroupKind   string
+	Graph       string
+}
+
+// Decision is the result of evaluating a Request against the policy
+type Decision struct {
+	Allowed bool
+	// Rule is the policy line that decided the request, empty when no rule matched
+	Rule string
+}
+
+// rule is a single "p" line of the policy
+type rule struct {
+	subject     string
+	project     string
+	application string
+	groupKind   string
+	graph       string
+	effect      Effect
+	line        int
+	raw         string
+}
+
+// Policy is a parsed policy in the style of Argo CD's policy.csv:
+//
+//	p, <subject>, <project>/<application>, <groupkind>/<graph>, <allow|deny>
+//	g, <user-or-group>, <role>
+//
+// Subjects are users, groups or roles (conventionally prefixed with "role:").
+// Every pattern segment accepts glob wildcards such as "*" or "payments-*".
+type Policy struct {
+	rules []rule
+	roles map[string][]string // member -> roles
+}
+
+// ParsePolicy parses a CSV policy. Blank lines and lines starting with '#' are ignored.
+func ParsePolicy(r io.Reader) (*Policy, error) {
+	p := &Policy{
+		roles: make(map[string][]string),
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		reader := csv.NewReader(strings.NewReader(line))
+		reader.TrimLeadingSpace = true
+		fields, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		switch fields[0] {
+		case "p":
+			r, err := parseRule(fields, lineNum, line)
+			if err != nil {
+				return nil, err
+			}
+			p.rules = append(p.rules, r)
+		case "g":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: expected 'g, <member>, <role>'", lineNum)
+			}
+			p.roles[fields[1]] = append(p.roles[fields[1]], fields[2])
+		default:
+			return nil, fmt.Errorf("line %d: unknown policy type %q", lineNum, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func parseRule(fields []string, lineNum int, raw string) (rule, error) {
+	if len(fields) != 5 {
+		return rule{}, fmt.Errorf("line %d: expected 'p, <subject>, <project>/<application>, <groupkind>/<graph>, <effect>'", lineNum)
+	}
+
+	project, application, ok := strings.Cut(fields[2], "/")
+	if !ok {
+		return rule{}, fmt.Errorf("line %d: object %q must be <project>/<application>", lineNum, fields[2])
+	}
+	groupKind, graph, ok := strings.Cut(fields[3], "/")
+	if !ok {
+		return rule{}, fmt.Errorf("line %d: graph %q must be <groupkind>/<graph>", lineNum, fields[3])
+	}
+
+	effect := Effect(strings.ToLower(fields[4]))
+	if effect != EffectAllow && effect != EffectDeny {
+		return rule{}, fmt.Errorf("line %d: effect must be allow or deny, got %q", lineNum, fields[4])
+	}
+
+	for _, pattern := range []string{fields[1], project, application, groupKind, graph} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return rule{}, fmt.Errorf("line %d: invalid pattern %q: %w", lineNum, pattern, err)
+		}
+	}
+
+	return rule{
+		subject:     fields[1],
+		project:     project,
+		application: application,
+		groupKind:   groupKind,
+		graph:       graph,
+		effect:      effect,
+		line:        lineNum,
+		raw:         raw,
+	}, nil
+}
+
+// LoadPolicyFile reads and parses a policy file
+func LoadPolicyFile(filename string) (*Policy, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open policy file: %w", err)
+	}
+	defer f.Close()
+
+	p, err := ParsePolicy(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %w", filename, err)
+	}
+	return p, nil
+}
+
+// subjects returns the user, its groups and every role they transitively belong to
+func (p *Policy) subjects(req Request) map[string]bool {
+	subjects := make(map[string]bool)
+	queue := append([]string{req.User}, req.Groups...)
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if s == "" || subjects[s] {
+			continue
+		}
+		subjects[s] = true
+		queue = append(queue, p.roles[s]...)
+	}
+	return subjects
+}
+
+// evaluate applies the policy to the request. Deny rules override allow rules
+// and requests matching no rule are denied.
+func (p *Policy) evaluate(req Request) Decision {
+	subjects := p.subjects(req)
+
+	var allowed *rule
+	for i := range p.rules {
+		r := &p.rules[i]
+		if !r.matches(subjects, req) {
+			continue
+		}
+		if r.effect == EffectDeny {
+			return Decision{Allowed: false, Rule: r.String()}
+		}
+		if allowed == nil {
+			allowed = r
+		}
+	}
+
+	if allowed != nil {
+		return Decision{Allowed: true, Rule: allowed.String()}
+	}
+	return Decision{Allowed: false}
+}
+
+func (r *rule) matches(subjects map[string]bool, req Request) bool {
+	if !r.matchesSubject(subjects) {
+		return false
+	}
+	return glob(r.project, req.Project) &&
+		glob(r.application, req.Application) &&
+		glob(r.groupKind, req.GroupKind) &&
+		glob(r.graph, req.Graph)
+}
+
+func (r *rule) matchesSubject(subjects map[string]bool) bool {
+	if subjects[r.subject] {
+		return true
+	}
+	for s := range subjects {
+		if glob(r.subject, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rule) String() string {
+	return fmt.Sprintf("line %d: %s", r.line, r.raw)
+}
+
+func glob(pattern, value string) bool {
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+// Enforcer authorizes requests against a policy and writes an audit log of
+// every decision. The policy can be replaced at runtime with SetPolicy.
+type Enforcer struct {
+	policy atomic.Pointer[Policy]
+	logger *slog.Logger
+}
+
+// NewEnforcer creates a new enforcer for the given policy
+func NewEnforcer(policy *Policy, logger *slog.Logger) *Enforcer {
+	e := &Enforcer{
+		logger: logger.With("component", "rbac"),
+	}
+	e.SetPolicy(policy)
+	return e
+}
+
+// SetPolicy atomically replaces the policy. A nil policy denies everything.
+func (e *Enforcer) SetPolicy(policy *Policy) {
+	if policy == nil {
+		policy = &Policy{roles: make(map[string][]string)}
+	}
+	e.policy.Store(policy)
+}
+
+// Reload reads the policy file and replaces the current policy.
+// On error the current policy is kept.
+func (e *Enforcer) Reload(filename string) error {
+	policy, err := LoadPolicyFile(filename)
+	if err != nil {
+		return err
+	}
+	e.SetPolicy(policy)
+	e.logger.Info("rbac policy reloaded", "file", filename)
+	return nil
+}
+
+// Enforce evaluates the request and records the decision in the audit log
+func (e *Enforcer) Enforce(req Request) Decision {
+	decision := e.policy.Load().evaluate(req)
+
+	result := "deny"
+	if decision.Allowed {
+		result = "allow"
+	}
+	e.logger.Info("authorization decision",
+		"audit", true,
+		"decision", result,
+		"user", req.User,
+		"groups", req.Groups,
+		"project", req.Project,
+		"application", req.Application,
+		"groupkind", req.GroupKind,
+		"graph", req.Graph,
+		"rule", decision.Rule,
+	)
+
+	return decision
+}
@@ -0,0 +1,159 @@
+package rbac
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+const testPolicy = `
+# Platform team sees everything
+p, role:admin, */*, */*, allow
+g, platform, role:admin
+
+# Developers see request graphs of their project, never cost or security
+p, role:dev, payments/*, */*, allow
+p, role:dev, */*, */cost-*, deny
+p, role:dev, */*, security/*, deny
+g, payments-devs, role:dev
+
+# Roles can inherit from other roles
+g, role:oncall, role:dev
+g, alice, role:oncall
+`
+
+func mustParse(t *testing.T, policy string) *Policy {
+	t.Helper()
+	p, err := ParsePolicy(strings.NewReader(policy))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	return p
+}
+
+func TestEnforcer_Enforce(t *testing.T) {
+	e := NewEnforcer(mustParse(t, testPolicy), testLogger)
+
+	tests := []struct {
+		name     string
+		req      Request
+		expected bool
+	}{
+		{
+			name:     "Admin group allowed everywhere",
+			req:      Request{User: "bob", Groups: []string{"platform"}, Project: "infra", Application: "ingress", GroupKind: "security", Graph: "cves"},
+			expected: true,
+		},
+		{
+			name:     "Developer allowed request rate",
+			req:      Request{User: "carol", Groups: []string{"payments-devs"}, Project: "payments", Application: "api", GroupKind: "deployment", Graph: "request-rate"},
+			expected: true,
+		},
+		{
+			name:     "Developer denied cost graph",
+			req:      Request{User: "carol", Groups: []string{"payments-devs"}, Project: "payments", Application: "api", GroupKind: "deployment", Graph: "cost-per-day"},
+			expected: false,
+		},
+		{
+			name:     "Developer denied security panels",
+			req:      Request{User: "carol", Groups: []string{"payments-devs"}, Project: "payments", Application: "api", GroupKind: "security", Graph: "cves"},
+			expected: false,
+		},
+		{
+			name:     "Developer denied other project",
+			req:      Request{User: "carol", Groups: []string{"payments-devs"}, Project: "infra", Application: "ingress", GroupKind: "deployment", Graph: "request-rate"},
+			expected: false,
+		},
+		{
+			name:     "Inherited role",
+			req:      Request{User: "alice", Project: "payments", Application: "api", GroupKind: "deployment", Graph: "request-rate"},
+			expected: true,
+		},
+		{
+			name:     "Deny by default",
+			req:      Request{User: "mallory", Project: "payments", Application: "api", GroupKind: "deployment", Graph: "request-rate"},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := e.Enforce(tt.req)
+			if decision.Allowed != tt.expected {
+				t.Errorf("Expected allowed=%v, got %v (rule %q)", tt.expected, decision.Allowed, decision.Rule)
+			}
+		})
+	}
+}
+
+func TestEnforcer_DecisionRule(t *testing.T) {
+	e := NewEnforcer(mustParse(t, testPolicy), testLogger)
+
+	decision := e.Enforce(Request{User: "carol", Groups: []string{"payments-devs"}, Project: "payments", Application: "api", GroupKind: "deployment", Graph: "cost-per-day"})
+	if !strings.Contains(decision.Rule, "*/cost-*, deny") {
+		t.Errorf("Expected deny rule in decision, got %q", decision.Rule)
+	}
+
+	decision = e.Enforce(Request{User: "mallory"})
+	if decision.Rule != "" {
+		t.Errorf("Expected no matching rule, got %q", decision.Rule)
+	}
+}
+
+func TestParsePolicy_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+	}{
+		{"Unknown type", "x, a, b"},
+		{"Missing effect", "p, role:dev, payments/*, */*"},
+		{"Bad effect", "p, role:dev, payments/*, */*, maybe"},
+		{"Object without application", "p, role:dev, payments, */*, allow"},
+		{"Graph without groupkind", "p, role:dev, payments/*, request-rate, allow"},
+		{"Bad pattern", "p, role:dev, payments/[, */*, allow"},
+		{"Bad grouping", "g, alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePolicy(strings.NewReader(tt.policy)); err == nil {
+				t.Error("Expected parse error")
+			}
+		})
+	}
+}
+
+func TestEnforcer_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.csv")
+	if err := os.WriteFile(path, []byte("p, alice, */*, */*, allow\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEnforcer(nil, testLogger)
+	req := Request{User: "alice", Project: "p", Application: "a", GroupKind: "g", Graph: "x"}
+	if e.Enforce(req).Allowed {
+		t.Error("Empty policy should deny")
+	}
+
+	if err := e.Reload(path); err != nil {
+		t.Fatalf("Failed to reload policy: %v", err)
+	}
+	if !e.Enforce(req).Allowed {
+		t.Error("Reloaded policy should allow")
+	}
+
+	if err := os.WriteFile(path, []byte("p, broken\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Reload(path); err == nil {
+		t.Error("Expected error for invalid policy")
+	}
+	if !e.Enforce(req).Allowed {
+		t.Error("Previous policy should be kept after failed reload")
+	}
+}
@@ -1,21 +1,94 @@
 package server
 
 import (
+	"bufio"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/server/middleware"
 )
 
-// handleExportMetrics handles exporting metrics to CSV or JSON
+func init() {
+	RegisterExporter(csvExporter{})
+	RegisterExporter(jsonExporter{})
+}
+
+// handleExportMetrics handles exporting metrics in any registered format,
+// selected by the format query parameter or the Accept header. With
+// mode=stats, summary statistics per series are exported instead, as JSON
+// or CSV. The default window of a providers.PointStreamer is streamed in
+// formats that can write points one at a time.
 func (s *Server) handleExportMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+
+	exporter, ok := exporters.Negotiate(r.URL.Query().Get("format"), r.Header.Get("Accept"))
+	if !ok {
+		s.respondError(w, http.StatusNotAcceptable, "unsupported export format",
+			"supported formats: "+strings.Join(exporters.Names(), ", "))
+		return
+	}
+	exporter, err := exporterForMode(r.URL.Query().Get("mode"), exporter)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid parameter", err.Error())
+		return
+	}
+
+	if v, ok := exporter.(optionsValidator); ok {
+		if err := v.ValidateOptions(r.URL.Query()); err != nil {
+			s.respondError(w, http.StatusBadRequest, "invalid parameter", err.Error())
+			return
+		}
+	}
+
+	if err := validateExportCompression(r.URL.Query()); err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid parameter", err.Error())
+		return
+	}
+
+	timeRange, err := exportRangeFromQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid parameter", err.Error())
+		return
+	}
+
+	query, ok := s.exportQuery(w, r)
+	if !ok {
+		return
+	}
+
+	if streamer, ok := s.provider.(providers.PointStreamer); ok && timeRange == nil {
+		if pe, ok := exporter.(pointsExporter); ok && pe.exportsPoints(r.URL.Query()) {
+			s.exportStream(r.Context(), w, exporter, pe, streamer, query, r.URL.Query())
+			return
+		}
+	}
+
+	// Execute query via provider
+	response, err := queryExport(r.Context(), s.provider, query, timeRange)
+	if err != nil {
+		s.logger.Error("query failed", "error", err)
+		s.respondError(w, http.StatusInternalServerError, "query failed", err.Error())
+		return
+	}
+
+	s.export(w, exporter, response, r.URL.Query())
+}
+
+// exportQuery builds the metrics query for an export request from the path
+// parameters and the caller identity, writing an error response if invalid
+func (s *Server) exportQuery(w http.ResponseWriter, r *http.Request) (*models.MetricsQuery, bool) {
 	// Extract path parameters
-	_application := chi.URLParam(r, "application")
 	groupkind := chi.URLParam(r, "groupkind")
 	row := chi.URLParam(r, "row")
 	graph := chi.URLParam(r, "graph")
@@ -23,130 +96,418 @@ func (s *Server) handleExportMetrics(w http.ResponseWriter, r *http.Request) {
 	// Extract query parameters
 	appQueryParam := r.URL.Query().Get("application_name")
 	projectQueryParam := r.URL.Query().Get("project")
-	format := r.URL.Query().Get("format") // csv or json
 
-	// Validate format
-	if format != "csv" && format != "json" {
-		format = "json" // default to JSON
+	// Prefer the identity verified by the Argo CD proxy middleware over
+	// free-form query parameters
+	if identity, ok := middleware.IdentityFromContext(r.Context()); ok {
+		appQueryParam = identity.Application
+		projectQueryParam = identity.Project
 	}
 
 	// Validate required parameters
 	if appQueryParam == "" {
 		s.respondError(w, http.StatusBadRequest, "missing parameter", "application_name is required")
-		return
+		return nil, false
 	}
 	if projectQueryParam == "" {
 		s.respondError(w, http.StatusBadRequest, "missing parameter", "project is required")
-		return
+		return nil, false
 	}
 
-	// Build query
-	query := &models.MetricsQuery{
+	return &models.MetricsQuery{
 		Application: appQueryParam,
 		Project:     projectQueryParam,
 		GroupKind:   groupkind,
 		Row:         row,
 		Graph:       graph,
+	}, true
+}
+
+// CSV layouts supported by writeCSV
+const (
+	// csvLayoutLong writes one row per data point with one column per label
+	csvLayoutLong = "long"
+	// csvLayoutWide writes one row per timestamp with one column per series
+	csvLayoutWide = "wide"
+)
+
+// csvOptions controls the shape of a CSV export
+type csvOptions struct {
+	layout string
+	// columns lists label keys to place first, in order; remaining labels
+	// follow in lexical order
+	columns []string
+}
+
+// csvOptionsFromQuery reads the layout and columns query parameters
+func csvOptionsFromQuery(params url.Values) (csvOptions, error) {
+	opts := csvOptions{layout: params.Get("layout")}
+	if opts.layout == "" {
+		opts.layout = csvLayoutLong
+	}
+	if opts.layout != csvLayoutLong && opts.layout != csvLayoutWide {
+		return opts, fmt.Errorf("layout must be %s or %s", csvLayoutLong, csvLayoutWide)
 	}
 
-	// Execute query via provider
-	response, err := s.provider.Query(r.Context(), query)
-	if err != nil {
-		s.logger.Error("query failed", "error", err)
-		s.respondError(w, http.StatusInternalServerError, "query failed", err.Error())
-		return
+	if columns := params.Get("columns"); columns != "" {
+		for _, c := range strings.Split(columns, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				opts.columns = append(opts.columns, c)
+			}
+		}
 	}
+	return opts, nil
+}
 
-	// Export based on format
-	if format == "csv" {
-		s.exportCSV(w, response)
-	} else {
-		s.exportJSON(w, response)
+// csvExporter writes one CSV row per data point, or per timestamp with the
+// wide layout
+type csvExporter struct{}
+
+func (csvExporter) Name() string        { return "csv" }
+func (csvExporter) ContentType() string { return "text/csv" }
+func (csvExporter) Extension() string   { return "csv" }
+
+// ValidateOptions checks the layout and columns parameters
+func (csvExporter) ValidateOptions(params url.Values) error {
+	_, err := csvOptionsFromQuery(params)
+	return err
+}
+
+func (csvExporter) Export(w io.Writer, response *models.MetricsResponse, opts ExportOptions) error {
+	csvOpts, err := csvOptionsFromQuery(opts.Params)
+	if err != nil {
+		return err
 	}
+	return writeCSV(w, response, csvOpts)
 }
 
-// exportCSV exports metrics data as CSV
-func (s *Server) exportCSV(w http.ResponseWriter, response *models.MetricsResponse) {
-	w.Header().Set("Content-Type", "text/csv")
-	w.Header().Set("Content-Disposition", 
-		fmt.Sprintf("attachment; filename=metrics_%s_%s.csv", 
-			response.Application, time.Now().Format("20060102_150405")))
+// exportsPoints reports whether the layout is long; the wide layout needs
+// every point to lay out its rows
+func (csvExporter) exportsPoints(params url.Values) bool {
+	opts, err := csvOptionsFromQuery(params)
+	return err == nil && opts.layout == csvLayoutLong
+}
+
+// replaysPoints reports that exportPoints reads the points twice
+func (csvExporter) replaysPoints() bool { return true }
+
+// exportPoints writes the long layout in two passes over the points: one
+// for the label columns, one for the rows
+func (csvExporter) exportPoints(w io.Writer, header *models.MetricsResponse, points pointSource, opts ExportOptions) error {
+	csvOpts, err := csvOptionsFromQuery(opts.Params)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	err = points(func(d models.MetricData) error {
+		for key := range d.Labels {
+			seen[key] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(w)
+	flushBuffer := func() error {
+		writer.Flush()
+		return writer.Error()
+	}
+	rows, err := newLongCSVWriter(writer, orderLabelColumns(seen, csvOpts.columns))
+	if err == nil {
+		err = points(func(d models.MetricData) error {
+			if err := rows.write(d); err != nil {
+				return err
+			}
+			return opts.stream.point(flushBuffer)
+		})
+	}
+	if err == nil {
+		writer.Flush()
+		err = writer.Error()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return nil
+}
 
+// writeCSV writes metrics data as CSV in the given layout, row by row so the
+// output is never held in memory
+func writeCSV(w io.Writer, response *models.MetricsResponse, opts csvOptions) error {
 	writer := csv.NewWriter(w)
-	defer writer.Flush()
+	var err error
+	if opts.layout == csvLayoutWide {
+		err = writeWideCSV(writer, response.Data, opts.columns)
+	} else {
+		err = writeLongCSV(writer, response.Data, opts.columns)
+	}
+	if err == nil {
+		writer.Flush()
+		err = writer.Error()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return nil
+}
 
-	// Write header
-	header := []string{"Timestamp", "Value"}
-	
-	// Add label columns (dynamically based on first data point)
-	if len(response.Data) > 0 && len(response.Data[0].Labels) > 0 {
-		for key := range response.Data[0].Labels {
-			header = append(header, key)
+// writeLongCSV writes a header plus one row per data point. Label columns are
+// the union of label keys across all points, so labels that only appear on
+// later series are never dropped.
+func writeLongCSV(writer *csv.Writer, data []models.MetricData, columns []string) error {
+	rows, err := newLongCSVWriter(writer, labelColumns(data, columns))
+	if err != nil {
+		return err
+	}
+	for _, d := range data {
+		if err := rows.write(d); err != nil {
+			return err
 		}
 	}
-	
+	return nil
+}
+
+// longCSVWriter writes rows of the long layout for a fixed set of label
+// columns
+type longCSVWriter struct {
+	writer *csv.Writer
+	keys   []string
+	row    []string
+}
+
+// newLongCSVWriter writes the header for the label columns keys
+func newLongCSVWriter(writer *csv.Writer, keys []string) (*longCSVWriter, error) {
+	header := append([]string{"Timestamp", "Value"}, keys...)
 	if err := writer.Write(header); err != nil {
-		s.logger.Error("failed to write CSV header", "error", err)
-		return
+		return nil, err
+	}
+	return &longCSVWriter{writer: writer, keys: keys, row: make([]string, 0, len(header))}, nil
+}
+
+func (l *longCSVWriter) write(d models.MetricData) error {
+	l.row = append(l.row[:0],
+		d.Timestamp.Format(time.RFC3339),
+		strconv.FormatFloat(d.Value, 'f', -1, 64),
+	)
+	// Missing labels are left empty
+	for _, key := range l.keys {
+		l.row = append(l.row, d.Labels[key])
+	}
+	return l.writer.Write(l.row)
+}
+
+// writeWideCSV writes a header plus one row per timestamp, with one value
+// column per distinct label set. Series are ordered by their label values
+// following the same column order as the long layout.
+func writeWideCSV(writer *csv.Writer, data []models.MetricData, columns []string) error {
+	keys := labelColumns(data, columns)
+
+	type series struct {
+		name   string
+		sortBy []string
+		values map[int64]float64
+	}
+
+	seriesByName := make(map[string]*series)
+	timestamps := make(map[int64]time.Time)
+	for _, d := range data {
+		name := formatLabels(d.Labels)
+		sr, ok := seriesByName[name]
+		if !ok {
+			sortBy := make([]string, len(keys))
+			for i, key := range keys {
+				sortBy[i] = d.Labels[key]
+			}
+			sr = &series{name: name, sortBy: sortBy, values: make(map[int64]float64)}
+			seriesByName[name] = sr
+		}
+		ts := d.Timestamp.UnixNano()
+		sr.values[ts] = d.Value
+		timestamps[ts] = d.Timestamp
 	}
 
-	// Write data rows
-	for _, data := range response.Data {
-		row := []string{
-			data.Timestamp.Format(time.RFC3339),
-			strconv.FormatFloat(data.Value, 'f', -1, 64),
+	ordered := make([]*series, 0, len(seriesByName))
+	for _, sr := range seriesByName {
+		ordered = append(ordered, sr)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		a, b := ordered[i].sortBy, ordered[j].sortBy
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
 		}
+		return ordered[i].name < ordered[j].name
+	})
 
-		// Add label values in same order as header
-		for i := 2; i < len(header); i++ {
-			labelKey := header[i]
-			if val, ok := data.Labels[labelKey]; ok {
-				row = append(row, val)
+	sortedTimes := make([]int64, 0, len(timestamps))
+	for ts := range timestamps {
+		sortedTimes = append(sortedTimes, ts)
+	}
+	sort.Slice(sortedTimes, func(i, j int) bool { return sortedTimes[i] < sortedTimes[j] })
+
+	header := make([]string, 0, len(ordered)+1)
+	header = append(header, "Timestamp")
+	for _, sr := range ordered {
+		name := sr.name
+		if name == "" {
+			name = "Value"
+		}
+		header = append(header, name)
+	}
+
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	row := make([]string, 0, len(header))
+	for _, ts := range sortedTimes {
+		row = append(row[:0], timestamps[ts].Format(time.RFC3339))
+		for _, sr := range ordered {
+			if v, ok := sr.values[ts]; ok {
+				row = append(row, strconv.FormatFloat(v, 'f', -1, 64))
 			} else {
 				row = append(row, "")
 			}
 		}
-
 		if err := writer.Write(row); err != nil {
-			s.logger.Error("failed to write CSV row", "error", err)
-			return
+			return err
 		}
 	}
+	return nil
+}
 
-	s.logger.Info("exported metrics as CSV", 
-		"application", response.Application,
-		"rows", len(response.Data))
+// labelColumns returns the union of label keys across all points. Keys listed
+// in preferred come first in the given order, the rest follow sorted.
+func labelColumns(data []models.MetricData, preferred []string) []string {
+	seen := make(map[string]bool)
+	for _, d := range data {
+		for key := range d.Labels {
+			seen[key] = true
+		}
+	}
+	return orderLabelColumns(seen, preferred)
 }
 
-// exportJSON exports metrics data as JSON
-func (s *Server) exportJSON(w http.ResponseWriter, response *models.MetricsResponse) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Content-Disposition", 
-		fmt.Sprintf("attachment; filename=metrics_%s_%s.json", 
-			response.Application, time.Now().Format("20060102_150405")))
+// orderLabelColumns orders the label keys in seen, which it consumes, as
+// labelColumns does
+func orderLabelColumns(seen map[string]bool, preferred []string) []string {
+	keys := make([]string, 0, len(seen))
+	for _, key := range preferred {
+		if seen[key] {
+			keys = append(keys, key)
+			delete(seen, key)
+		}
+	}
+
+	rest := make([]string, 0, len(seen))
+	for key := range seen {
+		rest = append(rest, key)
+	}
+	sort.Strings(rest)
+
+	return append(keys, rest...)
+}
 
+// labelFieldNames returns the column name used for each label key in the
+// columnar formats. Labels that would shadow the timestamp and value columns
+// are prefixed with "label_", and clean, if set, rewrites names the format
+// cannot represent. Names that still collide get a numeric suffix.
+func labelFieldNames(keys []string, clean func(string) string) []string {
+	used := map[string]bool{"timestamp": true, "value": true}
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		name := key
+		if clean != nil {
+			name = clean(name)
+		}
+		if used[name] {
+			name = "label_" + name
+		}
+		for n, base := 2, name; used[name]; n++ {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// jsonExporter writes the data points with export metadata as indented JSON
+type jsonExporter struct{}
+
+func (jsonExporter) Name() string        { return "json" }
+func (jsonExporter) ContentType() string { return "application/json" }
+func (jsonExporter) Extension() string   { return "json" }
+
+func (jsonExporter) Export(w io.Writer, response *models.MetricsResponse, opts ExportOptions) error {
 	// Create export structure with metadata
 	export := map[string]interface{}{
 		"metadata": map[string]interface{}{
-			"application":  response.Application,
-			"project":      response.Project,
-			"graph":        response.Graph,
-			"exported_at":  time.Now().Format(time.RFC3339),
-			"data_points":  len(response.Data),
+			"application": response.Application,
+			"project":     response.Project,
+			"graph":       response.Graph,
+			"exported_at": opts.ExportedAt.Format(time.RFC3339),
+			"data_points": len(response.Data),
 		},
 		"data": response.Data,
 	}
 
 	encoder := json.NewEncoder(w)
 	encoder.SetIndent("", "  ")
-	
+
 	if err := encoder.Encode(export); err != nil {
-		s.logger.Error("failed to encode JSON", "error", err)
-		http.Error(w, "Failed to export metrics", http.StatusInternalServerError)
-		return
+		return fmt.Errorf("failed to encode JSON: %w", err)
 	}
+	return nil
+}
 
-	s.logger.Info("exported metrics as JSON", 
-		"application", response.Application,
-		"rows", len(response.Data))
+func (jsonExporter) exportsPoints(params url.Values) bool { return true }
+
+// exportPoints writes the same document as Export one point at a time. The
+// keys are sorted as encoding/json sorts map keys, so the metadata, with the
+// point count, follows the data.
+func (jsonExporter) exportPoints(w io.Writer, header *models.MetricsResponse, points pointSource, opts ExportOptions) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("{\n  \"data\": [")
+	count := 0
+	err := points(func(d models.MetricData) error {
+		b, err := json.MarshalIndent(d, "    ", "  ")
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteString("\n    ")
+		bw.Write(b)
+		count++
+		return opts.stream.point(bw.Flush)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	if count > 0 {
+		bw.WriteString("\n  ")
+	}
+
+	metadata, err := json.MarshalIndent(map[string]interface{}{
+		"application": header.Application,
+		"project":     header.Project,
+		"graph":       header.Graph,
+		"exported_at": opts.ExportedAt.Format(time.RFC3339),
+		"data_points": count,
+	}, "  ", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	bw.WriteString("],\n  \"metadata\": ")
+	bw.Write(metadata)
+	bw.WriteString("\n}\n")
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	return nil
 }
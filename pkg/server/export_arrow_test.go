@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// goldenResponse is the fixed export used by the golden-file tests
+func goldenResponse() *models.MetricsResponse {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	return &models.MetricsResponse{
+		Application: "test-app",
+		Project:     "test-project",
+		Graph:       "request-rate",
+		Data: []models.MetricData{
+			{Timestamp: t0, Value: 100.5, Labels: map[string]string{"instance": "pod-1"}},
+			{Timestamp: t0.Add(time.Minute), Value: 150.25, Labels: map[string]string{"instance": "pod-2", "status": "500"}},
+			{Timestamp: t0.Add(2 * time.Minute), Value: 0, Labels: map[string]string{"value": "shadowed"}},
+		},
+	}
+}
+
+// goldenExportedAt is the export time recorded in golden files
+var goldenExportedAt = time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+// checkGolden compares output with testdata/name, rewriting the file when
+// the tests run with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+
+	if *updateGolden {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatalf("Failed to create testdata: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("Output does not match %s (run go test -update to regenerate)", path)
+	}
+}
+
+func TestWriteArrow_Golden(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeArrow(&buf, goldenResponse(), goldenExportedAt); err != nil {
+		t.Fatalf("Failed to write Arrow stream: %v", err)
+	}
+	checkGolden(t, "export.arrows", buf.Bytes())
+
+	rdr, err := ipc.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read Arrow stream: %v", err)
+	}
+	defer rdr.Release()
+
+	expectedFields := []arrow.Field{
+		{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: "UTC"}},
+		{Name: "value", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "instance", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "status", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "label_value", Type: arrow.BinaryTypes.String, Nullable: true},
+	}
+	schema := rdr.Schema()
+	if len(schema.Fields()) != len(expectedFields) {
+		t.Fatalf("Expected fields %v, got %v", expectedFields, schema.Fields())
+	}
+	for i, field := range schema.Fields() {
+		expected := expectedFields[i]
+		if field.Name != expected.Name || field.Nullable != expected.Nullable || !arrow.TypeEqual(field.Type, expected.Type) {
+			t.Errorf("Field %d: expected %v, got %v", i, expected, field)
+		}
+	}
+	if v, _ := schema.Metadata().GetValue("application"); v != "test-app" {
+		t.Errorf("Expected application metadata test-app, got %q", v)
+	}
+	if v, _ := schema.Metadata().GetValue("exported_at"); v != "2024-01-02T00:00:00Z" {
+		t.Errorf("Expected exported_at metadata 2024-01-02T00:00:00Z, got %q", v)
+	}
+
+	if !rdr.Next() {
+		t.Fatalf("Expected a record batch: %v", rdr.Err())
+	}
+	rec := rdr.Record()
+	if rec.NumRows() != 3 {
+		t.Fatalf("Expected 3 rows, got %d", rec.NumRows())
+	}
+	if ts := rec.Column(0).(*array.Timestamp).Value(1).ToTime(arrow.Millisecond); !ts.Equal(goldenResponse().Data[1].Timestamp) {
+		t.Errorf("Expected timestamp %v, got %v", goldenResponse().Data[1].Timestamp, ts)
+	}
+	if v := rec.Column(1).(*array.Float64).Value(1); v != 150.25 {
+		t.Errorf("Expected value 150.25, got %v", v)
+	}
+	if status := rec.Column(3).(*array.String); !status.IsNull(0) || status.Value(1) != "500" {
+		t.Errorf("Expected status [nil 500], got %v", status)
+	}
+	if v := rec.Column(4).(*array.String).Value(2); v != "shadowed" {
+		t.Errorf("Expected shadowed label value, got %v", v)
+	}
+	if rdr.Next() {
+		t.Error("Expected a single record batch")
+	}
+}
+
+func TestWriteArrow_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	response := &models.MetricsResponse{Application: "test-app"}
+	if err := writeArrow(&buf, response, goldenExportedAt); err != nil {
+		t.Fatalf("Failed to write Arrow stream: %v", err)
+	}
+
+	rdr, err := ipc.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read Arrow stream: %v", err)
+	}
+	defer rdr.Release()
+	if n := len(rdr.Schema().Fields()); n != 2 {
+		t.Errorf("Expected timestamp and value fields, got %d fields", n)
+	}
+	if rdr.Next() {
+		t.Error("Expected no record batches")
+	}
+}
+
+func TestExportArrow_Headers(t *testing.T) {
+	srv := &Server{
+		logger: testLogger,
+	}
+
+	rr := httptest.NewRecorder()
+	srv.export(rr, arrowExporter{}, goldenResponse(), nil)
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/vnd.apache.arrow.stream" {
+		t.Errorf("Expected Content-Type application/vnd.apache.arrow.stream, got %s", contentType)
+	}
+	rdr, err := ipc.NewReader(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read Arrow export: %v", err)
+	}
+	rdr.Release()
+}
+
+func TestLabelFieldNames(t *testing.T) {
+	keys := []string{"label_value", "pod.name", "pod_name", "timestamp", "value"}
+
+	names := labelFieldNames(keys, nil)
+	expected := []string{"label_value", "pod.name", "pod_name", "label_timestamp", "label_value_2"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+
+	names = labelFieldNames(keys, avroName)
+	expected = []string{"label_value", "pod_name", "label_pod_name", "label_timestamp", "label_value_2"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v with avroName, got %v", expected, names)
+	}
+}
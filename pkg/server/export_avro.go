@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hamba/avro/v2/ocf"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+// avroRecordName is the full name of the exported record schema
+const avroRecordName = "io.argoproj.extensions.metrics.MetricPoint"
+
+// avroBlockSize is the number of records per container file block
+const avroBlockSize = 4096
+
+func init() {
+	RegisterExporter(avroExporter{})
+}
+
+// avroExporter writes an Avro object container file for the archival
+// pipeline
+type avroExporter struct{}
+
+func (avroExporter) Name() string        { return "avro" }
+func (avroExporter) ContentType() string { return "application/avro" }
+func (avroExporter) Extension() string   { return "avro" }
+
+func (avroExporter) Export(w io.Writer, response *models.MetricsResponse, opts ExportOptions) error {
+	return writeAvro(w, response, opts.ExportedAt)
+}
+
+// writeAvro writes an Avro object container file whose record schema has
+// timestamp, value and one optional string field per label. Application,
+// project, graph and the export time are recorded in the file metadata.
+func writeAvro(w io.Writer, response *models.MetricsResponse, exportedAt time.Time) error {
+	keys := labelColumns(response.Data, nil)
+	names := labelFieldNames(keys, avroName)
+
+	enc, err := ocf.NewEncoder(avroSchema(names), w,
+		ocf.WithBlockLength(avroBlockSize),
+		ocf.WithMetadata(map[string][]byte{
+			"application": []byte(response.Application),
+			"project":     []byte(response.Project),
+			"graph":       []byte(response.Graph),
+			"exported_at": []byte(exportedAt.Format(time.RFC3339)),
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	record := make(map[string]interface{}, len(names)+2)
+	for _, data := range response.Data {
+		record["timestamp"] = data.Timestamp
+		record["value"] = data.Value
+		for i, key := range keys {
+			if val, ok := data.Labels[key]; ok {
+				record[names[i]] = val
+			} else {
+				record[names[i]] = nil
+			}
+		}
+
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	return enc.Close()
+}
+
+// avroSchema returns the JSON record schema with timestamp, value and an
+// optional string field for each of the label field names
+func avroSchema(labelNames []string) string {
+	type field struct {
+		Name    string          `json:"name"`
+		Type    interface{}     `json:"type"`
+		Default json.RawMessage `json:"default,omitempty"`
+	}
+
+	fields := []field{
+		{Name: "timestamp", Type: map[string]string{"type": "long", "logicalType": "timestamp-millis"}},
+		{Name: "value", Type: "double"},
+	}
+	for _, name := range labelNames {
+		fields = append(fields, field{Name: name, Type: []string{"null", "string"}, Default: json.RawMessage("null")})
+	}
+
+	schema, _ := json.Marshal(struct {
+		Type   string  `json:"type"`
+		Name   string  `json:"name"`
+		Fields []field `json:"fields"`
+	}{"record", avroRecordName, fields})
+	return string(schema)
+}
+
+// avroName rewrites a label key into a valid Avro field name, which must
+// match [A-Za-z_][A-Za-z0-9_]*
+func avroName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, key)
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/ocf"
+)
+
+func TestWriteAvro_Decode(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeAvro(&buf, goldenResponse(), goldenExportedAt); err != nil {
+		t.Fatalf("Failed to write Avro file: %v", err)
+	}
+
+	dec, err := ocf.NewDecoder(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read Avro file: %v", err)
+	}
+
+	meta := dec.Metadata()
+	parsed, err := avro.Parse(string(meta["avro.schema"]))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+	schema, ok := parsed.(*avro.RecordSchema)
+	if !ok || schema.FullName() != avroRecordName {
+		t.Fatalf("Expected record %s, got %v", avroRecordName, parsed)
+	}
+	expectedFields := []string{"timestamp", "value", "instance", "status", "label_value"}
+	if len(schema.Fields()) != len(expectedFields) {
+		t.Fatalf("Expected fields %v, got %v", expectedFields, schema.Fields())
+	}
+	for i, field := range schema.Fields() {
+		if field.Name() != expectedFields[i] {
+			t.Errorf("Field %d: expected %s, got %s", i, expectedFields[i], field.Name())
+		}
+		if optional := field.Type().Type() == avro.Union; optional != (i >= 2) {
+			t.Errorf("Field %s: expected optional=%v", field.Name(), i >= 2)
+		}
+	}
+	if string(meta["graph"]) != "request-rate" || string(meta["exported_at"]) != "2024-01-02T00:00:00Z" {
+		t.Errorf("Unexpected metadata: %q %q", meta["graph"], meta["exported_at"])
+	}
+
+	response := goldenResponse()
+	var records []map[string]interface{}
+	for dec.HasNext() {
+		var record map[string]interface{}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("Failed to decode record: %v", err)
+		}
+		records = append(records, record)
+	}
+	if err := dec.Error(); err != nil {
+		t.Fatalf("Failed to read Avro file: %v", err)
+	}
+	if len(records) != len(response.Data) {
+		t.Fatalf("Expected %d records, got %d", len(response.Data), len(records))
+	}
+	for i, data := range response.Data {
+		record := records[i]
+		if ts, _ := record["timestamp"].(time.Time); !ts.Equal(data.Timestamp) || record["value"] != data.Value {
+			t.Errorf("Record %d: expected %v %v, got %v %v", i, data.Timestamp, data.Value, record["timestamp"], record["value"])
+		}
+		for field, key := range map[string]string{"instance": "instance", "status": "status", "label_value": "value"} {
+			var expected interface{}
+			if val, ok := data.Labels[key]; ok {
+				expected = val
+			}
+			if got := record[field]; got != expected {
+				t.Errorf("Record %d label %s: expected %v, got %v", i, key, expected, got)
+			}
+		}
+	}
+}
+
+func TestExportAvro_Headers(t *testing.T) {
+	srv := &Server{
+		logger: testLogger,
+	}
+
+	rr := httptest.NewRecorder()
+	srv.export(rr, avroExporter{}, goldenResponse(), nil)
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/avro" {
+		t.Errorf("Expected Content-Type application/avro, got %s", contentType)
+	}
+	if _, err := ocf.NewDecoder(bytes.NewReader(rr.Body.Bytes())); err != nil {
+		t.Errorf("Failed to read Avro export: %v", err)
+	}
+}
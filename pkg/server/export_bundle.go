@@ -0,0 +1,332 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/server/middleware"
+)
+
+// maxBundleGraphs bounds the graphs exported by one bundle request
+const maxBundleGraphs = 500
+
+// bundleManifestName is the manifest entry, written last so it can record
+// the checksum of every file
+const bundleManifestName = "manifest.json"
+
+// bundleManifest describes the contents of a bundle
+type bundleManifest struct {
+	Application string          `json:"application"`
+	Project     string          `json:"project"`
+	GroupKind   string          `json:"groupkind,omitempty"`
+	Row         string          `json:"row,omitempty"`
+	Format      string          `json:"format"`
+	ExportedAt  time.Time       `json:"exported_at"`
+	Range       *bundleRange    `json:"range,omitempty"`
+	Files       []bundleFile    `json:"files"`
+	Errors      []bundleFailure `json:"errors,omitempty"`
+}
+
+// bundleRange is a time range of the bundle. The range of the manifest is
+// the requested one, absent when each graph's default window was exported;
+// the range of a file is the one its query covered.
+type bundleRange struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Step       string    `json:"step,omitempty"`
+	Downsample string    `json:"downsample,omitempty"`
+}
+
+// bundleFile describes one exported graph. Query and Range are recorded
+// when the provider can describe its queries.
+type bundleFile struct {
+	Path string `json:"path"`
+	providers.GraphRef
+	Query      string       `json:"query,omitempty"`
+	Range      *bundleRange `json:"range,omitempty"`
+	DataPoints int          `json:"data_points"`
+	SizeBytes  int64        `json:"size_bytes"`
+	SHA256     string       `json:"sha256"`
+}
+
+// bundleFailure records a graph that could not be exported. The archive is
+// already being streamed by then, so failures are reported here rather than
+// in the response status. Path names the truncated entry left when writing
+// the export failed.
+type bundleFailure struct {
+	providers.GraphRef
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error"`
+}
+
+// handleExportBundle streams a ZIP archive with one export per graph of the
+// application, or of the {groupkind} and {row} in the URL, in the format
+// given by the format parameter, followed by manifest.json. Time range
+// parameters apply to every graph.
+//
+// Graphs are listed by the provider, which must implement
+// providers.GraphLister. Graphs the caller may not view under
+// middleware.AuthorizeEach are left out; routes without a policy must use
+// middleware.AllowAllGraphs.
+func (s *Server) handleExportBundle(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	format := params.Get("format")
+	if format == "" {
+		format = defaultExportFormat
+	}
+	exporter, ok := exporters.Lookup(format)
+	if !ok {
+		s.respondError(w, http.StatusNotAcceptable, "unsupported export format",
+			"supported formats: "+strings.Join(exporters.Names(), ", "))
+		return
+	}
+	if v, ok := exporter.(optionsValidator); ok {
+		if err := v.ValidateOptions(params); err != nil {
+			s.respondError(w, http.StatusBadRequest, "invalid parameter", err.Error())
+			return
+		}
+	}
+
+	timeRange, err := exportRangeFromQuery(params, time.Now())
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid parameter", err.Error())
+		return
+	}
+
+	base, ok := s.exportQuery(w, r)
+	if !ok {
+		return
+	}
+
+	lister, ok := s.provider.(providers.GraphLister)
+	if !ok {
+		s.respondError(w, http.StatusNotImplemented, "bundle export unavailable",
+			"the metrics provider cannot list dashboard graphs")
+		return
+	}
+	all, err := lister.ListGraphs(r.Context(), base.Application, base.Project)
+	if errors.Is(err, providers.ErrNotSupported) {
+		s.respondError(w, http.StatusNotImplemented, "bundle export unavailable",
+			"the metrics provider cannot list dashboard graphs")
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to list graphs", "application", base.Application, "error", err)
+		s.respondError(w, http.StatusInternalServerError, "query failed", err.Error())
+		return
+	}
+
+	var graphs []providers.GraphRef
+	for _, g := range all {
+		if (base.GroupKind != "" && g.GroupKind != base.GroupKind) || (base.Row != "" && g.Row != base.Row) {
+			continue
+		}
+		if !middleware.GraphAllowed(r.Context(), g.GroupKind, g.Graph) {
+			continue
+		}
+		graphs = append(graphs, g)
+	}
+	if len(graphs) == 0 {
+		s.respondError(w, http.StatusNotFound, "no graphs", "no graphs match the request")
+		return
+	}
+	if len(graphs) > maxBundleGraphs {
+		s.respondError(w, http.StatusBadRequest, "too many graphs",
+			fmt.Sprintf("at most %d graphs may be bundled, narrow the request to a groupkind or row", maxBundleGraphs))
+		return
+	}
+
+	now := time.Now()
+	manifest := bundleManifest{
+		Application: base.Application,
+		Project:     base.Project,
+		GroupKind:   base.GroupKind,
+		Row:         base.Row,
+		Format:      exporter.Name(),
+		ExportedAt:  now.UTC(),
+		Files:       []bundleFile{},
+	}
+	if timeRange != nil {
+		manifest.Range = newBundleRange(timeRange.TimeRange)
+		manifest.Range.Downsample = timeRange.downsample
+	}
+	describer, _ := s.provider.(providers.QueryDescriber)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=metrics_%s_%s.zip", base.Application, now.Format("20060102_150405")))
+
+	zw := zip.NewWriter(w)
+	opts := ExportOptions{Params: params, ExportedAt: now}
+	paths := bundlePaths(graphs, exporter.Extension())
+	for i, g := range graphs {
+		if err := r.Context().Err(); err != nil {
+			s.logger.Info("bundle export canceled", "application", base.Application, "error", err)
+			return
+		}
+
+		query := *base
+		query.GroupKind, query.Row, query.Graph = g.GroupKind, g.Row, g.Graph
+		desc, response, err := s.queryBundleGraph(r.Context(), describer, &query, timeRange)
+		if err != nil {
+			s.logger.Warn("bundle graph query failed", "graph", g.Graph, "error", err)
+			manifest.Errors = append(manifest.Errors, bundleFailure{GraphRef: g, Error: err.Error()})
+			continue
+		}
+
+		file, err := writeBundleEntry(zw, paths[i], now, func(out io.Writer) error {
+			return exporter.Export(out, response, opts)
+		})
+		if err != nil {
+			// A failed write leaves a truncated entry; the manifest names it
+			s.logger.Error("failed to write bundle entry", "graph", g.Graph, "error", err)
+			manifest.Errors = append(manifest.Errors, bundleFailure{GraphRef: g, Path: paths[i], Error: err.Error()})
+			continue
+		}
+		file.GraphRef = g
+		file.DataPoints = len(response.Data)
+		if desc != nil {
+			file.Query = desc.Expression
+			file.Range = newBundleRange(desc.Range)
+		} else if manifest.Range != nil {
+			file.Range = newBundleRange(timeRange.TimeRange)
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	if _, err := writeBundleEntry(zw, bundleManifestName, now, func(out io.Writer) error {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(manifest)
+	}); err != nil {
+		s.logger.Error("failed to write bundle manifest", "error", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		s.logger.Error("failed to finish bundle", "error", err)
+		return
+	}
+
+	s.logger.Info("exported metrics bundle",
+		"format", exporter.Name(),
+		"application", base.Application,
+		"graphs", len(manifest.Files),
+		"failed", len(manifest.Errors))
+}
+
+// queryBundleGraph runs the export query of one graph. When the provider
+// describes its queries, the graph's default window is resolved first and
+// queried as a range, so the description is exactly what was exported.
+func (s *Server) queryBundleGraph(ctx context.Context, describer providers.QueryDescriber, query *models.MetricsQuery, timeRange *exportRange) (*providers.QueryDescription, *models.MetricsResponse, error) {
+	if describer == nil {
+		response, err := queryExport(ctx, s.provider, query, timeRange)
+		return nil, response, err
+	}
+
+	var tr *models.TimeRange
+	if timeRange != nil {
+		tr = &timeRange.TimeRange
+	}
+	desc, err := describer.DescribeQuery(ctx, query, tr)
+	if errors.Is(err, providers.ErrNotSupported) {
+		response, err := queryExport(ctx, s.provider, query, timeRange)
+		return nil, response, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if timeRange == nil {
+		response, err := queryRange(ctx, s.provider, query, desc.Range)
+		return desc, response, err
+	}
+	response, err := queryExport(ctx, s.provider, query, timeRange)
+	return desc, response, err
+}
+
+// newBundleRange returns the manifest form of r
+func newBundleRange(r models.TimeRange) *bundleRange {
+	br := &bundleRange{Start: r.Start, End: r.End}
+	if r.Step != 0 {
+		br.Step = r.Step.String()
+	}
+	return br
+}
+
+// bundlePaths places each graph's export at <groupkind>/<row>/<graph>.<ext>,
+// with path separators in names replaced. Names that clean to a path already
+// taken, such as "a/b" and "a_b", or that differ from it only in case, get a
+// numeric suffix (<graph>-2.<ext>) in graph order, so every entry is unique
+// even when extracted on a case-insensitive file system.
+func bundlePaths(graphs []providers.GraphRef, ext string) []string {
+	clean := func(s string) string {
+		s = strings.NewReplacer("/", "_", "\\", "_").Replace(s)
+		if s == "" || s == "." || s == ".." {
+			s = "_"
+		}
+		return s
+	}
+
+	used := make(map[string]bool, len(graphs))
+	paths := make([]string, len(graphs))
+	for i, g := range graphs {
+		dir := path.Join(clean(g.GroupKind), clean(g.Row))
+		name := clean(g.Graph)
+		p := path.Join(dir, name+"."+ext)
+		for n := 2; used[strings.ToLower(p)]; n++ {
+			p = path.Join(dir, fmt.Sprintf("%s-%d.%s", name, n, ext))
+		}
+		used[strings.ToLower(p)] = true
+		paths[i] = p
+	}
+	return paths
+}
+
+// writeBundleEntry adds a deflated entry written by write, returning its
+// size and SHA-256
+func writeBundleEntry(zw *zip.Writer, name string, modified time.Time, write func(io.Writer) error) (bundleFile, error) {
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return bundleFile{}, err
+	}
+
+	h := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(entry, h)}
+	if err := write(counter); err != nil {
+		return bundleFile{}, err
+	}
+	return bundleFile{
+		Path:      name,
+		SizeBytes: counter.n,
+		SHA256:    hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
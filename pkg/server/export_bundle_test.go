@@ -0,0 +1,345 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/rbac"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/server/middleware"
+)
+
+// fakeDashboardProvider lists a fixed dashboard and returns one point per
+// graph, failing queries for the graphs in fail
+type fakeDashboardProvider struct {
+	graphs []providers.GraphRef
+	fail   map[string]bool
+}
+
+func (p *fakeDashboardProvider) Query(ctx context.Context, query *models.MetricsQuery) (*models.MetricsResponse, error) {
+	if p.fail[query.Graph] {
+		return nil, errors.New("upstream timeout")
+	}
+	return &models.MetricsResponse{
+		Application: query.Application,
+		Project:     query.Project,
+		Graph:       query.Graph,
+		Data: []models.MetricData{
+			{Timestamp: time.Unix(1704067200, 0).UTC(), Value: 1, Labels: map[string]string{"row": query.Row}},
+		},
+	}, nil
+}
+
+func (p *fakeDashboardProvider) ListGraphs(ctx context.Context, application, project string) ([]providers.GraphRef, error) {
+	return p.graphs, nil
+}
+
+var testDashboard = []providers.GraphRef{
+	{GroupKind: "deployment", Row: "http", Graph: "request-rate"},
+	{GroupKind: "deployment", Row: "http", Graph: "error-rate"},
+	{GroupKind: "deployment", Row: "resources", Graph: "cpu"},
+	{GroupKind: "pod", Row: "resources", Graph: "memory"},
+}
+
+func readBundle(t *testing.T, body []byte) (map[string][]byte, bundleManifest) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+
+	var manifest bundleManifest
+	if err := json.Unmarshal(files[bundleManifestName], &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	return files, manifest
+}
+
+func bundleRouter(srv *Server, mw ...func(http.Handler) http.Handler) http.Handler {
+	r := chi.NewRouter()
+	r.With(mw...).Get("/api/applications/{application}/export/bundle", srv.handleExportBundle)
+	r.With(mw...).Get("/api/applications/{application}/groupkinds/{groupkind}/export/bundle", srv.handleExportBundle)
+	r.With(mw...).Get("/api/applications/{application}/groupkinds/{groupkind}/rows/{row}/export/bundle", srv.handleExportBundle)
+	return r
+}
+
+func TestHandleExportBundle(t *testing.T) {
+	srv := &Server{
+		logger:   testLogger,
+		provider: &fakeDashboardProvider{graphs: testDashboard, fail: map[string]bool{"error-rate": true}},
+	}
+
+	rr := httptest.NewRecorder()
+	bundleRouter(srv, middleware.AllowAllGraphs()).ServeHTTP(rr, httptest.NewRequest("GET",
+		"/api/applications/test-app/groupkinds/deployment/export/bundle?application_name=test-app&project=default&format=csv&duration=1h", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.HasSuffix(cd, ".zip") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	files, manifest := readBundle(t, rr.Body.Bytes())
+	if len(files) != 3 {
+		t.Errorf("got files %v, want two graphs and the manifest", len(files))
+	}
+	if manifest.Format != "csv" || manifest.GroupKind != "deployment" || manifest.Range == nil {
+		t.Errorf("manifest = %+v", manifest)
+	}
+	if len(manifest.Files) != 2 || len(manifest.Errors) != 1 || manifest.Errors[0].Graph != "error-rate" {
+		t.Fatalf("manifest files %+v, errors %+v", manifest.Files, manifest.Errors)
+	}
+
+	for _, f := range manifest.Files {
+		content, ok := files[f.Path]
+		if !ok {
+			t.Errorf("manifest lists missing file %s", f.Path)
+			continue
+		}
+		sum := sha256.Sum256(content)
+		if f.SHA256 != hex.EncodeToString(sum[:]) || f.SizeBytes != int64(len(content)) {
+			t.Errorf("%s: checksum or size does not match the content", f.Path)
+		}
+	}
+	if _, ok := files["deployment/resources/cpu.csv"]; !ok {
+		t.Errorf("missing deployment/resources/cpu.csv in %v", manifest.Files)
+	}
+}
+
+func TestHandleExportBundle_Row(t *testing.T) {
+	srv := &Server{logger: testLogger, provider: &fakeDashboardProvider{graphs: testDashboard}}
+
+	rr := httptest.NewRecorder()
+	bundleRouter(srv, middleware.AllowAllGraphs()).ServeHTTP(rr, httptest.NewRequest("GET",
+		"/api/applications/test-app/groupkinds/deployment/rows/http/export/bundle?application_name=test-app&project=default", nil))
+	_, manifest := readBundle(t, rr.Body.Bytes())
+	if len(manifest.Files) != 2 || manifest.Files[0].Path != "deployment/http/request-rate.json" {
+		t.Errorf("files = %+v", manifest.Files)
+	}
+}
+
+func TestHandleExportBundle_Authorization(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	policy, err := rbac.ParsePolicy(strings.NewReader("p, alice, default/*, */memory, allow\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enforcer := rbac.NewEnforcer(policy, logger)
+	srv := &Server{logger: testLogger, provider: &fakeDashboardProvider{graphs: testDashboard}}
+	handler := bundleRouter(srv, middleware.NewArgoCDAuth(logger).Authenticate(), middleware.AuthorizeEach(enforcer))
+
+	req := httptest.NewRequest("GET", "/api/applications/test-app/export/bundle", nil)
+	req.Header.Set(middleware.HeaderArgoCDApplicationName, "argocd:test-app")
+	req.Header.Set(middleware.HeaderArgoCDProjectName, "default")
+	req.Header.Set(middleware.HeaderArgoCDUsername, "alice")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	_, manifest := readBundle(t, rr.Body.Bytes())
+	if len(manifest.Files) != 1 || manifest.Files[0].Graph != "memory" {
+		t.Errorf("files = %+v, want only the permitted graph", manifest.Files)
+	}
+}
+
+func TestHandleExportBundle_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider providers.MetricsQuerier
+		target   string
+		want     int
+	}{
+		{"no graph listing", &fakeProvider{}, "/api/applications/test-app/export/bundle", http.StatusNotImplemented},
+		{"routed provider without graph listing", &unsupportedProvider{}, "/api/applications/test-app/export/bundle", http.StatusNotImplemented},
+		{"unknown format", &fakeDashboardProvider{graphs: testDashboard}, "/api/applications/test-app/export/bundle?format=pdf", http.StatusNotAcceptable},
+		{"bad range", &fakeDashboardProvider{graphs: testDashboard}, "/api/applications/test-app/export/bundle?step=1m", http.StatusBadRequest},
+		{"no matching graphs", &fakeDashboardProvider{graphs: testDashboard}, "/api/applications/test-app/groupkinds/statefulset/export/bundle", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &Server{logger: testLogger, provider: tt.provider}
+			sep := "?"
+			if strings.Contains(tt.target, "?") {
+				sep = "&"
+			}
+			rr := httptest.NewRecorder()
+			bundleRouter(srv, middleware.AllowAllGraphs()).ServeHTTP(rr, httptest.NewRequest("GET", tt.target+sep+"application_name=test-app&project=default", nil))
+			if rr.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rr.Code, tt.want, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestBundlePaths(t *testing.T) {
+	got := bundlePaths([]providers.GraphRef{
+		{GroupKind: "apps/Deployment", Row: "..", Graph: "p99 latency"},
+		{GroupKind: "deployment", Row: "http", Graph: "a/b"},
+		{GroupKind: "deployment", Row: "http", Graph: "a_b"},
+		{GroupKind: "deployment", Row: "http", Graph: "a_b-2"},
+		{GroupKind: "deployment", Row: "http", Graph: "A_B"},
+	}, "csv")
+	want := []string{
+		"apps_Deployment/_/p99 latency.csv",
+		"deployment/http/a_b.csv",
+		"deployment/http/a_b-2.csv",
+		"deployment/http/a_b-2-2.csv",
+		"deployment/http/A_B-3.csv",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("bundlePaths = %q, want %q", got, want)
+	}
+}
+
+// TestHandleExportBundle_PathCollision bundles graphs whose names clean to
+// the same path, each of which must get its own entry in the manifest
+func TestHandleExportBundle_PathCollision(t *testing.T) {
+	srv := &Server{
+		logger: testLogger,
+		provider: &fakeDashboardProvider{graphs: []providers.GraphRef{
+			{GroupKind: "deployment", Row: "http", Graph: "a/b"},
+			{GroupKind: "deployment", Row: "http", Graph: "a_b"},
+		}},
+	}
+
+	rr := httptest.NewRecorder()
+	bundleRouter(srv, middleware.AllowAllGraphs()).ServeHTTP(rr, httptest.NewRequest("GET",
+		"/api/applications/test-app/export/bundle?application_name=test-app&project=default&format=json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	files, manifest := readBundle(t, rr.Body.Bytes())
+	if len(files) != 3 || len(manifest.Files) != 2 {
+		t.Fatalf("got %d files and manifest entries %+v, want two graphs and the manifest", len(files), manifest.Files)
+	}
+	for _, f := range manifest.Files {
+		var export struct {
+			Metadata struct {
+				Graph string `json:"graph"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(files[f.Path], &export); err != nil {
+			t.Fatalf("%s: %v", f.Path, err)
+		}
+		if export.Metadata.Graph != f.Graph {
+			t.Errorf("%s holds graph %q, manifest says %q", f.Path, export.Metadata.Graph, f.Graph)
+		}
+	}
+}
+
+// fakeDescribingProvider describes its queries and records the ranges it
+// was queried with
+type fakeDescribingProvider struct {
+	fakeDashboardProvider
+	now    time.Time
+	ranges []models.TimeRange
+}
+
+func (p *fakeDescribingProvider) QueryRange(ctx context.Context, query *models.MetricsQuery, r models.TimeRange) (*models.MetricsResponse, error) {
+	p.ranges = append(p.ranges, r)
+	return p.Query(ctx, query)
+}
+
+func (p *fakeDescribingProvider) DescribeQuery(ctx context.Context, query *models.MetricsQuery, r *models.TimeRange) (*providers.QueryDescription, error) {
+	tr := models.TimeRange{Start: p.now.Add(-time.Hour), End: p.now, Step: 12 * time.Second}
+	if r != nil {
+		tr = *r
+	}
+	return &providers.QueryDescription{Expression: "rate(" + query.Graph + "[5m])", Range: tr}, nil
+}
+
+func TestHandleExportBundle_QueryDescriptions(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	provider := &fakeDescribingProvider{
+		fakeDashboardProvider: fakeDashboardProvider{graphs: testDashboard[:1]},
+		now:                   now,
+	}
+	srv := &Server{logger: testLogger, provider: provider}
+
+	rr := httptest.NewRecorder()
+	bundleRouter(srv, middleware.AllowAllGraphs()).ServeHTTP(rr, httptest.NewRequest("GET",
+		"/api/applications/test-app/export/bundle?application_name=test-app&project=default", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	_, manifest := readBundle(t, rr.Body.Bytes())
+	if manifest.Range != nil || len(manifest.Files) != 1 {
+		t.Fatalf("manifest = %+v", manifest)
+	}
+	file := manifest.Files[0]
+	if file.Query != "rate(request-rate[5m])" {
+		t.Errorf("query = %q", file.Query)
+	}
+	// The default window is recorded and is the range that was queried
+	want := &bundleRange{Start: now.Add(-time.Hour), End: now, Step: "12s"}
+	if file.Range == nil || !file.Range.Start.Equal(want.Start) || !file.Range.End.Equal(want.End) || file.Range.Step != want.Step {
+		t.Errorf("range = %+v, want %+v", file.Range, want)
+	}
+	if len(provider.ranges) != 1 || !provider.ranges[0].Start.Equal(want.Start) || !provider.ranges[0].End.Equal(want.End) {
+		t.Errorf("queried ranges %+v, want the described range", provider.ranges)
+	}
+}
+
+// brokenExporter fails after writing part of its output
+type brokenExporter struct {
+	failingExporter
+}
+
+func (brokenExporter) Name() string { return "broken" }
+
+func TestHandleExportBundle_WriteFailure(t *testing.T) {
+	saved := exporters
+	exporters = &ExporterRegistry{}
+	defer func() { exporters = saved }()
+	if err := exporters.Register(brokenExporter{failingExporter{written: "Timestamp"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &Server{logger: testLogger, provider: &fakeDashboardProvider{graphs: testDashboard[:1]}}
+	rr := httptest.NewRecorder()
+	bundleRouter(srv, middleware.AllowAllGraphs()).ServeHTTP(rr, httptest.NewRequest("GET",
+		"/api/applications/test-app/export/bundle?application_name=test-app&project=default&format=broken", nil))
+
+	files, manifest := readBundle(t, rr.Body.Bytes())
+	if len(manifest.Files) != 0 || len(manifest.Errors) != 1 {
+		t.Fatalf("manifest files %+v, errors %+v", manifest.Files, manifest.Errors)
+	}
+	failure := manifest.Errors[0]
+	if failure.Path != "deployment/http/request-rate.csv" {
+		t.Errorf("failure path = %q", failure.Path)
+	}
+	if _, ok := files[failure.Path]; !ok {
+		t.Errorf("the failure names %s, which is not in the archive", failure.Path)
+	}
+}
@@ -0,0 +1,943 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/common/model"
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/server/middleware"
+)
+
+// Defaults for ExportJobConfig
+const (
+	defaultJobWorkers        = 2
+	defaultJobQueueSize      = 100
+	defaultJobChunkSize      = 24 * time.Hour
+	defaultJobRetention      = 24 * time.Hour
+	defaultMaxJobsPerUser    = 3
+	jobCleanupInterval       = 5 * time.Minute
+	maxExportJobRequestBytes = 1 << 20
+	// maxBufferedJobRange and maxBufferedJobPoints bound jobs in formats
+	// that need every point in memory at once; the CSV long layout and JSON
+	// are written from disk and take any range
+	maxBufferedJobRange  = 7 * 24 * time.Hour
+	maxBufferedJobPoints = 5_000_000
+)
+
+// ExportJobConfig configures asynchronous export jobs
+type ExportJobConfig struct {
+	// Dir is where finished exports are stored
+	Dir string `yaml:"dir" json:"dir"`
+	// Workers is the number of jobs run concurrently (default 2)
+	Workers int `yaml:"workers" json:"workers"`
+	// QueueSize bounds the number of queued jobs (default 100)
+	QueueSize int `yaml:"queueSize" json:"queueSize"`
+	// ChunkSize is the time range fetched per provider query (default 24h)
+	ChunkSize time.Duration `yaml:"chunkSize" json:"chunkSize"`
+	// Retention is how long finished jobs and their files are kept (default 24h)
+	Retention time.Duration `yaml:"retention" json:"retention"`
+	// MaxJobsPerUser bounds queued and running jobs per user (default 3)
+	MaxJobsPerUser int `yaml:"maxJobsPerUser" json:"maxJobsPerUser"`
+}
+
+// ExportJobState is the lifecycle state of an export job
+type ExportJobState string
+
+const (
+	JobQueued    ExportJobState = "queued"
+	JobRunning   ExportJobState = "running"
+	JobSucceeded ExportJobState = "succeeded"
+	JobFailed    ExportJobState = "failed"
+	JobCanceled  ExportJobState = "canceled"
+)
+
+// finished reports whether a job in this state will not run again
+func (s ExportJobState) finished() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCanceled
+}
+
+// ExportJobRequest is the body of POST /api/exports. The range fields take
+// the same values as the start, end, duration, step and downsample
+// parameters of synchronous exports, without their limit on points.
+type ExportJobRequest struct {
+	Application string            `json:"application"`
+	Project     string            `json:"project"`
+	GroupKind   string            `json:"groupkind"`
+	Row         string            `json:"row"`
+	Graph       string            `json:"graph"`
+	Format      string            `json:"format"`
+	Start       time.Time         `json:"start"`
+	End         time.Time         `json:"end"`
+	Duration    string            `json:"duration,omitempty"`
+	Step        string            `json:"step,omitempty"`
+	Downsample  string            `json:"downsample,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+}
+
+// rangeParams returns the range fields as export query parameters
+func (req ExportJobRequest) rangeParams() url.Values {
+	params := make(url.Values)
+	if !req.Start.IsZero() {
+		params.Set("start", req.Start.Format(time.RFC3339Nano))
+	}
+	if !req.End.IsZero() {
+		params.Set("end", req.End.Format(time.RFC3339Nano))
+	}
+	for k, v := range map[string]string{"duration": req.Duration, "step": req.Step, "downsample": req.Downsample} {
+		if v != "" {
+			params.Set(k, v)
+		}
+	}
+	return params
+}
+
+// ExportJob reports the state of an export job
+type ExportJob struct {
+	ID          string         `json:"id"`
+	Owner       string         `json:"owner,omitempty"`
+	State       ExportJobState `json:"state"`
+	Format      string         `json:"format"`
+	Application string         `json:"application"`
+	Project     string         `json:"project"`
+	Graph       string         `json:"graph"`
+	Start       *time.Time     `json:"start,omitempty"`
+	End         *time.Time     `json:"end,omitempty"`
+	Step        string         `json:"step,omitempty"`
+	Downsample  string         `json:"downsample,omitempty"`
+	ChunksDone  int            `json:"chunks_done"`
+	ChunksTotal int            `json:"chunks_total"`
+	Progress    float64        `json:"progress"`
+	Rows        int            `json:"rows"`
+	SizeBytes   int64          `json:"size_bytes,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+	StartedAt   *time.Time     `json:"started_at,omitempty"`
+	CompletedAt *time.Time     `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time     `json:"expires_at,omitempty"`
+}
+
+// exportJob is the manager's record of a job; fields are guarded by the
+// manager's mutex
+type exportJob struct {
+	ExportJob
+	query    *models.MetricsQuery
+	rng      *exportRange
+	exporter Exporter
+	params   url.Values
+	file     string
+	cancel   context.CancelFunc
+}
+
+// Errors returned by Submit
+var (
+	ErrJobQuotaExceeded = errors.New("too many active export jobs")
+	ErrJobQueueFull     = errors.New("export job queue is full")
+
+	ErrJobRangeNotSupported = errors.New("the metrics provider does not support range queries; omit start and end to export its default window")
+)
+
+// ExportJobManager runs exports in the background on a pool of workers and
+// keeps the results in a local directory until they expire
+type ExportJobManager struct {
+	provider providers.MetricsQuerier
+	cfg      ExportJobConfig
+	queue    chan *exportJob
+	now      func() time.Time
+	logger   *slog.Logger
+
+	mu   sync.Mutex
+	jobs map[string]*exportJob
+}
+
+// NewExportJobManager creates a job manager storing exports in cfg.Dir,
+// which is created if missing. Call Start to run the workers.
+func NewExportJobManager(provider providers.MetricsQuerier, cfg ExportJobConfig, logger *slog.Logger) (*ExportJobManager, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("export jobs require a storage directory")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultJobWorkers
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultJobQueueSize
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = defaultJobChunkSize
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = defaultJobRetention
+	}
+	if cfg.MaxJobsPerUser <= 0 {
+		cfg.MaxJobsPerUser = defaultMaxJobsPerUser
+	}
+
+	return &ExportJobManager{
+		provider: provider,
+		cfg:      cfg,
+		queue:    make(chan *exportJob, cfg.QueueSize),
+		now:      time.Now,
+		logger:   logger.With("component", "export-jobs"),
+		jobs:     make(map[string]*exportJob),
+	}, nil
+}
+
+// Start runs the workers and the retention cleanup until ctx is done.
+// Running jobs are canceled when ctx is done.
+func (m *ExportJobManager) Start(ctx context.Context) {
+	for i := 0; i < m.cfg.Workers; i++ {
+		go m.worker(ctx)
+	}
+
+	go func() {
+		ticker := time.NewTicker(jobCleanupInterval)
+		defer ticker.Stop()
+		for {
+			m.Cleanup()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Submit validates and queues a job for owner
+func (m *ExportJobManager) Submit(owner string, req ExportJobRequest) (ExportJob, error) {
+	format := req.Format
+	if format == "" {
+		format = defaultExportFormat
+	}
+	exporter, ok := exporters.Lookup(format)
+	if !ok {
+		return ExportJob{}, fmt.Errorf("unsupported export format %q (supported formats: %s)",
+			format, strings.Join(exporters.Names(), ", "))
+	}
+
+	params := make(url.Values)
+	for k, v := range req.Params {
+		params.Set(k, v)
+	}
+	if v, ok := exporter.(optionsValidator); ok {
+		if err := v.ValidateOptions(params); err != nil {
+			return ExportJob{}, err
+		}
+	}
+
+	if req.Application == "" || req.Project == "" {
+		return ExportJob{}, errors.New("application and project are required")
+	}
+	rng, err := parseExportRange(req.rangeParams(), m.now())
+	if err != nil {
+		return ExportJob{}, err
+	}
+	// With a step, the points are bounded by maxBufferedJobPoints instead
+	if pe, ok := exporter.(pointsExporter); (!ok || !pe.exportsPoints(params)) &&
+		rng != nil && rng.Step == 0 && rng.End.Sub(rng.Start) > maxBufferedJobRange {
+		return ExportJob{}, fmt.Errorf("%s exports are limited to %s; use csv or json, or a step, for longer ranges",
+			exporter.Name(), model.Duration(maxBufferedJobRange))
+	}
+	// Chunks are range queries; without them every chunk would fetch the
+	// provider's whole default window again
+	if _, ok := m.provider.(providers.RangeQuerier); rng != nil && !ok {
+		return ExportJob{}, ErrJobRangeNotSupported
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return ExportJob{}, err
+	}
+
+	job := &exportJob{
+		ExportJob: ExportJob{
+			ID:          id,
+			Owner:       owner,
+			State:       JobQueued,
+			Format:      exporter.Name(),
+			Application: req.Application,
+			Project:     req.Project,
+			Graph:       req.Graph,
+			CreatedAt:   m.now(),
+		},
+		query: &models.MetricsQuery{
+			Application: req.Application,
+			Project:     req.Project,
+			GroupKind:   req.GroupKind,
+			Row:         req.Row,
+			Graph:       req.Graph,
+		},
+		rng:      rng,
+		exporter: exporter,
+		params:   params,
+	}
+	if rng != nil {
+		start, end := rng.Start.UTC(), rng.End.UTC()
+		job.Start, job.End = &start, &end
+		if rng.Step != 0 {
+			job.Step = model.Duration(rng.Step).String()
+			job.Downsample = rng.downsample
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	active := 0
+	for _, j := range m.jobs {
+		if j.Owner == owner && !j.State.finished() {
+			active++
+		}
+	}
+	if active >= m.cfg.MaxJobsPerUser {
+		return ExportJob{}, ErrJobQuotaExceeded
+	}
+
+	select {
+	case m.queue <- job:
+	default:
+		return ExportJob{}, ErrJobQueueFull
+	}
+	m.jobs[id] = job
+
+	m.logger.Info("export job queued",
+		"id", id,
+		"owner", owner,
+		"application", req.Application,
+		"format", job.Format)
+	return job.snapshot(), nil
+}
+
+// Get returns the state of a job
+func (m *ExportJobManager) Get(id string) (ExportJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return ExportJob{}, false
+	}
+	return job.snapshot(), true
+}
+
+// List returns the jobs of owner, newest first
+func (m *ExportJobManager) List(owner string) []ExportJob {
+	m.mu.Lock()
+	var out []ExportJob
+	for _, job := range m.jobs {
+		if job.Owner == owner {
+			out = append(out, job.snapshot())
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.After(out[j].CreatedAt)
+	})
+	return out
+}
+
+// Cancel stops a queued or running job, or deletes a finished job and its
+// file. It returns false if the job does not exist.
+func (m *ExportJobManager) Cancel(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return false
+	}
+
+	if job.State.finished() {
+		m.remove(job)
+		return true
+	}
+
+	if job.cancel != nil {
+		job.cancel()
+	}
+	m.finish(job, JobCanceled, "")
+	m.logger.Info("export job canceled", "id", id)
+	return true
+}
+
+// Cleanup deletes expired jobs with their files, plus job files in the
+// directory that belong to no job and are older than the retention period,
+// such as those left by a previous process. Files not named like a job's
+// export, spool or temporary file are never removed, so the directory may
+// be shared. It returns the number of files removed.
+func (m *ExportJobManager) Cleanup() int {
+	now := m.now()
+	removed := 0
+
+	m.mu.Lock()
+	known := make(map[string]bool)
+	for _, job := range m.jobs {
+		if job.ExpiresAt != nil && now.After(*job.ExpiresAt) {
+			if job.file != "" {
+				removed++
+			}
+			m.remove(job)
+			continue
+		}
+		if job.file != "" {
+			known[filepath.Base(job.file)] = true
+		}
+	}
+	m.mu.Unlock()
+
+	entries, err := os.ReadDir(m.cfg.Dir)
+	if err != nil {
+		m.logger.Warn("failed to list export directory", "error", err)
+		return removed
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || known[entry.Name()] || !isJobFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < m.cfg.Retention {
+			continue
+		}
+		if err := os.Remove(filepath.Join(m.cfg.Dir, entry.Name())); err == nil {
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		m.logger.Info("removed expired exports", "files", removed)
+	}
+	return removed
+}
+
+// isJobFile reports whether name is one of the files a job writes: its
+// export, <id>.<extension>, or its spool and temporary files,
+// <id>-<random>.spool and <id>-<random>.tmp
+func isJobFile(name string) bool {
+	const idLen = 32
+	if len(name) <= idLen {
+		return false
+	}
+	if _, err := hex.DecodeString(name[:idLen]); err != nil {
+		return false
+	}
+
+	rest := name[idLen:]
+	if strings.HasPrefix(rest, "-") {
+		return strings.HasSuffix(rest, ".spool") || strings.HasSuffix(rest, ".tmp")
+	}
+	for _, e := range exporters.Exporters() {
+		if rest == "."+e.Extension() {
+			return true
+		}
+	}
+	return false
+}
+
+// remove deletes a job and its file; the caller holds m.mu
+func (m *ExportJobManager) remove(job *exportJob) {
+	if job.file != "" {
+		if err := os.Remove(job.file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			m.logger.Warn("failed to remove export file", "id", job.ID, "error", err)
+		}
+	}
+	delete(m.jobs, job.ID)
+}
+
+// finish moves a job to a final state; the caller holds m.mu
+func (m *ExportJobManager) finish(job *exportJob, state ExportJobState, errMsg string) {
+	now := m.now()
+	expires := now.Add(m.cfg.Retention)
+	job.State = state
+	job.Error = errMsg
+	job.CompletedAt = &now
+	job.ExpiresAt = &expires
+	job.cancel = nil
+}
+
+func (m *ExportJobManager) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-m.queue:
+			m.run(ctx, job)
+		}
+	}
+}
+
+// run executes a job, fetching its range in chunks when the provider
+// supports range queries
+func (m *ExportJobManager) run(ctx context.Context, job *exportJob) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m.mu.Lock()
+	if job.State != JobQueued {
+		// Canceled while queued
+		m.mu.Unlock()
+		return
+	}
+	now := m.now()
+	job.State = JobRunning
+	job.StartedAt = &now
+	job.cancel = cancel
+	chunks := m.chunks(job)
+	job.ChunksTotal = len(chunks)
+	m.mu.Unlock()
+
+	m.logger.Info("export job started", "id", job.ID, "chunks", len(chunks))
+
+	spool, err := newJobSpool(m.cfg.Dir, job.ID)
+	if err == nil {
+		defer spool.close()
+		err = m.fetch(ctx, job, chunks, spool)
+	}
+	if err == nil {
+		err = m.write(ctx, job, spool)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job.State != JobRunning {
+		// Canceled while running; drop anything written
+		if job.file != "" {
+			os.Remove(job.file)
+			job.file = ""
+		}
+		return
+	}
+	if err != nil {
+		m.finish(job, JobFailed, err.Error())
+		m.logger.Error("export job failed", "id", job.ID, "error", err)
+		return
+	}
+	m.finish(job, JobSucceeded, "")
+	m.logger.Info("export job finished",
+		"id", job.ID,
+		"rows", job.Rows,
+		"bytes", job.SizeBytes,
+		"duration", job.CompletedAt.Sub(*job.StartedAt))
+}
+
+// timeRange is a half-open chunk of a job's range; the last chunk also
+// includes its end
+type timeRange struct {
+	start, end time.Time
+	last       bool
+}
+
+// chunks splits a job's range by the configured chunk size, rounded up to
+// a multiple of the step so that no downsampling bucket spans two chunks.
+// Jobs without a range run as a single query.
+func (m *ExportJobManager) chunks(job *exportJob) []timeRange {
+	if job.rng == nil {
+		return []timeRange{{last: true}}
+	}
+	start, end := job.rng.Start, job.rng.End
+
+	size := m.cfg.ChunkSize
+	if step := job.rng.Step; step != 0 && size%step != 0 {
+		size = (size/step + 1) * step
+	}
+
+	var chunks []timeRange
+	for from := start; from.Before(end); from = from.Add(size) {
+		to := from.Add(size)
+		if !to.Before(end) {
+			to = end
+		}
+		chunks = append(chunks, timeRange{start: from, end: to, last: to.Equal(end)})
+	}
+	return chunks
+}
+
+// fetch queries the chunks in turn, adding each chunk's points to the spool
+// so only one chunk is held in memory. With a step, each chunk is
+// downsampled before it is spooled.
+func (m *ExportJobManager) fetch(ctx context.Context, job *exportJob, chunks []timeRange, spool *jobSpool) error {
+	for i, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var resp *models.MetricsResponse
+		var err error
+		if chunk.start.IsZero() {
+			resp, err = m.provider.Query(ctx, job.query)
+		} else {
+			// Submit only accepts ranges for a RangeQuerier
+			resp, err = m.provider.(providers.RangeQuerier).QueryRange(ctx, job.query,
+				models.TimeRange{Start: chunk.start, End: chunk.end, Step: job.rng.Step})
+			if errors.Is(err, providers.ErrNotSupported) {
+				return ErrJobRangeNotSupported
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("query failed for chunk %d of %d: %w", i+1, len(chunks), err)
+		}
+
+		if i == 0 {
+			spool.header = *resp
+			spool.header.Data = nil
+		}
+		points := resp.Data
+		if !chunk.start.IsZero() {
+			// Chunks share their boundaries; keep each point once
+			points = make([]models.MetricData, 0, len(resp.Data))
+			for _, d := range resp.Data {
+				if d.Timestamp.Before(chunk.start) || (!chunk.last && !d.Timestamp.Before(chunk.end)) {
+					continue
+				}
+				points = append(points, d)
+			}
+			if job.rng.Step != 0 {
+				points = downsample(points, job.rng.Start, job.rng.Step, job.rng.downsample)
+			}
+		}
+		for _, d := range points {
+			if err := spool.add(d); err != nil {
+				return err
+			}
+		}
+
+		m.mu.Lock()
+		job.ChunksDone = i + 1
+		job.Rows = spool.points
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// write exports the spooled points to the job's file, going through a
+// temporary file so partial exports are never served. Formats that need
+// every point at once load them from the spool, up to maxBufferedJobPoints.
+// Writing stops when ctx is done, as when the job is canceled.
+func (m *ExportJobManager) write(ctx context.Context, job *exportJob, spool *jobSpool) error {
+	path := filepath.Join(m.cfg.Dir, job.ID+"."+job.exporter.Extension())
+	f, err := os.CreateTemp(m.cfg.Dir, job.ID+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	opts := ExportOptions{Params: job.params, ExportedAt: m.now()}
+	if pe, ok := job.exporter.(pointsExporter); ok && pe.exportsPoints(job.params) {
+		err = pe.exportPoints(f, &spool.header, func(yield func(models.MetricData) error) error {
+			return spool.replay(func(d models.MetricData) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				return yield(d)
+			})
+		}, opts)
+	} else {
+		var response *models.MetricsResponse
+		if response, err = spool.load(); err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = job.exporter.Export(f, response, opts)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to store export: %w", err)
+	}
+
+	m.mu.Lock()
+	job.file = path
+	job.SizeBytes = info.Size()
+	m.mu.Unlock()
+	return nil
+}
+
+// jobSpool holds the points of a running job in a temporary file, gob
+// encoded, so they can be replayed without keeping them in memory
+type jobSpool struct {
+	file   *os.File
+	buf    *bufio.Writer
+	enc    *gob.Encoder
+	header models.MetricsResponse
+	points int
+}
+
+func newJobSpool(dir, id string) (*jobSpool, error) {
+	f, err := os.CreateTemp(dir, id+"-*.spool")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	buf := bufio.NewWriter(f)
+	return &jobSpool{file: f, buf: buf, enc: gob.NewEncoder(buf)}, nil
+}
+
+func (s *jobSpool) add(d models.MetricData) error {
+	if err := s.enc.Encode(d); err != nil {
+		return fmt.Errorf("failed to spool points: %w", err)
+	}
+	s.points++
+	return nil
+}
+
+// replay yields the spooled points in the order they were added
+func (s *jobSpool) replay(yield func(models.MetricData) error) error {
+	if err := s.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to spool points: %w", err)
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(bufio.NewReader(s.file))
+	for i := 0; i < s.points; i++ {
+		// Decoding into a used value would merge the labels
+		var d models.MetricData
+		if err := dec.Decode(&d); err != nil {
+			return fmt.Errorf("failed to read spooled points: %w", err)
+		}
+		if err := yield(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load returns the spooled points as one response
+func (s *jobSpool) load() (*models.MetricsResponse, error) {
+	if s.points > maxBufferedJobPoints {
+		return nil, fmt.Errorf("%d points exceed the %d this format can export; use csv or json",
+			s.points, maxBufferedJobPoints)
+	}
+	response := s.header
+	response.Data = make([]models.MetricData, 0, s.points)
+	err := s.replay(func(d models.MetricData) error {
+		response.Data = append(response.Data, d)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (s *jobSpool) close() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// snapshot copies the reported state; the caller holds the manager's mutex
+func (job *exportJob) snapshot() ExportJob {
+	s := job.ExportJob
+	if s.ChunksTotal > 0 {
+		s.Progress = float64(s.ChunksDone) / float64(s.ChunksTotal)
+	}
+	if s.State == JobSucceeded {
+		s.Progress = 1
+	}
+	return s
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ExportJobsHandler returns the API of the export job manager m, to be
+// mounted at /api/exports:
+//
+//	POST   /                create a job from an ExportJobRequest
+//	GET    /                list the caller's jobs
+//	GET    /{id}            job state and progress
+//	GET    /{id}/download   the finished export
+//	DELETE /{id}            cancel a job, or delete a finished one
+//
+// Jobs are owned by the Argo CD user from the request identity and are only
+// visible to that user; requests without one are answered with 401. Under
+// middleware.AuthorizeEach, jobs for graphs the user may not view are
+// refused with 403; routes without a policy must use
+// middleware.AllowAllGraphs, or every job is refused.
+func (s *Server) ExportJobsHandler(m *ExportJobManager) http.Handler {
+	r := chi.NewRouter()
+	r.Post("/", func(w http.ResponseWriter, r *http.Request) { s.handleCreateExportJob(w, r, m) })
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) { s.handleListExportJobs(w, r, m) })
+	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) { s.handleGetExportJob(w, r, m) })
+	r.Get("/{id}/download", func(w http.ResponseWriter, r *http.Request) { s.handleDownloadExportJob(w, r, m) })
+	r.Delete("/{id}", func(w http.ResponseWriter, r *http.Request) { s.handleDeleteExportJob(w, r, m) })
+	return r
+}
+
+// jobOwner returns the user a request acts for. Requests without an identity
+// are answered with 401, as they could not be told apart.
+func (s *Server) jobOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if identity, ok := middleware.IdentityFromContext(r.Context()); ok && identity.Username != "" {
+		return identity.Username, true
+	}
+	s.respondError(w, http.StatusUnauthorized, "unauthenticated", "export jobs require an Argo CD user")
+	return "", false
+}
+
+func (s *Server) handleCreateExportJob(w http.ResponseWriter, r *http.Request, m *ExportJobManager) {
+	owner, ok := s.jobOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req ExportJobRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxExportJobRequestBytes)).Decode(&req); err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid request body", err.Error())
+		return
+	}
+
+	// Prefer the identity verified by the Argo CD proxy middleware, as for
+	// synchronous exports
+	if identity, ok := middleware.IdentityFromContext(r.Context()); ok {
+		req.Application = identity.Application
+		req.Project = identity.Project
+	}
+
+	if !middleware.GraphAllowed(r.Context(), req.GroupKind, req.Graph) {
+		s.respondError(w, http.StatusForbidden, "forbidden", "not permitted to view this graph")
+		return
+	}
+
+	job, err := m.Submit(owner, req)
+	switch {
+	case errors.Is(err, ErrJobQuotaExceeded):
+		s.respondError(w, http.StatusTooManyRequests, "quota exceeded",
+			fmt.Sprintf("at most %d export jobs may be queued or running per user", m.cfg.MaxJobsPerUser))
+		return
+	case errors.Is(err, ErrJobQueueFull):
+		s.respondError(w, http.StatusServiceUnavailable, "queue full", err.Error())
+		return
+	case err != nil:
+		s.respondError(w, http.StatusBadRequest, "invalid export job", err.Error())
+		return
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+job.ID)
+	writeJobJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleListExportJobs(w http.ResponseWriter, r *http.Request, m *ExportJobManager) {
+	owner, ok := s.jobOwner(w, r)
+	if !ok {
+		return
+	}
+	writeJobJSON(w, http.StatusOK, map[string]interface{}{
+		"jobs": m.List(owner),
+	})
+}
+
+// ownedJob looks up the job named in the URL, answering 404 for jobs of
+// other users so their existence is not revealed
+func (s *Server) ownedJob(w http.ResponseWriter, r *http.Request, m *ExportJobManager) (ExportJob, bool) {
+	owner, ok := s.jobOwner(w, r)
+	if !ok {
+		return ExportJob{}, false
+	}
+	job, ok := m.Get(chi.URLParam(r, "id"))
+	if !ok || job.Owner != owner {
+		s.respondError(w, http.StatusNotFound, "not found", "export job not found")
+		return ExportJob{}, false
+	}
+	return job, true
+}
+
+func (s *Server) handleGetExportJob(w http.ResponseWriter, r *http.Request, m *ExportJobManager) {
+	job, ok := s.ownedJob(w, r, m)
+	if !ok {
+		return
+	}
+
+	resp := struct {
+		ExportJob
+		DownloadURL string `json:"download_url,omitempty"`
+	}{ExportJob: job}
+	if job.State == JobSucceeded {
+		resp.DownloadURL = strings.TrimSuffix(r.URL.Path, "/") + "/download"
+	}
+	writeJobJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleDownloadExportJob(w http.ResponseWriter, r *http.Request, m *ExportJobManager) {
+	job, ok := s.ownedJob(w, r, m)
+	if !ok {
+		return
+	}
+	if job.State != JobSucceeded {
+		s.respondError(w, http.StatusConflict, "export not available",
+			fmt.Sprintf("export job is %s", job.State))
+		return
+	}
+
+	m.mu.Lock()
+	var path string
+	var exporter Exporter
+	if j, ok := m.jobs[job.ID]; ok {
+		path, exporter = j.file, j.exporter
+	}
+	m.mu.Unlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		m.logger.Error("failed to open export file", "id", job.ID, "error", err)
+		s.respondError(w, http.StatusGone, "export expired", "export file is no longer available")
+		return
+	}
+	defer f.Close()
+
+	name := fmt.Sprintf("metrics_%s_%s.%s",
+		job.Application, job.CompletedAt.Format("20060102_150405"), exporter.Extension())
+	w.Header().Set("Content-Type", exporter.ContentType())
+	w.Header().Set("Content-Disposition", "attachment; filename="+name)
+	http.ServeContent(w, r, name, *job.CompletedAt, f)
+}
+
+func (s *Server) handleDeleteExportJob(w http.ResponseWriter, r *http.Request, m *ExportJobManager) {
+	job, ok := s.ownedJob(w, r, m)
+	if !ok {
+		return
+	}
+	m.Cancel(job.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJobJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
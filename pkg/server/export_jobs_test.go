@@ -0,0 +1,552 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/rbac"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/server/middleware"
+)
+
+// fakeRangeProvider serves one point per minute between from and to and
+// records the ranges it was asked for
+type fakeRangeProvider struct {
+	fakeProvider
+	from, to time.Time
+	block    chan struct{}
+
+	mu     sync.Mutex
+	ranges [][2]time.Time
+}
+
+func (p *fakeRangeProvider) QueryRange(ctx context.Context, query *models.MetricsQuery, r models.TimeRange) (*models.MetricsResponse, error) {
+	p.mu.Lock()
+	p.ranges = append(p.ranges, [2]time.Time{r.Start, r.End})
+	p.mu.Unlock()
+
+	if p.block != nil {
+		select {
+		case <-p.block:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	resp := &models.MetricsResponse{Application: query.Application, Project: query.Project, Graph: query.Graph}
+	for ts := p.from; !ts.After(p.to); ts = ts.Add(time.Minute) {
+		// Inclusive at both ends, like Prometheus range queries
+		if !r.Contains(ts) {
+			continue
+		}
+		resp.Data = append(resp.Data, models.MetricData{
+			Timestamp: ts,
+			Value:     float64(ts.Sub(p.from) / time.Minute),
+			Labels:    map[string]string{"pod": "web-1"},
+		})
+	}
+	return resp, nil
+}
+
+func newTestJobManager(t *testing.T, provider providers.MetricsQuerier, cfg ExportJobConfig) *ExportJobManager {
+	t.Helper()
+	cfg.Dir = t.TempDir()
+	m, err := NewExportJobManager(provider, cfg, testLogger)
+	if err != nil {
+		t.Fatalf("NewExportJobManager: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	m.Start(ctx)
+	return m
+}
+
+// jobHandler serves the job API of m on a route without an RBAC policy
+func jobHandler(m *ExportJobManager) http.Handler {
+	return middleware.AllowAllGraphs()((&Server{logger: testLogger}).ExportJobsHandler(m))
+}
+
+func waitForJob(t *testing.T, m *ExportJobManager, id string, state ExportJobState) ExportJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, ok := m.Get(id)
+		if ok && job.State == state {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	job, _ := m.Get(id)
+	t.Fatalf("job %s is %s, want %s (error %q)", id, job.State, state, job.Error)
+	return job
+}
+
+func jobRequest(t *testing.T, method, target, user string, body interface{}) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, target, &buf)
+	identity := &middleware.ArgoCDIdentity{Application: "test-app", Project: "default", Username: user}
+	return req.WithContext(middleware.WithIdentity(req.Context(), identity))
+}
+
+func TestExportJobs_Lifecycle(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider := &fakeRangeProvider{from: from, to: from.Add(3 * time.Hour)}
+	m := newTestJobManager(t, provider, ExportJobConfig{ChunkSize: time.Hour})
+	handler := chi.NewRouter()
+	handler.Mount("/api/exports", jobHandler(m))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, jobRequest(t, "POST", "/api/exports", "alice", ExportJobRequest{
+		Graph:  "request-rate",
+		Format: "csv",
+		Start:  from,
+		End:    from.Add(3 * time.Hour),
+	}))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("create: status %d: %s", rr.Code, rr.Body.String())
+	}
+	var created ExportJob
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if loc := rr.Header().Get("Location"); loc != "/api/exports/"+created.ID {
+		t.Errorf("Location = %q", loc)
+	}
+	if created.Application != "test-app" || created.Owner != "alice" {
+		t.Errorf("job = %+v, want identity application and owner", created)
+	}
+
+	job := waitForJob(t, m, created.ID, JobSucceeded)
+	if job.ChunksTotal != 3 || job.ChunksDone != 3 {
+		t.Errorf("chunks = %d/%d, want 3/3", job.ChunksDone, job.ChunksTotal)
+	}
+	// 3 hours of minutes, inclusive of the end, with chunk boundaries
+	// returned by two queries counted once
+	if job.Rows != 181 {
+		t.Errorf("rows = %d, want 181", job.Rows)
+	}
+	if len(provider.ranges) != 3 || !provider.ranges[1][0].Equal(from.Add(time.Hour)) {
+		t.Errorf("ranges = %v", provider.ranges)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, jobRequest(t, "GET", "/api/exports/"+job.ID, "alice", nil))
+	var status struct {
+		ExportJob
+		DownloadURL string `json:"download_url"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Progress != 1 || status.DownloadURL != "/api/exports/"+job.ID+"/download" {
+		t.Errorf("status = %+v", status)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, jobRequest(t, "GET", status.DownloadURL, "alice", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("download: status %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.HasSuffix(cd, ".csv") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 182 {
+		t.Errorf("got %d CSV lines, want header and 181 rows", len(lines))
+	}
+	if int64(rr.Body.Len()) != job.SizeBytes {
+		t.Errorf("size_bytes = %d, body is %d bytes", job.SizeBytes, rr.Body.Len())
+	}
+}
+
+func TestExportJobs_WithoutRangeQueries(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider := &fakeProvider{response: &models.MetricsResponse{
+		Application: "test-app",
+		Data:        []models.MetricData{{Timestamp: from.Add(time.Hour), Value: 1}},
+	}}
+	m := newTestJobManager(t, provider, ExportJobConfig{ChunkSize: time.Hour})
+
+	_, err := m.Submit("alice", ExportJobRequest{
+		Application: "test-app",
+		Project:     "default",
+		Format:      "json",
+		Start:       from,
+		End:         from.Add(48 * time.Hour),
+	})
+	if !errors.Is(err, ErrJobRangeNotSupported) {
+		t.Fatalf("Submit error = %v, want %v", err, ErrJobRangeNotSupported)
+	}
+
+	// The default window is still exported as a single query
+	job, err := m.Submit("alice", ExportJobRequest{
+		Application: "test-app",
+		Project:     "default",
+		Format:      "json",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = waitForJob(t, m, job.ID, JobSucceeded)
+	if job.ChunksTotal != 1 || job.Rows != 1 {
+		t.Errorf("chunks = %d, rows = %d, want a single query with one point", job.ChunksTotal, job.Rows)
+	}
+}
+
+func TestExportJobs_RoutedWithoutRangeQueries(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := newTestJobManager(t, &unsupportedProvider{fakeProvider{response: &models.MetricsResponse{}}}, ExportJobConfig{ChunkSize: time.Hour})
+
+	job, err := m.Submit("alice", ExportJobRequest{
+		Application: "test-app",
+		Project:     "default",
+		Start:       from,
+		End:         from.Add(48 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = waitForJob(t, m, job.ID, JobFailed)
+	if job.ChunksDone != 0 || !strings.Contains(job.Error, ErrJobRangeNotSupported.Error()) {
+		t.Errorf("chunks done = %d, error = %q", job.ChunksDone, job.Error)
+	}
+}
+
+func TestExportJobs_Validation(t *testing.T) {
+	m := newTestJobManager(t, &fakeProvider{}, ExportJobConfig{})
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		req  ExportJobRequest
+		want string
+	}{
+		{"unknown format", ExportJobRequest{Format: "pdf"}, "unsupported export format"},
+		{"invalid options", ExportJobRequest{Format: "csv", Params: map[string]string{"layout": "tall"}}, "layout"},
+		{"end only", ExportJobRequest{End: from}, "end requires start"},
+		{"invalid step", ExportJobRequest{Start: from, End: from.Add(time.Hour), Step: "soon"}, "invalid step"},
+		{"downsample without step", ExportJobRequest{Start: from, End: from.Add(time.Hour), Downsample: "max"}, "downsample requires step"},
+		{"reversed range", ExportJobRequest{Start: from, End: from.Add(-time.Hour)}, "before end"},
+		{"long range in a buffered format", ExportJobRequest{Format: "parquet", Start: from, End: from.Add(30 * 24 * time.Hour)}, "limited to 1w"},
+		{"long range in the wide layout", ExportJobRequest{Format: "csv", Params: map[string]string{"layout": "wide"}, Start: from, End: from.Add(8 * 24 * time.Hour)}, "limited to 1w"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			jobHandler(m).ServeHTTP(rr, jobRequest(t, "POST", "/", "alice", tt.req))
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want 400", rr.Code)
+			}
+			if !strings.Contains(rr.Body.String(), tt.want) {
+				t.Errorf("body %q does not mention %q", rr.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestExportJobs_Step(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider := &fakeRangeProvider{from: from, to: from.Add(3 * time.Hour)}
+	// Chunks are rounded up to whole steps, so buckets never span two
+	m := newTestJobManager(t, provider, ExportJobConfig{ChunkSize: 50 * time.Minute})
+	handler := chi.NewRouter()
+	handler.Mount("/api/exports", jobHandler(m))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, jobRequest(t, "POST", "/api/exports", "alice", ExportJobRequest{
+		Graph:      "request-rate",
+		Format:     "csv",
+		Start:      from,
+		Duration:   "3h",
+		Step:       "1h",
+		Downsample: "max",
+	}))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("create: status %d: %s", rr.Code, rr.Body.String())
+	}
+	var created ExportJob
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Step != "1h" || created.Downsample != "max" || !created.End.Equal(from.Add(3*time.Hour)) {
+		t.Errorf("job = %+v, want the range, step and downsample", created)
+	}
+
+	job := waitForJob(t, m, created.ID, JobSucceeded)
+	if job.ChunksTotal != 3 || len(provider.ranges) != 3 || !provider.ranges[1][0].Equal(from.Add(time.Hour)) {
+		t.Errorf("chunks = %d, ranges = %v, want hourly chunks", job.ChunksTotal, provider.ranges)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, jobRequest(t, "GET", "/api/exports/"+job.ID+"/download", "alice", nil))
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	// One point per hour, plus the point at the end of the range
+	want := []string{"2024-01-01T00:00:00Z,59", "2024-01-01T01:00:00Z,119", "2024-01-01T02:00:00Z,179", "2024-01-01T03:00:00Z,180"}
+	if len(lines) != len(want)+1 {
+		t.Fatalf("got %d CSV lines, want header and %d rows:\n%s", len(lines), len(want), rr.Body.String())
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(lines[i+1], prefix) {
+			t.Errorf("row %d = %q, want %q...", i+1, lines[i+1], prefix)
+		}
+	}
+
+	// A step bounds the points, so buffered formats take long ranges too
+	if _, err := m.Submit("alice", ExportJobRequest{
+		Application: "test-app",
+		Project:     "default",
+		Format:      "parquet",
+		Start:       from,
+		End:         from.Add(90 * 24 * time.Hour),
+		Step:        "1h",
+	}); err != nil {
+		t.Errorf("parquet over 90 days with a step: %v", err)
+	}
+}
+
+func TestExportJobs_Formats(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		format string
+		days   int
+	}{{"csv", 30}, {"json", 30}, {"parquet", 1}} {
+		provider := &fakeRangeProvider{from: from, to: from.Add(2 * time.Hour)}
+		m := newTestJobManager(t, provider, ExportJobConfig{ChunkSize: time.Hour})
+		job, err := m.Submit("alice", ExportJobRequest{
+			Application: "test-app",
+			Project:     "default",
+			Format:      tt.format,
+			Start:       from,
+			End:         from.Add(time.Duration(tt.days) * 24 * time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		job = waitForJob(t, m, job.ID, JobSucceeded)
+		if job.Rows != 121 {
+			t.Errorf("%s: rows = %d, want 121", tt.format, job.Rows)
+		}
+		entries, _ := os.ReadDir(m.cfg.Dir)
+		if len(entries) != 1 {
+			t.Errorf("%s: %d files left, want only the export", tt.format, len(entries))
+		}
+	}
+}
+
+func TestExportJobs_Quota(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider := &fakeRangeProvider{from: from, to: from, block: make(chan struct{})}
+	m := newTestJobManager(t, provider, ExportJobConfig{Workers: 1, MaxJobsPerUser: 2})
+	req := ExportJobRequest{Application: "test-app", Project: "default", Start: from, End: from.Add(time.Hour)}
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		job, err := m.Submit("alice", req)
+		if err != nil {
+			t.Fatalf("job %d: %v", i, err)
+		}
+		ids = append(ids, job.ID)
+	}
+
+	rr := httptest.NewRecorder()
+	jobHandler(m).ServeHTTP(rr, jobRequest(t, "POST", "/", "alice", req))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("third job: status %d, want 429", rr.Code)
+	}
+	if _, err := m.Submit("bob", req); err != nil {
+		t.Errorf("quota is per user: %v", err)
+	}
+
+	close(provider.block)
+	for _, id := range ids {
+		waitForJob(t, m, id, JobSucceeded)
+	}
+	if _, err := m.Submit("alice", req); err != nil {
+		t.Errorf("finished jobs count against the quota: %v", err)
+	}
+}
+
+func TestExportJobs_OwnerIsolation(t *testing.T) {
+	m := newTestJobManager(t, &fakeProvider{response: &models.MetricsResponse{}}, ExportJobConfig{})
+	job, err := m.Submit("alice", ExportJobRequest{Application: "test-app", Project: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, m, job.ID, JobSucceeded)
+
+	for _, path := range []string{"/" + job.ID, "/" + job.ID + "/download"} {
+		rr := httptest.NewRecorder()
+		jobHandler(m).ServeHTTP(rr, jobRequest(t, "GET", path, "bob", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("GET %s as another user: status %d, want 404", path, rr.Code)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	jobHandler(m).ServeHTTP(rr, jobRequest(t, "GET", "/", "bob", nil))
+	if strings.Contains(rr.Body.String(), job.ID) {
+		t.Errorf("job listed for another user: %s", rr.Body.String())
+	}
+}
+
+func TestExportJobs_Authorization(t *testing.T) {
+	m := newTestJobManager(t, &fakeProvider{response: &models.MetricsResponse{}}, ExportJobConfig{})
+	policy, err := rbac.ParsePolicy(strings.NewReader("p, alice, default/*, deployment/memory, allow\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := middleware.AuthorizeEach(rbac.NewEnforcer(policy, testLogger))((&Server{logger: testLogger}).ExportJobsHandler(m))
+
+	for _, method := range []string{"POST", "GET"} {
+		rr := httptest.NewRecorder()
+		jobHandler(m).ServeHTTP(rr, httptest.NewRequest(method, "/", strings.NewReader("{}")))
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s without an identity: status %d, want 401", method, rr.Code)
+		}
+	}
+	rr := httptest.NewRecorder()
+	jobHandler(m).ServeHTTP(rr, jobRequest(t, "POST", "/", "", ExportJobRequest{}))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("POST without a user: status %d, want 401", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, jobRequest(t, "POST", "/", "alice", ExportJobRequest{GroupKind: "deployment", Graph: "cpu"}))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("denied graph: status %d, want 403", rr.Code)
+	}
+	if jobs := m.List("alice"); len(jobs) != 0 {
+		t.Errorf("job queued for a denied graph: %+v", jobs)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, jobRequest(t, "POST", "/", "alice", ExportJobRequest{GroupKind: "deployment", Graph: "memory"}))
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("permitted graph: status %d, want 202: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestExportJobs_Cancel(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider := &fakeRangeProvider{from: from, to: from, block: make(chan struct{})}
+	m := newTestJobManager(t, provider, ExportJobConfig{Workers: 1})
+
+	job, err := m.Submit("alice", ExportJobRequest{Application: "test-app", Project: "default", Start: from, End: from.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, m, job.ID, JobRunning)
+
+	rr := httptest.NewRecorder()
+	jobHandler(m).ServeHTTP(rr, jobRequest(t, "GET", "/"+job.ID+"/download", "alice", nil))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("download while running: status %d, want 409", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	jobHandler(m).ServeHTTP(rr, jobRequest(t, "DELETE", "/"+job.ID, "alice", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("cancel: status %d", rr.Code)
+	}
+	if got, _ := m.Get(job.ID); got.State != JobCanceled {
+		t.Errorf("state = %s, want canceled", got.State)
+	}
+
+	// Deleting a finished job removes it
+	m.Cancel(job.ID)
+	if _, ok := m.Get(job.ID); ok {
+		t.Error("job still present after delete")
+	}
+}
+
+func TestExportJobs_WriteCanceled(t *testing.T) {
+	m := newTestJobManager(t, &fakeProvider{}, ExportJobConfig{})
+	spool, err := newJobSpool(m.cfg.Dir, "canceled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.close()
+	for i := 0; i < 10; i++ {
+		if err := spool.add(models.MetricData{Value: float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, format := range []string{"csv", "xlsx"} {
+		exporter, _ := exporters.Lookup(format)
+		job := &exportJob{ExportJob: ExportJob{ID: "canceled-" + format}, exporter: exporter}
+		if err := m.write(ctx, job, spool); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: write error = %v, want %v", format, err, context.Canceled)
+		}
+		if job.file != "" {
+			t.Errorf("%s: export stored after cancellation", format)
+		}
+	}
+}
+
+func TestExportJobs_Cleanup(t *testing.T) {
+	m := newTestJobManager(t, &fakeProvider{response: &models.MetricsResponse{}}, ExportJobConfig{Retention: time.Hour})
+	job, err := m.Submit("alice", ExportJobRequest{Application: "test-app", Project: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, m, job.ID, JobSucceeded)
+
+	// Files left by a previous process, and files that are not ours
+	orphan := filepath.Join(m.cfg.Dir, "0123456789abcdef0123456789abcdef.csv")
+	spool := filepath.Join(m.cfg.Dir, "0123456789abcdef0123456789abcdef-42.spool")
+	foreign := filepath.Join(m.cfg.Dir, "report.csv")
+	for _, name := range []string{orphan, spool, foreign} {
+		if err := os.WriteFile(name, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := m.Cleanup(); n != 0 {
+		t.Errorf("removed %d files before expiry", n)
+	}
+
+	// Expire the job and age the other files past the retention period
+	past := time.Now().Add(-2 * time.Hour)
+	m.mu.Lock()
+	m.jobs[job.ID].ExpiresAt = &past
+	m.mu.Unlock()
+	for _, name := range []string{orphan, spool, foreign} {
+		if err := os.Chtimes(name, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := m.Cleanup(); n != 3 {
+		t.Errorf("removed %d files, want the job's, the orphan and the spool", n)
+	}
+	if _, ok := m.Get(job.ID); ok {
+		t.Error("expired job still present")
+	}
+	entries, _ := os.ReadDir(m.cfg.Dir)
+	if len(entries) != 1 || entries[0].Name() != "report.csv" {
+		t.Errorf("files left in the export directory: %v, want only report.csv", entries)
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+func init() {
+	RegisterExporter(openMetricsExporter{})
+}
+
+// openMetricsExporter writes the data as OpenMetrics text with explicit
+// timestamps, suitable for `promtool tsdb create-blocks-from openmetrics`
+type openMetricsExporter struct{}
+
+func (openMetricsExporter) Name() string { return "openmetrics" }
+func (openMetricsExporter) ContentType() string {
+	return "application/openmetrics-text; version=1.0.0; charset=utf-8"
+}
+func (openMetricsExporter) Extension() string { return "txt" }
+
+func (openMetricsExporter) Export(w io.Writer, response *models.MetricsResponse, opts ExportOptions) error {
+	for _, family := range openMetricsFamilies(response) {
+		if _, err := expfmt.MetricFamilyToOpenMetrics(w, family); err != nil {
+			return err
+		}
+	}
+	_, err := expfmt.FinalizeOpenMetrics(w)
+	return err
+}
+
+// openMetricsFamilies groups the data points into one untyped family per
+// metric name and one series per label set. Families and series are sorted
+// and samples within a series are in timestamp order, as backfilling
+// requires; of several samples with the same timestamp the last one wins.
+func openMetricsFamilies(response *models.MetricsResponse) []*dto.MetricFamily {
+	fallback := openMetricsName(response.Graph)
+	if fallback == "" {
+		fallback = "metric"
+	}
+
+	type series struct {
+		key     string
+		labels  []*dto.LabelPair
+		samples []models.MetricData
+	}
+	families := make(map[string]map[string]*series)
+
+	for _, d := range response.Data {
+		name := openMetricsName(d.Labels[model.MetricNameLabel])
+		if name == "" {
+			name = fallback
+		}
+
+		labels := openMetricsLabels(d.Labels)
+		key := labelPairsKey(labels)
+
+		if families[name] == nil {
+			families[name] = make(map[string]*series)
+		}
+		sr, ok := families[name][key]
+		if !ok {
+			sr = &series{key: key, labels: labels}
+			families[name][key] = sr
+		}
+		sr.samples = append(sr.samples, d)
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		ordered := make([]*series, 0, len(families[name]))
+		for _, sr := range families[name] {
+			ordered = append(ordered, sr)
+		}
+		sort.Slice(ordered, func(i, j int) bool {
+			return ordered[i].key < ordered[j].key
+		})
+
+		family := &dto.MetricFamily{
+			Name: stringPtr(name),
+			Type: dto.MetricType_UNTYPED.Enum(),
+		}
+		for _, sr := range ordered {
+			sort.SliceStable(sr.samples, func(i, j int) bool {
+				return sr.samples[i].Timestamp.Before(sr.samples[j].Timestamp)
+			})
+			for i, sample := range sr.samples {
+				ts := sample.Timestamp.UnixMilli()
+				if i+1 < len(sr.samples) && sr.samples[i+1].Timestamp.UnixMilli() == ts {
+					continue
+				}
+				family.Metric = append(family.Metric, &dto.Metric{
+					Label:       sr.labels,
+					Untyped:     &dto.Untyped{Value: float64Ptr(sample.Value)},
+					TimestampMs: &ts,
+				})
+			}
+		}
+		out = append(out, family)
+	}
+	return out
+}
+
+// openMetricsLabels converts labels other than the metric name to sorted
+// label pairs, rewriting names that are not valid Prometheus label names
+func openMetricsLabels(labels map[string]string) []*dto.LabelPair {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		if key != model.MetricNameLabel {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	used := make(map[string]bool)
+	pairs := make([]*dto.LabelPair, 0, len(keys))
+	for _, key := range keys {
+		name := key
+		if !model.LabelName(name).IsValid() {
+			name = sanitizeMetricName(name, false)
+		}
+		// Reserved and colliding names are kept under a distinct name
+		for strings.HasPrefix(name, "__") || used[name] {
+			name = "label_" + strings.TrimLeft(name, "_")
+		}
+		used[name] = true
+		pairs = append(pairs, &dto.LabelPair{Name: stringPtr(name), Value: stringPtr(labels[key])})
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].GetName() < pairs[j].GetName()
+	})
+	return pairs
+}
+
+// openMetricsName returns name as a valid metric name, or "" if it is empty
+func openMetricsName(name string) string {
+	if name == "" || model.IsValidMetricName(model.LabelValue(name)) {
+		return name
+	}
+	return sanitizeMetricName(name, true)
+}
+
+// sanitizeMetricName replaces characters that are not allowed in metric
+// names (or label names, which also exclude ':') with underscores
+func sanitizeMetricName(name string, allowColon bool) string {
+	var b strings.Builder
+	for i, r := range name {
+		valid := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(r == ':' && allowColon) || (r >= '0' && r <= '9' && i > 0)
+		if !valid {
+			if r >= '0' && r <= '9' {
+				b.WriteRune('_')
+				b.WriteRune(r)
+				continue
+			}
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// labelPairsKey identifies a series by its label pairs
+func labelPairsKey(pairs []*dto.LabelPair) string {
+	var b strings.Builder
+	for _, p := range pairs {
+		b.WriteString(p.GetName())
+		b.WriteByte(0)
+		b.WriteString(p.GetValue())
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func stringPtr(s string) *string    { return &s }
+func float64Ptr(f float64) *float64 { return &f }
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+func TestOpenMetricsExporter(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	response := &models.MetricsResponse{
+		Application: "test-app",
+		Graph:       "request-rate",
+		Data: []models.MetricData{
+			// Out of order and duplicated timestamps within a series
+			{Timestamp: t0.Add(time.Minute), Value: 2, Labels: map[string]string{"__name__": "http_requests_total", "pod": "pod-1"}},
+			{Timestamp: t0, Value: 1, Labels: map[string]string{"__name__": "http_requests_total", "pod": "pod-1"}},
+			{Timestamp: t0.Add(time.Minute), Value: 3, Labels: map[string]string{"__name__": "http_requests_total", "pod": "pod-1"}},
+			{Timestamp: t0.Add(1500 * time.Millisecond), Value: math.NaN(), Labels: map[string]string{"__name__": "http_requests_total", "pod": "pod-0"}},
+			// No metric name: falls back to the graph name
+			{Timestamp: t0, Value: 0.25, Labels: map[string]string{"path": "/a\"b\\c", "pod.name": "x", "__meta": "y"}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := (openMetricsExporter{}).Export(&buf, response, ExportOptions{}); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	expected := `# TYPE http_requests_total unknown
+http_requests_total{pod="pod-0"} NaN 1.7041104015e+09
+http_requests_total{pod="pod-1"} 1.0 1.7041104e+09
+http_requests_total{pod="pod-1"} 3.0 1.70411046e+09
+# TYPE request_rate unknown
+request_rate{label_meta="y",path="/a\"b\\c",pod_name="x"} 0.25 1.7041104e+09
+# EOF
+`
+	if buf.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestOpenMetricsExporter_Headers(t *testing.T) {
+	srv := &Server{
+		logger: testLogger,
+	}
+
+	rr := httptest.NewRecorder()
+	srv.export(rr, openMetricsExporter{}, &models.MetricsResponse{Graph: "cpu"}, nil)
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/openmetrics-text; version=1.0.0; charset=utf-8" {
+		t.Errorf("Unexpected Content-Type %s", contentType)
+	}
+	if rr.Body.String() != "# EOF\n" {
+		t.Errorf("Expected only the EOF marker for empty data, got %q", rr.Body.String())
+	}
+}
+
+func TestSanitizeMetricName(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowColon bool
+		expected   string
+	}{
+		{"request-rate", true, "request_rate"},
+		{"job:rate5m", true, "job:rate5m"},
+		{"job:rate5m", false, "job_rate5m"},
+		{"5xx.errors", true, "_5xx_errors"},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeMetricName(tt.name, tt.allowColon); got != tt.expected {
+			t.Errorf("sanitizeMetricName(%q, %v) = %q, expected %q", tt.name, tt.allowColon, got, tt.expected)
+		}
+	}
+}
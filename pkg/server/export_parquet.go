@@ -0,0 +1,76 @@
+package server
+
+import (
+	"io"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+// parquetRowGroupSize is the default number of rows per row group
+const parquetRowGroupSize = 128 * 1024
+
+func init() {
+	RegisterExporter(parquetExporter{})
+}
+
+// parquetExporter writes a Snappy-compressed Parquet file with the Arrow
+// export's columns, label columns dictionary-encoded. Application, project
+// and graph are recorded in the file's key/value metadata.
+type parquetExporter struct {
+	// rowGroupSize is the number of rows per row group; zero means
+	// parquetRowGroupSize
+	rowGroupSize int
+}
+
+func (parquetExporter) Name() string        { return "parquet" }
+func (parquetExporter) ContentType() string { return "application/vnd.apache.parquet" }
+func (parquetExporter) Extension() string   { return "parquet" }
+
+func (e parquetExporter) Export(w io.Writer, response *models.MetricsResponse, opts ExportOptions) error {
+	perGroup := e.rowGroupSize
+	if perGroup <= 0 {
+		perGroup = parquetRowGroupSize
+	}
+
+	keys := labelColumns(response.Data, nil)
+	schema := arrowSchema(response, keys, opts.ExportedAt)
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+
+	var records []arrow.Record
+	defer func() {
+		for _, rec := range records {
+			rec.Release()
+		}
+	}()
+	for n, data := range response.Data {
+		appendArrowRow(b, keys, data)
+		if (n+1)%perGroup == 0 || n == len(response.Data)-1 {
+			records = append(records, b.NewRecord())
+		}
+	}
+
+	table := array.NewTableFromRecords(schema, records)
+	defer table.Release()
+
+	options := []parquet.WriterProperty{
+		parquet.WithCompression(compress.Codecs.Snappy),
+		parquet.WithDictionaryDefault(false),
+	}
+	for _, field := range schema.Fields()[2:] {
+		options = append(options, parquet.WithDictionaryFor(field.Name, true))
+	}
+	props := parquet.NewWriterProperties(options...)
+
+	// WriteTable closes a writer that is an io.Closer, such as a job's file
+	w = struct{ io.Writer }{w}
+	return pqarrow.WriteTable(table, w, int64(perGroup), props, pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+}
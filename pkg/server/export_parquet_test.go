@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/file"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+func TestExportParquet_RoundTrip(t *testing.T) {
+	srv := &Server{
+		logger: testLogger,
+	}
+
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	response := &models.MetricsResponse{
+		Application: "test-app",
+		Project:     "test-project",
+		Graph:       "request-rate",
+		Data: []models.MetricData{
+			{Timestamp: t0, Value: 100.5, Labels: map[string]string{"instance": "pod-1"}},
+			{Timestamp: t0.Add(time.Minute), Value: 150.25, Labels: map[string]string{"instance": "pod-2", "status": "500"}},
+			{Timestamp: t0.Add(2 * time.Minute), Value: 0, Labels: map[string]string{"value": "shadowed"}},
+		},
+	}
+
+	rr := httptest.NewRecorder()
+	srv.export(rr, parquetExporter{}, response, nil)
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/vnd.apache.parquet" {
+		t.Errorf("Expected Content-Type application/vnd.apache.parquet, got %s", contentType)
+	}
+
+	rdr, err := file.NewParquetReader(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read Parquet export: %v", err)
+	}
+	defer rdr.Close()
+
+	// Verify schema metadata
+	for key, expected := range map[string]string{
+		"application": "test-app",
+		"project":     "test-project",
+		"graph":       "request-rate",
+	} {
+		if v := rdr.MetaData().KeyValueMetadata().FindValue(key); v == nil || *v != expected {
+			t.Errorf("Expected metadata %s=%s, got %v", key, expected, v)
+		}
+	}
+
+	fr, err := pqarrow.NewFileReader(rdr, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatalf("Failed to create arrow reader: %v", err)
+	}
+	table, err := fr.ReadTable(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to read table: %v", err)
+	}
+	defer table.Release()
+
+	// Verify columns
+	names := make([]string, table.Schema().NumFields())
+	for i, field := range table.Schema().Fields() {
+		names[i] = field.Name
+	}
+	expectedNames := []string{"timestamp", "value", "instance", "status", "label_value"}
+	if len(names) != len(expectedNames) {
+		t.Fatalf("Expected columns %v, got %v", expectedNames, names)
+	}
+	for i := range names {
+		if names[i] != expectedNames[i] {
+			t.Fatalf("Expected columns %v, got %v", expectedNames, names)
+		}
+	}
+
+	// Verify data
+	if table.NumRows() != 3 {
+		t.Fatalf("Expected 3 rows, got %d", table.NumRows())
+	}
+	timestamps := table.Column(0).Data().Chunk(0).(*array.Timestamp)
+	values := table.Column(1).Data().Chunk(0).(*array.Float64)
+	for i, data := range response.Data {
+		if ts := timestamps.Value(i).ToTime(arrow.Millisecond); !ts.Equal(data.Timestamp) {
+			t.Errorf("Row %d: expected timestamp %v, got %v", i, data.Timestamp, ts)
+		}
+		if v := values.Value(i); v != data.Value {
+			t.Errorf("Row %d: expected value %v, got %v", i, data.Value, v)
+		}
+	}
+	instance := table.Column(2).Data().Chunk(0).(*array.String)
+	status := table.Column(3).Data().Chunk(0).(*array.String)
+	if instance.Value(0) != "pod-1" || !status.IsNull(0) || status.Value(1) != "500" {
+		t.Errorf("Unexpected label values: %v %v", instance, status)
+	}
+	if renamed := table.Column(4).Data().Chunk(0).(*array.String); renamed.Value(2) != "shadowed" {
+		t.Errorf("Expected renamed label column, got %v", renamed)
+	}
+}
+
+func TestExportParquet_RowGroups(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	response := &models.MetricsResponse{Application: "test-app"}
+	for i := 0; i < 50; i++ {
+		response.Data = append(response.Data, models.MetricData{
+			Timestamp: t0.Add(time.Duration(i) * 15 * time.Second),
+			Value:     float64(i),
+			Labels:    map[string]string{"pod": []string{"pod-1", "pod-2"}[i%2]},
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := (parquetExporter{rowGroupSize: 20}).Export(&buf, response, ExportOptions{ExportedAt: t0}); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	rdr, err := file.NewParquetReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read Parquet export: %v", err)
+	}
+	defer rdr.Close()
+
+	if n := rdr.NumRowGroups(); n != 3 {
+		t.Fatalf("Expected 3 row groups, got %d", n)
+	}
+	if rows := rdr.NumRows(); rows != 50 {
+		t.Errorf("Expected 50 rows, got %d", rows)
+	}
+	for i := 0; i < rdr.NumRowGroups(); i++ {
+		rg := rdr.MetaData().RowGroup(i)
+		for c := 0; c < rg.NumColumns(); c++ {
+			col, err := rg.ColumnChunk(c)
+			if err != nil {
+				t.Fatalf("Failed to read column chunk: %v", err)
+			}
+			if col.Compression() != compress.Codecs.Snappy {
+				t.Errorf("Row group %d column %d: expected Snappy, got %v", i, c, col.Compression())
+			}
+		}
+		pod, err := rg.ColumnChunk(2)
+		if err != nil {
+			t.Fatalf("Failed to read column chunk: %v", err)
+		}
+		if !pod.HasDictionaryPage() {
+			t.Errorf("Row group %d: expected a dictionary-encoded label column", i)
+		}
+	}
+}
+
+func TestExportParquet_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	response := &models.MetricsResponse{Application: "test-app"}
+	if err := (parquetExporter{}).Export(&buf, response, ExportOptions{}); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	rdr, err := file.NewParquetReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read Parquet export: %v", err)
+	}
+	defer rdr.Close()
+	if rows := rdr.NumRows(); rows != 0 {
+		t.Errorf("Expected no rows, got %d", rows)
+	}
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+// maxExportPoints bounds the points per series a step may produce, as
+// Prometheus does for range queries. Longer exports should use export jobs.
+const maxExportPoints = 11000
+
+// Downsampling aggregations for the downsample parameter
+const (
+	downsampleAvg  = "avg"
+	downsampleMax  = "max"
+	downsampleMin  = "min"
+	downsampleLast = "last"
+)
+
+// exportRange is the time window, resolution and aggregation of an export
+type exportRange struct {
+	models.TimeRange
+	downsample string
+}
+
+// exportRangeFromQuery reads the start, end, duration, step and downsample
+// parameters of a synchronous export, which may produce at most
+// maxExportPoints per series. It returns nil without a range, leaving the
+// graph's default window.
+func exportRangeFromQuery(params url.Values, now time.Time) (*exportRange, error) {
+	r, err := parseExportRange(params, now)
+	if err != nil || r == nil {
+		return r, err
+	}
+	if r.Step != 0 && r.End.Sub(r.Start)/r.Step > maxExportPoints {
+		return nil, fmt.Errorf("step is too small: at most %d points per series may be exported, use an export job for finer data", maxExportPoints)
+	}
+	return r, nil
+}
+
+// parseExportRange reads the start, end, duration, step and downsample
+// parameters. It returns nil without a range. Times are RFC 3339 or Unix
+// seconds; durations are Prometheus durations (30s, 6h, 7d) or seconds.
+//
+// duration extends back from end, forward from start, or back from now.
+func parseExportRange(params url.Values, now time.Time) (*exportRange, error) {
+	var r exportRange
+	var err error
+
+	start, end, duration := params.Get("start"), params.Get("end"), params.Get("duration")
+	if start != "" {
+		if r.Start, err = parseExportTime(start); err != nil {
+			return nil, fmt.Errorf("invalid start: %w", err)
+		}
+	}
+	if end != "" {
+		if r.End, err = parseExportTime(end); err != nil {
+			return nil, fmt.Errorf("invalid end: %w", err)
+		}
+	}
+	if step := params.Get("step"); step != "" {
+		if r.Step, err = parseExportDuration(step); err != nil {
+			return nil, fmt.Errorf("invalid step: %w", err)
+		}
+	}
+	r.downsample = params.Get("downsample")
+
+	if duration != "" {
+		d, err := parseExportDuration(duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration: %w", err)
+		}
+		switch {
+		case start != "" && end != "":
+			return nil, errors.New("duration cannot be combined with both start and end")
+		case start != "":
+			r.End = r.Start.Add(d)
+		case end != "":
+			r.Start = r.End.Add(-d)
+		default:
+			r.End = now
+			r.Start = now.Add(-d)
+		}
+	} else {
+		switch {
+		case start == "" && end == "":
+			if r.Step != 0 || r.downsample != "" {
+				return nil, errors.New("step and downsample require start or duration")
+			}
+			return nil, nil
+		case start == "":
+			return nil, errors.New("end requires start or duration")
+		case end == "":
+			r.End = now
+		}
+	}
+
+	if !r.Start.Before(r.End) {
+		return nil, errors.New("start must be before end")
+	}
+
+	switch r.downsample {
+	case "":
+		if r.Step != 0 {
+			r.downsample = downsampleAvg
+		}
+	case downsampleAvg, downsampleMax, downsampleMin, downsampleLast:
+		if r.Step == 0 {
+			return nil, errors.New("downsample requires step")
+		}
+	default:
+		return nil, fmt.Errorf("downsample must be one of %s, %s, %s or %s",
+			downsampleAvg, downsampleMax, downsampleMin, downsampleLast)
+	}
+	return &r, nil
+}
+
+// parseExportTime parses RFC 3339 or (fractional) Unix seconds
+func parseExportTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		if math.IsNaN(secs) || math.IsInf(secs, 0) {
+			return time.Time{}, fmt.Errorf("%q is not a valid time", s)
+		}
+		sec, frac := math.Modf(secs)
+		return time.Unix(int64(sec), int64(math.Round(frac*1e9))).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not RFC 3339 or Unix seconds", s)
+	}
+	return t.UTC(), nil
+}
+
+// parseExportDuration parses a positive Prometheus duration or seconds
+func parseExportDuration(s string) (time.Duration, error) {
+	var d time.Duration
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		if math.IsNaN(secs) || math.IsInf(secs, 0) || secs > math.MaxInt64/float64(time.Second) {
+			return 0, fmt.Errorf("%q is out of range", s)
+		}
+		d = time.Duration(secs * float64(time.Second))
+	} else {
+		md, err := model.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a duration", s)
+		}
+		d = time.Duration(md)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%q must be positive", s)
+	}
+	return d, nil
+}
+
+// queryRange runs query over r. Providers that cannot query ranges, or
+// route the query to one that cannot, are queried for their default window
+// and the points outside r dropped.
+func queryRange(ctx context.Context, provider providers.MetricsQuerier, query *models.MetricsQuery, r models.TimeRange) (*models.MetricsResponse, error) {
+	if rq, ok := provider.(providers.RangeQuerier); ok {
+		response, err := rq.QueryRange(ctx, query, r)
+		if !errors.Is(err, providers.ErrNotSupported) {
+			return response, err
+		}
+	}
+
+	response, err := provider.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	filtered := *response
+	filtered.Data = make([]models.MetricData, 0, len(response.Data))
+	for _, d := range response.Data {
+		if r.Contains(d.Timestamp) {
+			filtered.Data = append(filtered.Data, d)
+		}
+	}
+	return &filtered, nil
+}
+
+// queryExport runs an export query over the requested range, downsampling
+// the result to the requested step
+func queryExport(ctx context.Context, provider providers.MetricsQuerier, query *models.MetricsQuery, r *exportRange) (*models.MetricsResponse, error) {
+	if r == nil {
+		return provider.Query(ctx, query)
+	}
+	response, err := queryRange(ctx, provider, query, r.TimeRange)
+	if err != nil || r.Step == 0 {
+		return response, err
+	}
+
+	downsampled := *response
+	downsampled.Data = downsample(response.Data, r.Start, r.Step, r.downsample)
+	return &downsampled, nil
+}
+
+// downsample aggregates each series into buckets of step aligned to origin,
+// each point stamped with the start of its bucket. Series keep the order in
+// which they first appear; buckets holding a single point are unchanged
+// apart from their timestamp. NaN values are ignored by avg, min and max
+// unless a bucket holds nothing else.
+func downsample(data []models.MetricData, origin time.Time, step time.Duration, fn string) []models.MetricData {
+	type bucket struct {
+		ts     time.Time
+		labels map[string]string
+		values []float64
+		// latest is the most recent point, for downsample=last
+		latest models.MetricData
+	}
+	type series struct {
+		buckets map[int64]*bucket
+		order   []int64
+	}
+
+	byKey := make(map[string]*series)
+	var keys []string
+	for _, d := range data {
+		key := formatLabels(d.Labels)
+		sr, ok := byKey[key]
+		if !ok {
+			sr = &series{buckets: make(map[int64]*bucket)}
+			byKey[key] = sr
+			keys = append(keys, key)
+		}
+
+		offset := d.Timestamp.Sub(origin)
+		n := int64(offset / step)
+		if offset < 0 && offset%step != 0 {
+			n--
+		}
+		b, ok := sr.buckets[n]
+		if !ok {
+			b = &bucket{ts: origin.Add(time.Duration(n) * step), labels: d.Labels}
+			sr.buckets[n] = b
+			sr.order = append(sr.order, n)
+		}
+		b.values = append(b.values, d.Value)
+		if len(b.values) == 1 || !d.Timestamp.Before(b.latest.Timestamp) {
+			b.latest = d
+		}
+	}
+
+	out := make([]models.MetricData, 0, len(data))
+	for _, key := range keys {
+		sr := byKey[key]
+		sort.Slice(sr.order, func(i, j int) bool { return sr.order[i] < sr.order[j] })
+		for _, n := range sr.order {
+			b := sr.buckets[n]
+			value := b.latest.Value
+			if fn != downsampleLast {
+				value = aggregate(b.values, fn)
+			}
+			out = append(out, models.MetricData{
+				Timestamp: b.ts,
+				Value:     value,
+				Labels:    b.labels,
+			})
+		}
+	}
+	return out
+}
+
+// aggregate computes avg, min or max over values
+func aggregate(values []float64, fn string) float64 {
+	result, n := math.NaN(), 0
+	for _, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		switch {
+		case n == 0:
+			result = v
+		case fn == downsampleMax:
+			result = math.Max(result, v)
+		case fn == downsampleMin:
+			result = math.Min(result, v)
+		default:
+			result += v
+		}
+		n++
+	}
+	if fn == downsampleAvg && n > 0 {
+		result /= float64(n)
+	}
+	return result
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+func TestExportRangeFromQuery(t *testing.T) {
+	now := time.Date(2024, 1, 8, 12, 0, 0, 0, time.UTC)
+	jan1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    *exportRange
+		wantErr string
+	}{
+		{name: "no range", query: "", want: nil},
+		{
+			name:  "start and end",
+			query: "start=2024-01-01T00:00:00Z&end=1704153600",
+			want:  &exportRange{TimeRange: models.TimeRange{Start: jan1, End: jan1.Add(24 * time.Hour)}},
+		},
+		{
+			name:  "start only ends now",
+			query: "start=2024-01-01T00:00:00Z",
+			want:  &exportRange{TimeRange: models.TimeRange{Start: jan1, End: now}},
+		},
+		{
+			name:  "duration back from now",
+			query: "duration=7d&step=1h",
+			want: &exportRange{
+				TimeRange:  models.TimeRange{Start: now.AddDate(0, 0, -7), End: now, Step: time.Hour},
+				downsample: downsampleAvg,
+			},
+		},
+		{
+			name:  "duration forward from start",
+			query: "start=2024-01-01T00:00:00Z&duration=6h&step=300&downsample=max",
+			want: &exportRange{
+				TimeRange:  models.TimeRange{Start: jan1, End: jan1.Add(6 * time.Hour), Step: 5 * time.Minute},
+				downsample: downsampleMax,
+			},
+		},
+		{
+			name:  "duration back from end",
+			query: "end=2024-01-01T06:00:00Z&duration=6h",
+			want:  &exportRange{TimeRange: models.TimeRange{Start: jan1, End: jan1.Add(6 * time.Hour)}},
+		},
+		{name: "invalid start", query: "start=yesterday", wantErr: "invalid start"},
+		{name: "invalid step", query: "duration=1h&step=-5s", wantErr: "invalid step"},
+		{name: "end only", query: "end=2024-01-01T00:00:00Z", wantErr: "end requires start"},
+		{name: "overdetermined", query: "start=1&end=2&duration=1h", wantErr: "duration cannot be combined"},
+		{name: "reversed", query: "start=2024-01-02T00:00:00Z&end=2024-01-01T00:00:00Z", wantErr: "before end"},
+		{name: "step without range", query: "step=1m", wantErr: "require start or duration"},
+		{name: "downsample without step", query: "duration=1h&downsample=max", wantErr: "requires step"},
+		{name: "unknown downsample", query: "duration=1h&step=1m&downsample=median", wantErr: "downsample must be"},
+		{name: "too many points", query: "duration=90d&step=15s", wantErr: "step is too small"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, _ := url.ParseQuery(tt.query)
+			got, err := exportRangeFromQuery(params, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && (!got.Start.Equal(tt.want.Start) ||
+				!got.End.Equal(tt.want.End) || got.Step != tt.want.Step || got.downsample != tt.want.downsample)) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	origin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return origin.Add(time.Duration(sec) * time.Second) }
+	a := map[string]string{"pod": "a"}
+	b := map[string]string{"pod": "b"}
+
+	// 15s data for two series, the last point of a out of order
+	data := []models.MetricData{
+		{Timestamp: at(0), Value: 1, Labels: a},
+		{Timestamp: at(0), Value: 10, Labels: b},
+		{Timestamp: at(15), Value: 3, Labels: a},
+		{Timestamp: at(30), Value: math.NaN(), Labels: a},
+		{Timestamp: at(75), Value: 6, Labels: a},
+		{Timestamp: at(60), Value: 4, Labels: a},
+	}
+
+	tests := []struct {
+		fn   string
+		want []float64
+	}{
+		{downsampleAvg, []float64{2, 5, 10}},
+		{downsampleMax, []float64{3, 6, 10}},
+		{downsampleMin, []float64{1, 4, 10}},
+		{downsampleLast, []float64{math.NaN(), 6, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fn, func(t *testing.T) {
+			got := downsample(data, origin, time.Minute, tt.fn)
+			if len(got) != 3 {
+				t.Fatalf("got %d points, want 3: %+v", len(got), got)
+			}
+			wantTimes := []time.Time{at(0), at(60), at(0)}
+			for i, d := range got {
+				same := d.Value == tt.want[i] || (math.IsNaN(d.Value) && math.IsNaN(tt.want[i]))
+				if !same || !d.Timestamp.Equal(wantTimes[i]) {
+					t.Errorf("point %d = %v at %s, want %v at %s", i, d.Value, d.Timestamp, tt.want[i], wantTimes[i])
+				}
+			}
+			if got[2].Labels["pod"] != "b" {
+				t.Errorf("series order changed: %+v", got)
+			}
+		})
+	}
+}
+
+func TestHandleExportMetrics_TimeRange(t *testing.T) {
+	origin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var data []models.MetricData
+	for i := 0; i < 8; i++ {
+		data = append(data, models.MetricData{
+			Timestamp: origin.Add(time.Duration(i) * 15 * time.Second),
+			Value:     float64(i),
+		})
+	}
+	srv := &Server{
+		logger:   testLogger,
+		provider: &fakeProvider{response: &models.MetricsResponse{Application: "test-app", Data: data}},
+	}
+
+	// Without range queries the provider's points are filtered to the range
+	// before downsampling to 30s
+	target := "/export?application_name=test-app&project=default&format=json" +
+		"&start=2024-01-01T00:00:15Z&end=2024-01-01T00:01:15Z&step=30s&downsample=max"
+	rr := httptest.NewRecorder()
+	srv.handleExportMetrics(rr, newExportRequest(context.Background(), target))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var export struct {
+		Data []models.MetricData `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{2, 4, 5}
+	if len(export.Data) != len(want) {
+		t.Fatalf("got %+v, want values %v", export.Data, want)
+	}
+	for i, d := range export.Data {
+		if d.Value != want[i] || !d.Timestamp.Equal(origin.Add(time.Duration(15+30*i)*time.Second)) {
+			t.Errorf("point %d = %+v", i, d)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handleExportMetrics(rr, newExportRequest(context.Background(),
+		"/export?application_name=test-app&project=default&duration=1h&downsample=max"))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("downsample without step: status %d, want 400", rr.Code)
+	}
+}
+
+func TestQueryRange_RangeQuerier(t *testing.T) {
+	origin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider := &fakeRangeProvider{from: origin, to: origin.Add(time.Hour)}
+	r := models.TimeRange{Start: origin.Add(10 * time.Minute), End: origin.Add(20 * time.Minute), Step: time.Minute}
+
+	response, err := queryRange(context.Background(), provider, &models.MetricsQuery{}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Data) != 11 || len(provider.ranges) != 1 {
+		t.Errorf("got %d points from %d queries, want 11 from 1", len(response.Data), len(provider.ranges))
+	}
+}
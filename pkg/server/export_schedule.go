@@ -0,0 +1,709 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/robfig/cron/v3"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/argocd"
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+// Defaults for ScheduledExportsConfig
+const (
+	defaultScheduleRange       = 24 * time.Hour
+	defaultScheduleRetries     = 3
+	defaultScheduleBackoff     = 10 * time.Second
+	defaultScheduleHistorySize = 100
+	defaultScheduleKeyTemplate = `{{.Schedule}}/{{.Project}}/{{.Application}}/{{.GroupKind}}/{{.Row}}/{{.Graph}}/{{.Time.Format "20060102T150405Z"}}.{{.Extension}}`
+)
+
+// ObjectStore stores exported files; internal/s3.Client implements it.
+// body is streamed as it is exported and its length is not known up front.
+type ObjectStore interface {
+	PutObject(ctx context.Context, key string, body io.Reader, contentType string) error
+}
+
+// ApplicationLister lists the Argo CD applications matched by schedule
+// selectors; internal/argocd.Client implements it
+type ApplicationLister interface {
+	ListApplications(ctx context.Context) ([]argocd.Application, error)
+}
+
+// ScheduledExportsConfig configures recurring exports to object storage
+type ScheduledExportsConfig struct {
+	// Retries is the number of extra attempts per file (default 3, -1 for
+	// none)
+	Retries int `yaml:"retries" json:"retries"`
+	// RetryBackoff is the wait before the first retry, doubled for each
+	// following one (default 10s)
+	RetryBackoff time.Duration `yaml:"retryBackoff" json:"retryBackoff"`
+	// HistorySize is the number of runs kept for the history endpoint
+	// (default 100)
+	HistorySize int              `yaml:"historySize" json:"historySize"`
+	Schedules   []ExportSchedule `yaml:"schedules" json:"schedules"`
+}
+
+// ExportSchedule exports a set of graphs for a set of applications on a
+// cron schedule
+type ExportSchedule struct {
+	Name string `yaml:"name" json:"name"`
+	// Cron is a standard five-field cron expression or a descriptor such as
+	// @weekly, evaluated in UTC unless prefixed with CRON_TZ=
+	Cron         string               `yaml:"cron" json:"cron"`
+	Applications []ScheduleTarget     `yaml:"applications" json:"applications"`
+	Graphs       []providers.GraphRef `yaml:"graphs" json:"graphs"`
+	Format       string               `yaml:"format" json:"format"`
+	Params       map[string]string    `yaml:"params,omitempty" json:"params,omitempty"`
+	// Range is the time window ending at the run time (default 24h)
+	Range time.Duration `yaml:"range" json:"range"`
+	// KeyTemplate is a text/template for object keys; see ExportKeyData
+	KeyTemplate string `yaml:"keyTemplate" json:"keyTemplate"`
+}
+
+// ScheduleTarget is an application exported by a schedule, or a selector
+// of applications
+type ScheduleTarget struct {
+	Application string `yaml:"application" json:"application,omitempty"`
+	Project     string `yaml:"project" json:"project,omitempty"`
+	// Selector matches the applications listed at each run instead, so that
+	// applications created later are exported too
+	Selector *ApplicationSelector `yaml:"selector" json:"selector,omitempty"`
+}
+
+// ApplicationSelector matches applications on every non-empty field
+type ApplicationSelector struct {
+	// Name and Project are glob patterns such as "payments-*"
+	Name    string `yaml:"name" json:"name,omitempty"`
+	Project string `yaml:"project" json:"project,omitempty"`
+	// Labels must all be set on the application with these values
+	Labels map[string]string `yaml:"labels" json:"labels,omitempty"`
+}
+
+// matches reports whether app is selected
+func (sel *ApplicationSelector) matches(app argocd.Application) bool {
+	if ok, _ := path.Match(sel.Name, app.Name); sel.Name != "" && !ok {
+		return false
+	}
+	if ok, _ := path.Match(sel.Project, app.Project); sel.Project != "" && !ok {
+		return false
+	}
+	for k, v := range sel.Labels {
+		if got, ok := app.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ExportKeyData is available to key templates
+type ExportKeyData struct {
+	Schedule    string
+	Application string
+	Project     string
+	GroupKind   string
+	Row         string
+	Graph       string
+	Format      string
+	Extension   string
+	// Time is the run time in UTC; Start and End bound the exported data
+	Time  time.Time
+	Start time.Time
+	End   time.Time
+}
+
+// ScheduledRunState is the outcome of a scheduled run
+type ScheduledRunState string
+
+const (
+	RunRunning   ScheduledRunState = "running"
+	RunSucceeded ScheduledRunState = "succeeded"
+	// RunPartial means some files were uploaded and others failed
+	RunPartial ScheduledRunState = "partial"
+	RunFailed  ScheduledRunState = "failed"
+)
+
+// ScheduledRun records one run of a schedule
+type ScheduledRun struct {
+	ID          string            `json:"id"`
+	Schedule    string            `json:"schedule"`
+	Trigger     string            `json:"trigger"`
+	State       ScheduledRunState `json:"state"`
+	Start       time.Time         `json:"start"`
+	End         time.Time         `json:"end"`
+	StartedAt   time.Time         `json:"started_at"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+	// Applications are the schedule's applications with selectors expanded
+	Applications []ScheduleTarget   `json:"applications"`
+	Files        []ScheduledRunFile `json:"files"`
+	// Error is set when the applications of selectors could not be listed
+	Error string `json:"error,omitempty"`
+}
+
+// ScheduledRunFile records the upload of one graph export
+type ScheduledRunFile struct {
+	Key         string `json:"key,omitempty"`
+	Application string `json:"application"`
+	Project     string `json:"project"`
+	GroupKind   string `json:"groupkind"`
+	Row         string `json:"row"`
+	Graph       string `json:"graph"`
+	Rows        int    `json:"rows"`
+	SizeBytes   int64  `json:"size_bytes"`
+	Attempts    int    `json:"attempts"`
+	Error       string `json:"error,omitempty"`
+}
+
+// compiledSchedule is a validated schedule
+type compiledSchedule struct {
+	ExportSchedule
+	exporter Exporter
+	params   url.Values
+	key      *template.Template
+	entry    cron.EntryID
+}
+
+// ExportScheduler runs the configured export schedules and uploads the
+// results to an object store
+type ExportScheduler struct {
+	provider  providers.MetricsQuerier
+	store     ObjectStore
+	apps      ApplicationLister
+	cfg       ScheduledExportsConfig
+	schedules map[string]*compiledSchedule
+	order     []string
+	cron      *cron.Cron
+	now       func() time.Time
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	ctx     context.Context
+	running map[string]bool
+	history []*ScheduledRun
+	wg      sync.WaitGroup
+}
+
+// NewExportScheduler validates the schedules. Call Start to run them.
+func NewExportScheduler(provider providers.MetricsQuerier, store ObjectStore, cfg ScheduledExportsConfig, logger *slog.Logger) (*ExportScheduler, error) {
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	} else if cfg.Retries == 0 {
+		cfg.Retries = defaultScheduleRetries
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultScheduleBackoff
+	}
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = defaultScheduleHistorySize
+	}
+
+	s := &ExportScheduler{
+		provider:  provider,
+		store:     store,
+		cfg:       cfg,
+		schedules: make(map[string]*compiledSchedule),
+		cron:      cron.New(cron.WithLocation(time.UTC)),
+		now:       time.Now,
+		logger:    logger.With("component", "export-scheduler"),
+		ctx:       context.Background(),
+		running:   make(map[string]bool),
+	}
+
+	for _, sc := range cfg.Schedules {
+		compiled, err := compileSchedule(sc)
+		if err != nil {
+			return nil, fmt.Errorf("export schedule %q: %w", sc.Name, err)
+		}
+		if _, ok := s.schedules[sc.Name]; ok {
+			return nil, fmt.Errorf("duplicate export schedule %q", sc.Name)
+		}
+
+		name := sc.Name
+		compiled.entry, err = s.cron.AddFunc(sc.Cron, func() {
+			if run := s.begin(name, "cron"); run != nil {
+				s.execute(run)
+			} else {
+				s.logger.Warn("skipping export schedule, previous run still in progress", "schedule", name)
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("export schedule %q: invalid cron expression: %w", sc.Name, err)
+		}
+		s.schedules[name] = compiled
+		s.order = append(s.order, name)
+	}
+	return s, nil
+}
+
+// SetApplicationLister sets the source of the applications matched by
+// schedule selectors. Without one, runs of schedules with selectors fail.
+func (s *ExportScheduler) SetApplicationLister(apps ApplicationLister) {
+	s.apps = apps
+}
+
+func compileSchedule(sc ExportSchedule) (*compiledSchedule, error) {
+	if sc.Name == "" || strings.Contains(sc.Name, "/") {
+		return nil, errors.New("name is required and may not contain '/'")
+	}
+	if len(sc.Applications) == 0 || len(sc.Graphs) == 0 {
+		return nil, errors.New("at least one application and one graph are required")
+	}
+	for _, app := range sc.Applications {
+		if sel := app.Selector; sel != nil {
+			if app.Application != "" || app.Project != "" {
+				return nil, errors.New("applications take either a selector or an application and a project")
+			}
+			if sel.Name == "" && sel.Project == "" && len(sel.Labels) == 0 {
+				return nil, errors.New("application selectors require a name, project or labels")
+			}
+			for _, pattern := range []string{sel.Name, sel.Project} {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("invalid application selector pattern %q: %w", pattern, err)
+				}
+			}
+			continue
+		}
+		if app.Application == "" || app.Project == "" {
+			return nil, errors.New("applications require an application and a project")
+		}
+	}
+	if sc.Range <= 0 {
+		sc.Range = defaultScheduleRange
+	}
+	if sc.Format == "" {
+		sc.Format = defaultExportFormat
+	}
+	if sc.KeyTemplate == "" {
+		sc.KeyTemplate = defaultScheduleKeyTemplate
+	}
+
+	exporter, ok := exporters.Lookup(sc.Format)
+	if !ok {
+		return nil, fmt.Errorf("unsupported export format %q", sc.Format)
+	}
+	params := make(url.Values)
+	for k, v := range sc.Params {
+		params.Set(k, v)
+	}
+	if v, ok := exporter.(optionsValidator); ok {
+		if err := v.ValidateOptions(params); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := template.New(sc.Name).Option("missingkey=error").Parse(sc.KeyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key template: %w", err)
+	}
+	// Catch references to unknown fields now rather than at the first run
+	if err := key.Execute(&bytes.Buffer{}, ExportKeyData{}); err != nil {
+		return nil, fmt.Errorf("invalid key template: %w", err)
+	}
+
+	return &compiledSchedule{ExportSchedule: sc, exporter: exporter, params: params, key: key}, nil
+}
+
+// Start runs the schedules until ctx is done, then waits for running
+// exports to stop
+func (s *ExportScheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	s.cron.Start()
+	go func() {
+		<-ctx.Done()
+		<-s.cron.Stop().Done()
+		s.wg.Wait()
+	}()
+}
+
+// Errors returned by Trigger
+var (
+	ErrScheduleNotFound = errors.New("export schedule not found")
+	ErrRunInProgress    = errors.New("export schedule is already running")
+)
+
+// Trigger starts a run of the named schedule in the background
+func (s *ExportScheduler) Trigger(name string) (ScheduledRun, error) {
+	if _, ok := s.schedules[name]; !ok {
+		return ScheduledRun{}, ErrScheduleNotFound
+	}
+	run := s.begin(name, "manual")
+	if run == nil {
+		return ScheduledRun{}, ErrRunInProgress
+	}
+
+	s.mu.Lock()
+	started := *run
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.execute(run)
+	}()
+	return started, nil
+}
+
+// History returns recorded runs, newest first, optionally for one schedule
+func (s *ExportScheduler) History(schedule string) []ScheduledRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := []ScheduledRun{}
+	for i := len(s.history) - 1; i >= 0; i-- {
+		run := s.history[i]
+		if schedule == "" || run.Schedule == schedule {
+			r := *run
+			r.Files = append([]ScheduledRunFile(nil), run.Files...)
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// begin records a new run of a schedule, or returns nil if one is already
+// in progress; runs of the same schedule never overlap
+func (s *ExportScheduler) begin(name, trigger string) *ScheduledRun {
+	sc := s.schedules[name]
+	now := s.now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[name] {
+		return nil
+	}
+	s.running[name] = true
+
+	run := &ScheduledRun{
+		ID:        fmt.Sprintf("%s-%s", name, now.Format("20060102T150405.000Z")),
+		Schedule:  name,
+		Trigger:   trigger,
+		State:     RunRunning,
+		Start:     now.Add(-sc.Range),
+		End:       now,
+		StartedAt: now,
+	}
+	s.history = append(s.history, run)
+	if len(s.history) > s.cfg.HistorySize {
+		s.history = s.history[len(s.history)-s.cfg.HistorySize:]
+	}
+	return run
+}
+
+// execute exports every graph of every application of a run's schedule
+func (s *ExportScheduler) execute(run *ScheduledRun) {
+	sc := s.schedules[run.Schedule]
+	name := sc.Name
+
+	s.mu.Lock()
+	ctx := s.ctx
+	s.mu.Unlock()
+
+	s.logger.Info("export schedule started", "schedule", name, "trigger", run.Trigger)
+
+	failed := 0
+	apps, err := s.applications(ctx, sc)
+	if err != nil {
+		s.logger.Warn("listing applications of export schedule failed", "schedule", name, "error", err)
+	}
+	s.mu.Lock()
+	run.Applications = apps
+	if err != nil {
+		run.Error = err.Error()
+	}
+	s.mu.Unlock()
+
+	for _, app := range apps {
+		for _, graph := range sc.Graphs {
+			file := s.exportFile(ctx, sc, run, app, graph)
+			if file.Error != "" {
+				failed++
+			}
+			s.mu.Lock()
+			run.Files = append(run.Files, file)
+			s.mu.Unlock()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	completed := s.now().UTC()
+	run.CompletedAt = &completed
+	switch {
+	case failed == 0 && run.Error == "":
+		run.State = RunSucceeded
+	case failed == len(run.Files):
+		run.State = RunFailed
+	default:
+		run.State = RunPartial
+	}
+	delete(s.running, name)
+
+	s.logger.Info("export schedule finished",
+		"schedule", name,
+		"state", run.State,
+		"files", len(run.Files),
+		"failed", failed,
+		"duration", completed.Sub(run.StartedAt))
+}
+
+// applications returns the applications of a schedule, listing those
+// matched by selectors. Applications listed twice are exported once. When
+// listing fails, the other applications are returned with the error.
+func (s *ExportScheduler) applications(ctx context.Context, sc *compiledSchedule) ([]ScheduleTarget, error) {
+	var (
+		apps   []ScheduleTarget
+		seen   = make(map[ScheduleTarget]bool)
+		listed []argocd.Application
+		err    error
+	)
+	add := func(app ScheduleTarget) {
+		if !seen[app] {
+			seen[app] = true
+			apps = append(apps, app)
+		}
+	}
+
+	for i, target := range sc.Applications {
+		if target.Selector == nil {
+			add(target)
+			continue
+		}
+		if listed == nil && err == nil {
+			listed, err = s.listApplications(ctx)
+		}
+		if err != nil {
+			continue
+		}
+		var matched []ScheduleTarget
+		for _, app := range listed {
+			if target.Selector.matches(app) {
+				matched = append(matched, ScheduleTarget{Application: app.Name, Project: app.Project})
+			}
+		}
+		sort.Slice(matched, func(a, b int) bool {
+			if matched[a].Project != matched[b].Project {
+				return matched[a].Project < matched[b].Project
+			}
+			return matched[a].Application < matched[b].Application
+		})
+		for _, app := range matched {
+			add(app)
+		}
+		if len(matched) == 0 {
+			s.logger.Warn("export schedule selector matched no applications", "schedule", sc.Name, "selector", i+1)
+		}
+	}
+	return apps, err
+}
+
+func (s *ExportScheduler) listApplications(ctx context.Context) ([]argocd.Application, error) {
+	if s.apps == nil {
+		return nil, errors.New("application selectors require an application lister")
+	}
+	apps, err := s.apps.ListApplications(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing applications: %w", err)
+	}
+	if apps == nil {
+		apps = []argocd.Application{}
+	}
+	return apps, nil
+}
+
+// exportFile queries, exports and uploads one graph, retrying with backoff
+func (s *ExportScheduler) exportFile(ctx context.Context, sc *compiledSchedule, run *ScheduledRun, app ScheduleTarget, graph providers.GraphRef) ScheduledRunFile {
+	file := ScheduledRunFile{
+		Application: app.Application,
+		Project:     app.Project,
+		GroupKind:   graph.GroupKind,
+		Row:         graph.Row,
+		Graph:       graph.Graph,
+	}
+
+	var key bytes.Buffer
+	err := sc.key.Execute(&key, ExportKeyData{
+		Schedule:    sc.Name,
+		Application: app.Application,
+		Project:     app.Project,
+		GroupKind:   graph.GroupKind,
+		Row:         graph.Row,
+		Graph:       graph.Graph,
+		Format:      sc.exporter.Name(),
+		Extension:   sc.exporter.Extension(),
+		Time:        run.End,
+		Start:       run.Start,
+		End:         run.End,
+	})
+	if err != nil {
+		file.Error = fmt.Sprintf("invalid key template: %v", err)
+		return file
+	}
+	file.Key = path.Clean(strings.TrimLeft(key.String(), "/"))
+	if file.Key == "." {
+		file.Error = "key template produced an empty key"
+		return file
+	}
+
+	query := &models.MetricsQuery{
+		Application: app.Application,
+		Project:     app.Project,
+		GroupKind:   graph.GroupKind,
+		Row:         graph.Row,
+		Graph:       graph.Graph,
+	}
+
+	backoff := s.cfg.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		file.Attempts = attempt
+		err = s.upload(ctx, sc, run, query, &file)
+		if err == nil {
+			file.Error = ""
+			return file
+		}
+		file.Error = err.Error()
+
+		s.logger.Warn("scheduled export failed",
+			"schedule", sc.Name,
+			"application", app.Application,
+			"graph", graph.Graph,
+			"attempt", attempt,
+			"error", err)
+		if attempt > s.cfg.Retries {
+			return file
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			file.Error = ctx.Err().Error()
+			return file
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// errUploadStopped is returned to an exporter whose upload has ended
+var errUploadStopped = errors.New("upload stopped")
+
+func (s *ExportScheduler) upload(ctx context.Context, sc *compiledSchedule, run *ScheduledRun, query *models.MetricsQuery, file *ScheduledRunFile) error {
+	response, err := queryRange(ctx, s.provider, query, models.TimeRange{Start: run.Start, End: run.End})
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+
+	// The export is piped into the upload so that it is never held in memory
+	pr, pw := io.Pipe()
+	counter := &countingWriter{w: pw}
+	exported := make(chan error, 1)
+	go func() {
+		err := sc.exporter.Export(counter, response, ExportOptions{Params: sc.params, ExportedAt: run.End})
+		pw.CloseWithError(err)
+		exported <- err
+	}()
+
+	err = s.store.PutObject(ctx, file.Key, pr, sc.exporter.ContentType())
+	// Unblock the exporter if the upload stopped reading early
+	pr.CloseWithError(errUploadStopped)
+	switch exportErr := <-exported; {
+	case exportErr != nil && !errors.Is(exportErr, errUploadStopped):
+		return fmt.Errorf("export failed: %w", exportErr)
+	case err != nil:
+		return fmt.Errorf("upload failed: %w", err)
+	}
+
+	file.Rows = len(response.Data)
+	file.SizeBytes = counter.n
+	return nil
+}
+
+// scheduleStatus describes a schedule for the API
+type scheduleStatus struct {
+	Name         string               `json:"name"`
+	Cron         string               `json:"cron"`
+	Format       string               `json:"format"`
+	Range        string               `json:"range"`
+	Applications []ScheduleTarget     `json:"applications"`
+	Graphs       []providers.GraphRef `json:"graphs"`
+	Running      bool                 `json:"running"`
+	NextRun      *time.Time           `json:"next_run,omitempty"`
+}
+
+// ExportSchedulesHandler returns the API of the export scheduler sched,
+// which should only be mounted on an internal route:
+//
+//	GET  /                 schedules with their next run time
+//	GET  /history          run history, optionally ?schedule=<name>
+//	POST /{schedule}/run   start a run now
+func (s *Server) ExportSchedulesHandler(sched *ExportScheduler) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", sched.handleSchedules)
+	r.Get("/history", func(w http.ResponseWriter, r *http.Request) { s.handleScheduleHistory(w, r, sched) })
+	r.Post("/{schedule}/run", func(w http.ResponseWriter, r *http.Request) { s.handleScheduleRun(w, r, sched) })
+	return r
+}
+
+func (s *ExportScheduler) handleSchedules(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	statuses := make([]scheduleStatus, 0, len(s.order))
+	for _, name := range s.order {
+		sc := s.schedules[name]
+		status := scheduleStatus{
+			Name:         sc.Name,
+			Cron:         sc.Cron,
+			Format:       sc.exporter.Name(),
+			Range:        sc.Range.String(),
+			Applications: sc.Applications,
+			Graphs:       sc.Graphs,
+			Running:      s.running[name],
+		}
+		if next := s.cron.Entry(sc.entry).Next; !next.IsZero() {
+			status.NextRun = &next
+		}
+		statuses = append(statuses, status)
+	}
+	s.mu.Unlock()
+
+	writeJobJSON(w, http.StatusOK, map[string]interface{}{"schedules": statuses})
+}
+
+func (s *Server) handleScheduleHistory(w http.ResponseWriter, r *http.Request, sched *ExportScheduler) {
+	schedule := r.URL.Query().Get("schedule")
+	if _, ok := sched.schedules[schedule]; schedule != "" && !ok {
+		s.respondError(w, http.StatusNotFound, "not found", ErrScheduleNotFound.Error())
+		return
+	}
+	writeJobJSON(w, http.StatusOK, map[string]interface{}{"runs": sched.History(schedule)})
+}
+
+func (s *Server) handleScheduleRun(w http.ResponseWriter, r *http.Request, sched *ExportScheduler) {
+	run, err := sched.Trigger(chi.URLParam(r, "schedule"))
+	switch {
+	case errors.Is(err, ErrScheduleNotFound):
+		s.respondError(w, http.StatusNotFound, "not found", err.Error())
+		return
+	case errors.Is(err, ErrRunInProgress):
+		s.respondError(w, http.StatusConflict, "run in progress", err.Error())
+		return
+	}
+	writeJobJSON(w, http.StatusAccepted, run)
+}
@@ -0,0 +1,391 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/argocd"
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/internal/s3"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+// fakeObjectStore keeps uploads in memory, failing the first failures calls
+type fakeObjectStore struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	objects  map[string][]byte
+	types    map[string]string
+}
+
+func newFakeObjectStore(failures int) *fakeObjectStore {
+	return &fakeObjectStore{failures: failures, objects: make(map[string][]byte), types: make(map[string]string)}
+}
+
+func (f *fakeObjectStore) PutObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.calls <= f.failures {
+		return errors.New("connection reset")
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	f.objects[key] = data
+	f.types[key] = contentType
+	return nil
+}
+
+// fakeApplicationLister lists apps, or fails with err
+type fakeApplicationLister struct {
+	apps []argocd.Application
+	err  error
+}
+
+func (f *fakeApplicationLister) ListApplications(ctx context.Context) ([]argocd.Application, error) {
+	return f.apps, f.err
+}
+
+func testSchedule() ExportSchedule {
+	return ExportSchedule{
+		Name: "weekly",
+		Cron: "@weekly",
+		Applications: []ScheduleTarget{
+			{Application: "payments", Project: "prod"},
+			{Application: "checkout", Project: "prod"},
+		},
+		Graphs: []providers.GraphRef{{GroupKind: "deployment", Row: "http", Graph: "request-rate"}},
+		Format: "csv",
+		Range:  7 * 24 * time.Hour,
+	}
+}
+
+func newTestScheduler(t *testing.T, provider providers.MetricsQuerier, store ObjectStore, cfg ScheduledExportsConfig) *ExportScheduler {
+	t.Helper()
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = time.Millisecond
+	}
+	s, err := NewExportScheduler(provider, store, cfg, testLogger)
+	if err != nil {
+		t.Fatalf("NewExportScheduler: %v", err)
+	}
+	s.now = func() time.Time { return time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC) }
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s.Start(ctx)
+	return s
+}
+
+func waitForRun(t *testing.T, s *ExportScheduler, id string) ScheduledRun {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, run := range s.History("") {
+			if run.ID == id && run.State != RunRunning {
+				return run
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("run %s did not finish", id)
+	return ScheduledRun{}
+}
+
+func TestExportScheduler_Run(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider := &fakeRangeProvider{from: from, to: from.Add(time.Hour)}
+	store := newFakeObjectStore(0)
+	s := newTestScheduler(t, provider, store, ScheduledExportsConfig{
+		Schedules: []ExportSchedule{testSchedule()},
+	})
+
+	started, err := s.Trigger("weekly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := waitForRun(t, s, started.ID)
+	if run.State != RunSucceeded || len(run.Files) != 2 {
+		t.Fatalf("run = %+v", run)
+	}
+
+	key := "weekly/prod/payments/deployment/http/request-rate/20240108T000000Z.csv"
+	if len(store.objects[key]) == 0 || store.types[key] != "text/csv" {
+		t.Errorf("no CSV uploaded at %s (objects: %v)", key, store.objects)
+	}
+	if run.Files[0].Key != key || run.Files[0].Rows != 61 || run.Files[0].Attempts != 1 ||
+		run.Files[0].SizeBytes != int64(len(store.objects[key])) {
+		t.Errorf("file = %+v", run.Files[0])
+	}
+	if got := provider.ranges[0]; !got[0].Equal(from) || !got[1].Equal(from.AddDate(0, 0, 7)) {
+		t.Errorf("queried %v, want the week before the run", got)
+	}
+}
+
+func TestExportScheduler_Retries(t *testing.T) {
+	provider := &fakeProvider{response: &models.MetricsResponse{}}
+
+	store := newFakeObjectStore(2)
+	sc := testSchedule()
+	sc.Applications = sc.Applications[:1]
+	s := newTestScheduler(t, provider, store, ScheduledExportsConfig{Retries: 2, Schedules: []ExportSchedule{sc}})
+	started, _ := s.Trigger("weekly")
+	run := waitForRun(t, s, started.ID)
+	if run.State != RunSucceeded || run.Files[0].Attempts != 3 {
+		t.Errorf("run = %+v, want success on the third attempt", run)
+	}
+
+	store = newFakeObjectStore(10)
+	s = newTestScheduler(t, provider, store, ScheduledExportsConfig{Retries: 1, Schedules: []ExportSchedule{sc}})
+	started, _ = s.Trigger("weekly")
+	run = waitForRun(t, s, started.ID)
+	if run.State != RunFailed || run.Files[0].Attempts != 2 || !strings.Contains(run.Files[0].Error, "connection reset") {
+		t.Errorf("run = %+v, want failure after two attempts", run)
+	}
+}
+
+// TestExportScheduler_S3 uploads to a fake store that accepts a single-part
+// multipart upload, as the S3 client sends for small streamed objects
+func TestExportScheduler_S3(t *testing.T) {
+	var mu sync.Mutex
+	objects := make(map[string]string)
+	parts := make(map[string]string)
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		query := r.URL.Query()
+		switch {
+		case query.Has("uploads"):
+			io.WriteString(w, `<InitiateMultipartUploadResult><UploadId>1</UploadId></InitiateMultipartUploadResult>`)
+		case query.Has("partNumber"):
+			parts[r.URL.Path] = string(decodeAWSChunked(t, r))
+			w.Header().Set("ETag", `"1"`)
+		case query.Has("uploadId"):
+			objects[r.URL.Path] = parts[r.URL.Path]
+			io.WriteString(w, `<CompleteMultipartUploadResult><Bucket>archive</Bucket></CompleteMultipartUploadResult>`)
+		default:
+			objects[r.URL.Path] = string(decodeAWSChunked(t, r))
+		}
+	}))
+	defer fake.Close()
+
+	store, err := s3.New(s3.Config{
+		Endpoint:        fake.URL,
+		Bucket:          "archive",
+		PathStyle:       true,
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc := testSchedule()
+	sc.Format = "json"
+	sc.KeyTemplate = `{{.Time.Format "2006/01/02"}}/{{.Application}}-{{.Graph}}.{{.Extension}}`
+	s := newTestScheduler(t, &fakeProvider{response: &models.MetricsResponse{Application: "payments"}}, store,
+		ScheduledExportsConfig{Schedules: []ExportSchedule{sc}})
+
+	started, _ := s.Trigger("weekly")
+	if run := waitForRun(t, s, started.ID); run.State != RunSucceeded {
+		t.Fatalf("run = %+v", run)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	body, ok := objects["/archive/2024/01/08/payments-request-rate.json"]
+	if !ok {
+		t.Fatalf("object not uploaded: %v", objects)
+	}
+	var export struct {
+		Metadata struct {
+			Application string `json:"application"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal([]byte(body), &export); err != nil || export.Metadata.Application != "payments" {
+		t.Errorf("uploaded %s", body)
+	}
+}
+
+// decodeAWSChunked reads a request body signed in chunks, as the S3 client
+// sends streamed payloads over plain HTTP
+func decodeAWSChunked(t *testing.T, r *http.Request) []byte {
+	body, _ := io.ReadAll(r.Body)
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return body
+	}
+	var decoded []byte
+	for {
+		header, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		size, err := strconv.ParseInt(strings.SplitN(string(header), ";", 2)[0], 16, 64)
+		if !ok || err != nil || int(size) > len(rest) {
+			t.Errorf("malformed aws-chunked body")
+			return nil
+		}
+		if size == 0 {
+			return decoded
+		}
+		decoded = append(decoded, rest[:size]...)
+		body = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+}
+
+func TestExportScheduler_Selectors(t *testing.T) {
+	lister := &fakeApplicationLister{apps: []argocd.Application{
+		{Name: "payments-eu", Project: "prod", Labels: map[string]string{"team": "payments"}},
+		{Name: "payments-us", Project: "prod", Labels: map[string]string{"team": "payments"}},
+		{Name: "payments-dev", Project: "dev", Labels: map[string]string{"team": "payments"}},
+		{Name: "search", Project: "prod", Labels: map[string]string{"team": "search"}},
+	}}
+	store := newFakeObjectStore(0)
+	sc := testSchedule()
+	sc.Applications = []ScheduleTarget{
+		{Selector: &ApplicationSelector{Project: "prod", Labels: map[string]string{"team": "payments"}}},
+		{Application: "payments-eu", Project: "prod"},
+		{Selector: &ApplicationSelector{Name: "sea*"}},
+	}
+	s := newTestScheduler(t, &fakeProvider{response: &models.MetricsResponse{}}, store,
+		ScheduledExportsConfig{Schedules: []ExportSchedule{sc}})
+	s.SetApplicationLister(lister)
+
+	started, _ := s.Trigger("weekly")
+	run := waitForRun(t, s, started.ID)
+	want := []ScheduleTarget{
+		{Application: "payments-eu", Project: "prod"},
+		{Application: "payments-us", Project: "prod"},
+		{Application: "search", Project: "prod"},
+	}
+	if run.State != RunSucceeded || !reflect.DeepEqual(run.Applications, want) || len(run.Files) != 3 {
+		t.Fatalf("run = %+v, want exports of %v", run, want)
+	}
+
+	// Applications created since are picked up by the next run
+	s.now = func() time.Time { return time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC) }
+	lister.apps = append(lister.apps, argocd.Application{Name: "payments-ap", Project: "prod", Labels: map[string]string{"team": "payments"}})
+	started, _ = s.Trigger("weekly")
+	if run := waitForRun(t, s, started.ID); len(run.Files) != 4 || run.Applications[0].Application != "payments-ap" {
+		t.Errorf("second run = %+v, want payments-ap exported", run)
+	}
+
+	// Static applications are still exported when listing fails
+	lister.err = errors.New("argocd: 503 Service Unavailable")
+	s.now = func() time.Time { return time.Date(2024, 1, 22, 0, 0, 0, 0, time.UTC) }
+	started, _ = s.Trigger("weekly")
+	run = waitForRun(t, s, started.ID)
+	if run.State != RunPartial || len(run.Files) != 1 || !strings.Contains(run.Error, "503") {
+		t.Errorf("run = %+v, want a partial run with the listing error", run)
+	}
+}
+
+func TestExportScheduler_Handler(t *testing.T) {
+	block := make(chan struct{})
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider := &fakeRangeProvider{from: from, to: from, block: block}
+	s := newTestScheduler(t, provider, newFakeObjectStore(0), ScheduledExportsConfig{
+		Schedules: []ExportSchedule{testSchedule()},
+	})
+	handler := (&Server{logger: testLogger}).ExportSchedulesHandler(s)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	var list struct {
+		Schedules []scheduleStatus `json:"schedules"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Schedules) != 1 || list.Schedules[0].NextRun == nil {
+		t.Errorf("schedules = %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/weekly/run", nil))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("run: status %d", rr.Code)
+	}
+	var started ScheduledRun
+	json.Unmarshal(rr.Body.Bytes(), &started)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/weekly/run", nil))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("overlapping run: status %d, want 409", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/daily/run", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown schedule: status %d, want 404", rr.Code)
+	}
+
+	close(block)
+	waitForRun(t, s, started.ID)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/history?schedule=weekly", nil))
+	var history struct {
+		Runs []ScheduledRun `json:"runs"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Runs) != 1 || history.Runs[0].Trigger != "manual" || history.Runs[0].State != RunSucceeded {
+		t.Errorf("history = %s", rr.Body.String())
+	}
+}
+
+func TestNewExportScheduler_Validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*ExportSchedule)
+		want   string
+	}{
+		{"bad cron", func(sc *ExportSchedule) { sc.Cron = "every tuesday" }, "cron"},
+		{"bad format", func(sc *ExportSchedule) { sc.Format = "pdf" }, "format"},
+		{"bad template", func(sc *ExportSchedule) { sc.KeyTemplate = "{{.Cluster}}" }, "key template"},
+		{"no graphs", func(sc *ExportSchedule) { sc.Graphs = nil }, "graph"},
+		{"empty selector", func(sc *ExportSchedule) {
+			sc.Applications = []ScheduleTarget{{Selector: &ApplicationSelector{}}}
+		}, "selector"},
+		{"selector and application", func(sc *ExportSchedule) {
+			sc.Applications = []ScheduleTarget{{Application: "a", Project: "p", Selector: &ApplicationSelector{Project: "p"}}}
+		}, "either"},
+		{"bad selector pattern", func(sc *ExportSchedule) {
+			sc.Applications = []ScheduleTarget{{Selector: &ApplicationSelector{Name: "payments-["}}}
+		}, "pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := testSchedule()
+			tt.modify(&sc)
+			_, err := NewExportScheduler(&fakeProvider{}, newFakeObjectStore(0),
+				ScheduledExportsConfig{Schedules: []ExportSchedule{sc}}, testLogger)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want mention of %q", err, tt.want)
+			}
+		})
+	}
+
+	_, err := NewExportScheduler(&fakeProvider{}, newFakeObjectStore(0),
+		ScheduledExportsConfig{Schedules: []ExportSchedule{testSchedule(), testSchedule()}}, testLogger)
+	if err == nil {
+		t.Error("duplicate schedule names accepted")
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/internal/quantile"
+)
+
+// Export modes selected by the mode parameter
+const (
+	exportModeRaw   = "raw"
+	exportModeStats = "stats"
+)
+
+// Quantile estimation for stats mode: series of up to statsExactLimit points
+// get exact quantiles, longer ones estimates within statsRelativeAccuracy
+const (
+	statsExactLimit       = 10000
+	statsRelativeAccuracy = 0.005
+)
+
+// seriesStats are the summary statistics of one series. Only finite values
+// are counted; the statistics are null for series without any.
+type seriesStats struct {
+	Labels map[string]string `json:"labels"`
+	Count  int               `json:"count"`
+	Min    *float64          `json:"min"`
+	Max    *float64          `json:"max"`
+	Avg    *float64          `json:"avg"`
+	P50    *float64          `json:"p50"`
+	P95    *float64          `json:"p95"`
+	P99    *float64          `json:"p99"`
+	// Exact is false when the quantiles are estimates
+	Exact bool `json:"exact"`
+}
+
+// exporterForMode returns the exporter for the mode parameter: e itself for
+// raw data, or its summary statistics counterpart
+func exporterForMode(mode string, e Exporter) (Exporter, error) {
+	switch mode {
+	case "", exportModeRaw:
+		return e, nil
+	case exportModeStats:
+		switch e.Name() {
+		case "json", "csv":
+			return statsExporter{format: e.Name()}, nil
+		}
+		return nil, fmt.Errorf("mode %s supports the json and csv formats", exportModeStats)
+	default:
+		return nil, fmt.Errorf("mode must be %s or %s", exportModeRaw, exportModeStats)
+	}
+}
+
+// statsExporter writes summary statistics per series instead of data
+// points, as JSON or CSV
+type statsExporter struct {
+	format string
+}
+
+func (e statsExporter) Name() string { return e.format }
+
+func (e statsExporter) ContentType() string {
+	if e.format == "csv" {
+		return "text/csv"
+	}
+	return "application/json"
+}
+
+func (e statsExporter) Extension() string { return "stats." + e.format }
+
+// ValidateOptions checks the columns parameter of CSV output
+func (e statsExporter) ValidateOptions(params url.Values) error {
+	if e.format == "csv" {
+		_, err := csvOptionsFromQuery(params)
+		return err
+	}
+	return nil
+}
+
+func (e statsExporter) Export(w io.Writer, response *models.MetricsResponse, opts ExportOptions) error {
+	stats := computeSeriesStats(response.Data)
+
+	if e.format == "csv" {
+		csvOpts, err := csvOptionsFromQuery(opts.Params)
+		if err != nil {
+			return err
+		}
+		writer := csv.NewWriter(w)
+		if err := writer.WriteAll(statsCSVRows(response.Data, stats, csvOpts.columns)); err != nil {
+			return fmt.Errorf("failed to write CSV: %w", err)
+		}
+		return nil
+	}
+
+	export := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"application": response.Application,
+			"project":     response.Project,
+			"graph":       response.Graph,
+			"exported_at": opts.ExportedAt.Format(time.RFC3339),
+			"data_points": len(response.Data),
+		},
+		"series": stats,
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	return nil
+}
+
+// computeSeriesStats computes statistics per label set in a single pass,
+// keeping series in the order they first appear
+func computeSeriesStats(data []models.MetricData) []seriesStats {
+	type accumulator struct {
+		labels    map[string]string
+		count     int
+		sum       float64
+		min, max  float64
+		quantiles *quantile.Estimator
+	}
+
+	index := make(map[string]int)
+	var series []*accumulator
+	for _, d := range data {
+		key := formatLabels(d.Labels)
+		i, ok := index[key]
+		if !ok {
+			i = len(series)
+			index[key] = i
+			series = append(series, &accumulator{
+				labels:    d.Labels,
+				min:       math.Inf(1),
+				max:       math.Inf(-1),
+				quantiles: quantile.NewEstimator(statsExactLimit, statsRelativeAccuracy),
+			})
+		}
+
+		if math.IsNaN(d.Value) || math.IsInf(d.Value, 0) {
+			continue
+		}
+		acc := series[i]
+		acc.count++
+		acc.sum += d.Value
+		acc.min, acc.max = math.Min(acc.min, d.Value), math.Max(acc.max, d.Value)
+		acc.quantiles.Add(d.Value)
+	}
+
+	stats := make([]seriesStats, len(series))
+	for i, acc := range series {
+		labels := acc.labels
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		stats[i] = seriesStats{Labels: labels, Count: acc.count, Exact: acc.quantiles.Exact()}
+		if acc.count == 0 {
+			continue
+		}
+		avg := acc.sum / float64(acc.count)
+		stats[i].Min, stats[i].Max, stats[i].Avg = &acc.min, &acc.max, &avg
+		q := func(q float64) *float64 {
+			v := acc.quantiles.Quantile(q)
+			return &v
+		}
+		stats[i].P50, stats[i].P95, stats[i].P99 = q(0.5), q(0.95), q(0.99)
+	}
+	return stats
+}
+
+// statsCSVRows returns a header plus one row per series, with a column per
+// label key as in the long layout. Statistics of empty series are left
+// empty.
+func statsCSVRows(data []models.MetricData, stats []seriesStats, columns []string) [][]string {
+	keys := labelColumns(data, columns)
+
+	header := append([]string{"Count", "Min", "Max", "Avg", "P50", "P95", "P99", "Exact"}, keys...)
+	rows := make([][]string, 0, len(stats)+1)
+	rows = append(rows, header)
+
+	format := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	}
+	for _, s := range stats {
+		row := make([]string, 0, len(header))
+		row = append(row,
+			strconv.Itoa(s.Count),
+			format(s.Min), format(s.Max), format(s.Avg),
+			format(s.P50), format(s.P95), format(s.P99),
+			strconv.FormatBool(s.Exact),
+		)
+		for _, key := range keys {
+			row = append(row, s.Labels[key])
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
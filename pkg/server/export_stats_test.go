@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+func statsTestData() []models.MetricData {
+	origin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := map[string]string{"pod": "a"}
+	b := map[string]string{"pod": "b", "zone": "eu"}
+	var data []models.MetricData
+	for i := 1; i <= 100; i++ {
+		data = append(data, models.MetricData{Timestamp: origin.Add(time.Duration(i) * time.Second), Value: float64(i), Labels: a})
+	}
+	data = append(data,
+		models.MetricData{Timestamp: origin, Value: math.NaN(), Labels: a},
+		models.MetricData{Timestamp: origin, Value: math.NaN(), Labels: b},
+	)
+	return data
+}
+
+func TestComputeSeriesStats(t *testing.T) {
+	stats := computeSeriesStats(statsTestData())
+	if len(stats) != 2 {
+		t.Fatalf("got %d series, want 2", len(stats))
+	}
+
+	a := stats[0]
+	if a.Labels["pod"] != "a" || a.Count != 100 || !a.Exact {
+		t.Errorf("series a = %+v", a)
+	}
+	for name, got := range map[string]*float64{"min": a.Min, "max": a.Max, "avg": a.Avg, "p50": a.P50, "p95": a.P95, "p99": a.P99} {
+		want := map[string]float64{"min": 1, "max": 100, "avg": 50.5, "p50": 50.5, "p95": 95.05, "p99": 99.01}[name]
+		if got == nil || math.Abs(*got-want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+
+	if b := stats[1]; b.Count != 0 || b.Min != nil || b.P99 != nil {
+		t.Errorf("series of NaNs = %+v, want empty statistics", b)
+	}
+}
+
+func TestComputeSeriesStats_LargeSeries(t *testing.T) {
+	data := make([]models.MetricData, 0, 3*statsExactLimit)
+	for i := 0; i < 3*statsExactLimit; i++ {
+		data = append(data, models.MetricData{Value: float64(i%1000) + 1})
+	}
+	stats := computeSeriesStats(data)
+	if stats[0].Exact {
+		t.Fatal("quantiles of a large series are not estimated")
+	}
+	for _, c := range []struct {
+		got  *float64
+		want float64
+	}{{stats[0].P50, 500}, {stats[0].P95, 950}, {stats[0].P99, 990}} {
+		if math.Abs(*c.got-c.want) > c.want*statsRelativeAccuracy+1 {
+			t.Errorf("quantile = %v, want %v", *c.got, c.want)
+		}
+	}
+}
+
+func TestHandleExportMetrics_Stats(t *testing.T) {
+	srv := &Server{
+		logger:   testLogger,
+		provider: &fakeProvider{response: &models.MetricsResponse{Application: "test-app", Graph: "cpu", Data: statsTestData()}},
+	}
+
+	rr := httptest.NewRecorder()
+	srv.handleExportMetrics(rr, newExportRequest(context.Background(),
+		"/export?application_name=test-app&project=default&mode=stats"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.HasSuffix(cd, ".stats.json") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	var export struct {
+		Metadata struct {
+			Graph string `json:"graph"`
+		} `json:"metadata"`
+		Series []seriesStats `json:"series"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	if export.Metadata.Graph != "cpu" || len(export.Series) != 2 || *export.Series[0].P95 != 95.05 {
+		t.Errorf("export = %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handleExportMetrics(rr, newExportRequest(context.Background(),
+		"/export?application_name=test-app&project=default&mode=stats&format=csv&columns=zone"))
+	rows, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Count", "Min", "Max", "Avg", "P50", "P95", "P99", "Exact", "zone", "pod"},
+		{"100", "1", "100", "50.5", "50.5", "95.05", "99.01", "true", "", "a"},
+		{"0", "", "", "", "", "", "", "true", "eu", "b"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows = %v", rows)
+	}
+	for i := range want {
+		if strings.Join(rows[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("row %d = %v, want %v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestHandleExportMetrics_StatsErrors(t *testing.T) {
+	srv := &Server{logger: testLogger, provider: &fakeProvider{response: &models.MetricsResponse{}}}
+	for _, query := range []string{"mode=stats&format=parquet", "mode=summary"} {
+		rr := httptest.NewRecorder()
+		srv.handleExportMetrics(rr, newExportRequest(context.Background(),
+			"/export?application_name=test-app&project=default&"+query))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, rr.Code)
+		}
+	}
+}
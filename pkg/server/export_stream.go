@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+func init() {
+	RegisterExporter(ndjsonExporter{})
+}
+
+// exportStream writes the points of query as the provider yields them, so
+// the response is never held in memory. Exporters that read the points more
+// than once, such as CSV collecting its label columns, replay them from a
+// temporary spool file; the others write each point as it arrives and
+// flush it to the client every streamFlushPoints points. The stream stops
+// as soon as the client goes away. When the streamer fails with
+// providers.ErrNotSupported, the provider's response is exported instead.
+func (s *Server) exportStream(ctx context.Context, w http.ResponseWriter, e Exporter, pe pointsExporter, streamer providers.PointStreamer, query *models.MetricsQuery, params url.Values) {
+	header := &models.MetricsResponse{
+		Application: query.Application,
+		Project:     query.Project,
+		Graph:       query.Graph,
+	}
+
+	s.writeExport(w, e, query.Application, params, streamFlushPoints, func(out io.Writer, opts ExportOptions) (int, error) {
+		var spool *jobSpool
+		if r, ok := pe.(pointReplayer); ok && r.replaysPoints() {
+			var err error
+			if spool, err = newJobSpool(os.TempDir(), "metrics-export"); err != nil {
+				return 0, err
+			}
+			defer spool.close()
+		}
+
+		rows, streamed := 0, false
+		source := func(yield func(models.MetricData) error) error {
+			if streamed {
+				if spool == nil {
+					return errors.New("streamed points can only be read once")
+				}
+				return spool.replay(yield)
+			}
+			streamed = true
+			point := func(d models.MetricData) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if spool != nil {
+					if err := spool.add(d); err != nil {
+						return err
+					}
+				}
+				rows++
+				return yield(d)
+			}
+			err := streamer.QueryStream(ctx, query, point)
+			if !errors.Is(err, providers.ErrNotSupported) || rows > 0 {
+				return err
+			}
+
+			// A registry routed the query to a provider that cannot
+			// stream; export its response instead
+			response, err := s.provider.Query(ctx, query)
+			if err != nil {
+				return err
+			}
+			for _, d := range response.Data {
+				if err := point(d); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		err := pe.exportPoints(out, header, source, opts)
+		return rows, err
+	})
+}
+
+// ndjsonExporter writes one JSON object per data point and line
+type ndjsonExporter struct{}
+
+func (ndjsonExporter) Name() string        { return "ndjson" }
+func (ndjsonExporter) ContentType() string { return "application/x-ndjson" }
+func (ndjsonExporter) Extension() string   { return "ndjson" }
+
+func (e ndjsonExporter) Export(w io.Writer, response *models.MetricsResponse, opts ExportOptions) error {
+	return e.exportPoints(w, response, func(yield func(models.MetricData) error) error {
+		for _, d := range response.Data {
+			if err := yield(d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, opts)
+}
+
+func (ndjsonExporter) exportsPoints(params url.Values) bool { return true }
+
+func (ndjsonExporter) exportPoints(w io.Writer, header *models.MetricsResponse, points pointSource, opts ExportOptions) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := points(func(d models.MetricData) error {
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+		return opts.stream.point(bw.Flush)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode NDJSON: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to encode NDJSON: %w", err)
+	}
+	return nil
+}
+
+// formatLabels renders labels as sorted Prometheus-style pairs: a="1",b="2"
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[k]))
+	}
+	return b.String()
+}
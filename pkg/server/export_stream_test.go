@@ -0,0 +1,362 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+// fakeProvider returns a fixed response from Query
+type fakeProvider struct {
+	response *models.MetricsResponse
+	err      error
+}
+
+func (p *fakeProvider) Query(ctx context.Context, query *models.MetricsQuery) (*models.MetricsResponse, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.response, nil
+}
+
+// fakeStreamProvider yields count points, or forever when count is 0
+type fakeStreamProvider struct {
+	fakeProvider
+	count   int
+	yielded int
+	onYield func(n int)
+}
+
+func (p *fakeStreamProvider) QueryStream(ctx context.Context, query *models.MetricsQuery, yield func(models.MetricData) error) error {
+	if p.err != nil {
+		return p.err
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; p.count == 0 || i < p.count; i++ {
+		if p.onYield != nil {
+			p.onYield(i)
+		}
+		err := yield(models.MetricData{
+			Timestamp: start.Add(time.Duration(i) * 15 * time.Second),
+			Value:     float64(i),
+			Labels:    map[string]string{"pod": "pod-1"},
+		})
+		if err != nil {
+			return err
+		}
+		p.yielded++
+	}
+	return nil
+}
+
+// fakeResponseStreamProvider streams its fixed response
+type fakeResponseStreamProvider struct {
+	fakeProvider
+	streamed bool
+}
+
+func (p *fakeResponseStreamProvider) QueryStream(ctx context.Context, query *models.MetricsQuery, yield func(models.MetricData) error) error {
+	p.streamed = true
+	for _, d := range p.response.Data {
+		if err := yield(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCountingRecorder records how many points the provider had yielded
+// when the first byte was written
+type writeCountingRecorder struct {
+	*httptest.ResponseRecorder
+	provider     *fakeStreamProvider
+	atFirstWrite int
+}
+
+func (w *writeCountingRecorder) Write(p []byte) (int, error) {
+	if w.ResponseRecorder.Body.Len() == 0 {
+		w.atFirstWrite = w.provider.yielded
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+func newExportRequest(ctx context.Context, target string) *http.Request {
+	req := httptest.NewRequest("GET", target, nil).WithContext(ctx)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("application", "test-app")
+	rctx.URLParams.Add("groupkind", "deployment")
+	rctx.URLParams.Add("row", "http")
+	rctx.URLParams.Add("graph", "request-rate")
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestHandleExportMetrics_StreamNDJSON(t *testing.T) {
+	provider := &fakeStreamProvider{count: 1200}
+	srv := &Server{
+		logger:   testLogger,
+		provider: provider,
+	}
+
+	rr := &writeCountingRecorder{ResponseRecorder: httptest.NewRecorder(), provider: provider}
+	srv.handleExportMetrics(rr, newExportRequest(context.Background(),
+		"/export?format=ndjson&application_name=test-app&project=default"))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected Content-Type application/x-ndjson, got %s", ct)
+	}
+	if rr.atFirstWrite >= 1200 {
+		t.Errorf("Expected rows to be written while streaming, first write after %d points", rr.atFirstWrite)
+	}
+
+	lines := 0
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		var point models.MetricData
+		if err := json.Unmarshal(scanner.Bytes(), &point); err != nil {
+			t.Fatalf("Line %d is not valid JSON: %v", lines+1, err)
+		}
+		lines++
+	}
+	if lines != 1200 {
+		t.Errorf("Expected 1200 lines, got %d", lines)
+	}
+}
+
+// flushCountingRecorder records how many points the provider had yielded
+// at each flush
+type flushCountingRecorder struct {
+	*httptest.ResponseRecorder
+	provider *fakeStreamProvider
+	flushes  []int
+}
+
+func (w *flushCountingRecorder) Flush() {
+	w.flushes = append(w.flushes, w.provider.yielded)
+	w.ResponseRecorder.Flush()
+}
+
+func TestHandleExportMetrics_StreamFlushes(t *testing.T) {
+	for _, format := range []string{"ndjson", "csv", "json"} {
+		t.Run(format, func(t *testing.T) {
+			provider := &fakeStreamProvider{count: 1200}
+			srv := &Server{
+				logger:   testLogger,
+				provider: provider,
+			}
+
+			rr := &flushCountingRecorder{ResponseRecorder: httptest.NewRecorder(), provider: provider}
+			srv.handleExportMetrics(rr, newExportRequest(context.Background(),
+				"/export?format="+format+"&compress=gzip&application_name=test-app&project=default"))
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+			}
+			if len(rr.flushes) != 1200/streamFlushPoints {
+				t.Fatalf("Expected %d flushes, got %v", 1200/streamFlushPoints, rr.flushes)
+			}
+			if format != "csv" && rr.flushes[0] >= 1200 {
+				t.Errorf("Expected the first flush while streaming, got it after %d points", rr.flushes[0])
+			}
+			if rr.Body.Len() == 0 {
+				t.Error("Expected a body")
+			}
+		})
+	}
+}
+
+func TestHandleExportMetrics_StreamNotSupported(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider := &unsupportedProvider{fakeProvider{response: &models.MetricsResponse{
+		Application: "test-app",
+		Data: []models.MetricData{
+			{Timestamp: from, Value: 1},
+			{Timestamp: from.Add(time.Hour), Value: 2},
+		},
+	}}}
+	srv := &Server{
+		logger:   testLogger,
+		provider: provider,
+	}
+
+	// Streams and ranges fall back to the provider's default window
+	for target, want := range map[string]int{
+		"/export?format=ndjson&application_name=test-app&project=default":                                                     2,
+		"/export?format=ndjson&application_name=test-app&project=default&start=2023-12-31T23:00:00Z&end=2024-01-01T00:30:00Z": 1,
+	} {
+		rr := httptest.NewRecorder()
+		srv.handleExportMetrics(rr, newExportRequest(context.Background(), target))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", target, rr.Code, rr.Body)
+		}
+		if lines := strings.Count(rr.Body.String(), "\n"); lines != want {
+			t.Errorf("%s: expected %d points, got %q", target, want, rr.Body)
+		}
+	}
+}
+
+func TestHandleExportMetrics_StreamCSV(t *testing.T) {
+	provider := &fakeResponseStreamProvider{fakeProvider: fakeProvider{response: &models.MetricsResponse{
+		Application: "test-app",
+		Data: []models.MetricData{
+			{
+				Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Value:     100.5,
+				Labels:    map[string]string{"status": "200", "instance": "pod-1"},
+			},
+			{
+				Timestamp: time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC),
+				Value:     3,
+				Labels:    map[string]string{"instance": "pod-2", "code": `a"b`},
+			},
+		},
+	}}}
+	srv := &Server{
+		logger:   testLogger,
+		provider: provider,
+	}
+
+	rr := httptest.NewRecorder()
+	srv.handleExportMetrics(rr, newExportRequest(context.Background(),
+		"/export?format=csv&application_name=test-app&project=default"))
+
+	if !provider.streamed {
+		t.Error("Expected the provider to be streamed")
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	// Label columns cover every point, as for buffered exports
+	expected := [][]string{
+		{"Timestamp", "Value", "code", "instance", "status"},
+		{"2024-01-01T12:00:00Z", "100.5", "", "pod-1", "200"},
+		{"2024-01-01T12:01:00Z", "3", `a"b`, "pod-2", ""},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected %q, got %q", expected, records)
+	}
+}
+
+func TestHandleExportMetrics_StreamNotUsed(t *testing.T) {
+	data := []models.MetricData{
+		{Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), Value: 1},
+		{Timestamp: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), Value: 2},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		rows  int
+	}{
+		// Ranges go through the range query, filtered and downsampled
+		{"Range", "format=csv&start=2024-01-02T00:00:00Z&end=2024-01-03T00:00:00Z", 1},
+		// The wide layout needs every point at once
+		{"Wide layout", "format=csv&layout=wide", 2},
+		{"Buffered format", "format=openmetrics", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeResponseStreamProvider{fakeProvider: fakeProvider{response: &models.MetricsResponse{
+				Application: "test-app",
+				Data:        data,
+			}}}
+			srv := &Server{logger: testLogger, provider: provider}
+
+			rr := httptest.NewRecorder()
+			srv.handleExportMetrics(rr, newExportRequest(context.Background(),
+				"/export?"+tt.query+"&application_name=test-app&project=default"))
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+			if provider.streamed {
+				t.Error("Expected a regular query")
+			}
+			if tt.rows == 1 && strings.Contains(rr.Body.String(), "2024-01-01") {
+				t.Errorf("Expected points outside the range to be dropped:\n%s", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleExportMetrics_StreamClientDisconnect(t *testing.T) {
+	var cancel context.CancelFunc
+	provider := &fakeStreamProvider{
+		onYield: func(n int) {
+			if n == 100 {
+				cancel()
+			}
+		},
+	}
+	srv := &Server{
+		logger:   testLogger,
+		provider: provider,
+	}
+
+	for _, format := range []string{"ndjson", "csv"} {
+		provider.yielded = 0
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			srv.handleExportMetrics(httptest.NewRecorder(), newExportRequest(ctx,
+				"/export?format="+format+"&application_name=test-app&project=default"))
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: streaming export did not stop after client disconnect", format)
+		}
+		if provider.yielded != 100 {
+			t.Errorf("%s: expected provider to stop after 100 points, got %d", format, provider.yielded)
+		}
+		cancel()
+	}
+}
+
+func TestHandleExportMetrics_StreamErrors(t *testing.T) {
+	srv := &Server{
+		logger:   testLogger,
+		provider: &fakeStreamProvider{fakeProvider: fakeProvider{err: errors.New("prometheus unavailable")}},
+	}
+
+	tests := []struct {
+		name           string
+		target         string
+		expectedStatus int
+	}{
+		{"Unsupported format", "/export?format=xml&application_name=test-app&project=default", http.StatusNotAcceptable},
+		{"Missing project", "/export?format=ndjson&application_name=test-app", http.StatusBadRequest},
+		{"Query failure", "/export?format=ndjson&application_name=test-app&project=default", http.StatusInternalServerError},
+		{"Query failure in CSV", "/export?format=csv&application_name=test-app&project=default", http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			srv.handleExportMetrics(rr, newExportRequest(context.Background(), tt.target))
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if cd := rr.Header().Get("Content-Disposition"); cd != "" {
+				t.Errorf("Expected no download, got Content-Disposition %q", cd)
+			}
+		})
+	}
+}
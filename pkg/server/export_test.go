@@ -1,10 +1,16 @@
 package server
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -42,7 +48,7 @@ func TestExportCSV(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	srv.exportCSV(rr, response)
+	srv.export(rr, csvExporter{}, response, nil)
 
 	// Check content type
 	if contentType := rr.Header().Get("Content-Type"); contentType != "text/csv" {
@@ -72,6 +78,134 @@ func TestExportCSV(t *testing.T) {
 	}
 }
 
+func TestExportCSV_LabelUnion(t *testing.T) {
+	srv := &Server{
+		logger: testLogger,
+	}
+
+	response := &models.MetricsResponse{
+		Application: "test-app",
+		Data: []models.MetricData{
+			{
+				Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Value:     1,
+				Labels:    map[string]string{"status": "200", "instance": "pod-1"},
+			},
+			{
+				Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Value:     2,
+				Labels:    map[string]string{"instance": "pod-2", "method": "POST"},
+			},
+		},
+	}
+
+	// Column order must be identical across calls
+	for i := 0; i < 10; i++ {
+		rr := httptest.NewRecorder()
+		srv.export(rr, csvExporter{}, response, nil)
+
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("Failed to read CSV: %v", err)
+		}
+
+		expected := [][]string{
+			{"Timestamp", "Value", "instance", "method", "status"},
+			{"2024-01-01T12:00:00Z", "1", "pod-1", "", "200"},
+			{"2024-01-01T12:00:00Z", "2", "pod-2", "POST", ""},
+		}
+		if !reflect.DeepEqual(records, expected) {
+			t.Fatalf("Expected %v, got %v", expected, records)
+		}
+	}
+}
+
+func TestExportCSV_ColumnOrder(t *testing.T) {
+	srv := &Server{
+		logger: testLogger,
+	}
+
+	response := &models.MetricsResponse{
+		Data: []models.MetricData{
+			{
+				Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Value:     1,
+				Labels:    map[string]string{"a": "1", "b": "2", "c": "3"},
+			},
+		},
+	}
+
+	rr := httptest.NewRecorder()
+	srv.export(rr, csvExporter{}, response, url.Values{"columns": {"c,missing,a"}})
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+
+	expected := []string{"Timestamp", "Value", "c", "a", "b"}
+	if !reflect.DeepEqual(records[0], expected) {
+		t.Errorf("Expected header %v, got %v", expected, records[0])
+	}
+}
+
+func TestExportCSV_WideLayout(t *testing.T) {
+	srv := &Server{
+		logger: testLogger,
+	}
+
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	response := &models.MetricsResponse{
+		Data: []models.MetricData{
+			{Timestamp: t1, Value: 4, Labels: map[string]string{"instance": "pod-2"}},
+			{Timestamp: t0, Value: 1, Labels: map[string]string{"instance": "pod-1"}},
+			{Timestamp: t1, Value: 2, Labels: map[string]string{"instance": "pod-1"}},
+		},
+	}
+
+	rr := httptest.NewRecorder()
+	srv.export(rr, csvExporter{}, response, url.Values{"layout": {csvLayoutWide}})
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+
+	expected := [][]string{
+		{"Timestamp", `instance="pod-1"`, `instance="pod-2"`},
+		{"2024-01-01T12:00:00Z", "1", ""},
+		{"2024-01-01T12:01:00Z", "2", "4"},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected %v, got %v", expected, records)
+	}
+}
+
+func TestCSVOptionsFromQuery(t *testing.T) {
+	req := httptest.NewRequest("GET", "/export?format=csv&layout=wide&columns=pod,+status,", nil)
+	opts, err := csvOptionsFromQuery(req.URL.Query())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.layout != csvLayoutWide {
+		t.Errorf("Expected layout wide, got %s", opts.layout)
+	}
+	if !reflect.DeepEqual(opts.columns, []string{"pod", "status"}) {
+		t.Errorf("Unexpected columns: %v", opts.columns)
+	}
+
+	req = httptest.NewRequest("GET", "/export?format=csv", nil)
+	if opts, _ := csvOptionsFromQuery(req.URL.Query()); opts.layout != csvLayoutLong {
+		t.Errorf("Expected default layout long, got %s", opts.layout)
+	}
+
+	req = httptest.NewRequest("GET", "/export?format=csv&layout=tall", nil)
+	if _, err := csvOptionsFromQuery(req.URL.Query()); err == nil {
+		t.Error("Expected error for unknown layout")
+	}
+}
+
 func TestExportJSON(t *testing.T) {
 	srv := &Server{
 		logger: testLogger,
@@ -93,7 +227,7 @@ func TestExportJSON(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	srv.exportJSON(rr, response)
+	srv.export(rr, jsonExporter{}, response, nil)
 
 	// Check content type
 	if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
@@ -122,28 +256,264 @@ func TestExportJSON(t *testing.T) {
 	}
 }
 
+func TestExportPoints(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	response := &models.MetricsResponse{
+		Application: "test-app",
+		Project:     "test-project",
+		Graph:       "request-rate",
+		Data: []models.MetricData{
+			{Timestamp: at, Value: 1, Labels: map[string]string{"status": "200", "instance": "pod-1"}},
+			{Timestamp: at, Value: 2.5, Labels: map[string]string{"instance": "pod-2", "method": "<POST>"}},
+		},
+	}
+	points := func(yield func(models.MetricData) error) error {
+		for _, d := range response.Data {
+			if err := yield(d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	header := &models.MetricsResponse{Application: response.Application, Project: response.Project, Graph: response.Graph}
+
+	tests := []struct {
+		name     string
+		exporter Exporter
+		params   url.Values
+		data     []models.MetricData
+	}{
+		{"json", jsonExporter{}, nil, response.Data},
+		{"json without points", jsonExporter{}, nil, []models.MetricData{}},
+		{"csv", csvExporter{}, nil, response.Data},
+		{"csv with columns", csvExporter{}, url.Values{"columns": {"status"}}, response.Data},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			full := *response
+			full.Data = tt.data
+			opts := ExportOptions{Params: tt.params, ExportedAt: at}
+			var want, got strings.Builder
+			if err := tt.exporter.Export(&want, &full, opts); err != nil {
+				t.Fatal(err)
+			}
+			source := points
+			if len(tt.data) == 0 {
+				source = func(func(models.MetricData) error) error { return nil }
+			}
+			pe := tt.exporter.(pointsExporter)
+			if !pe.exportsPoints(tt.params) {
+				t.Fatal("exportsPoints = false")
+			}
+			if err := pe.exportPoints(&got, header, source, opts); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != want.String() {
+				t.Errorf("exportPoints wrote\n%s\nExport wrote\n%s", got.String(), want.String())
+			}
+		})
+	}
+
+	if (csvExporter{}).exportsPoints(url.Values{"layout": {"wide"}}) {
+		t.Error("wide CSV layout exported from points")
+	}
+}
+
 func TestHandleExportMetrics_FormatValidation(t *testing.T) {
 	tests := []struct {
 		name           string
 		format         string
+		accept         string
+		expectedStatus int
 		expectedType   string
 	}{
-		{"CSV format", "csv", "text/csv"},
-		{"JSON format", "json", "application/json"},
-		{"Default to JSON", "invalid", "application/json"},
-		{"Empty defaults to JSON", "", "application/json"},
+		{"CSV format", "csv", "", http.StatusOK, "text/csv"},
+		{"JSON format", "json", "", http.StatusOK, "application/json"},
+		{"Parquet format", "parquet", "", http.StatusOK, "application/vnd.apache.parquet"},
+		{"Unknown format", "invalid", "", http.StatusNotAcceptable, "application/json"},
+		{"Empty defaults to JSON", "", "", http.StatusOK, "application/json"},
+		{"Format wins over Accept", "csv", "application/json", http.StatusOK, "text/csv"},
+		{"Accept media type", "", "application/avro", http.StatusOK, "application/avro"},
+		{"Accept by quality", "", "application/json;q=0.5, text/csv", http.StatusOK, "text/csv"},
+		{"Accept wildcard", "", "*/*", http.StatusOK, "application/json"},
+		{"Accept type wildcard", "", "text/*", http.StatusOK, "text/csv"},
+		{"Accept unsupported", "", "image/png", http.StatusNotAcceptable, "application/json"},
+		{"Accept refused", "", "application/json;q=0", http.StatusNotAcceptable, "application/json"},
+	}
+
+	srv := &Server{
+		logger: testLogger,
+		provider: &fakeProvider{response: &models.MetricsResponse{
+			Application: "test-app",
+			Data: []models.MetricData{
+				{Timestamp: time.Now(), Value: 1, Labels: map[string]string{"pod": "pod-1"}},
+			},
+		}},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Test will be implemented when integrated with full server
-			// This is a placeholder for format validation testing
-			if tt.format == "" {
-				format := "json" // default
-				if format != "json" {
-					t.Errorf("Expected default format json, got %s", format)
-				}
+			target := "/export?application_name=test-app&project=default"
+			if tt.format != "" {
+				target += "&format=" + tt.format
+			}
+			req := newExportRequest(context.Background(), target)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			rr := httptest.NewRecorder()
+			srv.handleExportMetrics(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if contentType := rr.Header().Get("Content-Type"); contentType != tt.expectedType {
+				t.Errorf("Expected Content-Type %s, got %s", tt.expectedType, contentType)
+			}
+			if rr.Header().Get("Vary") != "Accept" {
+				t.Error("Expected Vary: Accept")
 			}
 		})
 	}
 }
+
+func TestHandleExportMetrics_InvalidOptions(t *testing.T) {
+	provider := &fakeProvider{err: errors.New("should not be queried")}
+	srv := &Server{
+		logger:   testLogger,
+		provider: provider,
+	}
+
+	rr := httptest.NewRecorder()
+	srv.handleExportMetrics(rr, newExportRequest(context.Background(),
+		"/export?format=csv&layout=tall&application_name=test-app&project=default"))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", rr.Code)
+	}
+}
+
+func TestHandleExportFormats(t *testing.T) {
+	srv := &Server{
+		logger: testLogger,
+	}
+
+	rr := httptest.NewRecorder()
+	srv.handleExportFormats(rr, httptest.NewRequest("GET", "/api/export/formats", nil))
+
+	var body struct {
+		Formats []exportFormat `json:"formats"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	byName := make(map[string]exportFormat)
+	for _, f := range body.Formats {
+		byName[f.Name] = f
+	}
+	for _, name := range []string{"csv", "json", "parquet", "arrow", "avro"} {
+		if _, ok := byName[name]; !ok {
+			t.Errorf("Expected format %s to be listed", name)
+		}
+	}
+	if !byName["json"].Default || byName["csv"].Default {
+		t.Error("Expected json to be the only default format")
+	}
+	if byName["arrow"].ContentType != "application/vnd.apache.arrow.stream" || byName["arrow"].Extension != "arrows" {
+		t.Errorf("Unexpected arrow format: %+v", byName["arrow"])
+	}
+}
+
+func TestExporterRegistry_Register(t *testing.T) {
+	reg := &ExporterRegistry{}
+	if err := reg.Register(csvExporter{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := reg.Register(csvExporter{}); err == nil {
+		t.Error("Expected error registering a duplicate name")
+	}
+
+	if _, ok := reg.Negotiate("json", ""); ok {
+		t.Error("Expected unregistered format to be rejected")
+	}
+	// Without json registered, */* falls back to the first exporter
+	if e, ok := reg.Negotiate("", "*/*"); !ok || e.Name() != "csv" {
+		t.Errorf("Expected csv for */*, got %v", e)
+	}
+}
+
+func TestHandleExportMetrics_GzipDownload(t *testing.T) {
+	srv := &Server{
+		logger: testLogger,
+		provider: &fakeProvider{response: &models.MetricsResponse{
+			Application: "test-app",
+			Data:        []models.MetricData{{Timestamp: time.Unix(0, 0).UTC(), Value: 1}},
+		}},
+	}
+
+	rr := httptest.NewRecorder()
+	srv.handleExportMetrics(rr, newExportRequest(context.Background(),
+		"/export?application_name=test-app&project=default&format=csv&compress=gzip"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.HasSuffix(cd, ".csv.gz") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	zr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(zr)
+	if !strings.HasPrefix(string(content), "Timestamp,Value") {
+		t.Errorf("decompressed content = %q", content)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handleExportMetrics(rr, newExportRequest(context.Background(),
+		"/export?application_name=test-app&project=default&compress=brotli"))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("compress=brotli: status %d, want 400", rr.Code)
+	}
+}
+
+// failingExporter fails after writing written bytes
+type failingExporter struct {
+	csvExporter
+	written string
+}
+
+func (e failingExporter) Export(w io.Writer, response *models.MetricsResponse, opts ExportOptions) error {
+	if e.written != "" {
+		io.WriteString(w, e.written)
+	}
+	return errors.New("encoder failed")
+}
+
+func TestExport_Failure(t *testing.T) {
+	srv := &Server{logger: testLogger}
+	response := &models.MetricsResponse{Application: "test-app"}
+
+	for _, compress := range []string{"", "gzip"} {
+		rr := httptest.NewRecorder()
+		srv.export(rr, failingExporter{}, response, url.Values{"compress": {compress}})
+		if rr.Code != http.StatusInternalServerError || rr.Header().Get("Content-Disposition") != "" {
+			t.Errorf("compress=%q: status %d, Content-Disposition %q", compress, rr.Code, rr.Header().Get("Content-Disposition"))
+		}
+		if !strings.Contains(rr.Body.String(), "encoder failed") {
+			t.Errorf("compress=%q: body = %q", compress, rr.Body.String())
+		}
+	}
+
+	// Once the download has started, it can only be cut short
+	rr := httptest.NewRecorder()
+	srv.export(rr, failingExporter{written: "Timestamp,Value\n"}, response, url.Values{})
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/csv" || rr.Body.String() != "Timestamp,Value\n" {
+		t.Errorf("partial export: status %d, Content-Type %q, body %q", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+func init() {
+	RegisterExporter(xlsxExporter{})
+}
+
+// xlsxExporter writes an Excel workbook with a metadata sheet and one sheet
+// per distinct label set. Timestamps are datetime cells in UTC. A series
+// with more points than fit on a sheet continues on further sheets.
+type xlsxExporter struct {
+	// maxRows is the number of rows per sheet, header included; zero means
+	// Excel's limit of 1,048,576
+	maxRows int
+}
+
+func (xlsxExporter) Name() string { return "xlsx" }
+func (xlsxExporter) ContentType() string {
+	return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+}
+func (xlsxExporter) Extension() string { return "xlsx" }
+
+func (e xlsxExporter) Export(w io.Writer, response *models.MetricsResponse, opts ExportOptions) error {
+	perSheet := e.maxRows
+	if perSheet <= 0 {
+		perSheet = excelize.TotalRows
+	}
+	perSheet-- // header row
+
+	series := groupSeries(response.Data)
+
+	// Plan the sheets up front so the metadata sheet can index them
+	type seriesSheet struct {
+		name   string
+		series *labelSeries
+		points []models.MetricData
+	}
+	names := xlsxSheetNames{"metadata": true, "history": true}
+	var sheets []seriesSheet
+	for _, sr := range series {
+		points := sr.points
+		for {
+			n := len(points)
+			if n > perSheet {
+				n = perSheet
+			}
+			sheets = append(sheets, seriesSheet{name: names.add(sr.sheetName()), series: sr, points: points[:n]})
+			points = points[n:]
+			if len(points) == 0 {
+				break
+			}
+		}
+	}
+
+	f := excelize.NewFile()
+	defer f.Close()
+
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	dateFormat := "yyyy-mm-dd hh:mm:ss"
+	dateStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat})
+	if err != nil {
+		return err
+	}
+	header := func(names ...string) []interface{} {
+		row := make([]interface{}, len(names))
+		for i, name := range names {
+			row[i] = excelize.Cell{StyleID: headerStyle, Value: name}
+		}
+		return row
+	}
+
+	// Same fields as the JSON export metadata, plus an index of the series
+	// sheets since sheet names are truncated
+	if err := f.SetSheetName(f.GetSheetName(0), "Metadata"); err != nil {
+		return err
+	}
+	meta, err := f.NewStreamWriter("Metadata")
+	if err != nil {
+		return err
+	}
+	if err := meta.SetColWidth(1, 1, 20); err != nil {
+		return err
+	}
+	if err := meta.SetColWidth(2, 2, 40); err != nil {
+		return err
+	}
+	rows := [][]interface{}{
+		header("Field", "Value"),
+		{"Application", response.Application},
+		{"Project", response.Project},
+		{"Graph", response.Graph},
+		{"Exported At", excelize.Cell{StyleID: dateStyle, Value: opts.ExportedAt.UTC()}},
+		{"Data Points", len(response.Data)},
+		{"Series", len(series)},
+	}
+	if len(sheets) > 0 {
+		// Blank row between the metadata and the series index
+		rows = append(rows, nil, header("Sheet", "Labels"))
+	}
+	for _, sh := range sheets {
+		rows = append(rows, []interface{}{sh.name, formatLabels(sh.series.labels)})
+	}
+	for i, row := range rows {
+		if err := meta.SetRow(xlsxCell(1, i+1), row); err != nil {
+			return err
+		}
+	}
+	if err := meta.Flush(); err != nil {
+		return err
+	}
+
+	for _, sh := range sheets {
+		if _, err := f.NewSheet(sh.name); err != nil {
+			return err
+		}
+		sw, err := f.NewStreamWriter(sh.name)
+		if err != nil {
+			return err
+		}
+		if err := sw.SetColWidth(1, 1, 20); err != nil {
+			return err
+		}
+		if err := sw.SetRow("A1", header(append([]string{"Timestamp", "Value"}, sh.series.keys...)...)); err != nil {
+			return err
+		}
+
+		row := make([]interface{}, 2+len(sh.series.keys))
+		for j, key := range sh.series.keys {
+			row[2+j] = sh.series.labels[key]
+		}
+		for i, d := range sh.points {
+			row[0] = excelize.Cell{StyleID: dateStyle, Value: d.Timestamp.UTC()}
+			row[1] = d.Value
+			if math.IsNaN(d.Value) || math.IsInf(d.Value, 0) {
+				// Excel has no cell value for these
+				row[1] = strconv.FormatFloat(d.Value, 'g', -1, 64)
+			}
+			if err := sw.SetRow(xlsxCell(1, i+2), row); err != nil {
+				return err
+			}
+		}
+		if err := sw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return f.Write(w)
+}
+
+// xlsxCell returns the reference of a one-based column and row, such as A1
+func xlsxCell(col, row int) string {
+	cell, _ := excelize.CoordinatesToCellName(col, row)
+	return cell
+}
+
+// xlsxSheetNames tracks the sheet names in use, lower-cased since Excel
+// compares them without case
+type xlsxSheetNames map[string]bool
+
+// add makes name a valid sheet name with xlsxSheetName and, if it is
+// already taken, suffixes it with a counter
+func (names xlsxSheetNames) add(name string) string {
+	base := xlsxSheetName(name)
+	name = base
+	for n := 2; names[strings.ToLower(name)]; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		name = truncateRunes(base, excelize.MaxSheetNameLength-len(suffix)) + suffix
+	}
+	names[strings.ToLower(name)] = true
+	return name
+}
+
+// xlsxSheetName returns name with the characters Excel forbids in sheet
+// names replaced, truncated to 31 characters. Empty names become "Sheet".
+func xlsxSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '\\', '/', '?', '*', '[', ']':
+			return '_'
+		}
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, name)
+	// Names may not start or end with an apostrophe
+	name = strings.Trim(name, "'")
+	name = strings.TrimSpace(truncateRunes(name, excelize.MaxSheetNameLength))
+	if name == "" {
+		return "Sheet"
+	}
+	return name
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// labelSeries is the data points sharing one label set
+type labelSeries struct {
+	labels map[string]string
+	keys   []string
+	points []models.MetricData
+}
+
+// sheetName names a series by its label values in key order
+func (sr *labelSeries) sheetName() string {
+	if len(sr.keys) == 0 {
+		return "No labels"
+	}
+	values := make([]string, len(sr.keys))
+	for i, key := range sr.keys {
+		values[i] = sr.labels[key]
+	}
+	return strings.Join(values, ", ")
+}
+
+// groupSeries splits data points by label set, ordered by their formatted
+// labels with points in their original order
+func groupSeries(data []models.MetricData) []*labelSeries {
+	byKey := make(map[string]*labelSeries)
+	var order []string
+	for _, d := range data {
+		key := formatLabels(d.Labels)
+		sr, ok := byKey[key]
+		if !ok {
+			keys := make([]string, 0, len(d.Labels))
+			for k := range d.Labels {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			sr = &labelSeries{labels: d.Labels, keys: keys}
+			byKey[key] = sr
+			order = append(order, key)
+		}
+		sr.points = append(sr.points, d)
+	}
+
+	sort.Strings(order)
+	out := make([]*labelSeries, len(order))
+	for i, key := range order {
+		out[i] = byKey[key]
+	}
+	return out
+}
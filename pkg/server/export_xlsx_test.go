@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+func TestXLSXExporter(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	response := &models.MetricsResponse{
+		Application: "test-app",
+		Project:     "test-project",
+		Graph:       "request-rate",
+		Data: []models.MetricData{
+			{Timestamp: t0, Value: 1.5, Labels: map[string]string{"pod": "pod-2", "status": "500"}},
+			{Timestamp: t0, Value: 100.25, Labels: map[string]string{"pod": "pod-1"}},
+			{Timestamp: t0.Add(time.Minute), Value: 2.5, Labels: map[string]string{"pod": "pod-2", "status": "500"}},
+			{Timestamp: t0, Value: math.NaN(), Labels: nil},
+		},
+	}
+
+	var buf bytes.Buffer
+	opts := ExportOptions{ExportedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}
+	if err := (xlsxExporter{}).Export(&buf, response, opts); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("Failed to open workbook: %v", err)
+	}
+	defer f.Close()
+
+	names := f.GetSheetList()
+	expected := []string{"Metadata", "No labels", "pod-1", "pod-2, 500"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected sheets %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected sheets %v, got %v", expected, names)
+			break
+		}
+	}
+
+	// Metadata sheet
+	rows, err := f.GetRows("Metadata")
+	if err != nil {
+		t.Fatalf("Failed to read metadata sheet: %v", err)
+	}
+	meta := make(map[string]string)
+	for _, row := range rows {
+		if len(row) == 2 {
+			meta[row[0]] = row[1]
+		}
+	}
+	if meta["Application"] != "test-app" || meta["Project"] != "test-project" || meta["Graph"] != "request-rate" {
+		t.Errorf("Unexpected metadata: %v", meta)
+	}
+	if meta["Exported At"] != "2024-01-02 00:00:00" {
+		t.Errorf("Expected export time 2024-01-02 00:00:00, got %q", meta["Exported At"])
+	}
+	if meta["Data Points"] != "4" {
+		t.Errorf("Expected 4 data points, got %q", meta["Data Points"])
+	}
+	if meta["pod-2, 500"] != `pod="pod-2",status="500"` {
+		t.Errorf("Expected series index entry, got %q", meta["pod-2, 500"])
+	}
+
+	// Series sheet with two points and constant label columns
+	rows, err = f.GetRows("pod-2, 500")
+	if err != nil {
+		t.Fatalf("Failed to read series sheet: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Expected header and 2 rows, got %d rows", len(rows))
+	}
+	header := []string{"Timestamp", "Value", "pod", "status"}
+	for i, name := range header {
+		if rows[0][i] != name {
+			t.Errorf("Expected header %v, got %v", header, rows[0])
+			break
+		}
+	}
+	if style, _ := f.GetCellStyle("pod-2, 500", "A1"); style == 0 {
+		t.Error("Expected a bold header style")
+	}
+	if rows[2][0] != "2024-01-01 12:01:00" {
+		t.Errorf("Expected datetime 2024-01-01 12:01:00, got %q", rows[2][0])
+	}
+	if raw, _ := f.GetCellValue("pod-2, 500", "A3", excelize.Options{RawCellValue: true}); raw == rows[2][0] {
+		t.Errorf("Expected a serial date behind the formatted value, got %q", raw)
+	}
+	if rows[2][1] != "2.5" {
+		t.Errorf("Expected value 2.5, got %q", rows[2][1])
+	}
+	if rows[2][3] != "500" {
+		t.Errorf("Expected status 500, got %q", rows[2][3])
+	}
+
+	// NaN has no Excel number, so it is written as text
+	if v, _ := f.GetCellValue("No labels", "B2"); v != "NaN" {
+		t.Errorf("Expected NaN as text, got %q", v)
+	}
+}
+
+// TestXLSXExporter_RowLimit splits a series that does not fit on one sheet
+// across several, each indexed on the metadata sheet
+func TestXLSXExporter_RowLimit(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	response := &models.MetricsResponse{Application: "test-app"}
+	for i := 0; i < 5; i++ {
+		response.Data = append(response.Data, models.MetricData{
+			Timestamp: t0.Add(time.Duration(i) * time.Minute),
+			Value:     float64(i),
+			Labels:    map[string]string{"pod": "pod-1"},
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := (xlsxExporter{maxRows: 3}).Export(&buf, response, ExportOptions{ExportedAt: t0}); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("Failed to open workbook: %v", err)
+	}
+	defer f.Close()
+
+	expected := []string{"Metadata", "pod-1", "pod-1 (2)", "pod-1 (3)"}
+	if names := f.GetSheetList(); strings.Join(names, "|") != strings.Join(expected, "|") {
+		t.Fatalf("Expected sheets %v, got %v", expected, names)
+	}
+
+	var values []string
+	for _, name := range expected[1:] {
+		rows, err := f.GetRows(name)
+		if err != nil {
+			t.Fatalf("Failed to read sheet %s: %v", name, err)
+		}
+		if len(rows) > 3 || rows[0][0] != "Timestamp" {
+			t.Errorf("Sheet %s: expected a header and at most 2 rows, got %v", name, rows)
+		}
+		for _, row := range rows[1:] {
+			values = append(values, row[1])
+		}
+	}
+	if got := strings.Join(values, ","); got != "0,1,2,3,4" {
+		t.Errorf("Expected every point once in order, got %s", got)
+	}
+
+	rows, err := f.GetRows("Metadata")
+	if err != nil {
+		t.Fatalf("Failed to read metadata sheet: %v", err)
+	}
+	index := make(map[string]string)
+	for _, row := range rows {
+		if len(row) == 2 {
+			index[row[0]] = row[1]
+		}
+	}
+	for _, name := range expected[1:] {
+		if index[name] != `pod="pod-1"` {
+			t.Errorf("Expected index entry for %s, got %q", name, index[name])
+		}
+	}
+	if index["Series"] != "1" {
+		t.Errorf("Expected 1 series, got %q", index["Series"])
+	}
+}
+
+func TestXLSXSheetName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"pod-1, 500", "pod-1, 500"},
+		{"a/b:c[d]*?\\", "a_b_c_d____"},
+		{"'quoted'", "quoted"},
+		{"", "Sheet"},
+		{strings.Repeat("x", 40), strings.Repeat("x", excelize.MaxSheetNameLength)},
+	}
+
+	for _, tt := range tests {
+		if got := xlsxSheetName(tt.name); got != tt.expected {
+			t.Errorf("xlsxSheetName(%q) = %q, expected %q", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestXLSXSheetNames_Unique(t *testing.T) {
+	names := xlsxSheetNames{"history": true}
+	long := strings.Repeat("x", 40)
+
+	got := []string{names.add("Series"), names.add("series"), names.add(long), names.add(long), names.add("History")}
+	expected := []string{"Series", "series (2)", strings.Repeat("x", 31), strings.Repeat("x", 27) + " (2)", "History (2)"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestXLSXExporter_Registered(t *testing.T) {
+	e, ok := exporters.Negotiate("", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	if !ok || e.Name() != "xlsx" {
+		t.Errorf("Expected xlsx exporter for the spreadsheet media type, got %v", e)
+	}
+}
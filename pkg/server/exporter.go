@@ -0,0 +1,374 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+)
+
+// defaultExportFormat is used when a request names neither a format nor an
+// acceptable media type
+const defaultExportFormat = "json"
+
+// Exporter writes a metrics response in one export format. Formats register
+// themselves with RegisterExporter and are selected by handleExportMetrics.
+type Exporter interface {
+	// Name is the value of the format query parameter selecting the exporter
+	Name() string
+	// ContentType is the media type of the output, matched against Accept
+	ContentType() string
+	// Extension is the file extension used for downloads
+	Extension() string
+	// Export writes the response to w
+	Export(w io.Writer, response *models.MetricsResponse, opts ExportOptions) error
+}
+
+// ExportOptions carries the request details an exporter may use
+type ExportOptions struct {
+	// Params holds the request query for format specific options
+	Params url.Values
+	// ExportedAt is the export time recorded in metadata
+	ExportedAt time.Time
+
+	// stream flushes a streamed export to the client as points are
+	// written; nil for buffered exports
+	stream *streamFlusher
+}
+
+// streamFlushPoints is how many points a streamed export writes between
+// flushes to the client
+const streamFlushPoints = 500
+
+// streamFlusher sends a streamed export to the client every few points, so
+// rows reach it while the provider is still yielding them instead of all at
+// once when the buffers fill or the export ends
+type streamFlusher struct {
+	every int
+	n     int
+	flush func() error
+}
+
+// point counts a written point and, every so many points, flushes the
+// exporter's own buffer with flushBuffer and then the response. It is a
+// no-op on a nil flusher.
+func (f *streamFlusher) point(flushBuffer func() error) error {
+	if f == nil {
+		return nil
+	}
+	f.n++
+	if f.n%f.every != 0 {
+		return nil
+	}
+	if err := flushBuffer(); err != nil {
+		return err
+	}
+	return f.flush()
+}
+
+// optionsValidator is implemented by exporters that take options, so bad
+// parameters are rejected before the provider is queried
+type optionsValidator interface {
+	ValidateOptions(params url.Values) error
+}
+
+// pointSource yields the points of an export in order. It may be called
+// more than once, each call replaying every point.
+type pointSource func(yield func(models.MetricData) error) error
+
+// pointsExporter is implemented by exporters that can write points read
+// from a source instead of a response held in memory, so export jobs keep a
+// single chunk in memory and streaming providers are exported as their
+// points arrive. exportsPoints reports whether the options allow
+// it, as some layouts need every point at once.
+type pointsExporter interface {
+	exportsPoints(params url.Values) bool
+	exportPoints(w io.Writer, header *models.MetricsResponse, points pointSource, opts ExportOptions) error
+}
+
+// pointReplayer is implemented by points exporters that read their source
+// more than once, so streamed exports spool the points for them
+type pointReplayer interface {
+	replaysPoints() bool
+}
+
+// ExporterRegistry holds the available export formats in registration order
+type ExporterRegistry struct {
+	mu        sync.RWMutex
+	exporters []Exporter
+}
+
+// exporters is the registry used by handleExportMetrics
+var exporters = &ExporterRegistry{}
+
+// RegisterExporter adds an export format to the default registry
+func RegisterExporter(e Exporter) {
+	if err := exporters.Register(e); err != nil {
+		panic(err)
+	}
+}
+
+// Register adds an export format. Names must be unique.
+func (reg *ExporterRegistry) Register(e Exporter) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, existing := range reg.exporters {
+		if existing.Name() == e.Name() {
+			return fmt.Errorf("exporter %q already registered", e.Name())
+		}
+	}
+	reg.exporters = append(reg.exporters, e)
+	return nil
+}
+
+// Lookup returns the exporter registered under name
+func (reg *ExporterRegistry) Lookup(name string) (Exporter, bool) {
+	for _, e := range reg.Exporters() {
+		if e.Name() == name {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// Exporters returns the registered exporters in registration order
+func (reg *ExporterRegistry) Exporters() []Exporter {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	return append([]Exporter(nil), reg.exporters...)
+}
+
+// Names returns the registered format names in registration order
+func (reg *ExporterRegistry) Names() []string {
+	var names []string
+	for _, e := range reg.Exporters() {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// Negotiate selects an exporter from the format query parameter or, when it
+// is empty, the Accept header. It returns false if neither names a
+// registered format.
+func (reg *ExporterRegistry) Negotiate(format, accept string) (Exporter, bool) {
+	if format != "" {
+		return reg.Lookup(format)
+	}
+	if strings.TrimSpace(accept) == "" {
+		return reg.Lookup(defaultExportFormat)
+	}
+
+	registered := reg.Exporters()
+	for _, mediaRange := range parseAccept(accept) {
+		if mediaRange == "*/*" {
+			for _, e := range registered {
+				if e.Name() == defaultExportFormat {
+					return e, true
+				}
+			}
+			if len(registered) > 0 {
+				return registered[0], true
+			}
+			continue
+		}
+
+		for _, e := range registered {
+			if matchesMediaRange(e.ContentType(), mediaRange) {
+				return e, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// parseAccept returns the acceptable media ranges of an Accept header ordered
+// by quality. Ranges with q=0 are dropped; ties keep header order.
+func parseAccept(accept string) []string {
+	type weighted struct {
+		mediaRange string
+		q          float64
+	}
+
+	var ranges []weighted
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, weighted{mediaRange, q})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	out := make([]string, len(ranges))
+	for i, r := range ranges {
+		out[i] = r.mediaRange
+	}
+	return out
+}
+
+// matchesMediaRange reports whether a content type falls in a media range
+// such as "text/csv" or "application/*"
+func matchesMediaRange(contentType, mediaRange string) bool {
+	if base, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = base
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(contentType, strings.TrimSuffix(mediaRange, "*"))
+	}
+	return contentType == mediaRange
+}
+
+// exportCompressGzip is the compress parameter value for .gz downloads
+const exportCompressGzip = "gzip"
+
+// validateExportCompression checks the compress parameter
+func validateExportCompression(params url.Values) error {
+	if c := params.Get("compress"); c != "" && c != exportCompressGzip {
+		return fmt.Errorf("compress must be %s", exportCompressGzip)
+	}
+	return nil
+}
+
+// export writes a response with the given exporter
+func (s *Server) export(w http.ResponseWriter, e Exporter, response *models.MetricsResponse, params url.Values) {
+	s.writeExport(w, e, response.Application, params, 0, func(out io.Writer, opts ExportOptions) (int, error) {
+		return len(response.Data), e.Export(out, response, opts)
+	})
+}
+
+// writeExport writes the download produced by write, which returns the
+// number of points exported. The download headers are only set once write
+// produces output, so a failure up front is reported with an error status
+// instead of an empty download. With compress=gzip the download itself is a
+// gzip file (metrics_....csv.gz) rather than a gzip Content-Encoding, so it
+// is saved compressed. A positive flushEvery flushes the download to the
+// client every so many points for exporters that write point by point.
+func (s *Server) writeExport(w http.ResponseWriter, e Exporter, application string, params url.Values, flushEvery int, write func(io.Writer, ExportOptions) (int, error)) {
+	now := time.Now()
+	contentType, ext := e.ContentType(), e.Extension()
+	gzipped := params.Get("compress") == exportCompressGzip
+	if gzipped {
+		contentType, ext = "application/gzip", ext+".gz"
+	}
+
+	dst := &downloadWriter{w: w, start: func() {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf("attachment; filename=metrics_%s_%s.%s",
+				application, now.Format("20060102_150405"), ext))
+	}}
+	var out io.Writer = dst
+	var gz *gzip.Writer
+	if gzipped {
+		// gzip writes nothing until the first Write or Close
+		gz = gzip.NewWriter(dst)
+		out = gz
+	}
+
+	opts := ExportOptions{Params: params, ExportedAt: now}
+	if flushEvery > 0 {
+		rc := http.NewResponseController(w)
+		opts.stream = &streamFlusher{every: flushEvery, flush: func() error {
+			if gz != nil {
+				if err := gz.Flush(); err != nil {
+					return err
+				}
+			}
+			dst.begin()
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+			return nil
+		}}
+	}
+	rows, err := write(out, opts)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		s.logger.Error("failed to write export", "format", e.Name(), "error", err)
+		if !dst.started {
+			s.respondError(w, http.StatusInternalServerError, "export failed", err.Error())
+		}
+		return
+	}
+	dst.begin()
+
+	s.logger.Info("exported metrics",
+		"format", e.Name(),
+		"application", application,
+		"rows", rows)
+}
+
+// downloadWriter calls start before the first byte is written
+type downloadWriter struct {
+	w       io.Writer
+	start   func()
+	started bool
+}
+
+func (d *downloadWriter) begin() {
+	if !d.started {
+		d.started = true
+		d.start()
+	}
+}
+
+func (d *downloadWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	d.begin()
+	return d.w.Write(p)
+}
+
+// exportFormat describes an export format for discovery
+type exportFormat struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Extension   string `json:"extension"`
+	Default     bool   `json:"default"`
+}
+
+// handleExportFormats lists the registered export formats
+func (s *Server) handleExportFormats(w http.ResponseWriter, r *http.Request) {
+	registered := exporters.Exporters()
+	formats := make([]exportFormat, len(registered))
+	for i, e := range registered {
+		formats[i] = exportFormat{
+			Name:        e.Name(),
+			ContentType: e.ContentType(),
+			Extension:   e.Extension(),
+			Default:     e.Name() == defaultExportFormat,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"formats": formats,
+	})
+}
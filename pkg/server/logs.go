@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+// Log line limits of the logs endpoint
+const (
+	defaultLogLimit = 100
+	maxLogLimit     = 5000
+)
+
+// handleLogs returns the most recent log lines of a log panel as JSON,
+// newest first. limit bounds the lines returned (default 100, at most
+// 5000); start, end and duration select the window as for exports.
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	limit := defaultLogLimit
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxLogLimit {
+			s.respondError(w, http.StatusBadRequest, "invalid parameter",
+				"limit must be between 1 and "+strconv.Itoa(maxLogLimit))
+			return
+		}
+		limit = n
+	}
+
+	timeRange, err := exportRangeFromQuery(params, time.Now())
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid parameter", err.Error())
+		return
+	}
+
+	query, ok := s.exportQuery(w, r)
+	if !ok {
+		return
+	}
+
+	querier, ok := s.provider.(providers.LogQuerier)
+	if !ok {
+		s.respondError(w, http.StatusNotImplemented, "log panels unavailable",
+			"the metrics provider does not serve log lines")
+		return
+	}
+
+	var tr *models.TimeRange
+	if timeRange != nil {
+		tr = &timeRange.TimeRange
+	}
+	response, err := querier.QueryLogs(r.Context(), query, tr, limit)
+	if errors.Is(err, providers.ErrNotSupported) {
+		// A Registry routed the query to a provider without log panels
+		s.respondError(w, http.StatusNotImplemented, "log panels unavailable",
+			"the metrics provider does not serve log lines")
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to query logs",
+			"application", query.Application,
+			"graph", query.Graph,
+			"error", err,
+		)
+		s.respondError(w, http.StatusInternalServerError, "query failed", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+)
+
+// fakeLogProvider returns limit log lines, recording the query it served
+type fakeLogProvider struct {
+	fakeProvider
+	limit int
+	r     *models.TimeRange
+}
+
+func (p *fakeLogProvider) QueryLogs(ctx context.Context, query *models.MetricsQuery, r *models.TimeRange, limit int) (*models.LogsResponse, error) {
+	p.limit, p.r = limit, r
+	response := &models.LogsResponse{Application: query.Application, Graph: query.Graph, Entries: []models.LogEntry{}}
+	for i := 0; i < limit; i++ {
+		response.Entries = append(response.Entries, models.LogEntry{Timestamp: time.Unix(int64(i), 0).UTC(), Line: "line"})
+	}
+	return response, nil
+}
+
+// unsupportedProvider fails its optional queries with ErrNotSupported, as a
+// Registry does when the routed provider lacks them
+type unsupportedProvider struct {
+	fakeProvider
+}
+
+func (p *unsupportedProvider) QueryLogs(ctx context.Context, query *models.MetricsQuery, r *models.TimeRange, limit int) (*models.LogsResponse, error) {
+	return nil, providers.ErrNotSupported
+}
+
+func (p *unsupportedProvider) ListGraphs(ctx context.Context, application, project string) ([]providers.GraphRef, error) {
+	return nil, providers.ErrNotSupported
+}
+
+func (p *unsupportedProvider) QueryRange(ctx context.Context, query *models.MetricsQuery, r models.TimeRange) (*models.MetricsResponse, error) {
+	return nil, providers.ErrNotSupported
+}
+
+func (p *unsupportedProvider) QueryStream(ctx context.Context, query *models.MetricsQuery, yield func(models.MetricData) error) error {
+	return providers.ErrNotSupported
+}
+
+func TestHandleLogs(t *testing.T) {
+	provider := &fakeLogProvider{}
+	srv := &Server{
+		logger:   testLogger,
+		provider: provider,
+	}
+
+	rr := httptest.NewRecorder()
+	srv.handleLogs(rr, newExportRequest(context.Background(),
+		"/logs?application_name=test-app&project=default"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+	var response models.LogsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Graph != "request-rate" || len(response.Entries) != defaultLogLimit || provider.r != nil {
+		t.Errorf("Unexpected response: graph %q, %d entries, range %v", response.Graph, len(response.Entries), provider.r)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handleLogs(rr, newExportRequest(context.Background(),
+		"/logs?application_name=test-app&project=default&limit=5&duration=15m"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+	if provider.limit != 5 || provider.r == nil || provider.r.End.Sub(provider.r.Start) != 15*time.Minute {
+		t.Errorf("Unexpected query: limit %d, range %v", provider.limit, provider.r)
+	}
+}
+
+func TestHandleLogs_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider providers.MetricsQuerier
+		target   string
+		status   int
+	}{
+		{"zero limit", &fakeLogProvider{}, "/logs?application_name=test-app&project=default&limit=0", http.StatusBadRequest},
+		{"limit too large", &fakeLogProvider{}, "/logs?application_name=test-app&project=default&limit=5001", http.StatusBadRequest},
+		{"invalid duration", &fakeLogProvider{}, "/logs?application_name=test-app&project=default&duration=soon", http.StatusBadRequest},
+		{"missing project", &fakeLogProvider{}, "/logs?application_name=test-app", http.StatusBadRequest},
+		{"no log panels", &fakeProvider{}, "/logs?application_name=test-app&project=default", http.StatusNotImplemented},
+		{"routed provider without log panels", &unsupportedProvider{}, "/logs?application_name=test-app&project=default", http.StatusNotImplemented},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &Server{
+				logger:   testLogger,
+				provider: tt.provider,
+			}
+			rr := httptest.NewRecorder()
+			srv.handleLogs(rr, newExportRequest(context.Background(), tt.target))
+			if rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rr.Code, rr.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Headers set by the Argo CD API server proxy extension on every forwarded request
+const (
+	HeaderArgoCDApplicationName = "Argocd-Application-Name"
+	HeaderArgoCDProjectName     = "Argocd-Project-Name"
+	HeaderArgoCDUsername        = "Argocd-Username"
+	HeaderArgoCDUserGroups      = "Argocd-User-Groups"
+)
+
+// ArgoCDIdentity is the caller identity forwarded by the Argo CD proxy extension
+type ArgoCDIdentity struct {
+	Application  string   `json:"application"`
+	AppNamespace string   `json:"app_namespace,omitempty"`
+	Project      string   `json:"project"`
+	Username     string   `json:"username"`
+	Groups       []string `json:"groups,omitempty"`
+}
+
+type identityContextKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the given identity
+func WithIdentity(ctx context.Context, identity *ArgoCDIdentity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// IdentityFromContext returns the Argo CD identity stored by ArgoCDAuth, if any
+func IdentityFromContext(ctx context.Context) (*ArgoCDIdentity, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(*ArgoCDIdentity)
+	return identity, ok && identity != nil
+}
+
+// ArgoCDAuth validates the identity headers forwarded by the Argo CD proxy extension
+type ArgoCDAuth struct {
+	logger *slog.Logger
+}
+
+// NewArgoCDAuth creates a new Argo CD proxy authentication middleware
+func NewArgoCDAuth(logger *slog.Logger) *ArgoCDAuth {
+	return &ArgoCDAuth{
+		logger: logger.With("component", "argocd-auth"),
+	}
+}
+
+// Authenticate returns a middleware that requires the Argo CD proxy headers,
+// checks that they match the application and project being requested and
+// stores the resulting identity in the request context.
+//
+// Missing headers are rejected with 401 and mismatches with 403. The
+// {application} URL parameter is only resolved once chi has routed the
+// request, so the middleware should be attached to routes with r.With or
+// inside r.Route rather than at the top-level router.
+func (a *ArgoCDAuth) Authenticate() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, missing := parseArgoCDHeaders(r.Header)
+			if missing != "" {
+				a.logger.Warn("missing argocd proxy header",
+					"header", missing,
+					"path", r.URL.Path,
+				)
+				http.Error(w, "Missing required header "+missing, http.StatusUnauthorized)
+				return
+			}
+
+			if field, value := mismatch(identity, r); field != "" {
+				a.logger.Warn("request does not match argocd identity",
+					"field", field,
+					"requested", value,
+					"application", identity.Application,
+					"project", identity.Project,
+					"username", identity.Username,
+					"path", r.URL.Path,
+				)
+				http.Error(w, "Requested "+field+" does not match Argo CD identity", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+		})
+	}
+}
+
+// parseArgoCDHeaders builds an identity from the proxy headers, returning the
+// name of the first required header that is missing
+func parseArgoCDHeaders(h http.Header) (*ArgoCDIdentity, string) {
+	appHeader := strings.TrimSpace(h.Get(HeaderArgoCDApplicationName))
+	if appHeader == "" {
+		return nil, HeaderArgoCDApplicationName
+	}
+	project := strings.TrimSpace(h.Get(HeaderArgoCDProjectName))
+	if project == "" {
+		return nil, HeaderArgoCDProjectName
+	}
+	username := strings.TrimSpace(h.Get(HeaderArgoCDUsername))
+	if username == "" {
+		return nil, HeaderArgoCDUsername
+	}
+
+	identity := &ArgoCDIdentity{
+		Application: appHeader,
+		Project:     project,
+		Username:    username,
+	}
+
+	// Argo CD sends the application as "<namespace>:<name>"
+	if ns, name, ok := strings.Cut(appHeader, ":"); ok {
+		identity.AppNamespace = ns
+		identity.Application = name
+	}
+
+	for _, g := range strings.Split(h.Get(HeaderArgoCDUserGroups), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			identity.Groups = append(identity.Groups, g)
+		}
+	}
+
+	return identity, ""
+}
+
+// mismatch compares the application and project named in the path and query
+// against the identity, returning the first mismatching field and its value
+func mismatch(identity *ArgoCDIdentity, r *http.Request) (string, string) {
+	if app := chi.URLParam(r, "application"); app != "" && !identity.matchesApplication(app) {
+		return "application", app
+	}
+
+	query := r.URL.Query()
+	if app := query.Get("application_name"); app != "" && !identity.matchesApplication(app) {
+		return "application", app
+	}
+	if project := query.Get("project"); project != "" && project != identity.Project {
+		return "project", project
+	}
+
+	return "", ""
+}
+
+// matchesApplication accepts either the bare application name or the
+// namespace-qualified "<namespace>:<name>" form
+func (i *ArgoCDIdentity) matchesApplication(app string) bool {
+	if app == i.Application {
+		return true
+	}
+	return i.AppNamespace != "" && app == i.AppNamespace+":"+i.Application
+}
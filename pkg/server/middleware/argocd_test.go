@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func setArgoCDHeaders(req *http.Request, app, project, user, groups string) {
+	if app != "" {
+		req.Header.Set(HeaderArgoCDApplicationName, app)
+	}
+	if project != "" {
+		req.Header.Set(HeaderArgoCDProjectName, project)
+	}
+	if user != "" {
+		req.Header.Set(HeaderArgoCDUsername, user)
+	}
+	if groups != "" {
+		req.Header.Set(HeaderArgoCDUserGroups, groups)
+	}
+}
+
+func TestArgoCDAuth_Authenticate(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	auth := NewArgoCDAuth(logger)
+
+	var got *ArgoCDIdentity
+	router := chi.NewRouter()
+	router.With(auth.Authenticate()).Get("/api/applications/{application}/export",
+		func(w http.ResponseWriter, r *http.Request) {
+			got, _ = IdentityFromContext(r.Context())
+			w.WriteHeader(http.StatusOK)
+		})
+
+	tests := []struct {
+		name           string
+		path           string
+		app            string
+		project        string
+		user           string
+		groups         string
+		expectedStatus int
+	}{
+		{
+			name:           "Valid request",
+			path:           "/api/applications/guestbook/export?application_name=guestbook&project=default",
+			app:            "argocd:guestbook",
+			project:        "default",
+			user:           "alice",
+			groups:         "devs, ops",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Namespace-qualified query",
+			path:           "/api/applications/guestbook/export?application_name=argocd:guestbook",
+			app:            "argocd:guestbook",
+			project:        "default",
+			user:           "alice",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Missing username",
+			path:           "/api/applications/guestbook/export",
+			app:            "argocd:guestbook",
+			project:        "default",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Missing application",
+			path:           "/api/applications/guestbook/export",
+			project:        "default",
+			user:           "alice",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Path application mismatch",
+			path:           "/api/applications/other/export",
+			app:            "argocd:guestbook",
+			project:        "default",
+			user:           "alice",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Query application mismatch",
+			path:           "/api/applications/guestbook/export?application_name=other",
+			app:            "argocd:guestbook",
+			project:        "default",
+			user:           "alice",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Project mismatch",
+			path:           "/api/applications/guestbook/export?project=prod",
+			app:            "argocd:guestbook",
+			project:        "default",
+			user:           "alice",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest("GET", tt.path, nil)
+			setArgoCDHeaders(req, tt.app, tt.project, tt.user, tt.groups)
+			rr := httptest.NewRecorder()
+
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if tt.expectedStatus != http.StatusOK && got != nil {
+				t.Error("Handler should not run for rejected requests")
+			}
+		})
+	}
+}
+
+func TestArgoCDAuth_Identity(t *testing.T) {
+	identity, missing := parseArgoCDHeaders(http.Header{
+		HeaderArgoCDApplicationName: {"argocd:guestbook"},
+		HeaderArgoCDProjectName:     {"default"},
+		HeaderArgoCDUsername:        {"alice"},
+		HeaderArgoCDUserGroups:      {"devs, ops,"},
+	})
+	if missing != "" {
+		t.Fatalf("Unexpected missing header %s", missing)
+	}
+
+	expected := &ArgoCDIdentity{
+		Application:  "guestbook",
+		AppNamespace: "argocd",
+		Project:      "default",
+		Username:     "alice",
+		Groups:       []string{"devs", "ops"},
+	}
+	if !reflect.DeepEqual(identity, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, identity)
+	}
+}
+
+func TestRateLimiter_PerUserKey(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	rl := NewRateLimiter(1, time.Minute, logger)
+	auth := NewArgoCDAuth(logger)
+	verifier, err := NewProxyVerifier(ProxyVerifierConfig{SharedSecret: "s3cret"}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+
+	// Usernames are only trusted once the proxy is verified
+	handler := verifier.Verify()(auth.Authenticate()(rl.RateLimit()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))))
+
+	do := func(user string) int {
+		// All requests arrive from the Argo CD server pod
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "10.0.0.5:1234"
+		setArgoCDHeaders(req, "argocd:guestbook", "default", user, "")
+		if err := SignRequest(req, "s3cret", time.Now()); err != nil {
+			t.Fatalf("Failed to sign request: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := do("alice"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := do("bob"); code != http.StatusOK {
+		t.Errorf("Different user should have its own bucket, got %d", code)
+	}
+	if code := do("alice"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, code)
+	}
+}
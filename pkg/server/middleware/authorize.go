@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/rbac"
+)
+
+// Authorize returns a middleware that checks the Argo CD identity against the
+// RBAC policy for the requested {groupkind} and {graph} before the handler
+// queries the provider. It must run after ArgoCDAuth.Authenticate, on routes
+// where chi has resolved the URL parameters. Requests without an identity are
+// denied.
+func Authorize(enforcer *rbac.Enforcer) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := IdentityFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			decision := enforcer.Enforce(rbac.Request{
+				User:        identity.Username,
+				Groups:      identity.Groups,
+				Project:     identity.Project,
+				Application: identity.Application,
+				GroupKind:   chi.URLParam(r, "groupkind"),
+				Graph:       chi.URLParam(r, "graph"),
+			})
+			if !decision.Allowed {
+				http.Error(w, "Forbidden: not permitted to view this graph", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type graphAuthorizerKey struct{}
+
+// AuthorizeEach returns a middleware for routes that cover several graphs,
+// such as bundle exports, where no single {graph} can be checked up front.
+// It stores a per-graph check in the request context for handlers to call
+// through GraphAllowed. Requests without an identity are denied.
+func AuthorizeEach(enforcer *rbac.Enforcer) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := IdentityFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			allowed := func(groupKind, graph string) bool {
+				return enforcer.Enforce(rbac.Request{
+					User:        identity.Username,
+					Groups:      identity.Groups,
+					Project:     identity.Project,
+					Application: identity.Application,
+					GroupKind:   groupKind,
+					Graph:       graph,
+				}).Allowed
+			}
+			ctx := context.WithValue(r.Context(), graphAuthorizerKey{}, allowed)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// AllowAllGraphs returns a middleware for multi-graph routes that run
+// without an RBAC policy, such as deployments without Argo CD RBAC. It
+// records that every graph may be viewed, since GraphAllowed denies graphs on
+// routes with neither AuthorizeEach nor AllowAllGraphs.
+func AllowAllGraphs() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed := func(groupKind, graph string) bool { return true }
+			ctx := context.WithValue(r.Context(), graphAuthorizerKey{}, allowed)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GraphAllowed reports whether the caller may view a graph, as decided by
+// AuthorizeEach or AllowAllGraphs. Without either, no graph is allowed.
+func GraphAllowed(ctx context.Context, groupKind, graph string) bool {
+	allowed, ok := ctx.Value(graphAuthorizerKey{}).(func(string, string) bool)
+	return ok && allowed(groupKind, graph)
+}
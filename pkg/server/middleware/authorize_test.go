@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/rbac"
+)
+
+func TestAuthorize(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	policy, err := rbac.ParsePolicy(strings.NewReader(`
+p, role:dev, default/*, */request-rate, allow
+g, devs, role:dev
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	enforcer := rbac.NewEnforcer(policy, logger)
+
+	router := chi.NewRouter()
+	router.With(NewArgoCDAuth(logger).Authenticate(), Authorize(enforcer)).
+		Get("/api/applications/{application}/groupkinds/{groupkind}/rows/{row}/graphs/{graph}",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+	tests := []struct {
+		name           string
+		graph          string
+		groups         string
+		expectedStatus int
+	}{
+		{"Allowed graph", "request-rate", "devs", http.StatusOK},
+		{"Denied graph", "cost", "devs", http.StatusForbidden},
+		{"No matching group", "request-rate", "others", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/applications/guestbook/groupkinds/deployment/rows/http/graphs/"+tt.graph, nil)
+			setArgoCDHeaders(req, "argocd:guestbook", "default", "alice", tt.groups)
+			rr := httptest.NewRecorder()
+
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestAuthorize_RequiresIdentity(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	enforcer := rbac.NewEnforcer(nil, logger)
+
+	handler := Authorize(enforcer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/test", nil))
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, rr.Code)
+	}
+}
+
+func TestAuthorizeEach(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	policy, err := rbac.ParsePolicy(strings.NewReader(`
+p, role:dev, default/*, deployment/*, allow
+p, role:dev, */*, */cost, deny
+g, devs, role:dev
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	enforcer := rbac.NewEnforcer(policy, logger)
+
+	var got []bool
+	router := chi.NewRouter()
+	router.With(NewArgoCDAuth(logger).Authenticate(), AuthorizeEach(enforcer)).
+		Get("/api/applications/{application}/export/bundle", func(w http.ResponseWriter, r *http.Request) {
+			got = []bool{
+				GraphAllowed(r.Context(), "deployment", "request-rate"),
+				GraphAllowed(r.Context(), "deployment", "cost"),
+				GraphAllowed(r.Context(), "pod", "request-rate"),
+			}
+		})
+
+	req := httptest.NewRequest("GET", "/api/applications/guestbook/export/bundle", nil)
+	setArgoCDHeaders(req, "argocd:guestbook", "default", "alice", "devs")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	want := []bool{true, false, false}
+	if rr.Code != http.StatusOK || len(got) != len(want) {
+		t.Fatalf("status %d, decisions %v", rr.Code, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("decision %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if GraphAllowed(httptest.NewRequest("GET", "/", nil).Context(), "pod", "cost") {
+		t.Error("graphs should be denied on routes without AuthorizeEach or AllowAllGraphs")
+	}
+}
+
+func TestAllowAllGraphs(t *testing.T) {
+	var allowed bool
+	handler := AllowAllGraphs()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed = GraphAllowed(r.Context(), "pod", "cost")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if !allowed {
+		t.Error("graphs should be allowed on routes using AllowAllGraphs")
+	}
+}
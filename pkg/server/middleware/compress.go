@@ -0,0 +1,337 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Defaults for CompressConfig
+const (
+	defaultCompressMinSize = 1024
+	// zstdWindowSize is the largest window browsers are required to decode
+	zstdWindowSize = 8 << 20
+)
+
+// defaultCompressibleTypes are the media types compressed by default. Binary
+// export formats (Parquet, Arrow, Avro, XLSX, ZIP) are compressed already or
+// gain little.
+var defaultCompressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/x-ndjson",
+	"application/openmetrics-text",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// CompressConfig configures response compression
+type CompressConfig struct {
+	// MinSize is the smallest body compressed, in bytes (default 1024).
+	// Smaller bodies are sent as they are, unless the handler flushes first.
+	MinSize int `yaml:"minSize" json:"minSize"`
+	// Level is the gzip level (default gzip.DefaultCompression)
+	Level int `yaml:"level" json:"level"`
+	// ZstdLevel is the zstd level, from 1 (fastest) to 4 (best compression),
+	// as defined by zstd.EncoderLevel (default 2)
+	ZstdLevel int `yaml:"zstdLevel" json:"zstdLevel"`
+	// ContentTypes lists compressible media types; entries ending in "/"
+	// match a whole top-level type
+	ContentTypes []string `yaml:"contentTypes" json:"contentTypes"`
+}
+
+// EncoderFunc returns a writer compressing into w
+type EncoderFunc func(w io.Writer) (io.WriteCloser, error)
+
+type encoding struct {
+	name string
+	new  EncoderFunc
+	pool *sync.Pool
+}
+
+// Compressor compresses responses with the best encoding the client accepts
+// in its Accept-Encoding header
+type Compressor struct {
+	cfg       CompressConfig
+	mu        sync.RWMutex
+	encodings []*encoding
+	logger    *slog.Logger
+}
+
+// NewCompressor creates a compressor supporting zstd and gzip, preferring
+// zstd when the client accepts both equally. Further encodings can be added
+// with RegisterEncoding.
+func NewCompressor(cfg CompressConfig, logger *slog.Logger) *Compressor {
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = defaultCompressMinSize
+	}
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+	if cfg.ZstdLevel == 0 {
+		cfg.ZstdLevel = int(zstd.SpeedDefault)
+	}
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = defaultCompressibleTypes
+	}
+
+	c := &Compressor{
+		cfg:    cfg,
+		logger: logger.With("component", "compress"),
+	}
+	level := cfg.Level
+	c.RegisterEncoding("gzip", func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	})
+	zstdLevel := zstd.EncoderLevel(cfg.ZstdLevel)
+	c.RegisterEncoding("zstd", func(w io.Writer) (io.WriteCloser, error) {
+		// One goroutine per response, and a window browsers can decode
+		return zstd.NewWriter(w,
+			zstd.WithEncoderLevel(zstdLevel),
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithWindowSize(zstdWindowSize))
+	})
+	return c
+}
+
+// RegisterEncoding adds a content coding. When the client accepts several
+// codings equally, the most recently registered is preferred, so encodings
+// added after gzip win over it.
+func (c *Compressor) RegisterEncoding(name string, fn EncoderFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	enc := &encoding{name: strings.ToLower(name), new: fn, pool: &sync.Pool{}}
+	for i, e := range c.encodings {
+		if e.name == enc.name {
+			c.encodings = append(c.encodings[:i], c.encodings[i+1:]...)
+			break
+		}
+	}
+	c.encodings = append([]*encoding{enc}, c.encodings...)
+}
+
+// Compress returns a middleware that compresses compressible responses of at
+// least MinSize bytes. Streaming handlers keep working: flushing commits to
+// compression and flushes the encoder.
+func (c *Compressor) Compress() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			enc := c.negotiate(r.Header.Get("Accept-Encoding"))
+			if enc == nil || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, compressor: c, enc: enc}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiate picks the accepted encoding with the highest q value, or nil
+func (c *Compressor) negotiate(header string) *encoding {
+	if header == "" {
+		return nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	type candidate struct {
+		enc *encoding
+		q   float64
+	}
+	var candidates []candidate
+	for _, enc := range c.encodings {
+		q, ok := accepted[enc.name]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > 0 {
+			candidates = append(candidates, candidate{enc, q})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	return candidates[0].enc
+}
+
+// compressible reports whether responses of contentType should be compressed
+func (c *Compressor) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range c.cfg.ContentTypes {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter buffers the start of a response until it knows whether to
+// compress it
+type compressWriter struct {
+	http.ResponseWriter
+	compressor *Compressor
+	enc        *encoding
+
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	encoder io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 && status >= 200 {
+		cw.status = status
+	} else if status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf.Write(p)
+	if cw.buf.Len() >= cw.compressor.cfg.MinSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush commits to compressing, as more data is evidently on its way, and
+// flushes it to the client
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		if err := cw.decide(true); err != nil {
+			return
+		}
+	}
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide writes the headers and buffered data, compressed if large enough
+// and of a compressible type
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	h := cw.Header()
+
+	if h.Get("Content-Type") == "" && cw.buf.Len() > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf.Bytes()))
+	}
+	compressible := cw.compressor.compressible(h.Get("Content-Type"))
+	if compressible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+
+	if large && compressible && h.Get("Content-Encoding") == "" &&
+		cw.status != http.StatusNoContent && cw.status != http.StatusNotModified &&
+		cw.status != http.StatusPartialContent {
+		encoder, err := cw.newEncoder()
+		if err != nil {
+			cw.compressor.logger.Error("failed to create encoder", "encoding", cw.enc.name, "error", err)
+		} else {
+			cw.encoder = encoder
+			h.Set("Content-Encoding", cw.enc.name)
+			h.Del("Content-Length")
+			// A strong validator no longer matches the encoded bytes
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+	return err
+}
+
+func (cw *compressWriter) newEncoder() (io.WriteCloser, error) {
+	if pooled, ok := cw.enc.pool.Get().(io.WriteCloser); ok {
+		if r, ok := pooled.(interface{ Reset(io.Writer) }); ok {
+			r.Reset(cw.ResponseWriter)
+			return pooled, nil
+		}
+	}
+	return cw.enc.new(cw.ResponseWriter)
+}
+
+// close finishes the response once the handler returns
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 {
+			// The handler wrote nothing; let net/http send its default
+			return
+		}
+		cw.decide(false)
+	}
+	if cw.encoder != nil {
+		if err := cw.encoder.Close(); err != nil {
+			cw.compressor.logger.Debug("failed to finish compressed response", "error", err)
+		}
+		if _, ok := cw.encoder.(interface{ Reset(io.Writer) }); ok {
+			cw.enc.pool.Put(cw.encoder)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func newTestCompressor() *Compressor {
+	return NewCompressor(CompressConfig{MinSize: 100}, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+}
+
+func serveCompressed(c *Compressor, acceptEncoding string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rr := httptest.NewRecorder()
+	c.Compress()(handler).ServeHTTP(rr, req)
+	return rr
+}
+
+func gunzip(t *testing.T, body io.Reader) string {
+	t.Helper()
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		t.Fatalf("invalid gzip: %v", err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("invalid gzip: %v", err)
+	}
+	return string(out)
+}
+
+func unzstd(t *testing.T, body io.Reader) string {
+	t.Helper()
+	zr, err := zstd.NewReader(body)
+	if err != nil {
+		t.Fatalf("invalid zstd: %v", err)
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("invalid zstd: %v", err)
+	}
+	return string(out)
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("timestamp,value\n", 100)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		wantEncoding   string
+	}{
+		{"large CSV", "gzip, deflate, br", "text/csv", large, "gzip"},
+		{"small body", "gzip", "text/csv", "a,b\n", ""},
+		{"no Accept-Encoding", "", "text/csv", large, ""},
+		{"gzip refused", "gzip;q=0, identity", "text/csv", large, ""},
+		{"wildcard", "*", "application/json; charset=utf-8", large, "zstd"},
+		{"zstd preferred", "gzip, deflate, br, zstd", "text/csv", large, "zstd"},
+		{"gzip by q value", "zstd;q=0.5, gzip", "text/csv", large, "gzip"},
+		{"binary format", "gzip", "application/vnd.apache.parquet", large, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveCompressed(newTestCompressor(), tt.acceptEncoding, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				io.WriteString(w, tt.body)
+			})
+
+			if got := rr.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			body := rr.Body.String()
+			switch tt.wantEncoding {
+			case "gzip":
+				body = gunzip(t, rr.Body)
+			case "zstd":
+				body = unzstd(t, rr.Body)
+			}
+			if tt.wantEncoding != "" && rr.Header().Get("Content-Length") != "" {
+				t.Error("Content-Length kept for a compressed body")
+			}
+			if body != tt.body {
+				t.Errorf("body changed: got %d bytes, want %d", len(body), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestCompress_Streaming(t *testing.T) {
+	c := newTestCompressor()
+	var flushedBeforeEnd bool
+	rr := serveCompressed(c, "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		io.WriteString(w, `{"value":1}`+"\n")
+		w.(http.Flusher).Flush()
+		flushedBeforeEnd = w.(http.ResponseWriter).Header().Get("Content-Encoding") == "gzip"
+		io.WriteString(w, `{"value":2}`+"\n")
+	})
+
+	if !flushedBeforeEnd || !rr.Flushed {
+		t.Error("flush did not commit to compression and reach the client")
+	}
+	if got := gunzip(t, rr.Body); got != "{\"value\":1}\n{\"value\":2}\n" {
+		t.Errorf("body = %q", got)
+	}
+}
+
+func TestCompress_ZstdStreaming(t *testing.T) {
+	c := newTestCompressor()
+	for i := 0; i < 2; i++ {
+		// The second response reuses the pooled encoder
+		rr := serveCompressed(c, "zstd", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			io.WriteString(w, `{"value":1}`+"\n")
+			w.(http.Flusher).Flush()
+			io.WriteString(w, `{"value":2}`+"\n")
+		})
+		if rr.Header().Get("Content-Encoding") != "zstd" || !rr.Flushed {
+			t.Fatalf("response %d: Content-Encoding %q, flushed %v", i, rr.Header().Get("Content-Encoding"), rr.Flushed)
+		}
+		if got := unzstd(t, rr.Body); got != "{\"value\":1}\n{\"value\":2}\n" {
+			t.Errorf("response %d: body = %q", i, got)
+		}
+	}
+}
+
+func TestCompress_Status(t *testing.T) {
+	large := strings.Repeat("x", 200)
+	rr := serveCompressed(newTestCompressor(), "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Range", "bytes 0-199/1000")
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, large)
+	})
+	if rr.Code != http.StatusPartialContent || rr.Header().Get("Content-Encoding") != "" {
+		t.Errorf("partial content: status %d, encoding %q", rr.Code, rr.Header().Get("Content-Encoding"))
+	}
+
+	rr = serveCompressed(newTestCompressor(), "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, large)
+	})
+	if rr.Code != http.StatusServiceUnavailable || gunzip(t, rr.Body) != large {
+		t.Errorf("error response: status %d", rr.Code)
+	}
+}
+
+func TestCompress_RegisterEncoding(t *testing.T) {
+	c := newTestCompressor()
+	c.RegisterEncoding("deflate", func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.BestSpeed)
+	})
+	large := strings.Repeat("a", 500)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, large)
+	}
+
+	// Equal preference goes to the most recently registered encoding
+	rr := serveCompressed(c, "gzip, deflate", handler)
+	if rr.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatalf("Content-Encoding = %q, want deflate", rr.Header().Get("Content-Encoding"))
+	}
+	out, _ := io.ReadAll(flate.NewReader(rr.Body))
+	if string(out) != large {
+		t.Error("deflate body does not decode")
+	}
+
+	// Client q values still win
+	rr = serveCompressed(c, "gzip;q=1, deflate;q=0.5", handler)
+	if rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Content-Encoding = %q, want gzip", rr.Header().Get("Content-Encoding"))
+	}
+	if vary := rr.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("Vary = %q", vary)
+	}
+}
@@ -0,0 +1,295 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// IPFilterConfig holds CIDR allow and deny lists.
+// Bare IP addresses are accepted and treated as single-host prefixes.
+type IPFilterConfig struct {
+	Allow []string `yaml:"allow" json:"allow"`
+	Deny  []string `yaml:"deny" json:"deny"`
+	// TrustedProxies lists the proxies, such as an ingress controller, whose
+	// X-Forwarded-For and X-Real-IP headers name the client. The headers
+	// of other peers are ignored.
+	TrustedProxies []string `yaml:"trustedProxies" json:"trustedProxies"`
+}
+
+// IPFilterAction is the outcome of matching a client IP against the filter
+type IPFilterAction int
+
+const (
+	// IPNoMatch means the client matched no rule and is subject to normal rate limiting
+	IPNoMatch IPFilterAction = iota
+	// IPAllow means the client is allowlisted and is never throttled
+	IPAllow
+	// IPDeny means the client is denylisted and is rejected outright
+	IPDeny
+)
+
+func (a IPFilterAction) String() string {
+	switch a {
+	case IPAllow:
+		return "allow"
+	case IPDeny:
+		return "deny"
+	default:
+		return "none"
+	}
+}
+
+// IPFilter matches client IPs against CIDR allow and deny lists.
+// Deny rules take precedence over allow rules. Rules can be swapped at runtime
+// with Update, Reload or Watch without interrupting in-flight requests.
+type IPFilter struct {
+	rules  atomic.Pointer[ipRules]
+	logger *slog.Logger
+}
+
+type ipRules struct {
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+	trusted []netip.Prefix
+}
+
+// NewIPFilter creates a new IP filter from the given configuration
+func NewIPFilter(cfg IPFilterConfig, logger *slog.Logger) (*IPFilter, error) {
+	f := &IPFilter{
+		logger: logger.With("component", "ipfilter"),
+	}
+	if err := f.Update(cfg); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Update atomically replaces the filter rules. On error the previous rules are kept.
+func (f *IPFilter) Update(cfg IPFilterConfig) error {
+	allow, err := parsePrefixes(cfg.Allow)
+	if err != nil {
+		return fmt.Errorf("invalid allow rule: %w", err)
+	}
+	deny, err := parsePrefixes(cfg.Deny)
+	if err != nil {
+		return fmt.Errorf("invalid deny rule: %w", err)
+	}
+	trusted, err := parsePrefixes(cfg.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid trusted proxy: %w", err)
+	}
+
+	f.rules.Store(&ipRules{allow: allow, deny: deny, trusted: trusted})
+	f.logger.Info("ip filter rules loaded",
+		"allow", len(allow),
+		"deny", len(deny),
+		"trusted_proxies", len(trusted),
+	)
+	return nil
+}
+
+// Match returns the action for the given client IP and the rule that matched
+func (f *IPFilter) Match(clientIP string) (IPFilterAction, string) {
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return IPNoMatch, ""
+	}
+	addr = addr.Unmap()
+
+	rules := f.rules.Load()
+	for _, p := range rules.deny {
+		if p.Contains(addr) {
+			return IPDeny, p.String()
+		}
+	}
+	for _, p := range rules.allow {
+		if p.Contains(addr) {
+			return IPAllow, p.String()
+		}
+	}
+	return IPNoMatch, ""
+}
+
+// ClientIP returns the IP of the client making the request, honouring
+// forwarding headers only from the configured trusted proxies
+func (f *IPFilter) ClientIP(r *http.Request) string {
+	return trustedClientIP(r, f.rules.Load().trusted)
+}
+
+// Filter returns a middleware that rejects denylisted clients with 403.
+// Allowlisted and unmatched clients are passed through unchanged.
+func (f *IPFilter) Filter() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientIP := f.ClientIP(r)
+			if action, rule := f.Match(clientIP); action == IPDeny {
+				f.logDenied(r, clientIP, rule)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (f *IPFilter) logDenied(r *http.Request, clientIP, rule string) {
+	f.logger.Warn("request denied by ip filter",
+		"client_ip", clientIP,
+		"rule", rule,
+		"path", r.URL.Path,
+	)
+}
+
+// LoadIPFilterConfig reads an IP filter configuration from a YAML file
+func LoadIPFilterConfig(path string) (IPFilterConfig, error) {
+	var cfg IPFilterConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read ip filter config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse ip filter config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Reload reads the given file and replaces the filter rules
+func (f *IPFilter) Reload(path string) error {
+	cfg, err := LoadIPFilterConfig(path)
+	if err != nil {
+		return err
+	}
+	return f.Update(cfg)
+}
+
+// Watch polls the given file and reloads the rules whenever its modification
+// time changes, until ctx is cancelled. Invalid files are logged and ignored
+// so a bad edit never drops the rules currently in effect.
+func (f *IPFilter) Watch(ctx context.Context, path string, interval time.Duration) {
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				f.logger.Error("failed to stat ip filter config", "path", path, "error", err)
+				continue
+			}
+			if info.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = info.ModTime()
+
+			if err := f.Reload(path); err != nil {
+				f.logger.Error("failed to reload ip filter config", "path", path, "error", err)
+			}
+		}
+	}
+}
+
+// parsePrefixes parses CIDR strings, accepting bare addresses as single-host prefixes
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", v, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", v, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// trustedClientIP returns the IP of the client making the request: the
+// connected peer, unless that is a trusted proxy. Behind trusted proxies, the
+// X-Forwarded-For hops are walked from the nearest one and the first
+// untrusted address is the client, since addresses further left were
+// supplied by the client itself; X-Real-IP is used without the header.
+func trustedClientIP(r *http.Request, trusted []netip.Prefix) string {
+	ip := peerIP(r)
+	if !trusts(trusted, ip) {
+		return ip
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			ip = hop
+			if !trusts(trusted, hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	return ip
+}
+
+// trusts reports whether ip is within one of the trusted proxy prefixes
+func trusts(trusted []netip.Prefix, ip string) bool {
+	if len(trusted) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP returns the IP of the connected peer
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// No port, as with some test requests and unix sockets
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIPFilter_Match(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	f, err := NewIPFilter(IPFilterConfig{
+		Allow: []string{"10.0.0.0/8", "192.168.1.5", "fd00::/8"},
+		Deny:  []string{"10.1.2.3/32"},
+	}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		ip             string
+		expectedAction IPFilterAction
+		expectedRule   string
+	}{
+		{"Allowlisted CIDR", "10.20.30.40", IPAllow, "10.0.0.0/8"},
+		{"Allowlisted host", "192.168.1.5", IPAllow, "192.168.1.5/32"},
+		{"Deny takes precedence", "10.1.2.3", IPDeny, "10.1.2.3/32"},
+		{"IPv6 allowlisted", "fd00::1", IPAllow, "fd00::/8"},
+		{"IPv4-mapped IPv6", "::ffff:10.1.2.3", IPDeny, "10.1.2.3/32"},
+		{"No match", "172.16.0.1", IPNoMatch, ""},
+		{"Unparseable", "not-an-ip", IPNoMatch, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, rule := f.Match(tt.ip)
+			if action != tt.expectedAction || rule != tt.expectedRule {
+				t.Errorf("Expected %s (%q), got %s (%q)", tt.expectedAction, tt.expectedRule, action, rule)
+			}
+		})
+	}
+}
+
+func TestIPFilter_InvalidRule(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	f, err := NewIPFilter(IPFilterConfig{Deny: []string{"10.0.0.1"}}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	if err := f.Update(IPFilterConfig{Deny: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+
+	// Previous rules stay in effect
+	if action, _ := f.Match("10.0.0.1"); action != IPDeny {
+		t.Errorf("Expected previous rules to be kept, got %s", action)
+	}
+}
+
+func TestRateLimiter_IPFilter(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	rl := NewRateLimiter(1, time.Minute, logger)
+	f, err := NewIPFilter(IPFilterConfig{
+		Allow: []string{"10.0.0.0/8", "fd00::/8"},
+		Deny:  []string{"203.0.113.7"},
+	}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	rl.SetIPFilter(f)
+
+	handler := rl.RateLimit()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	do := func(remoteAddr string, header ...string) int {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = remoteAddr
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Allowlisted client is never throttled
+	for i := 0; i < 5; i++ {
+		if code := do("10.0.0.1:1234"); code != http.StatusOK {
+			t.Errorf("Allowlisted request %d: expected status %d, got %d", i+1, http.StatusOK, code)
+		}
+	}
+
+	// IPv6 peers are matched too
+	for i := 0; i < 2; i++ {
+		if code := do("[fd00::1]:1234"); code != http.StatusOK {
+			t.Errorf("Allowlisted IPv6 request %d: expected status %d, got %d", i+1, http.StatusOK, code)
+		}
+	}
+
+	// Denylisted client is rejected before consuming tokens, and cannot
+	// claim an allowlisted address without a trusted proxy
+	if code := do("203.0.113.7:1234"); code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, code)
+	}
+	if code := do("203.0.113.7:1234", "X-Forwarded-For", "10.0.0.1", "X-Real-IP", "10.0.0.1"); code != http.StatusForbidden {
+		t.Errorf("Spoofed headers: expected status %d, got %d", http.StatusForbidden, code)
+	}
+
+	// Other clients are rate limited as usual
+	if code := do("172.16.0.1:1234"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := do("172.16.0.1:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, code)
+	}
+
+	if got := testutil.ToFloat64(rl.metrics.filtered.WithLabelValues(unmatchedRoute, "allow")); got != 7 {
+		t.Errorf("Expected 7 allowlisted requests counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(rl.metrics.filtered.WithLabelValues(unmatchedRoute, "deny")); got != 2 {
+		t.Errorf("Expected 2 denied requests counted, got %v", got)
+	}
+}
+
+func TestIPFilter_ClientIP(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	f, err := NewIPFilter(IPFilterConfig{TrustedProxies: []string{"10.0.0.0/8", "fd00::/8"}}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		realIP       string
+		expectedIP   string
+	}{
+		{"Untrusted peer", "203.0.113.7:1234", "10.0.0.1", "", "203.0.113.7"},
+		{"Untrusted IPv6 peer", "[2001:db8::1]:1234", "10.0.0.1", "", "2001:db8::1"},
+		{"Trusted proxy", "10.0.0.2:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"Spoofed hop before the proxy", "10.0.0.2:1234", "10.9.9.9, 198.51.100.1", "", "198.51.100.1"},
+		{"Chain of trusted proxies", "10.0.0.2:1234", "198.51.100.1, 10.0.0.3", "", "198.51.100.1"},
+		{"Trusted IPv6 proxy", "[fd00::2]:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"X-Real-IP from trusted proxy", "10.0.0.2:1234", "", "198.51.100.1", "198.51.100.1"},
+		{"Trusted proxy without headers", "10.0.0.2:1234", "", "", "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if ip := f.ClientIP(req); ip != tt.expectedIP {
+				t.Errorf("Expected IP %s, got %s", tt.expectedIP, ip)
+			}
+		})
+	}
+}
+
+func TestIPFilter_Watch(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	path := filepath.Join(t.TempDir(), "ipfilter.yaml")
+	if err := os.WriteFile(path, []byte("deny:\n  - 10.0.0.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadIPFilterConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	f, err := NewIPFilter(cfg, logger)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Watch(ctx, path, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	// Rewrite the file with a distinct modification time
+	if err := os.WriteFile(path, []byte("allow:\n  - 10.0.0.0/24\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if action, _ := f.Match("10.0.0.1"); action == IPAllow {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expected rules to be reloaded from file")
+}
@@ -0,0 +1,273 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers carrying the request signature computed by the Argo CD API server side
+const (
+	HeaderProxyTimestamp = "X-Metrics-Timestamp"
+	HeaderProxyNonce     = "X-Metrics-Nonce"
+	HeaderProxySignature = "X-Metrics-Signature"
+)
+
+// signedHeaders are the identity headers covered by request signatures
+var signedHeaders = []string{
+	HeaderArgoCDApplicationName,
+	HeaderArgoCDProjectName,
+	HeaderArgoCDUsername,
+	HeaderArgoCDUserGroups,
+}
+
+// defaultReplayWindow bounds how far a signed timestamp may drift from the server clock
+const defaultReplayWindow = 5 * time.Minute
+
+// ProxyVerifierConfig configures how requests from the Argo CD API server are verified.
+// At least one of ClientCAFile or SharedSecret must be set; when both are set a
+// request is accepted if it passes either check.
+type ProxyVerifierConfig struct {
+	// ClientCAFile is a PEM bundle used to verify client certificates (mTLS)
+	ClientCAFile string `yaml:"clientCAFile" json:"clientCAFile"`
+	// SharedSecret is the HMAC-SHA256 key used to verify signed requests
+	SharedSecret string `yaml:"sharedSecret" json:"-"`
+	// ReplayWindow is the maximum allowed clock skew for signed requests (default 5m)
+	ReplayWindow time.Duration `yaml:"replayWindow" json:"replayWindow"`
+}
+
+type proxyVerifiedContextKey struct{}
+
+// ProxyVerifiedFromContext reports whether ProxyVerifier accepted the request,
+// and so whether its Argo CD identity headers can be trusted
+func ProxyVerifiedFromContext(ctx context.Context) bool {
+	verified, _ := ctx.Value(proxyVerifiedContextKey{}).(bool)
+	return verified
+}
+
+// ProxyVerifier ensures requests originate from the Argo CD API server rather
+// than from any workload that can reach the Service directly
+type ProxyVerifier struct {
+	clientCAs *x509.CertPool
+	secret    []byte
+	window    time.Duration
+	now       func() time.Time
+	logger    *slog.Logger
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time // nonce -> when it leaves the replay window
+	lastSweep time.Time
+}
+
+// NewProxyVerifier creates a new proxy verifier from the given configuration
+func NewProxyVerifier(cfg ProxyVerifierConfig, logger *slog.Logger) (*ProxyVerifier, error) {
+	if cfg.ClientCAFile == "" && cfg.SharedSecret == "" {
+		return nil, errors.New("proxy verification requires a client CA or a shared secret")
+	}
+
+	v := &ProxyVerifier{
+		secret: []byte(cfg.SharedSecret),
+		window: cfg.ReplayWindow,
+		now:    time.Now,
+		logger: logger.With("component", "proxy-verifier"),
+		nonces: make(map[string]time.Time),
+	}
+	if v.window <= 0 {
+		v.window = defaultReplayWindow
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		v.clientCAs = x509.NewCertPool()
+		if !v.clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", cfg.ClientCAFile)
+		}
+	}
+
+	return v, nil
+}
+
+// TLSConfig returns a copy of base configured to request and verify client
+// certificates against the configured CA. When a shared secret is also set,
+// certificates are optional so that signed requests can still be accepted.
+func (v *ProxyVerifier) TLSConfig(base *tls.Config) *tls.Config {
+	var cfg *tls.Config
+	if base != nil {
+		cfg = base.Clone()
+	} else {
+		cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if v.clientCAs == nil {
+		return cfg
+	}
+
+	cfg.ClientCAs = v.clientCAs
+	if len(v.secret) > 0 {
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	} else {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
+// Verify returns a middleware that rejects requests with 401 unless they
+// present a client certificate signed by the configured CA or a valid
+// HMAC signature within the replay window. Accepted requests are marked in
+// their context, see ProxyVerifiedFromContext.
+func (v *ProxyVerifier) Verify() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if v.verifiedClientCert(r) {
+				next.ServeHTTP(w, withProxyVerified(r))
+				return
+			}
+
+			if err := v.verifySignature(r); err != nil {
+				v.logger.Warn("unverified proxy request",
+					"client_ip", peerIP(r),
+					"path", r.URL.Path,
+					"reason", err.Error(),
+				)
+				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, withProxyVerified(r))
+		})
+	}
+}
+
+// withProxyVerified marks r as accepted by the verifier
+func withProxyVerified(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), proxyVerifiedContextKey{}, true))
+}
+
+// verifiedClientCert reports whether the TLS handshake verified a client
+// certificate against the configured CA
+func (v *ProxyVerifier) verifiedClientCert(r *http.Request) bool {
+	return v.clientCAs != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+func (v *ProxyVerifier) verifySignature(r *http.Request) error {
+	if len(v.secret) == 0 {
+		return errors.New("client certificate required")
+	}
+
+	timestamp := r.Header.Get(HeaderProxyTimestamp)
+	nonce := r.Header.Get(HeaderProxyNonce)
+	signature := r.Header.Get(HeaderProxySignature)
+	if timestamp == "" || nonce == "" || signature == "" {
+		return errors.New("missing request signature")
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("malformed request timestamp")
+	}
+	now := v.now()
+	skew := now.Sub(time.Unix(unix, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > v.window {
+		return errors.New("request timestamp outside allowed window")
+	}
+
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("malformed request signature")
+	}
+	expected := computeSignature(v.secret, r, timestamp, nonce)
+	if !hmac.Equal(got, expected) {
+		return errors.New("invalid request signature")
+	}
+
+	// Only nonces of valid signatures are remembered, so the cache is
+	// bounded by genuine traffic within the window
+	if !v.useNonce(nonce, time.Unix(unix, 0).Add(v.window), now) {
+		return errors.New("replayed request signature")
+	}
+
+	return nil
+}
+
+// useNonce records a nonce until expiry, when its signed timestamp leaves
+// the replay window, reporting false if it is already recorded
+func (v *ProxyVerifier) useNonce(nonce string, expiry, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now.Sub(v.lastSweep) > v.window {
+		for n, expiry := range v.nonces {
+			if now.After(expiry) {
+				delete(v.nonces, n)
+			}
+		}
+		v.lastSweep = now
+	}
+
+	if until, seen := v.nonces[nonce]; seen && !now.After(until) {
+		return false
+	}
+	v.nonces[nonce] = expiry
+	return true
+}
+
+// SignRequest sets the timestamp, nonce and signature headers on req using
+// the shared secret. It is used by callers forwarding requests to the
+// server, after setting the Argo CD identity headers.
+func SignRequest(req *http.Request, secret string, now time.Time) error {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	nonce := hex.EncodeToString(b[:])
+	signature := computeSignature([]byte(secret), req, timestamp, nonce)
+
+	req.Header.Set(HeaderProxyTimestamp, timestamp)
+	req.Header.Set(HeaderProxyNonce, nonce)
+	req.Header.Set(HeaderProxySignature, hex.EncodeToString(signature))
+	return nil
+}
+
+// computeSignature returns HMAC-SHA256 over the lines
+//
+//	<method>
+//	<path>
+//	<query, with keys sorted as by url.Values.Encode>
+//	<Argocd-Application-Name>
+//	<Argocd-Project-Name>
+//	<Argocd-Username>
+//	<Argocd-User-Groups>
+//	<timestamp>
+//	<nonce>
+//
+// so a signature cannot be reused for other parameters or another identity
+func computeSignature(secret []byte, r *http.Request, timestamp, nonce string) []byte {
+	lines := []string{r.Method, r.URL.Path, r.URL.Query().Encode()}
+	for _, h := range signedHeaders {
+		lines = append(lines, r.Header.Get(h))
+	}
+	lines = append(lines, timestamp, nonce)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	return mac.Sum(nil)
+}
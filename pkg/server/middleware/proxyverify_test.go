@@ -0,0 +1,370 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a self-signed certificate authority generated for a single test
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issueClientCert issues a client certificate signed by the CA
+func (ca *testCA) issueClientCert(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writeCAFile(t *testing.T, ca *testCA) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, ca.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newVerifiedServer starts a TLS test server protected by the verifier
+func newVerifiedServer(t *testing.T, v *ProxyVerifier) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(v.Verify()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	srv.TLS = v.TLSConfig(nil)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// clientWithCert returns a client trusting the test server, presenting cert if
+// given. Each client gets its own transport so connections are never reused.
+func clientWithCert(srv *httptest.Server, cert *tls.Certificate) *http.Client {
+	transport := srv.Client().Transport.(*http.Transport).Clone()
+	if cert != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	}
+	return &http.Client{Transport: transport}
+}
+
+func TestProxyVerifier_MutualTLS(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ca := newTestCA(t, "argocd-ca")
+	rogueCA := newTestCA(t, "rogue-ca")
+
+	v, err := NewProxyVerifier(ProxyVerifierConfig{ClientCAFile: writeCAFile(t, ca)}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	srv := newVerifiedServer(t, v)
+
+	// Trusted client certificate
+	trusted := ca.issueClientCert(t, "argocd-server")
+	resp, err := clientWithCert(srv, &trusted).Get(srv.URL + "/api/test")
+	if err != nil {
+		t.Fatalf("Request with trusted cert failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// Certificate from an untrusted CA fails the handshake
+	rogue := rogueCA.issueClientCert(t, "argocd-server")
+	if resp, err := clientWithCert(srv, &rogue).Get(srv.URL + "/api/test"); err == nil {
+		resp.Body.Close()
+		t.Error("Expected handshake failure with untrusted client cert")
+	}
+
+	// No certificate at all
+	if resp, err := clientWithCert(srv, nil).Get(srv.URL + "/api/test"); err == nil {
+		resp.Body.Close()
+		t.Error("Expected handshake failure without client cert")
+	}
+}
+
+func TestProxyVerifier_MutualTLSOrSignature(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ca := newTestCA(t, "argocd-ca")
+
+	v, err := NewProxyVerifier(ProxyVerifierConfig{
+		ClientCAFile: writeCAFile(t, ca),
+		SharedSecret: "s3cret",
+	}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	srv := newVerifiedServer(t, v)
+
+	// No certificate and no signature is rejected by the middleware
+	resp, err := clientWithCert(srv, nil).Get(srv.URL + "/api/test")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// Signed request without a certificate is accepted
+	req, _ := http.NewRequest("GET", srv.URL+"/api/test", nil)
+	if err := SignRequest(req, "s3cret", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = clientWithCert(srv, nil).Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestProxyVerifier_Signature(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	v, err := NewProxyVerifier(ProxyVerifierConfig{
+		SharedSecret: "s3cret",
+		ReplayWindow: time.Minute,
+	}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	v.now = func() time.Time { return now }
+
+	handler := v.Verify()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ProxyVerifiedFromContext(r.Context()) {
+			t.Error("accepted request is not marked as verified")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name           string
+		sign           func(req *http.Request)
+		expectedStatus int
+	}{
+		{
+			name:           "Valid signature",
+			sign:           func(req *http.Request) { SignRequest(req, "s3cret", now) },
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Within replay window",
+			sign:           func(req *http.Request) { SignRequest(req, "s3cret", now.Add(-30*time.Second)) },
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Missing signature",
+			sign:           func(req *http.Request) {},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Wrong secret",
+			sign:           func(req *http.Request) { SignRequest(req, "other", now) },
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Expired timestamp",
+			sign:           func(req *http.Request) { SignRequest(req, "s3cret", now.Add(-2*time.Minute)) },
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Future timestamp",
+			sign:           func(req *http.Request) { SignRequest(req, "s3cret", now.Add(2*time.Minute)) },
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Signed for another path",
+			sign: func(req *http.Request) {
+				other := httptest.NewRequest("GET", "/api/other", nil)
+				SignRequest(other, "s3cret", now)
+				req.Header = other.Header
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Signed for another method",
+			sign: func(req *http.Request) {
+				other := httptest.NewRequest("POST", "/api/test", nil)
+				SignRequest(other, "s3cret", now)
+				req.Header = other.Header
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Query parameters reordered",
+			sign: func(req *http.Request) {
+				other := httptest.NewRequest("GET", "/api/test?project=default&application_name=guestbook", nil)
+				other.Header = req.Header
+				SignRequest(other, "s3cret", now)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Signed for other query parameters",
+			sign: func(req *http.Request) {
+				other := httptest.NewRequest("GET", "/api/test?application_name=billing&project=default", nil)
+				other.Header = req.Header
+				SignRequest(other, "s3cret", now)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Identity changed after signing",
+			sign: func(req *http.Request) {
+				SignRequest(req, "s3cret", now)
+				req.Header.Set(HeaderArgoCDUsername, "admin")
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Missing nonce",
+			sign: func(req *http.Request) {
+				SignRequest(req, "s3cret", now)
+				req.Header.Del(HeaderProxyNonce)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/test?application_name=guestbook&project=default", nil)
+			req.Header.Set(HeaderArgoCDApplicationName, "argocd:guestbook")
+			req.Header.Set(HeaderArgoCDProjectName, "default")
+			req.Header.Set(HeaderArgoCDUsername, "alice")
+			tt.sign(req)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestProxyVerifier_Replay(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	v, err := NewProxyVerifier(ProxyVerifierConfig{
+		SharedSecret: "s3cret",
+		ReplayWindow: time.Minute,
+	}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	v.now = func() time.Time { return now }
+
+	handler := v.Verify()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	signed := httptest.NewRequest("GET", "/api/test", nil)
+	if err := SignRequest(signed, "s3cret", now); err != nil {
+		t.Fatal(err)
+	}
+	do := func() int {
+		req := httptest.NewRequest("GET", "/api/test", nil)
+		req.Header = signed.Header.Clone()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := do(); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := do(); code != http.StatusUnauthorized {
+		t.Errorf("Replayed request: expected status %d, got %d", http.StatusUnauthorized, code)
+	}
+
+	// Nonces are forgotten once their timestamps are out of the window
+	now = now.Add(2 * time.Minute)
+	if err := SignRequest(signed, "s3cret", now); err != nil {
+		t.Fatal(err)
+	}
+	if code := do(); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if n := len(v.nonces); n != 1 {
+		t.Errorf("Expected expired nonces to be dropped, %d tracked", n)
+	}
+}
+
+func TestNewProxyVerifier_RequiresMethod(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	if _, err := NewProxyVerifier(ProxyVerifierConfig{}, logger); err == nil {
+		t.Error("Expected error when neither CA nor secret is configured")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,15 +14,21 @@ import (
 type RateLimiter struct {
 	mu       sync.Mutex
 	buckets  map[string]*bucket
-	rate     int           // requests per interval
-	interval time.Duration // time window
+	exempt   map[string]time.Time // client key -> exemption expiry
+	rate     int                  // requests per interval
+	interval time.Duration        // time window
 	logger   *slog.Logger
+	metrics  *rateLimiterMetrics
+	filter   atomic.Pointer[IPFilter]
 }
 
 type bucket struct {
-	tokens       int
-	lastRefill   time.Time
-	mu           sync.Mutex
+	tokens     int
+	lastRefill time.Time
+	lastSeen   time.Time
+	allowed    uint64
+	rejected   uint64
+	mu         sync.Mutex
 }
 
 // NewRateLimiter creates a new rate limiter
@@ -30,10 +37,12 @@ type bucket struct {
 func NewRateLimiter(rate int, interval time.Duration, logger *slog.Logger) *RateLimiter {
 	rl := &RateLimiter{
 		buckets:  make(map[string]*bucket),
+		exempt:   make(map[string]time.Time),
 		rate:     rate,
 		interval: interval,
 		logger:   logger.With("component", "ratelimiter"),
 	}
+	rl.metrics = newRateLimiterMetrics(rl)
 
 	// Start cleanup goroutine to remove stale buckets
 	go rl.cleanup()
@@ -41,15 +50,54 @@ func NewRateLimiter(rate int, interval time.Duration, logger *slog.Logger) *Rate
 	return rl
 }
 
-// RateLimit returns a middleware that enforces rate limiting per client IP
+// SetIPFilter attaches an allow/deny filter that is evaluated before the token
+// bucket: denylisted clients are rejected with 403 and allowlisted clients are
+// never throttled. Passing nil removes the filter.
+func (rl *RateLimiter) SetIPFilter(f *IPFilter) {
+	rl.filter.Store(f)
+}
+
+// RateLimit returns a middleware that enforces rate limiting per client.
+// Requests authenticated by ArgoCDAuth and accepted by ProxyVerifier are
+// limited per Argo CD user, all others per client IP. The IP filter matches
+// its own notion of the client IP, which only honours forwarding headers from
+// its trusted proxies.
 func (rl *RateLimiter) RateLimit() func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			clientIP := getClientIP(r)
+			key := clientKey(r, clientIP)
+			route := routeLabel(r)
+
+			if f := rl.filter.Load(); f != nil {
+				filterIP := f.ClientIP(r)
+				action, rule := f.Match(filterIP)
+				if action != IPNoMatch {
+					rl.metrics.filtered.WithLabelValues(route, action.String()).Inc()
+				}
+				switch action {
+				case IPDeny:
+					f.logDenied(r, filterIP, rule)
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				case IPAllow:
+					rl.logger.Debug("rate limit bypassed by allowlist",
+						"client_ip", filterIP,
+						"rule", rule,
+						"path", r.URL.Path,
+					)
+					rl.metrics.allowed.WithLabelValues(route, "true").Inc()
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
 
-			if !rl.allow(clientIP) {
+			if !rl.allow(key) {
+				rl.metrics.rejected.WithLabelValues(route).Inc()
+				rl.recordClient(key, "rejected")
 				rl.logger.Warn("rate limit exceeded",
 					"client_ip", clientIP,
+					"key", key,
 					"path", r.URL.Path,
 				)
 				w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", rl.rate))
@@ -59,6 +107,8 @@ func (rl *RateLimiter) RateLimit() func(next http.Handler) http.Handler {
 				return
 			}
 
+			rl.metrics.allowed.WithLabelValues(route, "false").Inc()
+			rl.recordClient(key, "allowed")
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -66,24 +116,34 @@ func (rl *RateLimiter) RateLimit() func(next http.Handler) http.Handler {
 
 // allow checks if a request from the given client should be allowed
 func (rl *RateLimiter) allow(clientIP string) bool {
+	now := time.Now()
+
 	rl.mu.Lock()
 	b, exists := rl.buckets[clientIP]
 	if !exists {
 		b = &bucket{
 			tokens:     rl.rate,
-			lastRefill: time.Now(),
+			lastRefill: now,
 		}
 		rl.buckets[clientIP] = b
 	}
+	exempt := rl.isExempt(clientIP, now)
 	rl.mu.Unlock()
 
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	b.lastSeen = now
+
+	// Exempted clients bypass the bucket but are still accounted for
+	if exempt {
+		b.allowed++
+		return true
+	}
+
 	// Refill tokens based on elapsed time
-	now := time.Now()
 	elapsed := now.Sub(b.lastRefill)
-	
+
 	if elapsed >= rl.interval {
 		// Full refill
 		b.tokens = rl.rate
@@ -100,12 +160,28 @@ func (rl *RateLimiter) allow(clientIP string) bool {
 	// Check if we have tokens available
 	if b.tokens > 0 {
 		b.tokens--
+		b.allowed++
 		return true
 	}
 
+	b.rejected++
 	return false
 }
 
+// isExempt reports whether the client key has an active exemption,
+// dropping it once expired (caller must hold rl.mu)
+func (rl *RateLimiter) isExempt(key string, now time.Time) bool {
+	until, ok := rl.exempt[key]
+	if !ok {
+		return false
+	}
+	if now.After(until) {
+		delete(rl.exempt, key)
+		return false
+	}
+	return true
+}
+
 // cleanup removes stale buckets periodically
 func (rl *RateLimiter) cleanup() {
 	ticker := time.NewTicker(rl.interval * 2)
@@ -116,15 +192,35 @@ func (rl *RateLimiter) cleanup() {
 		now := time.Now()
 		for ip, b := range rl.buckets {
 			b.mu.Lock()
-			if now.Sub(b.lastRefill) > rl.interval*2 {
+			if now.Sub(b.lastSeen) > rl.interval*2 {
 				delete(rl.buckets, ip)
+				rl.metrics.forget(ip)
 			}
 			b.mu.Unlock()
 		}
+		for key, until := range rl.exempt {
+			if now.After(until) {
+				delete(rl.exempt, key)
+			}
+		}
 		rl.mu.Unlock()
 	}
 }
 
+// clientKey returns the bucket key for the request: the Argo CD username when
+// the request carries an identity and ProxyVerifier accepted it, otherwise
+// the client IP. Without proxy verification the username is only a header,
+// and keying on it would let clients pick a fresh bucket per request.
+func clientKey(r *http.Request, clientIP string) string {
+	if !ProxyVerifiedFromContext(r.Context()) {
+		return clientIP
+	}
+	if identity, ok := IdentityFromContext(r.Context()); ok {
+		return "user:" + identity.Username
+	}
+	return clientIP
+}
+
 // getClientIP extracts the client IP from the request
 func getClientIP(r *http.Request) string {
 	// Check X-Forwarded-For header first (for proxied requests)
@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultExemptDuration is used when an exemption request carries no duration
+const defaultExemptDuration = 15 * time.Minute
+
+// ClientUsage describes the rate limiter state for a single client key
+type ClientUsage struct {
+	Key         string     `json:"key"`
+	Allowed     uint64     `json:"allowed"`
+	Rejected    uint64     `json:"rejected"`
+	Tokens      int        `json:"tokens"`
+	LastSeen    time.Time  `json:"last_seen"`
+	ExemptUntil *time.Time `json:"exempt_until,omitempty"`
+}
+
+// TopConsumers returns up to limit tracked clients ordered by total requests.
+// A limit <= 0 returns all tracked clients.
+func (rl *RateLimiter) TopConsumers(limit int) []ClientUsage {
+	rl.mu.Lock()
+	now := time.Now()
+	usage := make([]ClientUsage, 0, len(rl.buckets))
+	for key, b := range rl.buckets {
+		b.mu.Lock()
+		u := ClientUsage{
+			Key:      key,
+			Allowed:  b.allowed,
+			Rejected: b.rejected,
+			Tokens:   b.tokens,
+			LastSeen: b.lastSeen,
+		}
+		b.mu.Unlock()
+		if until, ok := rl.exempt[key]; ok && now.Before(until) {
+			u.ExemptUntil = &until
+		}
+		usage = append(usage, u)
+	}
+	rl.mu.Unlock()
+
+	sort.Slice(usage, func(i, j int) bool {
+		ti := usage[i].Allowed + usage[i].Rejected
+		tj := usage[j].Allowed + usage[j].Rejected
+		if ti != tj {
+			return ti > tj
+		}
+		return usage[i].Key < usage[j].Key
+	})
+
+	if limit > 0 && len(usage) > limit {
+		usage = usage[:limit]
+	}
+	return usage
+}
+
+// Reset drops the bucket for the given client key, restoring its full quota.
+// It reports whether the key was being tracked.
+func (rl *RateLimiter) Reset(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	_, exists := rl.buckets[key]
+	delete(rl.buckets, key)
+	rl.metrics.forget(key)
+	return exists
+}
+
+// Exempt lets the given client key bypass rate limiting until the duration elapses
+func (rl *RateLimiter) Exempt(key string, d time.Duration) time.Time {
+	until := time.Now().Add(d)
+
+	rl.mu.Lock()
+	rl.exempt[key] = until
+	rl.mu.Unlock()
+
+	rl.logger.Info("client exempted from rate limiting", "key", key, "until", until)
+	return until
+}
+
+// Unexempt removes an exemption for the given client key
+func (rl *RateLimiter) Unexempt(key string) {
+	rl.mu.Lock()
+	delete(rl.exempt, key)
+	rl.mu.Unlock()
+}
+
+// AdminHandler returns an http.Handler exposing rate limiter administration:
+//
+//	GET    /clients?limit=N             top consumers
+//	DELETE /clients/{key}               reset a client's bucket
+//	POST   /clients/{key}/exempt?for=D  exempt a client for duration D
+//	DELETE /clients/{key}/exempt        remove an exemption
+//
+// It performs no authentication and should only be mounted on an
+// internal/admin listener.
+func (rl *RateLimiter) AdminHandler() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/clients", rl.handleTopConsumers)
+	r.Delete("/clients/{key}", rl.handleReset)
+	r.Post("/clients/{key}/exempt", rl.handleExempt)
+	r.Delete("/clients/{key}/exempt", rl.handleUnexempt)
+	return r
+}
+
+func (rl *RateLimiter) handleTopConsumers(w http.ResponseWriter, r *http.Request) {
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeAdminError(w, http.StatusBadRequest, "limit must be a non-negative integer")
+			return
+		}
+		limit = n
+	}
+
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{
+		"rate":     rl.rate,
+		"interval": rl.interval.String(),
+		"clients":  rl.TopConsumers(limit),
+	})
+}
+
+func (rl *RateLimiter) handleReset(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	if !rl.Reset(key) {
+		writeAdminError(w, http.StatusNotFound, "client key is not tracked")
+		return
+	}
+
+	rl.logger.Info("client rate limit reset", "key", key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rl *RateLimiter) handleExempt(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	d := defaultExemptDuration
+	if v := r.URL.Query().Get("for"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			writeAdminError(w, http.StatusBadRequest, "for must be a positive duration such as 30m")
+			return
+		}
+		d = parsed
+	}
+
+	until := rl.Exempt(key, d)
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{
+		"key":          key,
+		"exempt_until": until.Format(time.RFC3339),
+	})
+}
+
+func (rl *RateLimiter) handleUnexempt(w http.ResponseWriter, r *http.Request) {
+	rl.Unexempt(chi.URLParam(r, "key"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,262 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRateLimiter_TopConsumers(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	rl := NewRateLimiter(2, time.Minute, logger)
+
+	for i := 0; i < 5; i++ {
+		rl.allow("10.0.0.1")
+	}
+	rl.allow("10.0.0.2")
+
+	top := rl.TopConsumers(1)
+	if len(top) != 1 {
+		t.Fatalf("Expected 1 consumer, got %d", len(top))
+	}
+	if top[0].Key != "10.0.0.1" {
+		t.Errorf("Expected top consumer 10.0.0.1, got %s", top[0].Key)
+	}
+	if top[0].Allowed != 2 || top[0].Rejected != 3 {
+		t.Errorf("Expected 2 allowed / 3 rejected, got %d / %d", top[0].Allowed, top[0].Rejected)
+	}
+}
+
+func TestRateLimiter_ResetAndExempt(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	rl := NewRateLimiter(1, time.Minute, logger)
+
+	clientIP := "10.0.0.1"
+	rl.allow(clientIP)
+	if rl.allow(clientIP) {
+		t.Fatal("Second request should be blocked")
+	}
+
+	if !rl.Reset(clientIP) {
+		t.Error("Reset should report a tracked key")
+	}
+	if !rl.allow(clientIP) {
+		t.Error("Request should be allowed after reset")
+	}
+
+	rl.Exempt(clientIP, time.Minute)
+	for i := 0; i < 5; i++ {
+		if !rl.allow(clientIP) {
+			t.Errorf("Exempt request %d should be allowed", i+1)
+		}
+	}
+
+	rl.Unexempt(clientIP)
+	if rl.allow(clientIP) {
+		t.Error("Request should be blocked once exemption is removed")
+	}
+
+	rl.Exempt(clientIP, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if rl.allow(clientIP) {
+		t.Error("Request should be blocked once exemption expired")
+	}
+}
+
+func TestRateLimiter_AdminHandler(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	rl := NewRateLimiter(1, time.Minute, logger)
+	admin := rl.AdminHandler()
+
+	rl.allow("10.0.0.1")
+	rl.allow("10.0.0.1")
+
+	// List consumers
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("GET", "/clients?limit=5", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var body struct {
+		Clients []ClientUsage `json:"clients"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(body.Clients) != 1 || body.Clients[0].Rejected != 1 {
+		t.Errorf("Unexpected clients: %+v", body.Clients)
+	}
+
+	// Exempt with invalid duration
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("POST", "/clients/10.0.0.1/exempt?for=soon", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Exempt
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("POST", "/clients/10.0.0.1/exempt?for=1h", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !rl.allow("10.0.0.1") {
+		t.Error("Exempt client should be allowed")
+	}
+
+	// Reset
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("DELETE", "/clients/10.0.0.1", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, rr.Code)
+	}
+
+	// Reset unknown key
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("DELETE", "/clients/10.9.9.9", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestRateLimiter_Metrics(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	rl := NewRateLimiter(1, time.Minute, logger)
+
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(rl); err != nil {
+		t.Fatalf("Failed to register rate limiter: %v", err)
+	}
+
+	router := chi.NewRouter()
+	router.Use(rl.RateLimit())
+	router.Get("/applications/{application}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, target := range []string{"/applications/a", "/applications/b", "/applications/c", "/unknown/path"} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.RemoteAddr = "192.168.1.100:1234"
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	req := httptest.NewRequest("GET", "/applications/a", nil)
+	req.RemoteAddr = "192.168.1.101:1234"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	expected := `
+# HELP metrics_server_ratelimit_allowed_requests_total Requests allowed by the rate limiter, by route and whether the IP allowlist bypassed the limit.
+# TYPE metrics_server_ratelimit_allowed_requests_total counter
+metrics_server_ratelimit_allowed_requests_total{bypassed="false",route="/applications/{application}"} 2
+# HELP metrics_server_ratelimit_rejected_requests_total Requests rejected by the rate limiter, by route.
+# TYPE metrics_server_ratelimit_rejected_requests_total counter
+metrics_server_ratelimit_rejected_requests_total{route="/applications/{application}"} 2
+metrics_server_ratelimit_rejected_requests_total{route="unmatched"} 1
+# HELP metrics_server_ratelimit_tracked_buckets Number of client buckets currently tracked by the rate limiter.
+# TYPE metrics_server_ratelimit_tracked_buckets gauge
+metrics_server_ratelimit_tracked_buckets 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(rl, "metrics_server_ratelimit_client_requests_total"); n != 0 {
+		t.Errorf("Expected no per-client series by default, got %d", n)
+	}
+}
+
+func TestRateLimiter_MetricsBypassed(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	rl := NewRateLimiter(1, time.Minute, logger)
+	filter, err := NewIPFilter(IPFilterConfig{Allow: []string{"10.0.0.0/8"}}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	rl.SetIPFilter(filter)
+
+	handler := rl.RateLimit()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if v := testutil.ToFloat64(rl.metrics.allowed.WithLabelValues(unmatchedRoute, "true")); v != 3 {
+		t.Errorf("Expected 3 bypassed requests, got %v", v)
+	}
+}
+
+func TestRateLimiter_ClientMetrics(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	rl := NewRateLimiter(1, time.Minute, logger)
+	rl.SetClientMetrics(2)
+
+	handler := rl.RateLimit()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, addr := range []string{"192.168.1.100", "192.168.1.100", "192.168.1.101", "192.168.1.102", "192.168.1.103"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = addr + ":1234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expected := `
+# HELP metrics_server_ratelimit_client_requests_total Requests checked against the token bucket, by tracked client key and result.
+# TYPE metrics_server_ratelimit_client_requests_total counter
+metrics_server_ratelimit_client_requests_total{key="192.168.1.100",result="allowed"} 1
+metrics_server_ratelimit_client_requests_total{key="192.168.1.100",result="rejected"} 1
+metrics_server_ratelimit_client_requests_total{key="192.168.1.101",result="allowed"} 1
+metrics_server_ratelimit_client_requests_total{key="other",result="allowed"} 2
+`
+	if err := testutil.CollectAndCompare(rl, strings.NewReader(expected), "metrics_server_ratelimit_client_requests_total"); err != nil {
+		t.Error(err)
+	}
+
+	// Resetting a client drops its series with its bucket and frees a slot
+	rl.Reset("192.168.1.100")
+	if n := testutil.CollectAndCount(rl, "metrics_server_ratelimit_client_requests_total"); n != 2 {
+		t.Errorf("Expected 2 client series after reset, got %d", n)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.168.1.104:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if v := testutil.ToFloat64(rl.metrics.clients.WithLabelValues("192.168.1.104", "allowed")); v != 1 {
+		t.Errorf("Expected a series for the new client, got %v", v)
+	}
+}
+
+// TestRateLimiter_ClientMetricsRace records client series while their buckets
+// are reset; run with -race
+func TestRateLimiter_ClientMetricsRace(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	rl := NewRateLimiter(1000, time.Minute, logger)
+	rl.SetClientMetrics(10)
+	handler := rl.RateLimit()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				req := httptest.NewRequest("GET", "/", nil)
+				req.RemoteAddr = "192.168.1.100:1234"
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+				rl.Reset("192.168.1.100")
+			}
+		}()
+	}
+	wg.Wait()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if len(rl.metrics.keyed) > 1 {
+		t.Errorf("Expected at most one keyed client, got %v", rl.metrics.keyed)
+	}
+}
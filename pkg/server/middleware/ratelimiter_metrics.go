@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unmatchedRoute is the route label of requests matching no route, so that
+// requests for arbitrary paths cannot create new series
+const unmatchedRoute = "unmatched"
+
+// otherClientKey is the key label of clients beyond the per-key series limit
+const otherClientKey = "other"
+
+// rateLimiterMetrics holds the Prometheus collectors for a RateLimiter
+type rateLimiterMetrics struct {
+	allowed  *prometheus.CounterVec
+	rejected *prometheus.CounterVec
+	filtered *prometheus.CounterVec
+	clients  *prometheus.CounterVec
+	buckets  prometheus.GaugeFunc
+
+	// keyLimit caps the client keys with their own clients series; zero
+	// disables the series
+	keyLimit atomic.Int64
+	// keyed holds the client keys with series, guarded by RateLimiter.mu
+	// like the buckets they follow
+	keyed map[string]bool
+}
+
+func newRateLimiterMetrics(rl *RateLimiter) *rateLimiterMetrics {
+	return &rateLimiterMetrics{
+		allowed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "metrics_server",
+			Subsystem: "ratelimit",
+			Name:      "allowed_requests_total",
+			Help:      "Requests allowed by the rate limiter, by route and whether the IP allowlist bypassed the limit.",
+		}, []string{"route", "bypassed"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "metrics_server",
+			Subsystem: "ratelimit",
+			Name:      "rejected_requests_total",
+			Help:      "Requests rejected by the rate limiter, by route.",
+		}, []string{"route"}),
+		filtered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "metrics_server",
+			Subsystem: "ratelimit",
+			Name:      "filtered_requests_total",
+			Help:      "Requests matching an IP filter rule, by route and action.",
+		}, []string{"route", "action"}),
+		clients: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "metrics_server",
+			Subsystem: "ratelimit",
+			Name:      "client_requests_total",
+			Help:      "Requests checked against the token bucket, by tracked client key and result.",
+		}, []string{"key", "result"}),
+		buckets: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "metrics_server",
+			Subsystem: "ratelimit",
+			Name:      "tracked_buckets",
+			Help:      "Number of client buckets currently tracked by the rate limiter.",
+		}, func() float64 {
+			rl.mu.Lock()
+			defer rl.mu.Unlock()
+			return float64(len(rl.buckets))
+		}),
+		keyed: make(map[string]bool),
+	}
+}
+
+// SetClientMetrics enables the per-client
+// metrics_server_ratelimit_client_requests_total series for at most limit
+// client keys at a time; requests of further clients are counted under the
+// key "other". Client keys are IPs or usernames, so the series are off by
+// default. A limit of zero disables them again.
+func (rl *RateLimiter) SetClientMetrics(limit int) {
+	rl.metrics.keyLimit.Store(int64(limit))
+	if limit <= 0 {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		rl.metrics.clients.Reset()
+		rl.metrics.keyed = make(map[string]bool)
+	}
+}
+
+// recordClient counts a token bucket result for a client key. It holds
+// rl.mu so that series are never incremented while forget deletes them.
+func (rl *RateLimiter) recordClient(key, result string) {
+	limit := rl.metrics.keyLimit.Load()
+	if limit <= 0 {
+		return
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	m := rl.metrics
+	if !m.keyed[key] {
+		if int64(len(m.keyed)) >= limit {
+			key = otherClientKey
+		} else {
+			m.keyed[key] = true
+		}
+	}
+	m.clients.WithLabelValues(key, result).Inc()
+}
+
+// forget deletes the series of a client key once its bucket is dropped, so
+// the key label is bounded by the buckets being tracked (caller must hold
+// rl.mu)
+func (m *rateLimiterMetrics) forget(key string) {
+	if m.keyed[key] {
+		delete(m.keyed, key)
+		m.clients.DeletePartialMatch(prometheus.Labels{"key": key})
+	}
+}
+
+// Describe implements prometheus.Collector
+func (rl *RateLimiter) Describe(ch chan<- *prometheus.Desc) {
+	rl.metrics.allowed.Describe(ch)
+	rl.metrics.rejected.Describe(ch)
+	rl.metrics.filtered.Describe(ch)
+	rl.metrics.clients.Describe(ch)
+	rl.metrics.buckets.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (rl *RateLimiter) Collect(ch chan<- prometheus.Metric) {
+	rl.metrics.allowed.Collect(ch)
+	rl.metrics.rejected.Collect(ch)
+	rl.metrics.filtered.Collect(ch)
+	rl.metrics.clients.Collect(ch)
+	rl.metrics.buckets.Collect(ch)
+}
+
+// routeLabel returns the pattern of the chi route serving the request. The
+// limiter usually runs as router-level middleware, before routing has
+// filled in the pattern, so the top-level router is asked which route
+// matches; requests matching none are labeled unmatched rather than by
+// their raw path.
+func routeLabel(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return unmatchedRoute
+	}
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+	tctx := chi.NewRouteContext()
+	if !rctx.Routes.Match(tctx, r.Method, path) {
+		return unmatchedRoute
+	}
+	return tctx.RoutePattern()
+}
@@ -124,3 +124,56 @@ func TestGetClientIP(t *testing.T) {
 		})
 	}
 }
+
+func TestRateLimiter_UnverifiedUsernames(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	rl := NewRateLimiter(1, time.Minute, logger)
+
+	limited := rl.RateLimit()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(username string, verified bool) int {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req = req.WithContext(WithIdentity(req.Context(), &ArgoCDIdentity{
+			Application: "guestbook",
+			Project:     "default",
+			Username:    username,
+		}))
+		if verified {
+			req = withProxyVerified(req)
+		}
+		rr := httptest.NewRecorder()
+		limited.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Rotating the username header does not yield a fresh bucket
+	if code := serve("alice", false); code != http.StatusOK {
+		t.Errorf("first request: expected %d, got %d", http.StatusOK, code)
+	}
+	if code := serve("bob", false); code != http.StatusTooManyRequests {
+		t.Errorf("rotated username: expected %d, got %d", http.StatusTooManyRequests, code)
+	}
+	if code := serve("carol", false); code != http.StatusTooManyRequests {
+		t.Errorf("rotated username: expected %d, got %d", http.StatusTooManyRequests, code)
+	}
+
+	// Verified users get a bucket each
+	if code := serve("alice", true); code != http.StatusOK {
+		t.Errorf("verified alice: expected %d, got %d", http.StatusOK, code)
+	}
+	if code := serve("bob", true); code != http.StatusOK {
+		t.Errorf("verified bob: expected %d, got %d", http.StatusOK, code)
+	}
+	if code := serve("alice", true); code != http.StatusTooManyRequests {
+		t.Errorf("verified alice again: expected %d, got %d", http.StatusTooManyRequests, code)
+	}
+
+	rl.mu.Lock()
+	buckets := len(rl.buckets)
+	rl.mu.Unlock()
+	if buckets != 3 {
+		t.Errorf("expected 3 buckets (client IP and two users), got %d", buckets)
+	}
+}
@@ -0,0 +1,228 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/signing"
+)
+
+// Headers carrying export signatures
+const (
+	// HeaderExportSignature holds the base64 JSON signature envelope
+	HeaderExportSignature = "X-Export-Signature"
+	// HeaderExportSignatureError explains why a response was not signed
+	HeaderExportSignatureError = "X-Export-Signature-Error"
+)
+
+// SigningKeysPath is where the public signing keys are published
+const SigningKeysPath = "/.well-known/export-signing-keys"
+
+// defaultSignMaxSize bounds the responses buffered for signing
+const defaultSignMaxSize = 256 << 20
+
+// SigningConfig configures export signing
+type SigningConfig struct {
+	// KeyFile is a PEM PKCS #8 Ed25519 private key, as written by
+	// openssl genpkey -algorithm ed25519
+	KeyFile string `yaml:"keyFile" json:"keyFile"`
+	// PreviousKeyFiles are PEM public keys of retired signing keys, still
+	// published so that older exports can be verified
+	PreviousKeyFiles []string `yaml:"previousKeyFiles" json:"previousKeyFiles"`
+	// MaxSize is the largest response signed, in bytes (default 256MB).
+	// Responses are buffered until complete, so larger ones are sent
+	// unsigned.
+	MaxSize int64 `yaml:"maxSize" json:"maxSize"`
+}
+
+// ExportSigner signs export downloads with a detached Ed25519 signature
+type ExportSigner struct {
+	signer    *signing.Signer
+	published []ed25519.PublicKey
+	maxSize   int64
+	now       func() time.Time
+	logger    *slog.Logger
+}
+
+// NewExportSigner loads the signing key and the previous public keys
+func NewExportSigner(cfg SigningConfig, logger *slog.Logger) (*ExportSigner, error) {
+	if cfg.KeyFile == "" {
+		return nil, errors.New("export signing requires a key file")
+	}
+	data, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	key, err := signing.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key %s: %w", cfg.KeyFile, err)
+	}
+
+	s := &ExportSigner{
+		signer:  signing.NewSigner(key),
+		maxSize: cfg.MaxSize,
+		now:     time.Now,
+		logger:  logger.With("component", "export-signer"),
+	}
+	if s.maxSize <= 0 {
+		s.maxSize = defaultSignMaxSize
+	}
+	s.published = append(s.published, s.signer.PublicKey())
+	for _, file := range cfg.PreviousKeyFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read previous signing key: %w", err)
+		}
+		pub, err := signing.ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous signing key %s: %w", file, err)
+		}
+		s.published = append(s.published, pub)
+	}
+
+	s.logger.Info("export signing enabled", "key_id", s.signer.KeyID())
+	return s, nil
+}
+
+// Sign returns a middleware adding an X-Export-Signature header to
+// successful responses. The signature covers the response body and
+// metadata naming the application, graph, caller and request, so the
+// middleware should sit inside ArgoCDAuth and inside any compression, which
+// would change the bytes received. Responses are buffered until complete,
+// so streaming endpoints should not be signed.
+func (s *ExportSigner) Sign() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &signingWriter{ResponseWriter: w, maxSize: s.maxSize}
+			next.ServeHTTP(sw, r)
+			if sw.passthrough {
+				return
+			}
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+
+			if sw.status == http.StatusOK {
+				if env, err := s.signer.Sign(s.metadata(r, w.Header()), sw.buf.Bytes()); err != nil {
+					s.logger.Error("failed to sign export", "path", r.URL.Path, "error", err)
+				} else if encoded, err := env.Encode(); err != nil {
+					s.logger.Error("failed to encode export signature", "error", err)
+				} else {
+					w.Header().Set(HeaderExportSignature, encoded)
+					s.logger.Debug("signed export", "path", r.URL.Path, "sha256", env.Metadata.SHA256)
+				}
+			}
+			w.WriteHeader(sw.status)
+			w.Write(sw.buf.Bytes())
+		})
+	}
+}
+
+// metadata describes the export being signed
+func (s *ExportSigner) metadata(r *http.Request, header http.Header) signing.Metadata {
+	meta := signing.Metadata{
+		Application: r.URL.Query().Get("application_name"),
+		Project:     r.URL.Query().Get("project"),
+		GroupKind:   chi.URLParam(r, "groupkind"),
+		Row:         chi.URLParam(r, "row"),
+		Graph:       chi.URLParam(r, "graph"),
+		Path:        r.URL.Path,
+		Query:       r.URL.RawQuery,
+		ContentType: header.Get("Content-Type"),
+		ExportedAt:  s.now(),
+	}
+	if identity, ok := IdentityFromContext(r.Context()); ok {
+		meta.Application, meta.Project, meta.User = identity.Application, identity.Project, identity.Username
+	}
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		meta.Filename = params["filename"]
+	}
+	return meta
+}
+
+// KeysHandler publishes the current and previous public keys as a JSON Web
+// Key Set, for serving unauthenticated at SigningKeysPath
+func (s *ExportSigner) KeysHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		set := signing.JWKS{Keys: make([]signing.JWK, len(s.published))}
+		for i, key := range s.published {
+			set.Keys[i] = signing.NewJWK(key)
+		}
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		json.NewEncoder(w).Encode(set)
+	})
+}
+
+// signingWriter buffers a response so its signature can be sent as a
+// header before the body. Past maxSize, or when the handler flushes, it
+// gives up and passes the response through unsigned.
+type signingWriter struct {
+	http.ResponseWriter
+	maxSize     int64
+	status      int
+	buf         bytes.Buffer
+	passthrough bool
+}
+
+func (sw *signingWriter) WriteHeader(status int) {
+	if sw.passthrough {
+		sw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if sw.status == 0 && status >= 200 {
+		sw.status = status
+	} else if status < 200 {
+		sw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (sw *signingWriter) Write(p []byte) (int, error) {
+	if sw.passthrough {
+		return sw.ResponseWriter.Write(p)
+	}
+	if int64(sw.buf.Len()+len(p)) > sw.maxSize {
+		sw.release(fmt.Sprintf("response larger than %d bytes", sw.maxSize))
+		return sw.ResponseWriter.Write(p)
+	}
+	return sw.buf.Write(p)
+}
+
+// Flush sends the response unsigned, as the handler is streaming
+func (sw *signingWriter) Flush() {
+	if !sw.passthrough {
+		sw.release("streamed response")
+	}
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sw *signingWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// release writes out what was buffered and passes the rest through
+func (sw *signingWriter) release(reason string) {
+	sw.passthrough = true
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	if sw.status == http.StatusOK {
+		sw.Header().Set(HeaderExportSignatureError, reason)
+	}
+	sw.ResponseWriter.WriteHeader(sw.status)
+	sw.ResponseWriter.Write(sw.buf.Bytes())
+	sw.buf = bytes.Buffer{}
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/signing"
+)
+
+// writeSigningKey writes a PKCS #8 key derived from seed and returns its path
+func writeSigningKey(t *testing.T, seed byte) (string, ed25519.PrivateKey) {
+	t.Helper()
+	s := make([]byte, ed25519.SeedSize)
+	for i := range s {
+		s[i] = seed
+	}
+	key := ed25519.NewKeyFromSeed(s)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path, key
+}
+
+func newTestSigner(t *testing.T, cfg SigningConfig) (*ExportSigner, ed25519.PublicKey) {
+	t.Helper()
+	path, key := writeSigningKey(t, 7)
+	cfg.KeyFile = path
+	s, err := NewExportSigner(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+	return s, key.Public().(ed25519.PublicKey)
+}
+
+func TestExportSigner_Sign(t *testing.T) {
+	s, pub := newTestSigner(t, SigningConfig{})
+	body := strings.Repeat("2024-01-01T00:00:00Z,1\n", 100)
+
+	r := chi.NewRouter()
+	r.With(NewArgoCDAuth(slog.New(slog.NewTextHandler(io.Discard, nil))).Authenticate(), s.Sign()).
+		Get("/api/applications/{application}/groupkinds/{groupkind}/rows/{row}/graphs/{graph}/export",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/csv")
+				w.Header().Set("Content-Disposition", "attachment; filename=metrics_payments.csv")
+				io.WriteString(w, body[:100])
+				io.WriteString(w, body[100:])
+			})
+
+	req := httptest.NewRequest("GET", "/api/applications/payments/groupkinds/deployment/rows/http/graphs/request-rate/export?format=csv", nil)
+	req.Header.Set(HeaderArgoCDApplicationName, "argocd:payments")
+	req.Header.Set(HeaderArgoCDProjectName, "prod")
+	req.Header.Set(HeaderArgoCDUsername, "alice")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Body.String() != body {
+		t.Fatalf("status %d, body altered", rr.Code)
+	}
+	env, err := signing.ParseEnvelope([]byte(rr.Header().Get(HeaderExportSignature)))
+	if err != nil {
+		t.Fatalf("no signature: %v", err)
+	}
+	if err := signing.Verify(env, rr.Body.Bytes(), []ed25519.PublicKey{pub}); err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+
+	want := signing.Metadata{
+		Application: "payments",
+		Project:     "prod",
+		GroupKind:   "deployment",
+		Row:         "http",
+		Graph:       "request-rate",
+		User:        "alice",
+		Path:        "/api/applications/payments/groupkinds/deployment/rows/http/graphs/request-rate/export",
+		Query:       "format=csv",
+		Filename:    "metrics_payments.csv",
+		ContentType: "text/csv",
+		ExportedAt:  s.now(),
+		SizeBytes:   int64(len(body)),
+		SHA256:      env.Metadata.SHA256,
+	}
+	if env.Metadata != want {
+		t.Errorf("metadata = %+v\nwant %+v", env.Metadata, want)
+	}
+}
+
+func TestExportSigner_Unsigned(t *testing.T) {
+	s, _ := newTestSigner(t, SigningConfig{MaxSize: 10})
+
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		wantError bool
+	}{
+		{"error response", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+		}, false},
+		{"too large", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "0123456789abcdef")
+		}, true},
+		{"streamed", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "01")
+			w.(http.Flusher).Flush()
+			io.WriteString(w, "23")
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			s.Sign()(tt.handler).ServeHTTP(rr, httptest.NewRequest("GET", "/export", nil))
+			if rr.Header().Get(HeaderExportSignature) != "" {
+				t.Error("response signed")
+			}
+			if got := rr.Header().Get(HeaderExportSignatureError) != ""; got != tt.wantError {
+				t.Errorf("signature error header present = %v, want %v", got, tt.wantError)
+			}
+			if tt.name == "too large" && rr.Body.String() != "0123456789abcdef" {
+				t.Errorf("body = %q", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestExportSigner_KeysHandler(t *testing.T) {
+	_, previous := writeSigningKey(t, 9)
+	pubPEM, err := signing.MarshalPublicKey(previous.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousFile := filepath.Join(t.TempDir(), "previous.pem")
+	os.WriteFile(previousFile, pubPEM, 0o644)
+
+	s, pub := newTestSigner(t, SigningConfig{PreviousKeyFiles: []string{previousFile}})
+	rr := httptest.NewRecorder()
+	s.KeysHandler().ServeHTTP(rr, httptest.NewRequest("GET", SigningKeysPath, nil))
+
+	var set signing.JWKS
+	if err := json.Unmarshal(rr.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 2 || set.Keys[0].Kid != signing.KeyID(pub) ||
+		set.Keys[1].Kid != signing.KeyID(previous.Public().(ed25519.PublicKey)) {
+		t.Errorf("keys = %+v", set.Keys)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/jwk-set+json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestNewExportSigner_Errors(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := NewExportSigner(SigningConfig{}, logger); err == nil {
+		t.Error("missing key file accepted")
+	}
+	bad := filepath.Join(t.TempDir(), "bad.pem")
+	os.WriteFile(bad, []byte("not a key"), 0o600)
+	if _, err := NewExportSigner(SigningConfig{KeyFile: bad}, logger); err == nil {
+		t.Error("invalid key accepted")
+	}
+}
@@ -0,0 +1,465 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/chart"
+	"github.com/vjranagit/argocd-observability-extensions/internal/models"
+	"github.com/vjranagit/argocd-observability-extensions/internal/pdf"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/server/middleware"
+)
+
+// Report formats
+const (
+	reportFormatHTML = "html"
+	reportFormatPDF  = "pdf"
+)
+
+// maxReportGraphs bounds the graphs rendered into one report
+const maxReportGraphs = 50
+
+// report is a rendered snapshot of an application's graphs
+type report struct {
+	Title       string
+	Application string
+	Project     string
+	GeneratedAt time.Time
+	Range       *exportRange
+	Panels      []reportPanel
+}
+
+// reportPanel is one graph of a report
+type reportPanel struct {
+	providers.GraphRef
+	Chart  *chart.Drawing
+	Series []reportSeries
+	// Error is set when the graph could not be queried
+	Error string
+}
+
+// Heading names the graph as groupkind / row / graph
+func (p reportPanel) Heading() string {
+	return p.GroupKind + " / " + p.Row + " / " + p.Graph
+}
+
+// SVG renders the chart for inlining into the HTML report
+func (p reportPanel) SVG() template.HTML {
+	var b strings.Builder
+	p.Chart.SVG(&b)
+	// Text is escaped by the SVG renderer
+	return template.HTML(b.String())
+}
+
+// reportSeries summarises one series of a graph
+type reportSeries struct {
+	Name  string
+	Color chart.Color
+	seriesSummary
+}
+
+// seriesSummary holds summary statistics of a series' finite values; they
+// are NaN when it has none
+type seriesSummary struct {
+	Count int
+	Min   float64
+	Max   float64
+	Avg   float64
+	Last  float64
+}
+
+// handleReport renders a report of the application's graphs over a time
+// window as a self-contained HTML page with inline SVG charts, or as a PDF
+// with format=pdf. Each graph gets a chart and summary statistics per
+// series.
+//
+// Graphs are selected by graphs=groupkind/row/graph, repeated or comma
+// separated; without it every graph listed by the provider, which must then
+// implement providers.GraphLister, is included. Time range parameters are
+// those of the export endpoint. Graphs the caller may not view under
+// middleware.AuthorizeEach are left out; routes without a policy must use
+// middleware.AllowAllGraphs.
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	format := params.Get("format")
+	if format == "" {
+		format = reportFormatHTML
+	}
+	if format != reportFormatHTML && format != reportFormatPDF {
+		s.respondError(w, http.StatusNotAcceptable, "unsupported report format",
+			"supported formats: "+reportFormatHTML+", "+reportFormatPDF)
+		return
+	}
+
+	timeRange, err := exportRangeFromQuery(params, time.Now())
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid parameter", err.Error())
+		return
+	}
+
+	requested, err := parseReportGraphs(params["graphs"])
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid parameter", err.Error())
+		return
+	}
+
+	base, ok := s.exportQuery(w, r)
+	if !ok {
+		return
+	}
+
+	if len(requested) == 0 {
+		lister, ok := s.provider.(providers.GraphLister)
+		if !ok {
+			s.respondError(w, http.StatusBadRequest, "missing parameter",
+				"graphs is required, the metrics provider cannot list dashboard graphs")
+			return
+		}
+		requested, err = lister.ListGraphs(r.Context(), base.Application, base.Project)
+		if errors.Is(err, providers.ErrNotSupported) {
+			s.respondError(w, http.StatusBadRequest, "missing parameter",
+				"graphs is required, the metrics provider cannot list dashboard graphs")
+			return
+		}
+		if err != nil {
+			s.logger.Error("failed to list graphs", "application", base.Application, "error", err)
+			s.respondError(w, http.StatusInternalServerError, "query failed", err.Error())
+			return
+		}
+	}
+
+	var graphs []providers.GraphRef
+	for _, g := range requested {
+		if middleware.GraphAllowed(r.Context(), g.GroupKind, g.Graph) {
+			graphs = append(graphs, g)
+		}
+	}
+	if len(graphs) == 0 {
+		s.respondError(w, http.StatusNotFound, "no graphs", "no graphs match the request")
+		return
+	}
+	if len(graphs) > maxReportGraphs {
+		s.respondError(w, http.StatusBadRequest, "too many graphs",
+			fmt.Sprintf("at most %d graphs may be included in a report", maxReportGraphs))
+		return
+	}
+
+	now := time.Now()
+	rep := report{
+		Title:       params.Get("title"),
+		Application: base.Application,
+		Project:     base.Project,
+		GeneratedAt: now.UTC(),
+		Range:       timeRange,
+	}
+	if rep.Title == "" {
+		rep.Title = base.Application + " metrics report"
+	}
+
+	for _, g := range graphs {
+		if err := r.Context().Err(); err != nil {
+			s.logger.Info("report canceled", "application", base.Application, "error", err)
+			return
+		}
+
+		query := *base
+		query.GroupKind, query.Row, query.Graph = g.GroupKind, g.Row, g.Graph
+		panel := reportPanel{GraphRef: g}
+		response, err := queryExport(r.Context(), s.provider, &query, timeRange)
+		if err != nil {
+			s.logger.Warn("report graph query failed", "graph", g.Graph, "error", err)
+			panel.Error = err.Error()
+		} else {
+			panel.Series, panel.Chart = reportChart(response.Data, format)
+		}
+		rep.Panels = append(rep.Panels, panel)
+	}
+
+	var buf bytes.Buffer
+	contentType := "text/html; charset=utf-8"
+	if format == reportFormatPDF {
+		contentType = "application/pdf"
+		_, err = renderReportPDF(rep).WriteTo(&buf)
+	} else {
+		err = reportTemplate.Execute(&buf, rep)
+	}
+	if err != nil {
+		s.logger.Error("failed to render report", "format", format, "error", err)
+		s.respondError(w, http.StatusInternalServerError, "report failed", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=report_%s_%s.%s", base.Application, now.Format("20060102_150405"), format))
+	w.Write(buf.Bytes())
+
+	s.logger.Info("generated metrics report",
+		"format", format,
+		"application", base.Application,
+		"graphs", len(rep.Panels))
+}
+
+// parseReportGraphs parses groupkind/row/graph references
+func parseReportGraphs(values []string) ([]providers.GraphRef, error) {
+	var graphs []providers.GraphRef
+	for _, v := range values {
+		for _, ref := range strings.Split(v, ",") {
+			ref = strings.TrimSpace(ref)
+			if ref == "" {
+				continue
+			}
+			parts := strings.Split(ref, "/")
+			if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+				return nil, fmt.Errorf("invalid graph %q: want groupkind/row/graph", ref)
+			}
+			graphs = append(graphs, providers.GraphRef{GroupKind: parts[0], Row: parts[1], Graph: parts[2]})
+		}
+	}
+	return graphs, nil
+}
+
+// reportChart splits data into series by label set, in order of first
+// appearance, and lays out their chart sized for the report format. The
+// panel heading serves as the chart title.
+func reportChart(data []models.MetricData, format string) ([]reportSeries, *chart.Drawing) {
+	c := chart.Chart{Width: 900, Height: 280}
+	if format == reportFormatPDF {
+		c.Width, c.Height = pdf.A4Height-2*reportPDFMargin, 260
+	}
+
+	index := make(map[string]int)
+	for _, d := range data {
+		key := formatLabels(d.Labels)
+		i, ok := index[key]
+		if !ok {
+			i = len(c.Series)
+			index[key] = i
+			name := key
+			if name == "" {
+				name = "value"
+			}
+			c.Series = append(c.Series, chart.Series{Name: name})
+		}
+		c.Series[i].Points = append(c.Series[i].Points, chart.Point{Time: d.Timestamp, Value: d.Value})
+	}
+
+	series := make([]reportSeries, len(c.Series))
+	for i := range c.Series {
+		points := c.Series[i].Points
+		sort.SliceStable(points, func(a, b int) bool { return points[a].Time.Before(points[b].Time) })
+		series[i] = reportSeries{
+			Name:          c.Series[i].Name,
+			Color:         chart.Palette[i%len(chart.Palette)],
+			seriesSummary: summarize(points),
+		}
+	}
+	return series, c.Layout()
+}
+
+// summarize computes summary statistics of points in time order
+func summarize(points []chart.Point) seriesSummary {
+	s := seriesSummary{Min: math.NaN(), Max: math.NaN(), Avg: math.NaN(), Last: math.NaN()}
+	var sum float64
+	for _, p := range points {
+		if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+			continue
+		}
+		if s.Count == 0 {
+			s.Min, s.Max = p.Value, p.Value
+		}
+		s.Min, s.Max = math.Min(s.Min, p.Value), math.Max(s.Max, p.Value)
+		s.Last = p.Value
+		sum += p.Value
+		s.Count++
+	}
+	if s.Count > 0 {
+		s.Avg = sum / float64(s.Count)
+	}
+	return s
+}
+
+// reportRangeText describes the report window
+func reportRangeText(r *exportRange) string {
+	if r == nil {
+		return "default graph window"
+	}
+	text := r.Start.UTC().Format(time.RFC3339) + " to " + r.End.UTC().Format(time.RFC3339)
+	if r.Step != 0 {
+		text += fmt.Sprintf(", %s %s", r.Step, r.downsample)
+	}
+	return text
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"value":  chart.FormatValue,
+	"window": reportRangeText,
+	"time":   func(t time.Time) string { return t.Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; color: #222; margin: 2rem auto; max-width: 960px; padding: 0 1rem; }
+h1 { font-size: 1.6rem; margin-bottom: .25rem; }
+h2 { font-size: 1.1rem; margin: 2rem 0 .5rem; }
+.meta { color: #6b7280; margin: 0; }
+svg { max-width: 100%; height: auto; }
+table { border-collapse: collapse; width: 100%; font-size: .85rem; }
+th, td { text-align: right; padding: .25rem .5rem; border-bottom: 1px solid #e5e7eb; }
+th:first-child, td:first-child { text-align: left; word-break: break-all; }
+.swatch { display: inline-block; width: .75rem; height: .75rem; margin-right: .4rem; vertical-align: middle; }
+.error { color: #b91c1c; }
+@media print { h2 { break-after: avoid; } section { break-inside: avoid; } }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">Application <strong>{{.Application}}</strong> in project <strong>{{.Project}}</strong></p>
+<p class="meta">Window: {{window .Range}} &middot; Generated {{time .GeneratedAt}}</p>
+{{range .Panels}}
+<section>
+<h2>{{.Heading}}</h2>
+{{if .Error}}<p class="error">Query failed: {{.Error}}</p>{{else}}
+{{.SVG}}
+{{if .Series}}<table>
+<tr><th>Series</th><th>Points</th><th>Min</th><th>Avg</th><th>Max</th><th>Last</th></tr>
+{{range .Series}}<tr><td><span class="swatch" style="background: {{.Color.Hex}}"></span>{{.Name}}</td><td>{{.Count}}</td><td>{{value .Min}}</td><td>{{value .Avg}}</td><td>{{value .Max}}</td><td>{{value .Last}}</td></tr>
+{{end}}</table>{{end}}{{end}}
+</section>
+{{end}}
+</body>
+</html>
+`))
+
+// Layout of PDF reports, on landscape A4 pages
+const (
+	reportPDFMargin = 36
+	reportPDFRow    = 14
+)
+
+// renderReportPDF lays out one graph per page, the first page headed by the
+// report title
+func renderReportPDF(rep report) *pdf.Document {
+	doc := pdf.New(rep.Title)
+	doc.Created = rep.GeneratedAt
+	pageW, pageH := pdf.A4Height, pdf.A4Width
+
+	for i, panel := range rep.Panels {
+		page := doc.AddPage(pageW, pageH)
+		y := float64(reportPDFMargin)
+		if i == 0 {
+			page.Text(reportPDFMargin, y+16, 18, true, pdf.AlignLeft, 0x22, 0x22, 0x22, rep.Title)
+			page.Text(reportPDFMargin, y+34, 10, false, pdf.AlignLeft, 0x6b, 0x72, 0x80,
+				fmt.Sprintf("Application %s in project %s - window: %s - generated %s",
+					rep.Application, rep.Project, reportRangeText(rep.Range), rep.GeneratedAt.Format(time.RFC3339)))
+			y += 52
+		}
+		page.Text(reportPDFMargin, y+12, 12, true, pdf.AlignLeft, 0x22, 0x22, 0x22, panel.Heading())
+		y += 20
+
+		if panel.Error != "" {
+			page.Text(reportPDFMargin, y+12, 10, false, pdf.AlignLeft, 0xb9, 0x1c, 0x1c, "Query failed: "+panel.Error)
+			continue
+		}
+		panel.Chart.Draw(&pdfCanvas{page: page, dx: reportPDFMargin, dy: y})
+		y += panel.Chart.Height + 12
+
+		renderSummaryTable(doc, page, panel.Series, y)
+	}
+	return doc
+}
+
+// renderSummaryTable draws the statistics of each series, continuing on
+// new pages as needed
+func renderSummaryTable(doc *pdf.Document, page *pdf.Page, series []reportSeries, y float64) {
+	if len(series) == 0 {
+		return
+	}
+	pageW, pageH := page.Size()
+	right := pageW - reportPDFMargin
+	columns := []float64{right - 400, right - 320, right - 240, right - 160, right - 80, right}
+	cell := func(x, y float64, align pdf.Align, bold bool, s string) {
+		page.Text(x, y, 9, bold, align, 0x22, 0x22, 0x22, s)
+	}
+	header := func() {
+		y += reportPDFRow
+		cell(reportPDFMargin, y, pdf.AlignLeft, true, "Series")
+		for i, h := range []string{"Points", "Min", "Avg", "Max", "Last"} {
+			cell(columns[i+1], y, pdf.AlignRight, true, h)
+		}
+		page.Line(reportPDFMargin, y+4, right, y+4, 0.5, 0xe5, 0xe7, 0xeb)
+	}
+
+	header()
+	for _, s := range series {
+		y += reportPDFRow
+		if y > pageH-reportPDFMargin {
+			page = doc.AddPage(pageW, pageH)
+			y = reportPDFMargin
+			header()
+			y += reportPDFRow
+		}
+		page.Rect(reportPDFMargin, y-7, 7, 7, s.Color.R, s.Color.G, s.Color.B)
+		cell(reportPDFMargin+11, y, pdf.AlignLeft, false, truncateText(s.Name, 9, columns[0]-reportPDFMargin-20))
+		for i, v := range []string{fmt.Sprint(s.Count), chart.FormatValue(s.Min), chart.FormatValue(s.Avg),
+			chart.FormatValue(s.Max), chart.FormatValue(s.Last)} {
+			cell(columns[i+1], y, pdf.AlignRight, false, v)
+		}
+	}
+}
+
+// truncateText shortens s with an ellipsis to fit width at size
+func truncateText(s string, size, width float64) string {
+	if pdf.TextWidth(s, size) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && pdf.TextWidth(string(runes)+"...", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// pdfCanvas draws a chart onto a PDF page at an offset
+type pdfCanvas struct {
+	page   *pdf.Page
+	dx, dy float64
+}
+
+func (c *pdfCanvas) Line(x1, y1, x2, y2, width float64, col chart.Color) {
+	c.page.Line(x1+c.dx, y1+c.dy, x2+c.dx, y2+c.dy, width, col.R, col.G, col.B)
+}
+
+func (c *pdfCanvas) Polyline(points [][2]float64, width float64, col chart.Color) {
+	moved := make([][2]float64, len(points))
+	for i, p := range points {
+		moved[i] = [2]float64{p[0] + c.dx, p[1] + c.dy}
+	}
+	c.page.Polyline(moved, width, col.R, col.G, col.B)
+}
+
+func (c *pdfCanvas) Rect(x, y, w, h float64, fill chart.Color) {
+	c.page.Rect(x+c.dx, y+c.dy, w, h, fill.R, fill.G, fill.B)
+}
+
+func (c *pdfCanvas) Text(x, y, size float64, bold bool, anchor chart.Anchor, col chart.Color, s string) {
+	align := pdf.AlignLeft
+	switch anchor {
+	case chart.AnchorMiddle:
+		align = pdf.AlignCenter
+	case chart.AnchorEnd:
+		align = pdf.AlignRight
+	}
+	c.page.Text(x+c.dx, y+c.dy, size, bold, align, col.R, col.G, col.B, s)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/vjranagit/argocd-observability-extensions/internal/chart"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/providers"
+	"github.com/vjranagit/argocd-observability-extensions/pkg/server/middleware"
+)
+
+func reportRouter(srv *Server) http.Handler {
+	r := chi.NewRouter()
+	r.With(middleware.AllowAllGraphs()).Get("/api/applications/{application}/report", srv.handleReport)
+	return r
+}
+
+func TestHandleReport_HTML(t *testing.T) {
+	srv := &Server{
+		logger:   testLogger,
+		provider: &fakeDashboardProvider{graphs: testDashboard, fail: map[string]bool{"error-rate": true}},
+	}
+
+	rr := httptest.NewRecorder()
+	reportRouter(srv).ServeHTTP(rr, httptest.NewRequest("GET",
+		"/api/applications/test-app/report?application_name=test-app&project=default"+
+			"&graphs=deployment/http/request-rate,deployment/http/error-rate&title=INC-42+%3Cpayments%3E", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.HasSuffix(cd, ".html") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		"<title>INC-42 &lt;payments&gt;</title>",
+		"deployment / http / request-rate",
+		"<svg xmlns=",
+		`<td><span class="swatch" style="background: #1f77b4"></span>row=&#34;http&#34;</td><td>1</td>`,
+		"Query failed: upstream timeout",
+		"default graph window",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+	if strings.Contains(body, "resources") {
+		t.Error("report includes graphs that were not requested")
+	}
+}
+
+func TestHandleReport_AllGraphs(t *testing.T) {
+	srv := &Server{logger: testLogger, provider: &fakeDashboardProvider{graphs: testDashboard}}
+
+	rr := httptest.NewRecorder()
+	reportRouter(srv).ServeHTTP(rr, httptest.NewRequest("GET",
+		"/api/applications/test-app/report?application_name=test-app&project=default&duration=1h", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	if n := strings.Count(rr.Body.String(), "<section>"); n != len(testDashboard) {
+		t.Errorf("got %d panels, want %d", n, len(testDashboard))
+	}
+}
+
+func TestHandleReport_PDF(t *testing.T) {
+	srv := &Server{logger: testLogger, provider: &fakeDashboardProvider{graphs: testDashboard}}
+
+	rr := httptest.NewRecorder()
+	reportRouter(srv).ServeHTTP(rr, httptest.NewRequest("GET",
+		"/api/applications/test-app/report?application_name=test-app&project=default&format=pdf", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	out := rr.Body.Bytes()
+	if !bytes.HasPrefix(out, []byte("%PDF-")) {
+		t.Fatal("not a PDF")
+	}
+	if !bytes.Contains(out, []byte("/Count 4")) {
+		t.Error("want one page per graph")
+	}
+
+	// The first page carries the title and the first graph's chart
+	m := regexp.MustCompile(`/Length (\d+) /Filter /FlateDecode >>\nstream\n`).FindSubmatchIndex(out)
+	length, _ := strconv.Atoi(string(out[m[2]:m[3]]))
+	zr, err := zlib.NewReader(bytes.NewReader(out[m[1] : m[1]+length]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(zr)
+	for _, want := range []string{"(test-app metrics report) Tj", "(deployment / http / request-rate) Tj", "(Last) Tj"} {
+		if !bytes.Contains(content, []byte(want)) {
+			t.Errorf("first page missing %q", want)
+		}
+	}
+}
+
+func TestHandleReport_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider providers.MetricsQuerier
+		query    string
+		want     int
+	}{
+		{"unknown format", &fakeDashboardProvider{graphs: testDashboard}, "format=docx", http.StatusNotAcceptable},
+		{"malformed graph", &fakeDashboardProvider{graphs: testDashboard}, "graphs=deployment/cpu", http.StatusBadRequest},
+		{"bad range", &fakeDashboardProvider{graphs: testDashboard}, "step=1m", http.StatusBadRequest},
+		{"no graph listing", &fakeProvider{}, "", http.StatusBadRequest},
+		{"routed provider without graph listing", &unsupportedProvider{}, "", http.StatusBadRequest},
+		{"no graphs", &fakeDashboardProvider{}, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &Server{logger: testLogger, provider: tt.provider}
+			rr := httptest.NewRecorder()
+			reportRouter(srv).ServeHTTP(rr, httptest.NewRequest("GET",
+				"/api/applications/test-app/report?application_name=test-app&project=default&"+tt.query, nil))
+			if rr.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rr.Code, tt.want, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var points []chart.Point
+	for i, v := range []float64{4, math.NaN(), 1, 7, math.Inf(1)} {
+		points = append(points, chart.Point{Time: time.Unix(int64(15*i), 0), Value: v})
+	}
+	got := summarize(points)
+	want := seriesSummary{Count: 3, Min: 1, Max: 7, Avg: 4, Last: 7}
+	if got != want {
+		t.Errorf("summarize = %+v, want %+v", got, want)
+	}
+	if empty := summarize(nil); empty.Count != 0 || !math.IsNaN(empty.Avg) {
+		t.Errorf("summarize(nil) = %+v", empty)
+	}
+}